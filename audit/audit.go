@@ -0,0 +1,243 @@
+/*
+Copyright (c) 2021 Memgraph Ltd. [https://memgraph.com]
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Tamper-evident record of who connected through the proxy and what they
+// ran. Entries are JSON objects, one per line, each carrying the hash of
+// the one before it, so editing or removing an entry breaks the chain.
+package audit
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"sync"
+	"time"
+
+	"github.com/memgraph/bolt-proxy/config"
+	"github.com/memgraph/bolt-proxy/metrics"
+	"github.com/memgraph/bolt-proxy/proxy_logger"
+	"github.com/memgraph/bolt-proxy/redact"
+)
+
+var auditErrors = metrics.NewCounter("bolt_proxy_audit_errors_total",
+	"Audit entries that couldn't be written.")
+
+// Supported values for Event.Type
+const (
+	SESSION_OPEN  string = "session_open"
+	SESSION_CLOSE string = "session_close"
+	// A client authenticated again, or logged off, within a session
+	LOGON  string = "logon"
+	LOGOFF string = "logoff"
+	// A query, once its last record was sent or it failed
+	QUERY string = "query"
+	// An explicit transaction, once committed or rolled back
+	TRANSACTION string = "transaction"
+	// A principal or client address locked out for DurationMs after
+	// repeated authentication failures, outside of any session
+	LOCKOUT string = "lockout"
+)
+
+// Supported values for Event.Outcome
+const (
+	OUTCOME_SUCCESS string = "success"
+	OUTCOME_FAILURE string = "failure"
+	// The server skipped the request after an earlier failure
+	OUTCOME_IGNORED string = "ignored"
+	// The proxy refused the request, it never reached the server
+	OUTCOME_REFUSED     string = "refused"
+	OUTCOME_COMMITTED   string = "committed"
+	OUTCOME_ROLLED_BACK string = "rolled_back"
+)
+
+// Value recorded for parameters in AUDIT_PARAMETERS_REDACT mode
+const REDACTED string = "redacted"
+
+// TLS details of a client connection.
+type TLS struct {
+	Version     string `json:"version"`
+	CipherSuite string `json:"cipher_suite"`
+	// Principal of the client certificate, if one was given
+	ClientCert string `json:"client_cert,omitempty"`
+}
+
+// A single audit entry. Which fields are set depends on the type.
+type Event struct {
+	Time time.Time `json:"time"`
+	Type string    `json:"type"`
+	// Random id shared by all the entries of a client session
+	Session       string `json:"session"`
+	Listener      string `json:"listener,omitempty"`
+	Principal     string `json:"principal,omitempty"`
+	ClientAddress string `json:"client_address,omitempty"`
+	TLS           *TLS   `json:"tls,omitempty"`
+	AuthMethod    string `json:"auth_method,omitempty"`
+	Database      string `json:"database,omitempty"`
+	Query         string `json:"query,omitempty"`
+	// Parameter values by name, see AUDIT_PARAMETERS_*
+	Parameters map[string]string `json:"parameters,omitempty"`
+	// Whether a query ran in an explicit transaction
+	Transaction bool   `json:"transaction,omitempty"`
+	Outcome     string `json:"outcome,omitempty"`
+	// The Neo4j status code of a failure, or why the proxy refused
+	Error string `json:"error,omitempty"`
+	// Records sent to the client
+	Rows       int64   `json:"rows,omitempty"`
+	DurationMs float64 `json:"duration_ms,omitempty"`
+	// What a lockout applies to, the principal or the client address
+	LockedOut string `json:"locked_out,omitempty"`
+
+	// Hash of the previous entry, empty for the first one of a chain
+	PrevHash string `json:"prev_hash"`
+	// SHA-256 of this entry as written, without the hash itself
+	Hash string `json:"hash,omitempty"`
+}
+
+// Where the entries go, one line at a time.
+type sink interface {
+	// The hash of the last entry written before, to continue its chain
+	lastHash() (string, error)
+	write(line []byte) error
+	Close() error
+}
+
+// Writes audit entries to a sink, chaining them together. Safe for
+// concurrent use.
+type Logger struct {
+	mu   sync.Mutex
+	sink sink
+	prev string
+
+	// Masks sensitive values in query text, errors and parameters
+	redactor   *redact.Redactor
+	parameters string
+	now        func() time.Time
+}
+
+// The audit logger for conf, nil if auditing is disabled.
+func New(conf config.Audit, redactor *redact.Redactor) (*Logger, error) {
+	var s sink
+	var err error
+	switch conf.Sink {
+	case "":
+		return nil, nil
+	case config.AUDIT_SINK_FILE:
+		s, err = openFile(conf.File)
+	case config.AUDIT_SINK_SYSLOG:
+		s, err = dialSyslog(conf.Syslog)
+	}
+	if err != nil {
+		return nil, err
+	}
+	prev, err := s.lastHash()
+	if err != nil {
+		s.Close()
+		return nil, err
+	}
+	return &Logger{sink: s, prev: prev, redactor: redactor, parameters: conf.Parameters, now: time.Now}, nil
+}
+
+// Write an entry, filling in its time if unset, and chain it to the
+// previous one. The redaction patterns are applied to its query and error.
+// Failures are logged rather than returned, so clients aren't held up.
+func (l *Logger) Log(e Event) {
+	e.Query = l.redactor.Text(e.Query)
+	e.Error = l.redactor.Text(e.Error)
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if e.Time.IsZero() {
+		e.Time = l.now()
+	}
+	e.Time = e.Time.UTC()
+	e.PrevHash = l.prev
+	e.Hash = ""
+	line, hash, err := seal(e)
+	if err == nil {
+		err = l.sink.write(line)
+	}
+	if err != nil {
+		auditErrors.Inc()
+		proxy_logger.WarnLog.Printf("audit: can't record %s of session %s: %v", e.Type, e.Session, err)
+		return
+	}
+	l.prev = hash
+}
+
+// How a query's parameters are recorded, from their names and packed
+// values.
+func (l *Logger) Parameters(values map[string][]byte) map[string]string {
+	if len(values) == 0 {
+		return nil
+	}
+	recorded := make(map[string]string, len(values))
+	for name, value := range values {
+		switch l.parameters {
+		case config.AUDIT_PARAMETERS_HASH:
+			if l.redactor.Secret(name) {
+				recorded[name] = REDACTED
+				break
+			}
+			sum := sha256.Sum256(value)
+			recorded[name] = "sha256:" + hex.EncodeToString(sum[:])
+		case config.AUDIT_PARAMETERS_VALUES:
+			recorded[name] = l.redactor.PackedValue(name, value)
+		default:
+			recorded[name] = REDACTED
+		}
+	}
+	return recorded
+}
+
+func (l *Logger) Close() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.sink.Close()
+}
+
+// The line to write for e and its hash. The hash covers the JSON of the
+// entry without the hash field, which is then appended as the last one.
+func seal(e Event) ([]byte, string, error) {
+	body, err := json.Marshal(e)
+	if err != nil {
+		return nil, "", err
+	}
+	sum := sha256.Sum256(body)
+	hash := hex.EncodeToString(sum[:])
+
+	line := make([]byte, 0, len(body)+len(hash)+len(hashField)+3)
+	line = append(line, body[:len(body)-1]...)
+	line = append(line, hashField...)
+	line = append(line, hash...)
+	line = append(line, '"', '}', '\n')
+	return line, hash, nil
+}
+
+const hashField = `,"hash":"`
+
+// Split a line written by seal into the hashed body and the hash.
+func unseal(line []byte) ([]byte, string, bool) {
+	line = bytes.TrimRight(line, "\r\n")
+	i := bytes.LastIndex(line, []byte(hashField))
+	if i < 0 || !bytes.HasSuffix(line, []byte(`"}`)) {
+		return nil, "", false
+	}
+	hash := string(line[i+len(hashField) : len(line)-2])
+	body := append(append([]byte{}, line[:i]...), '}')
+	return body, hash, true
+}
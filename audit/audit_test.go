@@ -0,0 +1,198 @@
+/*
+Copyright (c) 2021 Memgraph Ltd. [https://memgraph.com]
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package audit
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/memgraph/bolt-proxy/config"
+	"github.com/memgraph/bolt-proxy/redact"
+)
+
+func tempDir(t *testing.T) string {
+	dir, err := ioutil.TempDir("", "bolt-proxy-audit")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	return dir
+}
+
+func fileLogger(t *testing.T, conf config.AuditFile, parameters string) *Logger {
+	logger, err := New(config.Audit{Sink: config.AUDIT_SINK_FILE, File: conf, Parameters: parameters}, redact.Default())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { logger.Close() })
+	return logger
+}
+
+func readLines(t *testing.T, path string) []string {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.SplitAfter(string(data), "\n")
+	return lines[:len(lines)-1]
+}
+
+func TestDisabled(t *testing.T) {
+	if logger, err := New(config.Default().Audit, redact.Default()); logger != nil || err != nil {
+		t.Fatalf("expected no logger by default, got %v, %v", logger, err)
+	}
+}
+
+func TestChain(t *testing.T) {
+	path := filepath.Join(tempDir(t), "audit.log")
+	logger := fileLogger(t, config.AuditFile{Path: path}, config.AUDIT_PARAMETERS_REDACT)
+	logger.now = func() time.Time { return time.Date(2021, 6, 1, 12, 0, 0, 0, time.UTC) }
+	logger.Log(Event{Type: SESSION_OPEN, Session: "s1", Principal: "alice", ClientAddress: "10.0.0.1:5000"})
+	logger.Log(Event{Type: QUERY, Session: "s1", Query: "MATCH (n) RETURN n", Outcome: OUTCOME_SUCCESS, Rows: 3})
+	logger.Log(Event{Type: SESSION_CLOSE, Session: "s1"})
+	logger.Close()
+
+	lines := readLines(t, path)
+	if len(lines) != 3 {
+		t.Fatalf("expected 3 entries, got %q", lines)
+	}
+	var first, second Event
+	if err := json.Unmarshal([]byte(lines[0]), &first); err != nil {
+		t.Fatal(err)
+	}
+	if err := json.Unmarshal([]byte(lines[1]), &second); err != nil {
+		t.Fatal(err)
+	}
+	if first.PrevHash != "" || first.Principal != "alice" || !first.Time.Equal(logger.now()) {
+		t.Errorf("unexpected first entry %+v", first)
+	}
+	if second.PrevHash != first.Hash || second.Rows != 3 {
+		t.Errorf("expected the query to follow the session, got %+v", second)
+	}
+
+	last, entries, err := Verify(strings.NewReader(strings.Join(lines, "")), "")
+	if err != nil || entries != 3 {
+		t.Fatalf("expected the chain to verify, got %d entries, %v", entries, err)
+	}
+
+	// a restart continues the chain
+	logger = fileLogger(t, config.AuditFile{Path: path}, config.AUDIT_PARAMETERS_REDACT)
+	logger.Log(Event{Type: SESSION_OPEN, Session: "s2"})
+	logger.Close()
+	lines = readLines(t, path)
+	var restarted Event
+	if err := json.Unmarshal([]byte(lines[3]), &restarted); err != nil || restarted.PrevHash != last {
+		t.Fatalf("expected the chain to continue from %s, got %+v, %v", last, restarted, err)
+	}
+
+	tampered := append([]string{}, lines...)
+	tampered[1] = strings.Replace(tampered[1], `"rows":3`, `"rows":1`, 1)
+	if _, _, err := Verify(strings.NewReader(strings.Join(tampered, "")), ""); !errors.Is(err, ErrTampered) {
+		t.Errorf("expected the changed entry to be caught, got %v", err)
+	}
+	removed := append(append([]string{}, lines[:1]...), lines[2:]...)
+	if _, _, err := Verify(strings.NewReader(strings.Join(removed, "")), ""); !errors.Is(err, ErrBrokenChain) {
+		t.Errorf("expected the removed entry to be caught, got %v", err)
+	}
+	if _, _, err := Verify(strings.NewReader(strings.Join(lines[1:], "")), first.Hash+"x"); !errors.Is(err, ErrBrokenChain) {
+		t.Errorf("expected the first entry to follow the given hash, got %v", err)
+	}
+}
+
+func TestRotation(t *testing.T) {
+	path := filepath.Join(tempDir(t), "audit.log")
+	logger := fileLogger(t, config.AuditFile{Path: path, MaxFiles: 2}, config.AUDIT_PARAMETERS_REDACT)
+	sink := logger.sink.(*fileSink)
+	// a little over two entries per file
+	sink.maxSize = 600
+	for i := 0; i < 10; i++ {
+		logger.Log(Event{Type: QUERY, Session: "s1", Query: "MATCH (n) RETURN n", Outcome: OUTCOME_SUCCESS})
+	}
+	logger.Close()
+
+	if _, err := os.Stat(path + ".3"); !os.IsNotExist(err) {
+		t.Errorf("expected only 2 rotated files to be kept, got %v", err)
+	}
+	// the chain runs from the oldest file to the current one
+	var data bytes.Buffer
+	for _, name := range []string{path + ".2", path + ".1", path} {
+		lines := readLines(t, name)
+		if len(lines) != 2 {
+			t.Errorf("%s: expected 2 entries, got %d", name, len(lines))
+		}
+		data.WriteString(strings.Join(lines, ""))
+	}
+	if _, entries, err := Verify(&data, ""); err != nil || entries != 6 {
+		t.Fatalf("expected the chain to run through the files, got %d entries, %v", entries, err)
+	}
+}
+
+func TestParameters(t *testing.T) {
+	values := map[string][]byte{"id": {0x01}, "name": {0x85, 'a', 'l', 'i', 'c', 'e'}}
+	dir := tempDir(t)
+
+	redacted := fileLogger(t, config.AuditFile{Path: filepath.Join(dir, "redacted.log")}, config.AUDIT_PARAMETERS_REDACT)
+	if got := redacted.Parameters(values); len(got) != 2 || got["id"] != REDACTED || got["name"] != REDACTED {
+		t.Errorf("expected the values to be redacted, got %v", got)
+	}
+
+	hashed := fileLogger(t, config.AuditFile{Path: filepath.Join(dir, "hashed.log")}, config.AUDIT_PARAMETERS_HASH)
+	got := hashed.Parameters(values)
+	if !strings.HasPrefix(got["name"], "sha256:") || got["name"] == got["id"] {
+		t.Errorf("expected the values to be hashed, got %v", got)
+	}
+	if again := hashed.Parameters(values); again["name"] != got["name"] {
+		t.Error("expected the same value to hash the same")
+	}
+	if hashed.Parameters(nil) != nil {
+		t.Error("expected no parameters to be recorded as none")
+	}
+	// passwords are never hashed
+	if got := hashed.Parameters(map[string][]byte{"Password": {0x81, 'x'}}); got["Password"] != REDACTED {
+		t.Errorf("expected the password to be redacted, got %v", got)
+	}
+
+	// values go through the redaction rules
+	values["password"] = []byte{0x86, 's', 'e', 'c', 'r', 'e', 't'}
+	masked := fileLogger(t, config.AuditFile{Path: filepath.Join(dir, "values.log")}, config.AUDIT_PARAMETERS_VALUES)
+	if got := masked.Parameters(values); got["id"] != "1" || got["name"] != `"alice"` || got["password"] != redact.MASK {
+		t.Errorf("expected the values with the password masked, got %v", got)
+	}
+}
+
+func TestRedactedQuery(t *testing.T) {
+	path := filepath.Join(tempDir(t), "audit.log")
+	logger := fileLogger(t, config.AuditFile{Path: path}, config.AUDIT_PARAMETERS_REDACT)
+	logger.Log(Event{Type: QUERY, Session: "s1", Query: "MATCH (p {email: 'alice@example.com'}) RETURN p",
+		Outcome: OUTCOME_REFUSED, Error: "refused by policy: alice@example.com is blocked"})
+	logger.Close()
+
+	var e Event
+	if err := json.Unmarshal([]byte(readLines(t, path)[0]), &e); err != nil {
+		t.Fatal(err)
+	}
+	if e.Query != "MATCH (p {email: '***'}) RETURN p" || e.Error != "refused by policy: *** is blocked" {
+		t.Errorf("expected the email to be masked, got %+v", e)
+	}
+}
@@ -0,0 +1,165 @@
+/*
+Copyright (c) 2021 Memgraph Ltd. [https://memgraph.com]
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package audit
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/memgraph/bolt-proxy/config"
+)
+
+// JSON lines file, renamed to path.1 once it reaches its maximum size, the
+// older ones being shifted to path.2 and so on. The chain continues from
+// one file into the next.
+type fileSink struct {
+	path     string
+	maxSize  int64
+	maxFiles int
+
+	file *os.File
+	size int64
+}
+
+func openFile(conf config.AuditFile) (*fileSink, error) {
+	s := &fileSink{
+		path:     conf.Path,
+		maxSize:  int64(conf.MaxSizeMB) << 20,
+		maxFiles: conf.MaxFiles,
+	}
+	return s, s.open()
+}
+
+func (s *fileSink) open() error {
+	file, err := os.OpenFile(s.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+	s.file, s.size = file, info.Size()
+	return nil
+}
+
+func (s *fileSink) lastHash() (string, error) {
+	// right after a rotation the chain continues in the newest rotated file
+	for _, path := range []string{s.path, s.rotated(1)} {
+		line, err := lastLine(path)
+		if err != nil {
+			return "", err
+		}
+		if line == nil {
+			continue
+		}
+		var entry struct {
+			Hash string `json:"hash"`
+		}
+		if err := json.Unmarshal(line, &entry); err != nil || entry.Hash == "" {
+			return "", fmt.Errorf("audit: can't continue the chain of %s, its last line isn't an entry", path)
+		}
+		return entry.Hash, nil
+	}
+	return "", nil
+}
+
+func (s *fileSink) write(line []byte) error {
+	if s.maxSize > 0 && s.size > 0 && s.size+int64(len(line)) > s.maxSize {
+		if err := s.rotate(); err != nil {
+			return err
+		}
+	}
+	n, err := s.file.Write(line)
+	s.size += int64(n)
+	return err
+}
+
+func (s *fileSink) rotated(n int) string {
+	return fmt.Sprintf("%s.%d", s.path, n)
+}
+
+func (s *fileSink) rotate() error {
+	if err := s.file.Close(); err != nil {
+		return err
+	}
+	if s.maxFiles == 0 {
+		if err := os.Remove(s.path); err != nil {
+			return err
+		}
+		return s.open()
+	}
+
+	if err := os.Remove(s.rotated(s.maxFiles)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	for n := s.maxFiles - 1; n >= 1; n-- {
+		if err := os.Rename(s.rotated(n), s.rotated(n+1)); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	if err := os.Rename(s.path, s.rotated(1)); err != nil {
+		return err
+	}
+	return s.open()
+}
+
+func (s *fileSink) Close() error {
+	return s.file.Close()
+}
+
+// The last non-empty line of the file at path, nil if there is none.
+func lastLine(path string) ([]byte, error) {
+	file, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	info, err := file.Stat()
+	if err != nil {
+		return nil, err
+	}
+
+	// read backwards from the end until a whole line is in
+	size := info.Size()
+	for chunk := int64(4096); ; chunk *= 2 {
+		if chunk > size {
+			chunk = size
+		}
+		buf := make([]byte, chunk)
+		if _, err := file.ReadAt(buf, size-chunk); err != nil && err != io.EOF {
+			return nil, err
+		}
+		buf = bytes.TrimRight(buf, "\r\n")
+		if i := bytes.LastIndexByte(buf, '\n'); i >= 0 {
+			return buf[i+1:], nil
+		}
+		if chunk == size {
+			if len(buf) == 0 {
+				return nil, nil
+			}
+			return buf, nil
+		}
+	}
+}
@@ -0,0 +1,53 @@
+//go:build !windows && !plan9
+// +build !windows,!plan9
+
+/*
+Copyright (c) 2021 Memgraph Ltd. [https://memgraph.com]
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package audit
+
+import (
+	"log/syslog"
+
+	"github.com/memgraph/bolt-proxy/config"
+)
+
+// One syslog message per entry, with the authpriv facility. The proxy can't
+// read back what it sent, so each start of the proxy begins a new chain.
+type syslogSink struct {
+	w *syslog.Writer
+}
+
+func dialSyslog(conf config.AuditSyslog) (*syslogSink, error) {
+	w, err := syslog.Dial(conf.Network, conf.Address, syslog.LOG_INFO|syslog.LOG_AUTHPRIV, conf.Tag)
+	if err != nil {
+		return nil, err
+	}
+	return &syslogSink{w: w}, nil
+}
+
+func (s *syslogSink) lastHash() (string, error) {
+	return "", nil
+}
+
+func (s *syslogSink) write(line []byte) error {
+	_, err := s.w.Write(line)
+	return err
+}
+
+func (s *syslogSink) Close() error {
+	return s.w.Close()
+}
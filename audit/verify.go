@@ -0,0 +1,77 @@
+/*
+Copyright (c) 2021 Memgraph Ltd. [https://memgraph.com]
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package audit
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+)
+
+var (
+	// An entry was changed after it was written
+	ErrTampered = errors.New("entry doesn't match its hash")
+	// An entry was removed, inserted or moved
+	ErrBrokenChain = errors.New("entry doesn't follow the previous one")
+)
+
+// Check the entries read from r hash as written and each follows the one
+// before. prev is the hash of the entry before the first one, e.g. the last
+// one of the previous rotated file; when empty the first entry is trusted
+// to start the chain. Returns the hash of the last entry and how many there
+// were.
+//
+// Entries cut off the end can't be told from the chain alone, compare the
+// last hash with one kept somewhere else.
+func Verify(r io.Reader, prev string) (string, int, error) {
+	reader := bufio.NewReader(r)
+	entries := 0
+	for lineNumber := 1; ; lineNumber++ {
+		line, err := reader.ReadBytes('\n')
+		if err != nil && err != io.EOF {
+			return prev, entries, err
+		}
+		if len(line) == 0 && err == io.EOF {
+			return prev, entries, nil
+		}
+
+		body, hash, ok := unseal(line)
+		if !ok {
+			return prev, entries, fmt.Errorf("line %d: %w", lineNumber, ErrTampered)
+		}
+		sum := sha256.Sum256(body)
+		if hex.EncodeToString(sum[:]) != hash {
+			return prev, entries, fmt.Errorf("line %d: %w", lineNumber, ErrTampered)
+		}
+		var entry Event
+		if err := json.Unmarshal(body, &entry); err != nil {
+			return prev, entries, fmt.Errorf("line %d: %v", lineNumber, err)
+		}
+		if entry.PrevHash != prev && (entries > 0 || prev != "") {
+			return prev, entries, fmt.Errorf("line %d: %w", lineNumber, ErrBrokenChain)
+		}
+		prev = hash
+		entries++
+		if err == io.EOF {
+			return prev, entries, nil
+		}
+	}
+}
@@ -0,0 +1,204 @@
+/*
+Copyright (c) 2021 Memgraph Ltd. [https://memgraph.com]
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package backend
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/memgraph/bolt-proxy/config"
+	"github.com/memgraph/bolt-proxy/cypher"
+	"github.com/memgraph/bolt-proxy/proxy_logger"
+	"gopkg.in/yaml.v3"
+)
+
+// RUN metadata entry naming an approved query
+const QUERY_ID_KEY = "query_id"
+
+// How often the allowlist is checked for changes, at most
+const ALLOWLIST_CHECK_INTERVAL = 5 * time.Second
+
+// Extension of the query files in an allowlist directory
+const QUERY_FILE_EXT = ".cypher"
+
+// The approved queries of config.QueryAllowlist.
+type QueryAllowlist struct {
+	path          string
+	checkInterval time.Duration
+
+	mu sync.Mutex
+	// approved queries by id, and their ids by hash
+	queries map[string]string
+	hashes  map[string]string
+	// size and modification time of the files, to notice changes
+	fingerprint string
+	lastCheck   time.Time
+}
+
+// Returns nil if no allowlist is configured.
+func NewQueryAllowlist(conf config.QueryAllowlist) (*QueryAllowlist, error) {
+	if !conf.Enabled() {
+		return nil, nil
+	}
+	allowlist := &QueryAllowlist{
+		path:          conf.Path,
+		checkInterval: ALLOWLIST_CHECK_INTERVAL,
+	}
+	err := allowlist.load()
+	if err != nil {
+		return nil, err
+	}
+	allowlist.lastCheck = time.Now()
+	return allowlist, nil
+}
+
+// The query to run in place of the one a client sent: the approved query
+// with the given id if it's set, otherwise the client's own if it's
+// approved.
+func (a *QueryAllowlist) Resolve(query, id string) (string, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.reloadIfChanged()
+
+	if id != "" {
+		approved, found := a.queries[id]
+		if !found {
+			return "", fmt.Errorf("unknown query id %q", id)
+		}
+		return approved, nil
+	}
+	if _, found := a.hashes[QueryHash(query)]; !found {
+		return "", errors.New("query is not in the allowlist")
+	}
+	return query, nil
+}
+
+// Reload the queries if any file changed. Must be called with the lock
+// held.
+func (a *QueryAllowlist) reloadIfChanged() {
+	if time.Since(a.lastCheck) < a.checkInterval {
+		return
+	}
+	a.lastCheck = time.Now()
+
+	fingerprint, err := a.files()
+	if err == nil && fingerprint == a.fingerprint {
+		return
+	}
+	if err == nil {
+		err = a.load()
+	}
+	if err != nil {
+		// keep the queries we have
+		proxy_logger.WarnLog.Printf("failed to reload query allowlist: %v", err)
+		return
+	}
+	proxy_logger.InfoLog.Printf("reloaded %d approved queries from %s", len(a.queries), a.path)
+}
+
+// Must be called with the lock held or before the allowlist is shared.
+func (a *QueryAllowlist) load() error {
+	fingerprint, err := a.files()
+	if err != nil {
+		return err
+	}
+	queries, err := readQueries(a.path)
+	if err != nil {
+		return fmt.Errorf("%s: %v", a.path, err)
+	}
+
+	hashes := make(map[string]string, len(queries))
+	for id, query := range queries {
+		if strings.TrimSpace(query) == "" {
+			return fmt.Errorf("%s: query %q is empty", a.path, id)
+		}
+		hashes[QueryHash(query)] = id
+	}
+	a.queries = queries
+	a.hashes = hashes
+	a.fingerprint = fingerprint
+	return nil
+}
+
+// Names, sizes and modification times of the allowlist's files.
+func (a *QueryAllowlist) files() (string, error) {
+	info, err := os.Stat(a.path)
+	if err != nil {
+		return "", err
+	}
+	infos := []os.FileInfo{info}
+	if info.IsDir() {
+		infos, err = ioutil.ReadDir(a.path)
+		if err != nil {
+			return "", err
+		}
+	}
+
+	var fingerprint strings.Builder
+	for _, info := range infos {
+		fmt.Fprintf(&fingerprint, "%s %d %d\n", info.Name(), info.Size(), info.ModTime().UnixNano())
+	}
+	return fingerprint.String(), nil
+}
+
+func readQueries(path string) (map[string]string, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	if !info.IsDir() {
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		queries := make(map[string]string)
+		err = yaml.Unmarshal(data, &queries)
+		return queries, err
+	}
+
+	infos, err := ioutil.ReadDir(path)
+	if err != nil {
+		return nil, err
+	}
+	queries := make(map[string]string)
+	for _, info := range infos {
+		if info.IsDir() || filepath.Ext(info.Name()) != QUERY_FILE_EXT {
+			continue
+		}
+		data, err := ioutil.ReadFile(filepath.Join(path, info.Name()))
+		if err != nil {
+			return nil, err
+		}
+		queries[strings.TrimSuffix(info.Name(), QUERY_FILE_EXT)] = string(data)
+	}
+	return queries, nil
+}
+
+// Hash identifying a query regardless of its formatting, see
+// cypher.Normalize.
+func QueryHash(query string) string {
+	sum := sha256.Sum256([]byte(cypher.Normalize(query)))
+	return hex.EncodeToString(sum[:])
+}
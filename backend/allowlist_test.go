@@ -0,0 +1,97 @@
+/*
+Copyright (c) 2021 Memgraph Ltd. [https://memgraph.com]
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package backend
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/memgraph/bolt-proxy/config"
+)
+
+func TestQueryHash(t *testing.T) {
+	if QueryHash("RETURN 1") != QueryHash("RETURN  1; // one") || QueryHash("RETURN 1") == QueryHash("RETURN 2") {
+		t.Fatal("expected hashes of the normalized queries")
+	}
+}
+
+func TestQueryAllowlist(t *testing.T) {
+	dir, err := ioutil.TempDir("", "bolt-proxy-allowlist")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	path := filepath.Join(dir, "queries.yaml")
+	write := func(path, content string) {
+		if err := ioutil.WriteFile(path, []byte(content), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write(path, `
+person_by_id: |
+  MATCH (p:Person {id: $id})
+  RETURN p
+count: MATCH (n) RETURN count(n)
+`)
+
+	allowlist, err := NewQueryAllowlist(config.QueryAllowlist{Path: path})
+	if err != nil {
+		t.Fatal(err)
+	}
+	query, err := allowlist.Resolve("", "person_by_id")
+	if err != nil || query != "MATCH (p:Person {id: $id})\nRETURN p\n" {
+		t.Fatalf("expected the query with the id, got %q, %v", query, err)
+	}
+	query, err = allowlist.Resolve("MATCH (p:Person {id: $id}) RETURN p;", "")
+	if err != nil || query != "MATCH (p:Person {id: $id}) RETURN p;" {
+		t.Fatalf("expected the approved query to be kept, got %q, %v", query, err)
+	}
+	for _, refused := range [][2]string{
+		{"MATCH (n) DETACH DELETE n", ""},
+		{"", "delete_all"},
+	} {
+		if _, err := allowlist.Resolve(refused[0], refused[1]); err == nil {
+			t.Errorf("expected %q to be refused", refused)
+		}
+	}
+
+	// a directory of query files, picked up when it changes
+	queries := filepath.Join(dir, "queries")
+	if err := os.Mkdir(queries, 0700); err != nil {
+		t.Fatal(err)
+	}
+	write(filepath.Join(queries, "count.cypher"), "MATCH (n) RETURN count(n)")
+	write(filepath.Join(queries, "README.md"), "not a query")
+	allowlist, err = NewQueryAllowlist(config.QueryAllowlist{Path: queries})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(allowlist.queries) != 1 {
+		t.Fatalf("expected only the query file to be loaded, got %v", allowlist.queries)
+	}
+	allowlist.checkInterval = 0
+	write(filepath.Join(queries, "labels.cypher"), "CALL db.labels() YIELD label RETURN label")
+	if _, err := allowlist.Resolve("", "labels"); err != nil {
+		t.Fatalf("expected the new query to be picked up: %v", err)
+	}
+
+	if allowlist, err := NewQueryAllowlist(config.QueryAllowlist{}); allowlist != nil || err != nil {
+		t.Fatalf("expected no allowlist by default, got %v, %v", allowlist, err)
+	}
+}
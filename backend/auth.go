@@ -17,113 +17,124 @@ limitations under the License.
 package backend
 
 import (
-	"context"
 	"errors"
-	"net/http"
-	"os"
-	"time"
+	"fmt"
 
-	"github.com/coreos/go-oidc/v3/oidc"
+	"github.com/memgraph/bolt-proxy/config"
 )
 
+// Checks the credentials a client sent and tells who the client is.
+// Schemes an Authenticator doesn't handle are rejected.
 type Authenticator interface {
-	Authenticate(authData map[string]interface{}) error
+	Authenticate(token *AuthToken) (*Identity, error)
 }
 
-type BasicAuth struct {
-	url string
-}
-
-type AADTokenAuth struct {
-	provider string
-	clientID string
-}
-
-func NewAuth() (Authenticator, error) {
-	authMethod := os.Getenv("AUTH_METHOD")
-
-	switch authMethod {
-	case "BASIC_AUTH":
-		authURL := os.Getenv("BASIC_AUTH_URL")
-		if authURL == "" {
-			return nil, errors.New("BASIC_AUTH_URL must be set when using BASIC_AUTH")
-		}
-
-		return &BasicAuth{
-			url: authURL,
-		}, nil
-	case "AAD_TOKEN_AUTH":
-		clientID := os.Getenv("AAD_TOKEN_CLIENT_ID")
-		provider := os.Getenv("AAD_TOKEN_PROVIDER")
-		if clientID == "" || provider == "" {
-			return nil, errors.New("AAD_TOKEN_CLIENT_ID and AAD_TOKEN_PROVIDER must be set when using AAD_TOKEN_AUTH")
+// Build the Authenticator for the configured auth method. If a separate
+// bearer method is configured, bearer tokens are routed to it and all
+// other schemes to the main method. Decisions are cached if the cache is
+// enabled, and failures are counted towards lockouts, cached or not, if
+// lockout is enabled. Returns a nil Authenticator if the proxy should not
+// authenticate clients itself.
+func NewAuth(conf config.Auth) (Authenticator, error) {
+	auth, err := newRoutedAuth(conf)
+	if err != nil || auth == nil {
+		return auth, err
+	}
+	if conf.Cache.Enabled() {
+		auth, err = NewCachedAuth(auth, conf.Cache)
+		if err != nil {
+			return nil, err
 		}
-
-		return &AADTokenAuth{
-			provider: provider,
-			clientID: clientID,
-		}, nil
-	default:
-		return nil, nil
 	}
+	if conf.Lockout.Enabled() {
+		auth = NewLockoutAuth(auth, conf.Lockout)
+	}
+	return auth, nil
 }
 
-func (auth *BasicAuth) Authenticate(authData map[string]interface{}) error {
-	principal, creds, err := getCredentials(authData)
+func newRoutedAuth(conf config.Auth) (Authenticator, error) {
+	auth, err := newMethodAuth(conf, conf.Method)
 	if err != nil {
-		return err
-	}
-
-	client := &http.Client{
-		Timeout: time.Second * 5,
+		return nil, err
 	}
-	req, err := http.NewRequest("GET", auth.url, nil)
-	if err != nil {
-		return err
+	if conf.BearerMethod == "" || conf.BearerMethod == conf.Method {
+		return auth, nil
 	}
-	req.SetBasicAuth(principal, creds)
-	rawResp, err := client.Do(req)
+
+	bearer, err := newMethodAuth(conf, conf.BearerMethod)
 	if err != nil {
-		return err
-	}
-	if rawResp.StatusCode != 200 {
-		return errors.New("unauthorized creds")
+		return nil, err
 	}
-
-	return nil
+	router := NewSchemeRouter(auth)
+	router.Route(SCHEME_BEARER, bearer)
+	return router, nil
 }
 
-func (auth *AADTokenAuth) Authenticate(authData map[string]interface{}) error {
-	_, jwtString, err := getCredentials(authData)
-	if err != nil {
-		return err
-	}
+func newMethodAuth(conf config.Auth, method string) (Authenticator, error) {
+	switch method {
+	case config.AUTH_BASIC:
+		auth, err := NewBasicAuth(conf.Basic)
+		if err != nil {
+			return nil, err
+		}
+		return auth, nil
+	case config.AUTH_AAD_TOKEN:
+		if conf.AADToken.ClientID == "" || conf.AADToken.Provider == "" {
+			return nil, errors.New("aad token client id and provider must be set when using aad token auth")
+		}
 
-	ctx := context.Background()
-	provider, err := oidc.NewProvider(ctx, auth.provider)
-	if err != nil {
-		return err
+		// Azure AD is just an OIDC issuer whose tokens are meant for the
+		// client id
+		return jwtAuthenticator(config.JWTAuth{
+			Issuers:     []config.JWTIssuer{{Issuer: conf.AADToken.Provider}},
+			Audiences:   []string{conf.AADToken.ClientID},
+			JWKSRefresh: conf.AADToken.JWKSRefresh,
+		})
+	case config.AUTH_JWT:
+		return jwtAuthenticator(conf.JWT)
+	case config.AUTH_LDAP:
+		auth, err := NewLDAPAuth(conf.LDAP)
+		if err != nil {
+			return nil, err
+		}
+		return auth, nil
+	case config.AUTH_HTPASSWD:
+		auth, err := NewHtpasswdAuth(conf.Htpasswd)
+		if err != nil {
+			return nil, err
+		}
+		return auth, nil
+	case config.AUTH_CERT:
+		return ClientCertAuth{}, nil
+	case config.AUTH_CHAIN:
+		auth, err := NewAuthChain(conf)
+		if err != nil {
+			return nil, err
+		}
+		return auth, nil
+	case config.AUTH_NONE, "":
+		return nil, nil
+	default:
+		return nil, fmt.Errorf("unknown auth method %q", method)
 	}
+}
 
-	var verifier = provider.Verifier(&oidc.Config{ClientID: auth.clientID})
-
-	// Parse and verify ID Token payload.
-	_, err = verifier.Verify(ctx, jwtString)
+// Avoid wrapping a nil *JWTAuth into a non-nil Authenticator.
+func jwtAuthenticator(conf config.JWTAuth) (Authenticator, error) {
+	auth, err := NewJWTAuth(conf)
 	if err != nil {
-		return err
+		return nil, err
 	}
-	return nil
+	return auth, nil
 }
 
-func getCredentials(authData map[string]interface{}) (string, string, error) {
-	principal, ok := authData["principal"].(string)
-	if !ok {
-		return "", "", errors.New("no principal")
-	}
-	creds, ok := authData["credentials"].(string)
-	if !ok {
-		return "", "", errors.New("no credentials")
-	}
+// Accepts clients that presented a verified TLS certificate, whatever
+// their credentials. The principal is the certificate's.
+type ClientCertAuth struct{}
 
-	return principal, creds, nil
+func (ClientCertAuth) Authenticate(token *AuthToken) (*Identity, error) {
+	if token.Client.CertPrincipal == "" {
+		return nil, errors.New("no verified client certificate")
+	}
+	return &Identity{Principal: token.Client.CertPrincipal}, nil
 }
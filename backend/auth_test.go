@@ -17,22 +17,35 @@ limitations under the License.
 package backend
 
 import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/tls"
+	"encoding/json"
+	"net"
 	"net/http"
 	"net/http/httptest"
+	"reflect"
+	"sync"
 	"testing"
+	"time"
+
+	"github.com/memgraph/bolt-proxy/config"
+	"gopkg.in/square/go-jose.v2"
+	"gopkg.in/square/go-jose.v2/jwt"
 )
 
 func TestBasicAuth(t *testing.T) {
-	authData := map[string]interface{}{
-		"principal":   "user",
-		"credentials": "creds",
+	authData := &AuthToken{
+		Scheme:      SCHEME_BASIC,
+		Principal:   "user",
+		Credentials: "creds",
 	}
 	ts := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
 		username, credentials, ok := r.BasicAuth()
 		if !ok {
 			t.Fatalf("auth ok %v", ok)
 		}
-		if username != authData["principal"] || credentials != authData["credentials"] {
+		if username != authData.Principal || credentials != authData.Credentials {
 			t.Fatalf("given user and creds do not match: %v, %v", username, credentials)
 		}
 		rw.WriteHeader(http.StatusOK)
@@ -42,16 +55,25 @@ func TestBasicAuth(t *testing.T) {
 	basicAuth := BasicAuth{
 		url: ts.URL,
 	}
-	err := basicAuth.Authenticate(authData)
+	identity, err := basicAuth.Authenticate(authData)
 	if err != nil {
 		t.Fatalf("user not authenticated: %v", err)
 	}
+	if identity.Principal != "user" {
+		t.Fatalf("unexpected principal %q", identity.Principal)
+	}
+
+	_, err = basicAuth.Authenticate(&AuthToken{Scheme: SCHEME_BEARER, Credentials: "token"})
+	if err == nil {
+		t.Fatal("expected bearer token to be rejected by basic auth")
+	}
 }
 
 func TestBasicAuthWrongCreds(t *testing.T) {
-	authData := map[string]interface{}{
-		"principal":   "user",
-		"credentials": "creds",
+	authData := &AuthToken{
+		Scheme:      SCHEME_BASIC,
+		Principal:   "user",
+		Credentials: "creds",
 	}
 	ts := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
 		rw.WriteHeader(http.StatusUnauthorized)
@@ -61,8 +83,427 @@ func TestBasicAuthWrongCreds(t *testing.T) {
 	basicAuth := BasicAuth{
 		url: ts.URL,
 	}
-	err := basicAuth.Authenticate(authData)
+	_, err := basicAuth.Authenticate(authData)
 	if err == nil {
 		t.Fatalf("expecting err msg")
 	}
 }
+
+func TestBasicAuthWebhook(t *testing.T) {
+	var request webhookRequest
+	ts := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost || r.Header.Get("X-Api-Key") != "proxy-key" {
+			t.Errorf("unexpected %s request with headers %v", r.Method, r.Header)
+		}
+		if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+			t.Error(err)
+		}
+		if request.Credentials != "creds" {
+			rw.WriteHeader(http.StatusForbidden)
+			return
+		}
+		rw.Header().Set("Content-Type", "application/json; charset=utf-8")
+		_, _ = rw.Write([]byte(`{
+			"roles": ["analyst", "reader"],
+			"claims": {"team": "data"},
+			"backend": {"user": "analysts", "password": "analyst-secret"},
+			"session_ttl": "90m"
+		}`))
+	}))
+	defer ts.Close()
+
+	auth, err := NewBasicAuth(config.BasicAuth{
+		URL:     ts.URL,
+		Method:  http.MethodPost,
+		Headers: map[string]string{"X-Api-Key": "proxy-key"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	token := basicToken("user", "creds")
+	token.UserAgent = "neo4j-go/4.2"
+	token.Client = ClientInfo{
+		RemoteAddr:    &net.TCPAddr{IP: net.IPv4(10, 0, 0, 7), Port: 51234},
+		TLS:           &tls.ConnectionState{Version: tls.VersionTLS13, CipherSuite: tls.TLS_AES_128_GCM_SHA256, ServerName: "memgraph.local"},
+		CertPrincipal: "service-a",
+	}
+	identity, err := auth.Authenticate(token)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := webhookRequest{
+		Scheme:      SCHEME_BASIC,
+		Principal:   "user",
+		Credentials: "creds",
+		Client: webhookClient{
+			Address:   "10.0.0.7:51234",
+			UserAgent: "neo4j-go/4.2",
+			TLS: &webhookTLS{
+				Version:              "1.3",
+				CipherSuite:          "TLS_AES_128_GCM_SHA256",
+				ServerName:           "memgraph.local",
+				CertificatePrincipal: "service-a",
+			},
+		},
+	}
+	if !reflect.DeepEqual(request, expected) {
+		t.Fatalf("unexpected webhook request %#v", request)
+	}
+	if identity.Principal != "user" || identity.SessionTTL != 90*time.Minute ||
+		identity.Backend == nil || identity.Backend.User != "analysts" || identity.Backend.Password != "analyst-secret" {
+		t.Fatalf("unexpected identity %#v", identity)
+	}
+	if !reflect.DeepEqual(identity.Claims, map[string]interface{}{
+		"team":  "data",
+		"roles": []interface{}{"analyst", "reader"},
+	}) {
+		t.Fatalf("unexpected claims %#v", identity.Claims)
+	}
+
+	if _, err := auth.Authenticate(basicToken("user", "wrong")); err == nil {
+		t.Fatal("expected rejected credentials to fail")
+	}
+}
+
+func TestBasicAuthWebhookRetries(t *testing.T) {
+	var mu sync.Mutex
+	failures, calls := 0, 0
+	ts := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		calls++
+		if failures > 0 {
+			failures--
+			rw.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		if _, credentials, _ := r.BasicAuth(); credentials != "creds" {
+			rw.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		rw.Header().Set("Content-Type", "application/json")
+		_, _ = rw.Write([]byte(`{"session_ttl": 30}`))
+	}))
+	defer ts.Close()
+
+	auth, err := NewBasicAuth(config.BasicAuth{URL: ts.URL, Retries: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	authenticate := func(failing int, credentials string) (*Identity, int, error) {
+		mu.Lock()
+		failures, calls = failing, 0
+		mu.Unlock()
+		identity, err := auth.Authenticate(basicToken("user", credentials))
+		mu.Lock()
+		defer mu.Unlock()
+		return identity, calls, err
+	}
+
+	identity, n, err := authenticate(2, "creds")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 3 || identity.SessionTTL != 30*time.Second {
+		t.Fatalf("expected 3 calls and a 30s session, got %d and %v", n, identity.SessionTTL)
+	}
+	if _, n, err = authenticate(3, "creds"); err == nil || n != 3 {
+		t.Fatalf("expected failure after 3 calls, got %d calls and %v", n, err)
+	}
+	if _, n, err = authenticate(0, "wrong"); err == nil || n != 1 {
+		t.Fatalf("expected rejection without retries, got %d calls and %v", n, err)
+	}
+}
+
+// Stand-in for an OIDC provider, counting discovery and JWKS requests.
+type testIdP struct {
+	*httptest.Server
+	t *testing.T
+
+	mu        sync.Mutex
+	key       *rsa.PrivateKey
+	kid       string
+	down      bool
+	discovery int
+	jwks      int
+}
+
+func newTestIdP(t *testing.T) *testIdP {
+	idp := &testIdP{t: t}
+	idp.rotate("key-1")
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		idp.mu.Lock()
+		idp.discovery++
+		down := idp.down
+		idp.mu.Unlock()
+		if down {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{
+			"issuer":                                idp.URL,
+			"jwks_uri":                              idp.URL + "/keys",
+			"id_token_signing_alg_values_supported": []string{"RS256"},
+		})
+	})
+	mux.HandleFunc("/keys", func(w http.ResponseWriter, r *http.Request) {
+		idp.mu.Lock()
+		defer idp.mu.Unlock()
+		idp.jwks++
+		_ = json.NewEncoder(w).Encode(jose.JSONWebKeySet{Keys: []jose.JSONWebKey{{
+			Key:       &idp.key.PublicKey,
+			KeyID:     idp.kid,
+			Algorithm: "RS256",
+			Use:       "sig",
+		}}})
+	})
+	idp.Server = httptest.NewServer(mux)
+	t.Cleanup(idp.Close)
+	return idp
+}
+
+// Replace the signing key, as the IdP does on key rotation.
+func (idp *testIdP) rotate(kid string) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		idp.t.Fatal(err)
+	}
+	idp.mu.Lock()
+	idp.key, idp.kid = key, kid
+	idp.mu.Unlock()
+}
+
+func (idp *testIdP) token(audience string) string {
+	return idp.tokenWithClaims(audience, nil)
+}
+
+func (idp *testIdP) tokenWithClaims(audience string, extra map[string]interface{}) string {
+	idp.mu.Lock()
+	defer idp.mu.Unlock()
+
+	signer, err := jose.NewSigner(jose.SigningKey{Algorithm: jose.RS256, Key: idp.key},
+		(&jose.SignerOptions{}).WithType("JWT").WithHeader("kid", idp.kid))
+	if err != nil {
+		idp.t.Fatal(err)
+	}
+	token, err := jwt.Signed(signer).Claims(jwt.Claims{
+		Issuer:   idp.URL,
+		Subject:  "user",
+		Audience: jwt.Audience{audience},
+		Expiry:   jwt.NewNumericDate(time.Now().Add(time.Hour)),
+		IssuedAt: jwt.NewNumericDate(time.Now()),
+	}).Claims(extra).CompactSerialize()
+	if err != nil {
+		idp.t.Fatal(err)
+	}
+	return token
+}
+
+func (idp *testIdP) counts() (discovery, jwks int) {
+	idp.mu.Lock()
+	defer idp.mu.Unlock()
+	return idp.discovery, idp.jwks
+}
+
+func newTestAADAuth(t *testing.T, idp *testIdP, jwksRefresh time.Duration) *JWTAuth {
+	auth, err := NewAuth(config.Auth{
+		Method: config.AUTH_AAD_TOKEN,
+		AADToken: config.AADTokenAuth{
+			ClientID:    "client",
+			Provider:    idp.URL,
+			JWKSRefresh: config.Duration{Duration: jwksRefresh},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	aad := auth.(*JWTAuth)
+	t.Cleanup(aad.Close)
+	return aad
+}
+
+func tokenAuthData(token string) *AuthToken {
+	return &AuthToken{
+		Scheme:      SCHEME_BEARER,
+		Credentials: token,
+	}
+}
+
+func TestAADTokenAuthCachesDiscoveryAndKeys(t *testing.T) {
+	idp := newTestIdP(t)
+	auth := newTestAADAuth(t, idp, time.Hour)
+
+	for i := 0; i < 5; i++ {
+		if _, err := auth.Authenticate(tokenAuthData(idp.token("client"))); err != nil {
+			t.Fatalf("login %d failed: %v", i, err)
+		}
+	}
+	if discovery, jwks := idp.counts(); discovery != 1 || jwks != 1 {
+		t.Fatalf("expected one discovery and one jwks fetch, got %d and %d", discovery, jwks)
+	}
+
+	if _, err := auth.Authenticate(tokenAuthData(idp.token("someone-else"))); err == nil {
+		t.Fatal("expected token for another audience to be rejected")
+	}
+}
+
+func TestAADTokenAuthKeyRotation(t *testing.T) {
+	idp := newTestIdP(t)
+	auth := newTestAADAuth(t, idp, time.Hour)
+
+	if _, err := auth.Authenticate(tokenAuthData(idp.token("client"))); err != nil {
+		t.Fatal(err)
+	}
+
+	// a token signed with an unknown kid triggers a refetch, but only once
+	// per minimum refresh interval
+	idp.rotate("key-2")
+	if _, err := auth.Authenticate(tokenAuthData(idp.token("client"))); err == nil {
+		t.Fatal("expected refetch to be rate limited")
+	}
+	auth.issuers[idp.URL].keys.minRefresh = 0
+	if _, err := auth.Authenticate(tokenAuthData(idp.token("client"))); err != nil {
+		t.Fatalf("login after key rotation failed: %v", err)
+	}
+	if discovery, jwks := idp.counts(); discovery != 1 || jwks != 2 {
+		t.Fatalf("expected one discovery and two jwks fetches, got %d and %d", discovery, jwks)
+	}
+}
+
+func TestAADTokenAuthBackgroundRefresh(t *testing.T) {
+	idp := newTestIdP(t)
+	auth := newTestAADAuth(t, idp, 20*time.Millisecond)
+
+	if _, err := auth.Authenticate(tokenAuthData(idp.token("client"))); err != nil {
+		t.Fatal(err)
+	}
+	idp.rotate("key-2")
+
+	// the new key gets picked up without any login asking for it
+	deadline := time.Now().Add(5 * time.Second)
+	for len(auth.issuers[idp.URL].keys.lookup("key-2")) == 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("rotated key was not fetched in the background")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if _, err := auth.Authenticate(tokenAuthData(idp.token("client"))); err != nil {
+		t.Fatalf("login after background refresh failed: %v", err)
+	}
+}
+
+func TestAADTokenAuthRetriesDiscovery(t *testing.T) {
+	idp := newTestIdP(t)
+	auth := newTestAADAuth(t, idp, time.Hour)
+	token := idp.token("client")
+
+	idp.mu.Lock()
+	idp.down = true
+	idp.mu.Unlock()
+	if _, err := auth.Authenticate(tokenAuthData(token)); err == nil {
+		t.Fatal("expected failed discovery to fail the login")
+	}
+
+	// failures aren't cached, the next login discovers again
+	idp.mu.Lock()
+	idp.down = false
+	idp.mu.Unlock()
+	if _, err := auth.Authenticate(tokenAuthData(token)); err != nil {
+		t.Fatal(err)
+	}
+	if discovery, _ := idp.counts(); discovery != 2 {
+		t.Fatalf("expected two discovery requests, got %d", discovery)
+	}
+}
+
+func TestNewAuthToken(t *testing.T) {
+	tests := []struct {
+		authData map[string]interface{}
+		scheme   string
+	}{
+		{map[string]interface{}{"principal": "user", "credentials": "pw"}, SCHEME_BASIC},
+		{map[string]interface{}{}, SCHEME_NONE},
+		{map[string]interface{}{"scheme": "bearer", "credentials": "token"}, SCHEME_BEARER},
+		{map[string]interface{}{"scheme": "custom", "principal": "user", "parameters": map[string]interface{}{}}, "custom"},
+	}
+	for _, test := range tests {
+		token, err := NewAuthToken(test.authData)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if token.Scheme != test.scheme {
+			t.Fatalf("expected scheme %q for %v, got %q", test.scheme, test.authData, token.Scheme)
+		}
+	}
+
+	token, _ := NewAuthToken(tests[3].authData)
+	if _, found := token.Parameters["parameters"]; !found {
+		t.Fatal("expected custom scheme parameters to be kept")
+	}
+	if _, err := NewAuthToken(map[string]interface{}{"credentials": 42}); err == nil {
+		t.Fatal("expected non-string credentials to be rejected")
+	}
+}
+
+func TestBearerMethodRouting(t *testing.T) {
+	idp := newTestIdP(t)
+	passwords := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		if user, password, _ := r.BasicAuth(); user != "user" || password != "secret" {
+			rw.WriteHeader(http.StatusUnauthorized)
+		}
+	}))
+	defer passwords.Close()
+
+	auth, err := NewAuth(config.Auth{
+		Method:       config.AUTH_BASIC,
+		BearerMethod: config.AUTH_JWT,
+		Basic:        config.BasicAuth{URL: passwords.URL},
+		JWT: config.JWTAuth{
+			Issuers:   []config.JWTIssuer{{Issuer: idp.URL}},
+			Audiences: []string{"graph"},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	identity, err := auth.Authenticate(tokenAuthData(idp.token("graph")))
+	if err != nil {
+		t.Fatalf("bearer token rejected: %v", err)
+	}
+	if identity.Principal != "user" {
+		t.Fatalf("expected the token subject as principal, got %q", identity.Principal)
+	}
+	_, err = auth.Authenticate(&AuthToken{Scheme: SCHEME_BASIC, Principal: "user", Credentials: "secret"})
+	if err != nil {
+		t.Fatalf("basic credentials rejected: %v", err)
+	}
+	// the token isn't a password, and basic auth doesn't know kerberos
+	_, err = auth.Authenticate(&AuthToken{Scheme: SCHEME_BASIC, Principal: "user", Credentials: idp.token("graph")})
+	if err == nil {
+		t.Fatal("expected token sent as basic credentials to go to basic auth")
+	}
+	_, err = auth.Authenticate(&AuthToken{Scheme: SCHEME_KERBEROS, Credentials: "ticket"})
+	if err == nil {
+		t.Fatal("expected unsupported scheme to be rejected")
+	}
+
+	// bearer only
+	auth, err = NewAuth(config.Auth{
+		Method:       config.AUTH_NONE,
+		BearerMethod: config.AUTH_JWT,
+		JWT: config.JWTAuth{
+			Issuers:   []config.JWTIssuer{{Issuer: idp.URL}},
+			Audiences: []string{"graph"},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = auth.Authenticate(&AuthToken{Scheme: SCHEME_BASIC, Principal: "user", Credentials: "secret"}); err == nil {
+		t.Fatal("expected basic credentials to be rejected without a basic method")
+	}
+}
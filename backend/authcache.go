@@ -0,0 +1,204 @@
+/*
+Copyright (c) 2021 Memgraph Ltd. [https://memgraph.com]
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package backend
+
+import (
+	"container/list"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/memgraph/bolt-proxy/config"
+	"github.com/memgraph/bolt-proxy/metrics"
+)
+
+var (
+	authCacheRequests = metrics.NewCounter("bolt_proxy_auth_cache_requests_total",
+		"Authentication decisions looked up in the cache, by result (hit or miss).", "result")
+	authCacheEvictions = metrics.NewCounter("bolt_proxy_auth_cache_evictions_total",
+		"Cached authentication decisions dropped, by reason (expired or size).", "reason")
+	authCacheEntries = metrics.NewGauge("bolt_proxy_auth_cache_entries",
+		"Authentication decisions currently cached.")
+)
+
+type authCacheEntry struct {
+	key      string
+	identity *Identity
+	err      error
+	expires  time.Time
+}
+
+// An authentication in progress, shared by concurrent clients sending the
+// same credentials.
+type authCall struct {
+	done     chan struct{}
+	identity *Identity
+	err      error
+}
+
+// Caches the decisions of another Authenticator, see config.AuthCache.
+// Safe for concurrent use; concurrent misses for the same credentials
+// are authenticated only once.
+type CachedAuth struct {
+	auth        Authenticator
+	ttl         time.Duration
+	negativeTTL time.Duration
+	maxEntries  int
+	salt        []byte
+	now         func() time.Time
+
+	mu       sync.Mutex
+	entries  map[string]*list.Element
+	lru      *list.List
+	inflight map[string]*authCall
+}
+
+func NewCachedAuth(auth Authenticator, conf config.AuthCache) (*CachedAuth, error) {
+	salt := make([]byte, sha256.Size)
+	_, err := rand.Read(salt)
+	if err != nil {
+		return nil, err
+	}
+	maxEntries := conf.MaxEntries
+	if maxEntries <= 0 {
+		maxEntries = config.DEFAULT_AUTH_CACHE_SIZE
+	}
+
+	return &CachedAuth{
+		auth:        auth,
+		ttl:         conf.TTL.Duration,
+		negativeTTL: conf.NegativeTTL.Duration,
+		maxEntries:  maxEntries,
+		salt:        salt,
+		now:         time.Now,
+		entries:     make(map[string]*list.Element),
+		lru:         list.New(),
+		inflight:    make(map[string]*authCall),
+	}, nil
+}
+
+func (c *CachedAuth) Authenticate(token *AuthToken) (*Identity, error) {
+	key := c.key(token)
+
+	c.mu.Lock()
+	if entry := c.lookup(key); entry != nil {
+		c.mu.Unlock()
+		authCacheRequests.Inc("hit")
+		return copyIdentity(entry.identity), entry.err
+	}
+	if call, found := c.inflight[key]; found {
+		c.mu.Unlock()
+		<-call.done
+		authCacheRequests.Inc("hit")
+		return copyIdentity(call.identity), call.err
+	}
+	call := &authCall{done: make(chan struct{})}
+	c.inflight[key] = call
+	c.mu.Unlock()
+	authCacheRequests.Inc("miss")
+
+	call.identity, call.err = c.auth.Authenticate(token)
+
+	c.mu.Lock()
+	delete(c.inflight, key)
+	c.store(key, call.identity, call.err)
+	c.mu.Unlock()
+	close(call.done)
+
+	return copyIdentity(call.identity), call.err
+}
+
+// Salted, so the cache never holds anything a password could be guessed
+// from offline. Covers everything an authenticator may decide on, except
+// the client's port, which changes with every connection.
+func (c *CachedAuth) key(token *AuthToken) string {
+	mac := hmac.New(sha256.New, c.salt)
+	fmt.Fprintf(mac, "%q %q %q %q %q %v %q %q", token.Scheme, token.Principal,
+		token.Credentials, token.Realm, token.UserAgent, token.Parameters,
+		token.Client.CertPrincipal, token.Client.Host())
+	return string(mac.Sum(nil))
+}
+
+// Must be called with the lock held.
+func (c *CachedAuth) lookup(key string) *authCacheEntry {
+	element, found := c.entries[key]
+	if !found {
+		return nil
+	}
+	entry := element.Value.(*authCacheEntry)
+	if !c.now().Before(entry.expires) {
+		c.remove(element, "expired")
+		return nil
+	}
+	c.lru.MoveToFront(element)
+	return entry
+}
+
+// Must be called with the lock held.
+func (c *CachedAuth) store(key string, identity *Identity, err error) {
+	ttl := c.ttl
+	if err != nil {
+		ttl = c.negativeTTL
+	}
+	if ttl <= 0 {
+		return
+	}
+	expires := c.now().Add(ttl)
+	if tokenExpiry, found := identity.Expiry(); found && tokenExpiry.Before(expires) {
+		expires = tokenExpiry
+	}
+	if !expires.After(c.now()) {
+		return
+	}
+
+	if element, found := c.entries[key]; found {
+		c.remove(element, "expired")
+	}
+	for c.lru.Len() >= c.maxEntries {
+		oldest := c.lru.Back()
+		reason := "size"
+		if !c.now().Before(oldest.Value.(*authCacheEntry).expires) {
+			reason = "expired"
+		}
+		c.remove(oldest, reason)
+	}
+
+	entry := &authCacheEntry{key: key, identity: identity, err: err, expires: expires}
+	c.entries[key] = c.lru.PushFront(entry)
+	authCacheEntries.Add(1)
+}
+
+// Must be called with the lock held.
+func (c *CachedAuth) remove(element *list.Element, reason string) {
+	c.lru.Remove(element)
+	delete(c.entries, element.Value.(*authCacheEntry).key)
+	authCacheEvictions.Inc(reason)
+	authCacheEntries.Add(-1)
+}
+
+// Callers may adjust the identity they get, e.g. its principal, so they
+// never get the cached one.
+func copyIdentity(identity *Identity) *Identity {
+	if identity == nil {
+		return nil
+	}
+	copied := *identity
+	return &copied
+}
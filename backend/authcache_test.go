@@ -0,0 +1,175 @@
+/*
+Copyright (c) 2021 Memgraph Ltd. [https://memgraph.com]
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package backend
+
+import (
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/memgraph/bolt-proxy/config"
+)
+
+// Accepts the password "secret", counting calls. If release is set, every
+// call waits for it to be closed.
+type countingAuth struct {
+	calls   int32
+	claims  map[string]interface{}
+	release chan struct{}
+}
+
+func (a *countingAuth) Authenticate(token *AuthToken) (*Identity, error) {
+	atomic.AddInt32(&a.calls, 1)
+	if a.release != nil {
+		<-a.release
+	}
+	if token.Credentials != "secret" {
+		return nil, errors.New("unauthorized creds")
+	}
+	return &Identity{Principal: token.Principal, Claims: a.claims}, nil
+}
+
+func (a *countingAuth) count() int {
+	return int(atomic.LoadInt32(&a.calls))
+}
+
+func newTestCache(t *testing.T, auth Authenticator, conf config.AuthCache) (*CachedAuth, *time.Time) {
+	cache, err := NewCachedAuth(auth, conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+	cache.now = func() time.Time { return now }
+	return cache, &now
+}
+
+func TestCachedAuth(t *testing.T) {
+	auth := &countingAuth{}
+	cache, now := newTestCache(t, auth, config.AuthCache{TTL: config.Duration{Duration: time.Minute}, MaxEntries: 10})
+	hits, expired := authCacheRequests.Value("hit"), authCacheEvictions.Value("expired")
+
+	for i := 0; i < 3; i++ {
+		identity, err := cache.Authenticate(basicToken("alice", "secret"))
+		if err != nil || identity.Principal != "alice" {
+			t.Fatalf("unexpected result %v, %v", identity, err)
+		}
+		// callers can't change cached identities
+		identity.Principal = "mallory"
+	}
+	if auth.count() != 1 || authCacheRequests.Value("hit")-hits != 2 {
+		t.Fatalf("expected 1 call and 2 hits, got %d calls", auth.count())
+	}
+
+	// other credentials are a different entry, failures aren't cached
+	for i := 0; i < 2; i++ {
+		if _, err := cache.Authenticate(basicToken("alice", "wrong")); err == nil {
+			t.Fatal("expected wrong password to be rejected")
+		}
+	}
+	if auth.count() != 3 {
+		t.Fatalf("expected failures not to be cached, got %d calls", auth.count())
+	}
+
+	*now = now.Add(time.Minute)
+	if _, err := cache.Authenticate(basicToken("alice", "secret")); err != nil {
+		t.Fatal(err)
+	}
+	if auth.count() != 4 || authCacheEvictions.Value("expired")-expired != 1 {
+		t.Fatalf("expected the entry to expire, got %d calls", auth.count())
+	}
+}
+
+func TestCachedAuthNegativeAndExpiry(t *testing.T) {
+	auth := &countingAuth{}
+	cache, now := newTestCache(t, auth, config.AuthCache{NegativeTTL: config.Duration{Duration: 10 * time.Second}, MaxEntries: 10})
+
+	for i := 0; i < 2; i++ {
+		if _, err := cache.Authenticate(basicToken("alice", "wrong")); err == nil {
+			t.Fatal("expected wrong password to be rejected")
+		}
+		if _, err := cache.Authenticate(basicToken("alice", "secret")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if auth.count() != 3 {
+		t.Fatalf("expected only the failure to be cached, got %d calls", auth.count())
+	}
+
+	// tokens are cached until they expire at most
+	auth = &countingAuth{claims: map[string]interface{}{"exp": float64(now.Add(time.Minute).Unix())}}
+	cache, now = newTestCache(t, auth, config.AuthCache{TTL: config.Duration{Duration: time.Hour}, MaxEntries: 10})
+	for _, elapsed := range []time.Duration{0, 30 * time.Second, time.Minute} {
+		*now = now.Add(elapsed)
+		if _, err := cache.Authenticate(basicToken("alice", "secret")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if auth.count() != 2 {
+		t.Fatalf("expected the entry to expire with the token, got %d calls", auth.count())
+	}
+}
+
+func TestCachedAuthEviction(t *testing.T) {
+	auth := &countingAuth{}
+	cache, _ := newTestCache(t, auth, config.AuthCache{TTL: config.Duration{Duration: time.Minute}, MaxEntries: 2})
+	evicted := authCacheEvictions.Value("size")
+
+	for _, user := range []string{"alice", "bob", "alice", "carol", "alice", "bob"} {
+		if _, err := cache.Authenticate(basicToken(user, "secret")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// bob was least recently used when carol came in
+	if auth.count() != 4 || authCacheEvictions.Value("size")-evicted != 2 {
+		t.Fatalf("unexpected %d calls, %v evictions", auth.count(), authCacheEvictions.Value("size")-evicted)
+	}
+	if cache.lru.Len() != 2 || len(cache.entries) != 2 {
+		t.Fatalf("expected 2 entries, got %d", cache.lru.Len())
+	}
+}
+
+func TestCachedAuthConcurrentMisses(t *testing.T) {
+	auth := &countingAuth{release: make(chan struct{})}
+	cache, _ := newTestCache(t, auth, config.AuthCache{TTL: config.Duration{Duration: time.Minute}, MaxEntries: 10})
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 20)
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := cache.Authenticate(basicToken("alice", "secret"))
+			errs <- err
+		}()
+	}
+	// let the goroutines pile up on the first call
+	time.Sleep(50 * time.Millisecond)
+	close(auth.release)
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if auth.count() != 1 {
+		t.Fatalf("expected concurrent misses to share one call, got %d", auth.count())
+	}
+}
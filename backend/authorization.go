@@ -0,0 +1,148 @@
+/*
+Copyright (c) 2021 Memgraph Ltd. [https://memgraph.com]
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package backend
+
+import (
+	"errors"
+	"fmt"
+	"path"
+	"sort"
+
+	"github.com/memgraph/bolt-proxy/config"
+	"github.com/memgraph/bolt-proxy/cypher"
+)
+
+// How much each config.ACCESS_* level grants
+var accessLevels = map[string]int{
+	config.ACCESS_READ:  1,
+	config.ACCESS_WRITE: 2,
+	config.ACCESS_ADMIN: 3,
+}
+
+// Works out what clients may do from their roles, see
+// config.Authorization.
+type Authorizer struct {
+	roles        map[string]config.Role
+	roleClaims   []string
+	principals   map[string][]string
+	defaultRoles []string
+}
+
+// What a client may do, going by its roles.
+type Permissions struct {
+	Roles []string
+	// Access of the most permissive role, "" without any role
+	Access     string
+	procedures []string
+}
+
+// Returns nil if authorization is disabled, in which case clients may run
+// any query.
+func NewAuthorizer(conf config.Authorization) (*Authorizer, error) {
+	if !conf.Enabled() {
+		return nil, nil
+	}
+
+	roles := make(map[string]config.Role, len(conf.Roles))
+	for _, role := range conf.Roles {
+		if _, found := accessLevels[role.Access]; !found {
+			return nil, fmt.Errorf("role %q: unknown access %q", role.Name, role.Access)
+		}
+		for _, pattern := range role.Procedures {
+			if _, err := path.Match(pattern, ""); err != nil {
+				return nil, fmt.Errorf("role %q: procedure %q: %v", role.Name, pattern, err)
+			}
+		}
+		roles[role.Name] = role
+	}
+
+	return &Authorizer{
+		roles:        roles,
+		roleClaims:   conf.RoleClaims,
+		principals:   conf.Principals,
+		defaultRoles: conf.DefaultRoles,
+	}, nil
+}
+
+// Permissions of an authenticated client. Role names in its claims that
+// aren't defined, like unrelated LDAP groups, are ignored.
+func (a *Authorizer) Permissions(identity *Identity) *Permissions {
+	names := append([]string(nil), a.defaultRoles...)
+	if identity != nil {
+		names = append(names, a.principals[identity.Principal]...)
+		for _, claim := range a.roleClaims {
+			names = append(names, claimValues(identity.Claims, claim)...)
+		}
+	}
+
+	permissions := &Permissions{}
+	seen := make(map[string]bool, len(names))
+	for _, name := range names {
+		role, found := a.roles[name]
+		if !found || seen[name] {
+			continue
+		}
+		seen[name] = true
+		permissions.Roles = append(permissions.Roles, name)
+		if accessLevels[role.Access] > accessLevels[permissions.Access] {
+			permissions.Access = role.Access
+		}
+		permissions.procedures = append(permissions.procedures, role.Procedures...)
+	}
+	sort.Strings(permissions.Roles)
+	return permissions
+}
+
+// Whether the client may start a transaction at all.
+func (p *Permissions) AllowBegin() error {
+	if p.Access == "" {
+		return errors.New("no role grants access")
+	}
+	return nil
+}
+
+// Whether the client may run the query.
+func (p *Permissions) Allow(query string) error {
+	if err := p.AllowBegin(); err != nil {
+		return err
+	}
+	if p.Access == config.ACCESS_ADMIN {
+		return nil
+	}
+	info := cypher.Analyze(query)
+	if info.Admin {
+		return fmt.Errorf("%s access doesn't allow schema changes or administration", p.Access)
+	}
+	if info.Writes() && p.Access != config.ACCESS_WRITE {
+		return fmt.Errorf("%s access doesn't allow writes", p.Access)
+	}
+	for _, procedure := range info.Procedures {
+		if !p.allowsProcedure(procedure) {
+			return fmt.Errorf("calling %s is not allowed", procedure)
+		}
+	}
+	return nil
+}
+
+func (p *Permissions) allowsProcedure(name string) bool {
+	for _, pattern := range p.procedures {
+		if matched, _ := path.Match(pattern, name); matched {
+			return true
+		}
+	}
+	return false
+}
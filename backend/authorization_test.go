@@ -0,0 +1,93 @@
+/*
+Copyright (c) 2021 Memgraph Ltd. [https://memgraph.com]
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package backend
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/memgraph/bolt-proxy/config"
+)
+
+func TestAuthorizer(t *testing.T) {
+	authorizer, err := NewAuthorizer(config.Authorization{
+		Roles: []config.Role{
+			{Name: "analyst", Access: config.ACCESS_READ, Procedures: []string{"nxalg.*"}},
+			{Name: "editor", Access: config.ACCESS_WRITE},
+			{Name: "dba", Access: config.ACCESS_ADMIN},
+		},
+		RoleClaims: []string{"roles", "groups"},
+		Principals: map[string][]string{"root": {"dba"}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name     string
+		identity *Identity
+		roles    []string
+		allowed  []string
+		refused  []string
+	}{
+		{
+			name:     "no roles",
+			identity: &Identity{Principal: "eve", Claims: map[string]interface{}{"groups": []interface{}{"staff"}}},
+			refused:  []string{"RETURN 1"},
+		},
+		{
+			name:     "read",
+			identity: &Identity{Principal: "ann", Claims: map[string]interface{}{"groups": []interface{}{"analyst", "staff"}}},
+			roles:    []string{"analyst"},
+			allowed:  []string{"MATCH (n) RETURN n", "CALL nxalg.pagerank() YIELD *"},
+			refused:  []string{"CREATE (n)", "CALL mg.load_all()", "CREATE INDEX ON :A(b)"},
+		},
+		{
+			name:     "write",
+			identity: &Identity{Principal: "bob", Claims: map[string]interface{}{"roles": "analyst editor"}},
+			roles:    []string{"analyst", "editor"},
+			allowed:  []string{"CREATE (n)", "CALL nxalg.pagerank() YIELD *"},
+			refused:  []string{"DROP INDEX ON :A(b)", "CALL mg.load_all()"},
+		},
+		{
+			name:     "admin by principal",
+			identity: &Identity{Principal: "root"},
+			roles:    []string{"dba"},
+			allowed:  []string{"CREATE INDEX ON :A(b)", "CALL mg.load_all()"},
+		},
+	}
+	for _, test := range tests {
+		permissions := authorizer.Permissions(test.identity)
+		if !reflect.DeepEqual(permissions.Roles, test.roles) {
+			t.Errorf("%s: expected roles %v, got %v", test.name, test.roles, permissions.Roles)
+		}
+		for _, query := range test.allowed {
+			if err := permissions.Allow(query); err != nil {
+				t.Errorf("%s: expected %q to be allowed: %v", test.name, query, err)
+			}
+		}
+		for _, query := range test.refused {
+			if err := permissions.Allow(query); err == nil {
+				t.Errorf("%s: expected %q to be refused", test.name, query)
+			}
+		}
+	}
+
+	if authorizer, err := NewAuthorizer(config.Authorization{}); authorizer != nil || err != nil {
+		t.Fatalf("expected no authorizer without roles, got %v, %v", authorizer, err)
+	}
+}
@@ -0,0 +1,123 @@
+/*
+Copyright (c) 2021 Memgraph Ltd. [https://memgraph.com]
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package backend
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Bolt auth schemes
+const (
+	SCHEME_NONE     string = "none"
+	SCHEME_BASIC    string = "basic"
+	SCHEME_BEARER   string = "bearer"
+	SCHEME_KERBEROS string = "kerberos"
+)
+
+// Credentials a client sent in its HELLO or LOGON message.
+type AuthToken struct {
+	// One of the SCHEME_* values or the name of a custom scheme
+	Scheme      string
+	Principal   string
+	Credentials string
+	Realm       string
+	// The driver's user agent, from HELLO or INIT
+	UserAgent string
+	// Any other entries, e.g. the parameters of a custom scheme
+	Parameters map[string]interface{}
+
+	// The connection the token was sent over
+	Client ClientInfo
+}
+
+// Build an AuthToken from a HELLO or LOGON auth map. Old drivers leave out
+// the scheme, in which case it's basic if there is a principal.
+func NewAuthToken(authData map[string]interface{}) (*AuthToken, error) {
+	token := &AuthToken{Parameters: make(map[string]interface{})}
+	for key, value := range authData {
+		var field *string
+		switch key {
+		case "scheme":
+			field = &token.Scheme
+		case "principal":
+			field = &token.Principal
+		case "credentials":
+			field = &token.Credentials
+		case "realm":
+			field = &token.Realm
+		case "user_agent":
+			field = &token.UserAgent
+		default:
+			token.Parameters[key] = value
+			continue
+		}
+
+		if value == nil {
+			continue
+		}
+		s, ok := value.(string)
+		if !ok {
+			return nil, fmt.Errorf("auth %s must be a string", key)
+		}
+		*field = s
+	}
+
+	if token.Scheme == "" {
+		token.Scheme = SCHEME_NONE
+		if token.Principal != "" {
+			token.Scheme = SCHEME_BASIC
+		}
+	}
+	return token, nil
+}
+
+func unsupportedScheme(scheme string) error {
+	return fmt.Errorf("unsupported auth scheme %q", scheme)
+}
+
+// Hands each AuthToken to the Authenticator registered for its scheme,
+// e.g. bearer tokens to a JWTAuth and basic credentials to a BasicAuth.
+// Schemes without one go to the fallback, if any.
+type SchemeRouter struct {
+	schemes  map[string]Authenticator
+	fallback Authenticator
+}
+
+func NewSchemeRouter(fallback Authenticator) *SchemeRouter {
+	return &SchemeRouter{
+		schemes:  make(map[string]Authenticator),
+		fallback: fallback,
+	}
+}
+
+func (r *SchemeRouter) Route(scheme string, auth Authenticator) {
+	r.schemes[scheme] = auth
+}
+
+func (r *SchemeRouter) Authenticate(token *AuthToken) (*Identity, error) {
+	auth, found := r.schemes[token.Scheme]
+	if !found {
+		auth = r.fallback
+	}
+	if auth == nil {
+		return nil, unsupportedScheme(token.Scheme)
+	}
+	return auth.Authenticate(token)
+}
+
+var errNoCredentials = errors.New("no credentials")
@@ -22,53 +22,56 @@ import (
 	"fmt"
 	"net"
 	"net/url"
+	"sync/atomic"
+	"time"
 
 	"github.com/memgraph/bolt-proxy/bolt"
+	"github.com/memgraph/bolt-proxy/config"
 	"github.com/memgraph/bolt-proxy/proxy_logger"
 )
 
-type Parameters struct {
-	bindOn             string
-	proxyTo            string
-	username, password string
-	certFile, keyFile  string
-	debugMode          bool
-}
-
 type Backend struct {
 	monitor        *Monitor
 	main_uri       *url.URL
-	auth           Authenticator
 	connectionPool map[string]map[string]bolt.BoltConn
-	tls            bool
+	tlsConfig      *tls.Config
+	dialTimeout    time.Duration
+	// host:port of the replicas, and a counter to take turns with them
+	replicas    []string
+	nextReplica uint32
 }
 
-func NewBackend(username, password, uri string, auth Authenticator, hosts ...string) (*Backend, error) {
-	tls := false
-	u, err := url.Parse(uri)
+func NewBackend(conf config.Backend, pool config.Pool, timeouts config.Timeouts) (*Backend, error) {
+	u, err := url.Parse(conf.URI)
 	if err != nil {
 		return nil, err
 	}
 	switch u.Scheme {
 	case "bolt+s", "bolt+ssc", "neo4j+s", "neo4j+ssc":
-		tls = true
+		// tls
 	case "bolt", "neo4j":
 		// ok
 	default:
 		return nil, errors.New("invalid bolt connection scheme")
 	}
 
-	monitor, err := NewMonitor(username, password, uri, hosts...)
+	tlsConfig, err := newTLSConfig(u.Scheme, conf.TLS)
+	if err != nil {
+		return nil, err
+	}
+
+	monitor, err := NewMonitor(conf.User, conf.Password, conf.URI, pool, tlsConfig, conf.Hosts...)
 	if err != nil {
 		return nil, err
 	}
 
 	return &Backend{
 		monitor:        monitor,
-		tls:            tls,
+		tlsConfig:      tlsConfig,
 		main_uri:       u,
-		auth:           auth,
 		connectionPool: make(map[string]map[string]bolt.BoltConn),
+		dialTimeout:    timeouts.Dial.Duration,
+		replicas:       conf.Replicas,
 	}, nil
 }
 
@@ -80,25 +83,50 @@ func (b *Backend) MainInstance() *url.URL {
 	return b.main_uri
 }
 
-func (b *Backend) IsAuthEnabled() bool {
-	return b.auth != nil
+// Connect to the main instance and authenticate with the given HELLO, and
+// the LOGON that follows it for Bolt 5.1+ clients unless logon is nil.
+func (b *Backend) InitBoltConnection(hello, logon []byte, network string) (bolt.BoltConn, error) {
+	return b.connect(b.monitor.host, hello, logon, network)
+}
+
+func (b *Backend) HasReplicas() bool {
+	return len(b.replicas) > 0
 }
 
-func (b *Backend) InitBoltConnection(hello []byte, network string) (bolt.BoltConn, error) {
+// Connect to the next replica like InitBoltConnection does to the main
+// instance, trying the others if it can't be reached. Returns the address
+// of the replica as well.
+func (b *Backend) InitReplicaConnection(hello, logon []byte, network string) (bolt.BoltConn, string, error) {
+	if len(b.replicas) == 0 {
+		return nil, "", errors.New("no replicas")
+	}
+	next := atomic.AddUint32(&b.nextReplica, 1)
+	var err error
+	for i := range b.replicas {
+		address := b.replicas[(next+uint32(i))%uint32(len(b.replicas))]
+		var conn bolt.BoltConn
+		conn, err = b.connect(address, hello, logon, network)
+		if err == nil {
+			return conn, address, nil
+		}
+		proxy_logger.WarnLog.Printf("replica %s unavailable: %v", address, err)
+	}
+	return nil, "", err
+}
+
+func (b *Backend) connect(address string, hello, logon []byte, network string) (bolt.BoltConn, error) {
 	backend_version := b.Version().Bytes()
-	address := b.monitor.host
-	useTls := b.tls
 	var (
 		conn net.Conn
 		err  error
 	)
 	fmt.Println("Before sending")
 
-	if useTls {
-		conf := &tls.Config{}
-		conn, err = tls.Dial(network, address, conf)
+	dialer := &net.Dialer{Timeout: b.dialTimeout}
+	if b.tlsConfig != nil {
+		conn, err = tls.DialWithDialer(dialer, network, address, b.tlsConfig)
 	} else {
-		conn, err = net.Dial(network, address)
+		conn, err = dialer.Dial(network, address)
 	}
 	if err != nil {
 		return nil, err
@@ -127,84 +155,107 @@ func (b *Backend) InitBoltConnection(hello []byte, network string) (bolt.BoltCon
 		return nil, errors.New(msg)
 	}
 
-	// Try performing the bolt auth the given hello message
-	_, err = conn.Write(hello)
+	for _, msg := range [][]byte{hello, logon} {
+		if msg == nil {
+			continue
+		}
+		err = authExchange(conn, address, msg, buf)
+		if err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+
+	// The only happy outcome! Keep conn open.
+	return bolt.NewDirectConn(conn), nil
+}
+
+// Send a HELLO or LOGON and wait for the server to accept it.
+func authExchange(conn net.Conn, address string, msg, buf []byte) error {
+	_, err := conn.Write(msg)
 	if err != nil {
-		msg := fmt.Sprintf("failed to send hello buffer to server %s: %s", address, err)
-		conn.Close()
-		return nil, errors.New(msg)
+		return fmt.Errorf("failed to send auth message to server %s: %s", address, err)
 	}
 
-	n, err = conn.Read(buf)
+	n, err := conn.Read(buf)
 	if err != nil {
-		msg := fmt.Sprintf("failed to get auth response from auth server %s: %s", address, err)
-		conn.Close()
-		return nil, errors.New(msg)
+		return fmt.Errorf("failed to get auth response from auth server %s: %s", address, err)
 	}
 
-	msg := bolt.IdentifyType(buf)
-	switch msg {
+	switch bolt.IdentifyType(buf) {
 	case bolt.FailureMsg:
 		// See if we can extract the error message
 		r, _, errParse := bolt.ParseMap(buf[4:n])
 		if errParse != nil {
-			conn.Close()
-			return nil, errParse
+			return errParse
 		}
 
 		val, found := r["message"]
 		if found {
 			failmsg, ok := val.(string)
 			if ok {
-				conn.Close()
-				return nil, errors.New(failmsg)
+				return errors.New(failmsg)
 			}
 		}
-		conn.Close()
-		return nil, errors.New("could not parse auth server response")
+		return errors.New("could not parse auth server response")
 	case bolt.SuccessMsg:
-		// The only happy outcome! Keep conn open.
-		bolt_connection := bolt.NewDirectConn(conn)
-		return bolt_connection, nil
+		return nil
 	}
 
 	// Try to be polite and say goodbye if we know we failed.
 	_, err = conn.Write([]byte{0x00, 0x02, 0xb0, 0x02})
 	if err != nil {
-		return nil, fmt.Errorf("write: %v", err)
+		return fmt.Errorf("write: %v", err)
 	}
+	return errors.New("unknown error from auth server")
+}
 
-	err = conn.Close()
-	if err != nil {
-		return nil, fmt.Errorf("close: %v", err)
+// Authenticate a client, so that Memgraph does not have to perform auth
+// itself. msg is the HELLO, or the LOGON for Bolt 5.1+ clients. A verified
+// client certificate is combined with its credentials according to
+// certAuth (see config.CERT_AUTH_*):
+//
+//      ignore: only the credentials are checked by auth
+//  sufficient: a certificate principal is enough, otherwise credentials are checked
+//    required: a certificate principal is needed and credentials are checked too
+//
+// A nil Authenticator accepts any credentials.
+func Authenticate(auth Authenticator, certAuth string, msg *bolt.Message, client ClientInfo) (*Identity, error) {
+	if msg.T != bolt.HelloMsg && msg.T != bolt.LogonMsg {
+		panic("authenticate requires a Hello or Logon message")
 	}
 
-	return nil, errors.New("unknown error from auth server")
-}
-
-// This part can be extended with third party auth service, so that Memgraph does not perform auth
-func (b *Backend) Authenticate(hello *bolt.Message) error {
-	if hello.T != bolt.HelloMsg {
-		panic("authenticate requires a Hello message")
+	switch certAuth {
+	case config.CERT_AUTH_SUFFICIENT:
+		if client.CertPrincipal != "" {
+			return &Identity{Principal: client.CertPrincipal}, nil
+		}
+	case config.CERT_AUTH_REQUIRED:
+		if client.CertPrincipal == "" {
+			return nil, errors.New("verified client certificate required")
+		}
 	}
 
-	// TODO: clean up this api...push the dirt into Bolt package?
-	data := hello.Data[4:]
-	client_string, pos, err := bolt.ParseString(data)
+	authData, err := bolt.ParseAuth(msg)
 	if err != nil {
-		return fmt.Errorf("parse: %v", err)
+		return nil, fmt.Errorf("parse: %v", err)
 	}
-	proxy_logger.DebugLog.Printf("client string %s", client_string)
-
-	auth_data := data[pos:]
-	msg, pos, err := bolt.ParseMap(auth_data)
+	token, err := NewAuthToken(authData)
 	if err != nil {
-		proxy_logger.DebugLog.Printf("XXX pos: %d, hello map: %#v\n", pos, msg)
-		panic(err)
+		return nil, err
 	}
+	token.Client = client
+	proxy_logger.DebugLog.Printf("client uses auth scheme %q", token.Scheme)
 
-	if b.auth != nil {
-		return b.auth.Authenticate(msg)
+	identity := &Identity{Principal: token.Principal}
+	if auth != nil {
+		identity, err = auth.Authenticate(token)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if certAuth == config.CERT_AUTH_REQUIRED {
+		identity.Principal = client.CertPrincipal
 	}
-	return nil
+	return identity, nil
 }
@@ -0,0 +1,252 @@
+/*
+Copyright (c) 2021 Memgraph Ltd. [https://memgraph.com]
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package backend
+
+import (
+	"bytes"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"time"
+
+	"github.com/memgraph/bolt-proxy/config"
+)
+
+// Largest webhook response the proxy reads
+const MAX_WEBHOOK_RESPONSE = 1 << 20
+
+// Asks a webhook whether basic credentials are valid, see config.BasicAuth.
+type BasicAuth struct {
+	url        string
+	method     string
+	headers    map[string]string
+	timeout    time.Duration
+	retries    int
+	retryDelay time.Duration
+}
+
+func NewBasicAuth(conf config.BasicAuth) (*BasicAuth, error) {
+	if conf.URL == "" {
+		return nil, errors.New("basic auth url must be set when using basic auth")
+	}
+	timeout := conf.Timeout.Duration
+	if timeout <= 0 {
+		timeout = config.DEFAULT_AUTH_TIMEOUT
+	}
+
+	return &BasicAuth{
+		url:        conf.URL,
+		method:     conf.Method,
+		headers:    conf.Headers,
+		timeout:    timeout,
+		retries:    conf.Retries,
+		retryDelay: conf.RetryDelay.Duration,
+	}, nil
+}
+
+// Body of webhook requests other than GET.
+type webhookRequest struct {
+	Scheme      string        `json:"scheme"`
+	Principal   string        `json:"principal"`
+	Credentials string        `json:"credentials"`
+	Realm       string        `json:"realm,omitempty"`
+	Client      webhookClient `json:"client"`
+}
+
+type webhookClient struct {
+	Address   string      `json:"address,omitempty"`
+	UserAgent string      `json:"user_agent,omitempty"`
+	TLS       *webhookTLS `json:"tls,omitempty"`
+}
+
+type webhookTLS struct {
+	Version     string `json:"version"`
+	CipherSuite string `json:"cipher_suite"`
+	ServerName  string `json:"server_name,omitempty"`
+	// Principal of the client's verified certificate
+	CertificatePrincipal string `json:"certificate_principal,omitempty"`
+}
+
+// A JSON webhook response; every field is optional.
+type webhookResponse struct {
+	Roles   []string               `json:"roles"`
+	Claims  map[string]interface{} `json:"claims"`
+	Backend *struct {
+		User     string `json:"user"`
+		Password string `json:"password"`
+	} `json:"backend"`
+	// Seconds, or a duration string like "8h"
+	SessionTTL interface{} `json:"session_ttl"`
+}
+
+func newWebhookRequest(token *AuthToken) webhookRequest {
+	request := webhookRequest{
+		Scheme:      token.Scheme,
+		Principal:   token.Principal,
+		Credentials: token.Credentials,
+		Realm:       token.Realm,
+		Client:      webhookClient{UserAgent: token.UserAgent},
+	}
+	if token.Client.RemoteAddr != nil {
+		request.Client.Address = token.Client.RemoteAddr.String()
+	}
+	if state := token.Client.TLS; state != nil {
+		request.Client.TLS = &webhookTLS{
+			Version:              config.TLSVersionName(state.Version),
+			CipherSuite:          tls.CipherSuiteName(state.CipherSuite),
+			ServerName:           state.ServerName,
+			CertificatePrincipal: token.Client.CertPrincipal,
+		}
+	}
+	return request
+}
+
+// Any 200 response accepts the credentials. A JSON response may add roles,
+// claims, backend credentials and a session TTL to the identity.
+func (auth *BasicAuth) Authenticate(token *AuthToken) (*Identity, error) {
+	if token.Scheme != SCHEME_BASIC {
+		return nil, unsupportedScheme(token.Scheme)
+	}
+	if token.Principal == "" {
+		return nil, errors.New("no principal")
+	}
+	if token.Credentials == "" {
+		return nil, errNoCredentials
+	}
+
+	var body []byte
+	if auth.method != "" && auth.method != http.MethodGet {
+		var err error
+		body, err = json.Marshal(newWebhookRequest(token))
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	rawResp, err := auth.call(token, body)
+	if err != nil {
+		return nil, err
+	}
+	defer rawResp.Body.Close()
+	if rawResp.StatusCode != 200 {
+		return nil, errors.New("unauthorized creds")
+	}
+
+	identity := &Identity{Principal: token.Principal}
+	mediaType, _, _ := mime.ParseMediaType(rawResp.Header.Get("Content-Type"))
+	if mediaType != "application/json" {
+		return identity, nil
+	}
+	var resp webhookResponse
+	err = json.NewDecoder(io.LimitReader(rawResp.Body, MAX_WEBHOOK_RESPONSE)).Decode(&resp)
+	if err != nil && err != io.EOF {
+		return nil, fmt.Errorf("invalid auth webhook response: %v", err)
+	}
+	err = resp.apply(identity)
+	if err != nil {
+		return nil, fmt.Errorf("invalid auth webhook response: %v", err)
+	}
+	return identity, nil
+}
+
+// Send the request, retrying after network errors and 5xx responses.
+func (auth *BasicAuth) call(token *AuthToken, body []byte) (*http.Response, error) {
+	method := auth.method
+	if method == "" {
+		method = http.MethodGet
+	}
+	client := &http.Client{
+		Timeout: auth.timeout,
+	}
+
+	for attempt := 0; ; attempt++ {
+		req, err := http.NewRequest(method, auth.url, bytes.NewReader(body))
+		if err != nil {
+			return nil, err
+		}
+		for name, value := range auth.headers {
+			req.Header.Set(name, value)
+		}
+		if body != nil {
+			req.Header.Set("Content-Type", "application/json")
+		}
+		req.SetBasicAuth(token.Principal, token.Credentials)
+
+		rawResp, err := client.Do(req)
+		if err == nil && rawResp.StatusCode < 500 {
+			return rawResp, nil
+		}
+		if err == nil {
+			_, _ = io.Copy(ioutil.Discard, io.LimitReader(rawResp.Body, MAX_WEBHOOK_RESPONSE))
+			rawResp.Body.Close()
+			err = fmt.Errorf("auth webhook answered %s", rawResp.Status)
+		}
+		if attempt >= auth.retries {
+			return nil, err
+		}
+		time.Sleep(auth.retryDelay)
+	}
+}
+
+func (resp *webhookResponse) apply(identity *Identity) error {
+	if len(resp.Claims) > 0 || len(resp.Roles) > 0 {
+		identity.Claims = make(map[string]interface{}, len(resp.Claims)+1)
+		for key, value := range resp.Claims {
+			identity.Claims[key] = value
+		}
+	}
+	if len(resp.Roles) > 0 {
+		roles := make([]interface{}, len(resp.Roles))
+		for i, role := range resp.Roles {
+			roles[i] = role
+		}
+		identity.Claims["roles"] = roles
+	}
+
+	if resp.Backend != nil {
+		if resp.Backend.User == "" {
+			return errors.New("backend user must be set")
+		}
+		identity.Backend = &config.MemgraphCredential{
+			User:     resp.Backend.User,
+			Password: resp.Backend.Password,
+		}
+	}
+
+	switch ttl := resp.SessionTTL.(type) {
+	case nil:
+	case float64:
+		identity.SessionTTL = time.Duration(ttl * float64(time.Second))
+	case string:
+		parsed, err := time.ParseDuration(ttl)
+		if err != nil {
+			return fmt.Errorf("session_ttl: %v", err)
+		}
+		identity.SessionTTL = parsed
+	default:
+		return errors.New("session_ttl must be seconds or a duration string")
+	}
+	if identity.SessionTTL < 0 {
+		return errors.New("session_ttl must not be negative")
+	}
+	return nil
+}
@@ -0,0 +1,139 @@
+/*
+Copyright (c) 2021 Memgraph Ltd. [https://memgraph.com]
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package backend
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/memgraph/bolt-proxy/config"
+	"github.com/memgraph/bolt-proxy/proxy_logger"
+)
+
+type chainStep struct {
+	method  string
+	control string
+	auth    Authenticator
+}
+
+// Runs several Authenticators in order, see config.AuthStep for how their
+// outcomes are combined.
+type AuthChain struct {
+	steps []chainStep
+}
+
+// Build the chain from conf.Chain, each step using the settings of its
+// method from conf.
+func NewAuthChain(conf config.Auth) (*AuthChain, error) {
+	chain := &AuthChain{}
+	decisive := false
+	for i, step := range conf.Chain {
+		if step.Method == config.AUTH_CHAIN {
+			return nil, fmt.Errorf("auth step %d: chains can't be nested", i)
+		}
+		switch step.Control {
+		case config.CONTROL_SUFFICIENT, config.CONTROL_REQUIRED:
+			decisive = true
+		case config.CONTROL_OPTIONAL:
+		default:
+			return nil, fmt.Errorf("auth step %d: unknown control %q", i, step.Control)
+		}
+
+		auth, err := newMethodAuth(conf, step.Method)
+		if err != nil {
+			return nil, fmt.Errorf("auth step %d (%s): %v", i, step.Method, err)
+		}
+		if auth == nil {
+			return nil, fmt.Errorf("auth step %d: method %q doesn't authenticate", i, step.Method)
+		}
+		chain.steps = append(chain.steps, chainStep{step.Method, step.Control, auth})
+	}
+	if !decisive {
+		return nil, errors.New("auth chain needs at least one sufficient or required step")
+	}
+	return chain, nil
+}
+
+func (c *AuthChain) Authenticate(token *AuthToken) (*Identity, error) {
+	var identity *Identity
+	var failures []string
+	for i, step := range c.steps {
+		stepIdentity, err := step.auth.Authenticate(token)
+		if err != nil {
+			proxy_logger.DebugLog.Printf("auth step %d (%s, %s) failed: %v", i, step.method, step.control, err)
+			switch step.control {
+			case config.CONTROL_REQUIRED:
+				return nil, fmt.Errorf("required auth step %d (%s) rejected the client: %v", i, step.method, err)
+			case config.CONTROL_SUFFICIENT:
+				failures = append(failures, fmt.Sprintf("step %d (%s): %v", i, step.method, err))
+			}
+			continue
+		}
+
+		identity = mergeIdentities(identity, stepIdentity)
+		if step.control == config.CONTROL_SUFFICIENT {
+			return identity, nil
+		}
+	}
+
+	// every required step passed, failed sufficient steps don't count
+	if c.hasRequired() {
+		return identity, nil
+	}
+	if len(failures) > 0 {
+		return nil, fmt.Errorf("no sufficient auth step accepted the client: %s", strings.Join(failures, "; "))
+	}
+	return nil, errors.New("no auth step accepted the client")
+}
+
+func (c *AuthChain) hasRequired() bool {
+	for _, step := range c.steps {
+		if step.control == config.CONTROL_REQUIRED {
+			return true
+		}
+	}
+	return false
+}
+
+// Keep the principal of the first identity, adding the claims of the next
+// one that aren't set yet. The first backend account wins and the shorter
+// session TTL applies. Neither identity is modified.
+func mergeIdentities(first, next *Identity) *Identity {
+	if first == nil {
+		return next
+	}
+	merged := &Identity{
+		Principal:  first.Principal,
+		Claims:     make(map[string]interface{}, len(first.Claims)+len(next.Claims)),
+		Backend:    first.Backend,
+		SessionTTL: first.SessionTTL,
+	}
+	if merged.Backend == nil {
+		merged.Backend = next.Backend
+	}
+	if merged.SessionTTL == 0 || (next.SessionTTL > 0 && next.SessionTTL < merged.SessionTTL) {
+		merged.SessionTTL = next.SessionTTL
+	}
+	for key, value := range next.Claims {
+		merged.Claims[key] = value
+	}
+	for key, value := range first.Claims {
+		merged.Claims[key] = value
+	}
+	return merged
+}
@@ -0,0 +1,227 @@
+/*
+Copyright (c) 2021 Memgraph Ltd. [https://memgraph.com]
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package backend
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/memgraph/bolt-proxy/config"
+)
+
+// Accepts everything as principal with claims, or rejects with err.
+type claimsAuth struct {
+	principal string
+	claims    map[string]interface{}
+	err       error
+	calls     int
+}
+
+func (a *claimsAuth) Authenticate(token *AuthToken) (*Identity, error) {
+	a.calls++
+	if a.err != nil {
+		return nil, a.err
+	}
+	return &Identity{Principal: a.principal, Claims: a.claims}, nil
+}
+
+func TestAuthChain(t *testing.T) {
+	accept := func(principal, claim string) *claimsAuth {
+		return &claimsAuth{principal: principal, claims: map[string]interface{}{claim: principal}}
+	}
+	reject := func(reason string) *claimsAuth {
+		return &claimsAuth{err: errors.New(reason)}
+	}
+	step := func(control string, auth *claimsAuth) chainStep {
+		return chainStep{"fake", control, auth}
+	}
+
+	tests := []struct {
+		name      string
+		steps     []chainStep
+		principal string
+		err       string
+	}{
+		{
+			"first sufficient wins",
+			[]chainStep{step(config.CONTROL_SUFFICIENT, accept("jwt-user", "sub")), step(config.CONTROL_SUFFICIENT, reject("not run"))},
+			"jwt-user", "",
+		},
+		{
+			"fallback",
+			[]chainStep{step(config.CONTROL_SUFFICIENT, reject("bad token")), step(config.CONTROL_SUFFICIENT, accept("ldap-user", "dn"))},
+			"ldap-user", "",
+		},
+		{
+			"all sufficient fail",
+			[]chainStep{step(config.CONTROL_SUFFICIENT, reject("bad token")), step(config.CONTROL_SUFFICIENT, reject("bad password"))},
+			"", "no sufficient auth step accepted the client: step 0 (fake): bad token; step 1 (fake): bad password",
+		},
+		{
+			"all required pass",
+			[]chainStep{step(config.CONTROL_REQUIRED, accept("cert-user", "cn")), step(config.CONTROL_REQUIRED, accept("basic-user", "uid"))},
+			"cert-user", "",
+		},
+		{
+			"required fails",
+			[]chainStep{step(config.CONTROL_REQUIRED, accept("cert-user", "cn")), step(config.CONTROL_REQUIRED, reject("bad password"))},
+			"", "required auth step 1 (fake) rejected the client: bad password",
+		},
+		{
+			"required and failed sufficient",
+			[]chainStep{step(config.CONTROL_REQUIRED, accept("cert-user", "cn")),
+				step(config.CONTROL_SUFFICIENT, reject("bad token")), step(config.CONTROL_SUFFICIENT, reject("bad password"))},
+			"cert-user", "",
+		},
+		{
+			"failed sufficient then required",
+			[]chainStep{step(config.CONTROL_SUFFICIENT, reject("no certificate")), step(config.CONTROL_REQUIRED, accept("basic-user", "uid"))},
+			"basic-user", "",
+		},
+		{
+			"failed sufficient then optional",
+			[]chainStep{step(config.CONTROL_SUFFICIENT, reject("no certificate")), step(config.CONTROL_OPTIONAL, accept("ldap-user", "groups"))},
+			"", "no sufficient auth step accepted the client: step 0 (fake): no certificate",
+		},
+		{
+			"optional failure ignored",
+			[]chainStep{step(config.CONTROL_OPTIONAL, reject("no groups")), step(config.CONTROL_REQUIRED, accept("user", "uid"))},
+			"user", "",
+		},
+	}
+	for _, test := range tests {
+		chain := &AuthChain{steps: test.steps}
+		identity, err := chain.Authenticate(basicToken("user", "secret"))
+		if test.err != "" {
+			if err == nil || !strings.HasPrefix(err.Error(), test.err) {
+				t.Fatalf("%s: expected error %q, got %v", test.name, test.err, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("%s: %v", test.name, err)
+		}
+		if identity.Principal != test.principal {
+			t.Fatalf("%s: expected principal %q, got %q", test.name, test.principal, identity.Principal)
+		}
+	}
+
+	last := reject("not run")
+	chain := &AuthChain{steps: []chainStep{
+		step(config.CONTROL_REQUIRED, accept("cert-user", "cn")),
+		step(config.CONTROL_OPTIONAL, accept("ldap-user", "groups")),
+		step(config.CONTROL_SUFFICIENT, accept("basic-user", "cn")),
+		step(config.CONTROL_REQUIRED, last),
+	}}
+	identity, err := chain.Authenticate(basicToken("user", "secret"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if identity.Principal != "cert-user" || identity.Claims["cn"] != "cert-user" || identity.Claims["groups"] != "ldap-user" {
+		t.Fatalf("unexpected merged identity %#v", identity)
+	}
+	if last.calls != 0 {
+		t.Fatal("expected steps after a successful sufficient one not to run")
+	}
+}
+
+func TestMergeIdentities(t *testing.T) {
+	account := &config.MemgraphCredential{User: "analysts"}
+	first := &Identity{Principal: "cert-user", SessionTTL: time.Hour}
+	next := &Identity{Principal: "basic-user", Backend: account, SessionTTL: 10 * time.Minute}
+
+	merged := mergeIdentities(first, next)
+	if merged.Principal != "cert-user" || merged.Backend != account || merged.SessionTTL != 10*time.Minute {
+		t.Fatalf("unexpected merged identity %#v", merged)
+	}
+	merged = mergeIdentities(next, &Identity{Backend: &config.MemgraphCredential{User: "other"}})
+	if merged.Backend != account || merged.SessionTTL != 10*time.Minute {
+		t.Fatalf("expected the first account and the only TTL, got %#v", merged)
+	}
+}
+
+func TestAuthChainCertAndPassword(t *testing.T) {
+	dir, err := ioutil.TempDir("", "bolt-proxy-chain")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	path := filepath.Join(dir, "users")
+	writeHtpasswd(t, path, htpasswdLine(t, "alice", "secret", HASH_BCRYPT, "analyst"))
+
+	auth, err := NewAuth(config.Auth{
+		Method: config.AUTH_CHAIN,
+		Chain: []config.AuthStep{
+			{Method: config.AUTH_CERT, Control: config.CONTROL_REQUIRED},
+			{Method: config.AUTH_HTPASSWD, Control: config.CONTROL_REQUIRED},
+		},
+		Htpasswd: config.HtpasswdAuth{File: path},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	hello := helloMessage(t, map[string]interface{}{"scheme": "basic", "principal": "alice", "credentials": "secret"})
+	identity, err := Authenticate(auth, config.CERT_AUTH_IGNORE, hello, ClientInfo{CertPrincipal: "alice.example.com"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if identity.Principal != "alice.example.com" || identity.Claims["roles"] == nil {
+		t.Fatalf("unexpected identity %#v", identity)
+	}
+
+	_, err = Authenticate(auth, config.CERT_AUTH_IGNORE, hello, ClientInfo{})
+	if err == nil || !strings.Contains(err.Error(), "step 0 (cert)") {
+		t.Fatalf("expected the cert step to reject the client, got %v", err)
+	}
+
+	// a certificate is enough, without one the password has to do
+	auth, err = NewAuth(config.Auth{
+		Method: config.AUTH_CHAIN,
+		Chain: []config.AuthStep{
+			{Method: config.AUTH_CERT, Control: config.CONTROL_SUFFICIENT},
+			{Method: config.AUTH_HTPASSWD, Control: config.CONTROL_REQUIRED},
+		},
+		Htpasswd: config.HtpasswdAuth{File: path},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	forged := helloMessage(t, map[string]interface{}{"scheme": "basic", "principal": "alice", "credentials": "wrong"})
+	if identity, err = Authenticate(auth, config.CERT_AUTH_IGNORE, forged, ClientInfo{CertPrincipal: "alice.example.com"}); err != nil || identity.Principal != "alice.example.com" {
+		t.Fatalf("expected the certificate to suffice, got %v, %v", identity, err)
+	}
+	if identity, err = Authenticate(auth, config.CERT_AUTH_IGNORE, hello, ClientInfo{}); err != nil || identity.Principal != "alice" {
+		t.Fatalf("expected the password to let the client in without a certificate, got %v, %v", identity, err)
+	}
+	if _, err = Authenticate(auth, config.CERT_AUTH_IGNORE, forged, ClientInfo{}); err == nil {
+		t.Fatal("expected a wrong password without a certificate to be rejected")
+	}
+
+	_, err = NewAuth(config.Auth{
+		Method: config.AUTH_CHAIN,
+		Chain:  []config.AuthStep{{Method: config.AUTH_CERT, Control: config.CONTROL_OPTIONAL}},
+	})
+	if err == nil {
+		t.Fatal("expected a chain of optional steps to be rejected")
+	}
+}
@@ -0,0 +1,140 @@
+/*
+Copyright (c) 2021 Memgraph Ltd. [https://memgraph.com]
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package backend
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/memgraph/bolt-proxy/bolt"
+	"github.com/memgraph/bolt-proxy/config"
+)
+
+// Placeholder for the client's token in SSO credentials
+const SSO_TOKEN_PLACEHOLDER = "${token}"
+
+// Decides which credentials the proxy presents to Memgraph for a client it
+// authenticated, see config.CredentialMapping.
+type CredentialMapper struct {
+	mode    string
+	service config.MemgraphCredential
+	rules   []config.CredentialRule
+	sso     config.SSOCredential
+}
+
+// Returns a nil CredentialMapper in passthrough mode.
+func NewCredentialMapper(conf config.CredentialMapping) (*CredentialMapper, error) {
+	mapper := &CredentialMapper{
+		mode:    conf.Mode,
+		service: conf.Service,
+		rules:   conf.Rules,
+		sso:     conf.SSO,
+	}
+
+	switch conf.Mode {
+	case config.CREDENTIALS_PASSTHROUGH, "":
+		return nil, nil
+	case config.CREDENTIALS_SERVICE:
+		if conf.Service.User == "" {
+			return nil, errors.New("service user must be set")
+		}
+	case config.CREDENTIALS_MAP:
+		if len(conf.Rules) == 0 {
+			return nil, errors.New("at least one credential rule must be set")
+		}
+	case config.CREDENTIALS_SSO:
+		if conf.SSO.Scheme == "" {
+			return nil, errors.New("sso scheme must be set")
+		}
+		if mapper.sso.Credentials == "" {
+			mapper.sso.Credentials = SSO_TOKEN_PLACEHOLDER
+		}
+	default:
+		return nil, fmt.Errorf("unknown credential mapping mode %q", conf.Mode)
+	}
+	return mapper, nil
+}
+
+// Auth map to send to Memgraph for the client.
+func (m *CredentialMapper) Map(identity *Identity, token *AuthToken) (map[string]interface{}, error) {
+	switch m.mode {
+	case config.CREDENTIALS_SERVICE:
+		return basicAuthMap(m.service), nil
+	case config.CREDENTIALS_MAP:
+		for _, rule := range m.rules {
+			if m.ruleMatches(rule, identity) {
+				return basicAuthMap(config.MemgraphCredential{User: rule.User, Password: rule.Password}), nil
+			}
+		}
+		if m.service.User != "" {
+			return basicAuthMap(m.service), nil
+		}
+		return nil, fmt.Errorf("no backend credentials for %q", identity.Principal)
+	case config.CREDENTIALS_SSO:
+		if token.Credentials == "" {
+			return nil, errors.New("no token to pass on to the backend")
+		}
+		return map[string]interface{}{
+			"scheme":      m.sso.Scheme,
+			"credentials": strings.ReplaceAll(m.sso.Credentials, SSO_TOKEN_PLACEHOLDER, token.Credentials),
+		}, nil
+	}
+	return nil, fmt.Errorf("unknown credential mapping mode %q", m.mode)
+}
+
+func (m *CredentialMapper) ruleMatches(rule config.CredentialRule, identity *Identity) bool {
+	if rule.Principal != "" {
+		return rule.Principal == identity.Principal
+	}
+	return claimMatches(identity.Claims, rule.Claim, rule.Values)
+}
+
+func basicAuthMap(credential config.MemgraphCredential) map[string]interface{} {
+	return map[string]interface{}{
+		"scheme":      SCHEME_BASIC,
+		"principal":   credential.User,
+		"credentials": credential.Password,
+	}
+}
+
+// The HELLO or LOGON to send to Memgraph for an authenticated client: a
+// copy carrying the account its authenticator picked if there is one, the
+// client's own if mapper is nil, otherwise a copy carrying the mapped
+// credentials.
+func BackendAuthMessage(mapper *CredentialMapper, msg *bolt.Message, identity *Identity) (*bolt.Message, error) {
+	if identity != nil && identity.Backend != nil {
+		return bolt.RewriteAuth(msg, basicAuthMap(*identity.Backend))
+	}
+	if mapper == nil {
+		return msg, nil
+	}
+
+	authData, err := bolt.ParseAuth(msg)
+	if err != nil {
+		return nil, err
+	}
+	token, err := NewAuthToken(authData)
+	if err != nil {
+		return nil, err
+	}
+	auth, err := mapper.Map(identity, token)
+	if err != nil {
+		return nil, err
+	}
+	return bolt.RewriteAuth(msg, auth)
+}
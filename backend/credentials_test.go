@@ -0,0 +1,144 @@
+/*
+Copyright (c) 2021 Memgraph Ltd. [https://memgraph.com]
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package backend
+
+import (
+	"testing"
+
+	"github.com/memgraph/bolt-proxy/bolt"
+	"github.com/memgraph/bolt-proxy/config"
+)
+
+func backendAuth(t *testing.T, conf config.CredentialMapping, identity *Identity) (map[string]interface{}, error) {
+	mapper, err := NewCredentialMapper(conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hello := helloMessage(t, map[string]interface{}{
+		"scheme":      "bearer",
+		"credentials": "client-token",
+	})
+	msg, err := BackendAuthMessage(mapper, hello, identity)
+	if err != nil {
+		return nil, err
+	}
+	auth, err := bolt.ParseAuth(msg)
+	if err != nil {
+		return nil, err
+	}
+	// INIT's user agent is not part of the credentials
+	if auth["user_agent"] != "test-client/1.0" {
+		t.Fatalf("expected the user agent to be kept, got %#v", auth)
+	}
+	delete(auth, "user_agent")
+	return auth, nil
+}
+
+func TestCredentialMapping(t *testing.T) {
+	analyst := &Identity{
+		Principal: "alice",
+		Claims: map[string]interface{}{
+			"realm_access": map[string]interface{}{"roles": []interface{}{"analyst"}},
+		},
+	}
+	service := config.MemgraphCredential{User: "proxy", Password: "service-secret"}
+	rules := []config.CredentialRule{
+		{Principal: "bob", User: "bob_mg", Password: "bob-secret"},
+		{Claim: "realm_access.roles", Values: []string{"analyst"}, User: "analysts", Password: "analyst-secret"},
+	}
+
+	tests := []struct {
+		name     string
+		conf     config.CredentialMapping
+		identity *Identity
+		expected map[string]interface{}
+	}{
+		{
+			"passthrough",
+			config.CredentialMapping{Mode: config.CREDENTIALS_PASSTHROUGH},
+			analyst,
+			map[string]interface{}{"scheme": "bearer", "credentials": "client-token"},
+		},
+		{
+			"service",
+			config.CredentialMapping{Mode: config.CREDENTIALS_SERVICE, Service: service},
+			analyst,
+			map[string]interface{}{"scheme": "basic", "principal": "proxy", "credentials": "service-secret"},
+		},
+		{
+			"map by principal",
+			config.CredentialMapping{Mode: config.CREDENTIALS_MAP, Rules: rules},
+			&Identity{Principal: "bob"},
+			map[string]interface{}{"scheme": "basic", "principal": "bob_mg", "credentials": "bob-secret"},
+		},
+		{
+			"map by claim",
+			config.CredentialMapping{Mode: config.CREDENTIALS_MAP, Rules: rules},
+			analyst,
+			map[string]interface{}{"scheme": "basic", "principal": "analysts", "credentials": "analyst-secret"},
+		},
+		{
+			"map falls back to service",
+			config.CredentialMapping{Mode: config.CREDENTIALS_MAP, Rules: rules, Service: service},
+			&Identity{Principal: "carol"},
+			map[string]interface{}{"scheme": "basic", "principal": "proxy", "credentials": "service-secret"},
+		},
+		{
+			"sso",
+			config.CredentialMapping{
+				Mode: config.CREDENTIALS_SSO,
+				SSO:  config.SSOCredential{Scheme: "oidc-entra-id", Credentials: "access_token=${token};id_token=${token}"},
+			},
+			analyst,
+			map[string]interface{}{"scheme": "oidc-entra-id", "credentials": "access_token=client-token;id_token=client-token"},
+		},
+	}
+	for _, test := range tests {
+		auth, err := backendAuth(t, test.conf, test.identity)
+		if err != nil {
+			t.Fatalf("%s: %v", test.name, err)
+		}
+		for key, value := range test.expected {
+			if auth[key] != value {
+				t.Fatalf("%s: expected %s %v, got %#v", test.name, key, value, auth)
+			}
+		}
+		if len(auth) != len(test.expected) {
+			t.Fatalf("%s: unexpected auth %#v", test.name, auth)
+		}
+	}
+
+	_, err := backendAuth(t, config.CredentialMapping{Mode: config.CREDENTIALS_MAP, Rules: rules}, &Identity{Principal: "carol"})
+	if err == nil {
+		t.Fatal("expected unmapped principal without service account to be rejected")
+	}
+
+	// an account picked by the authenticator beats any mapping
+	picked := &Identity{Principal: "carol", Backend: &config.MemgraphCredential{User: "carol_mg", Password: "carol-secret"}}
+	for _, conf := range []config.CredentialMapping{
+		{Mode: config.CREDENTIALS_PASSTHROUGH},
+		{Mode: config.CREDENTIALS_SERVICE, Service: service},
+	} {
+		auth, err := backendAuth(t, conf, picked)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if auth["principal"] != "carol_mg" || auth["credentials"] != "carol-secret" {
+			t.Fatalf("%s: expected the picked account, got %#v", conf.Mode, auth)
+		}
+	}
+}
@@ -0,0 +1,341 @@
+/*
+Copyright (c) 2021 Memgraph Ltd. [https://memgraph.com]
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package backend
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/memgraph/bolt-proxy/config"
+	"github.com/memgraph/bolt-proxy/proxy_logger"
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Supported password hash algorithms
+const (
+	HASH_BCRYPT   = "bcrypt"
+	HASH_ARGON2ID = "argon2id"
+)
+
+// Parameters of new argon2id hashes, the second recommended option of
+// RFC 9106. Existing hashes carry their own parameters.
+const (
+	ARGON2_TIME     = 3
+	ARGON2_MEMORY   = 64 * 1024
+	ARGON2_THREADS  = 4
+	ARGON2_KEY_LEN  = 32
+	ARGON2_SALT_LEN = 16
+)
+
+// How often the htpasswd file is checked for changes
+const HTPASSWD_CHECK_INTERVAL = 5 * time.Second
+
+var errUnsupportedHash = errors.New("unsupported hash, expected bcrypt or argon2")
+
+type htpasswdUser struct {
+	hash  string
+	roles []string
+}
+
+// Authenticates basic credentials against a local htpasswd style file,
+// see config.HtpasswdAuth. Roles listed in the file end up in the "roles"
+// claim of the client's identity.
+type HtpasswdAuth struct {
+	path          string
+	checkInterval time.Duration
+	// compared against for unknown users, so they take as long as known ones
+	dummyHash string
+
+	mu        sync.Mutex
+	users     map[string]htpasswdUser
+	modTime   time.Time
+	size      int64
+	lastCheck time.Time
+}
+
+func NewHtpasswdAuth(conf config.HtpasswdAuth) (*HtpasswdAuth, error) {
+	if conf.File == "" {
+		return nil, errors.New("htpasswd file must be set when using htpasswd auth")
+	}
+	dummy, err := bcrypt.GenerateFromPassword([]byte("dummy"), bcrypt.DefaultCost)
+	if err != nil {
+		return nil, err
+	}
+
+	auth := &HtpasswdAuth{
+		path:          conf.File,
+		checkInterval: HTPASSWD_CHECK_INTERVAL,
+		dummyHash:     string(dummy),
+	}
+	err = auth.load()
+	if err != nil {
+		return nil, err
+	}
+	auth.lastCheck = time.Now()
+	return auth, nil
+}
+
+func (auth *HtpasswdAuth) Authenticate(token *AuthToken) (*Identity, error) {
+	if token.Scheme != SCHEME_BASIC {
+		return nil, unsupportedScheme(token.Scheme)
+	}
+	if token.Principal == "" {
+		return nil, errors.New("no principal")
+	}
+	if token.Credentials == "" {
+		return nil, errNoCredentials
+	}
+
+	auth.mu.Lock()
+	auth.reloadIfChanged()
+	user, found := auth.users[token.Principal]
+	auth.mu.Unlock()
+
+	if !found {
+		_ = checkPassword(auth.dummyHash, token.Credentials)
+		return nil, errors.New("unauthorized creds")
+	}
+	if checkPassword(user.hash, token.Credentials) != nil {
+		return nil, errors.New("unauthorized creds")
+	}
+
+	identity := &Identity{Principal: token.Principal}
+	if len(user.roles) > 0 {
+		roles := make([]interface{}, len(user.roles))
+		for i, role := range user.roles {
+			roles[i] = role
+		}
+		identity.Claims = map[string]interface{}{"roles": roles}
+	}
+	return identity, nil
+}
+
+// Reload the file if its size or modification time changed. Must be
+// called with the lock held.
+func (auth *HtpasswdAuth) reloadIfChanged() {
+	if time.Since(auth.lastCheck) < auth.checkInterval {
+		return
+	}
+	auth.lastCheck = time.Now()
+
+	info, err := os.Stat(auth.path)
+	if err == nil && info.ModTime().Equal(auth.modTime) && info.Size() == auth.size {
+		return
+	}
+	if err == nil {
+		err = auth.load()
+	}
+	if err != nil {
+		// keep the users we have
+		proxy_logger.WarnLog.Printf("failed to reload htpasswd file: %v", err)
+		return
+	}
+	proxy_logger.InfoLog.Printf("reloaded %d users from %s", len(auth.users), auth.path)
+}
+
+// Must be called with the lock held or before auth is shared.
+func (auth *HtpasswdAuth) load() error {
+	info, err := os.Stat(auth.path)
+	if err != nil {
+		return err
+	}
+	data, err := ioutil.ReadFile(auth.path)
+	if err != nil {
+		return err
+	}
+	users, err := parseHtpasswd(string(data))
+	if err != nil {
+		return fmt.Errorf("%s: %v", auth.path, err)
+	}
+
+	auth.users = users
+	auth.modTime = info.ModTime()
+	auth.size = info.Size()
+	return nil
+}
+
+// Parse user:hash[:role,role...] lines, skipping blank lines and # comments.
+func parseHtpasswd(data string) (map[string]htpasswdUser, error) {
+	users := make(map[string]htpasswdUser)
+	for i, line := range strings.Split(data, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		fields := strings.Split(line, ":")
+		if len(fields) < 2 || len(fields) > 3 || fields[0] == "" {
+			return nil, fmt.Errorf("line %d: expected user:hash[:roles]", i+1)
+		}
+		if _, found := users[fields[0]]; found {
+			return nil, fmt.Errorf("line %d: duplicate user %q", i+1, fields[0])
+		}
+		err := checkHashFormat(fields[1])
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %v", i+1, err)
+		}
+
+		user := htpasswdUser{hash: fields[1]}
+		if len(fields) == 3 {
+			user.roles = splitRoles(fields[2])
+		}
+		users[fields[0]] = user
+	}
+	return users, nil
+}
+
+func splitRoles(roles string) []string {
+	var split []string
+	for _, role := range strings.Split(roles, ",") {
+		role = strings.TrimSpace(role)
+		if role != "" {
+			split = append(split, role)
+		}
+	}
+	return split
+}
+
+// Format an htpasswd line for the user, roles being a comma separated list.
+func HtpasswdEntry(user, hash, roles string) (string, error) {
+	if user == "" || strings.ContainsAny(user, ":\n#") {
+		return "", fmt.Errorf("invalid user name %q", user)
+	}
+	if strings.ContainsAny(roles, ":\n") {
+		return "", fmt.Errorf("invalid roles %q", roles)
+	}
+	entry := user + ":" + hash
+	if split := splitRoles(roles); len(split) > 0 {
+		entry += ":" + strings.Join(split, ",")
+	}
+	return entry, nil
+}
+
+// Hash a password with bcrypt or argon2id for use in an htpasswd file.
+func HashPassword(password, algorithm string) (string, error) {
+	if password == "" {
+		return "", errors.New("empty password")
+	}
+
+	switch algorithm {
+	case HASH_BCRYPT:
+		// longer passwords would be silently truncated
+		if len(password) > 72 {
+			return "", errors.New("bcrypt passwords are limited to 72 bytes")
+		}
+		hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+		return string(hash), err
+	case HASH_ARGON2ID:
+		salt := make([]byte, ARGON2_SALT_LEN)
+		_, err := rand.Read(salt)
+		if err != nil {
+			return "", err
+		}
+		key := argon2.IDKey([]byte(password), salt, ARGON2_TIME, ARGON2_MEMORY, ARGON2_THREADS, ARGON2_KEY_LEN)
+		return fmt.Sprintf("$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s", argon2.Version,
+			ARGON2_MEMORY, ARGON2_TIME, ARGON2_THREADS,
+			base64.RawStdEncoding.EncodeToString(salt),
+			base64.RawStdEncoding.EncodeToString(key)), nil
+	default:
+		return "", fmt.Errorf("unknown hash algorithm %q, expected %s or %s", algorithm, HASH_BCRYPT, HASH_ARGON2ID)
+	}
+}
+
+func checkHashFormat(hash string) error {
+	switch {
+	case strings.HasPrefix(hash, "$2"):
+		_, err := bcrypt.Cost([]byte(hash))
+		return err
+	case strings.HasPrefix(hash, "$argon2"):
+		_, err := parseArgon2(hash)
+		return err
+	default:
+		return errUnsupportedHash
+	}
+}
+
+// Returns nil if password matches the bcrypt or argon2 hash.
+func checkPassword(hash, password string) error {
+	switch {
+	case strings.HasPrefix(hash, "$2"):
+		return bcrypt.CompareHashAndPassword([]byte(hash), []byte(password))
+	case strings.HasPrefix(hash, "$argon2"):
+		params, err := parseArgon2(hash)
+		if err != nil {
+			return err
+		}
+		derive := argon2.IDKey
+		if params.variant == "argon2i" {
+			derive = argon2.Key
+		}
+		key := derive([]byte(password), params.salt, params.time, params.memory, params.threads, uint32(len(params.key)))
+		if subtle.ConstantTimeCompare(key, params.key) != 1 {
+			return errors.New("password mismatch")
+		}
+		return nil
+	default:
+		return errUnsupportedHash
+	}
+}
+
+type argon2Params struct {
+	variant string
+	memory  uint32
+	time    uint32
+	threads uint8
+	salt    []byte
+	key     []byte
+}
+
+// Parse a PHC formatted hash: $argon2id$v=19$m=65536,t=3,p=4$salt$key
+func parseArgon2(hash string) (*argon2Params, error) {
+	parts := strings.Split(hash, "$")
+	if len(parts) != 6 {
+		return nil, errors.New("malformed argon2 hash")
+	}
+
+	params := &argon2Params{variant: parts[1]}
+	if params.variant != "argon2id" && params.variant != "argon2i" {
+		return nil, fmt.Errorf("unsupported variant %s", params.variant)
+	}
+	var version int
+	_, err := fmt.Sscanf(parts[2], "v=%d", &version)
+	if err != nil || version != argon2.Version {
+		return nil, fmt.Errorf("unsupported argon2 version %q", parts[2])
+	}
+	_, err = fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &params.memory, &params.time, &params.threads)
+	if err != nil || params.time == 0 || params.threads == 0 {
+		return nil, fmt.Errorf("malformed argon2 parameters %q", parts[3])
+	}
+	params.salt, err = base64.RawStdEncoding.DecodeString(parts[4])
+	if err != nil {
+		return nil, fmt.Errorf("malformed argon2 salt: %v", err)
+	}
+	params.key, err = base64.RawStdEncoding.DecodeString(parts[5])
+	if err != nil || len(params.key) == 0 {
+		return nil, errors.New("malformed argon2 key")
+	}
+	return params, nil
+}
@@ -0,0 +1,149 @@
+/*
+Copyright (c) 2021 Memgraph Ltd. [https://memgraph.com]
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package backend
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/memgraph/bolt-proxy/config"
+)
+
+func htpasswdLine(t *testing.T, user, password, algorithm, roles string) string {
+	hash, err := HashPassword(password, algorithm)
+	if err != nil {
+		t.Fatal(err)
+	}
+	entry, err := HtpasswdEntry(user, hash, roles)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return entry
+}
+
+func writeHtpasswd(t *testing.T, path string, lines ...string) {
+	err := ioutil.WriteFile(path, []byte(strings.Join(lines, "\n")+"\n"), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestHtpasswdAuth(t *testing.T) {
+	dir, err := ioutil.TempDir("", "bolt-proxy-htpasswd")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	path := filepath.Join(dir, "users")
+
+	// htpasswd -B writes $2y$ hashes
+	alice := strings.Replace(htpasswdLine(t, "alice", "alice-secret", HASH_BCRYPT, "admin, analyst"), "$2a$", "$2y$", 1)
+	writeHtpasswd(t, path,
+		"# proxy users",
+		alice,
+		"",
+		htpasswdLine(t, "bob", "bob-secret", HASH_ARGON2ID, ""),
+	)
+
+	auth, err := NewHtpasswdAuth(config.HtpasswdAuth{File: path})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	identity, err := auth.Authenticate(basicToken("alice", "alice-secret"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(identity.Claims["roles"], []interface{}{"admin", "analyst"}) {
+		t.Fatalf("unexpected identity %#v", identity)
+	}
+	identity, err = auth.Authenticate(basicToken("bob", "bob-secret"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if identity.Principal != "bob" || identity.Claims != nil {
+		t.Fatalf("unexpected identity %#v", identity)
+	}
+
+	for _, token := range []*AuthToken{
+		basicToken("alice", "bob-secret"),
+		basicToken("bob", "wrong"),
+		basicToken("carol", "alice-secret"),
+		basicToken("alice", ""),
+		{Scheme: SCHEME_BEARER, Credentials: "alice-secret"},
+	} {
+		if _, err := auth.Authenticate(token); err == nil {
+			t.Fatalf("expected %#v to be rejected", token)
+		}
+	}
+
+	// changes are picked up, broken files are ignored
+	auth.checkInterval = 0
+	writeHtpasswd(t, path, htpasswdLine(t, "carol", "carol-secret", HASH_BCRYPT, "readers"))
+	if _, err := auth.Authenticate(basicToken("carol", "carol-secret")); err != nil {
+		t.Fatalf("expected reloaded user to be accepted: %v", err)
+	}
+	if _, err := auth.Authenticate(basicToken("alice", "alice-secret")); err == nil {
+		t.Fatal("expected removed user to be rejected")
+	}
+	writeHtpasswd(t, path, "carol")
+	if _, err := auth.Authenticate(basicToken("carol", "carol-secret")); err != nil {
+		t.Fatalf("expected previous users to be kept: %v", err)
+	}
+}
+
+func TestParseHtpasswd(t *testing.T) {
+	tests := []struct {
+		data     string
+		expected string
+	}{
+		{"alice", "line 1: expected user:hash[:roles]"},
+		{"# comment\nalice:$apr1$salt$hash", "line 2: unsupported hash"},
+		{"alice:secret", "line 1: unsupported hash"},
+		{"alice:$argon2id$v=19$m=65536$salt$key", "line 1: malformed argon2"},
+		{"alice:$2y$nope", "line 1: "},
+	}
+	for _, test := range tests {
+		_, err := parseHtpasswd(test.data)
+		if err == nil || !strings.HasPrefix(err.Error(), test.expected) {
+			t.Fatalf("%q: expected error %q, got %v", test.data, test.expected, err)
+		}
+	}
+
+	hash, err := HashPassword("secret", HASH_BCRYPT)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = parseHtpasswd("alice:" + hash + "\nalice:" + hash)
+	if err == nil || !strings.Contains(err.Error(), "duplicate user") {
+		t.Fatalf("expected duplicate user to be rejected, got %v", err)
+	}
+
+	if _, err := HashPassword(strings.Repeat("x", 73), HASH_BCRYPT); err == nil {
+		t.Fatal("expected long bcrypt password to be rejected")
+	}
+	if _, err := HashPassword("secret", "md5"); err == nil {
+		t.Fatal("expected unknown algorithm to be rejected")
+	}
+	if _, err := HtpasswdEntry("a:b", hash, ""); err == nil {
+		t.Fatal("expected user name with a colon to be rejected")
+	}
+}
@@ -0,0 +1,116 @@
+/*
+Copyright (c) 2021 Memgraph Ltd. [https://memgraph.com]
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package backend
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net"
+	"time"
+
+	"github.com/memgraph/bolt-proxy/config"
+)
+
+// What we know about a client from its connection, before looking at any
+// Bolt message it sends.
+type ClientInfo struct {
+	RemoteAddr net.Addr
+	// Set for TLS connections once the handshake is done
+	TLS *tls.ConnectionState
+	// Principal taken from a verified client certificate, if any
+	CertPrincipal string
+}
+
+// The client's IP address without the port, or an empty string if unknown.
+func (c ClientInfo) Host() string {
+	if c.RemoteAddr == nil {
+		return ""
+	}
+	host, _, err := net.SplitHostPort(c.RemoteAddr.String())
+	if err != nil {
+		return c.RemoteAddr.String()
+	}
+	return host
+}
+
+// Who the proxy authenticated a client as.
+type Identity struct {
+	Principal string
+	// What the authenticator vouches for about the client, e.g. verified
+	// token claims, LDAP groups or roles
+	Claims map[string]interface{}
+	// Memgraph account to use for the client, overriding the credential
+	// mapping, if the authenticator picked one
+	Backend *config.MemgraphCredential
+	// How long the client's session may last, 0 for no limit
+	SessionTTL time.Duration
+}
+
+// When the identity's token expires, going by its exp claim.
+func (i *Identity) Expiry() (time.Time, bool) {
+	if i == nil {
+		return time.Time{}, false
+	}
+	exp, ok := i.Claims["exp"].(float64)
+	if !ok {
+		return time.Time{}, false
+	}
+	return time.Unix(int64(exp), 0), true
+}
+
+// Extract the principal from a verified client certificate, using the
+// subject or SAN field selected by from (see config.PRINCIPAL_*).
+func ClientCertPrincipal(cert *x509.Certificate, from string) (string, error) {
+	var principal string
+
+	switch from {
+	case config.PRINCIPAL_SUBJECT_CN, "":
+		principal = cert.Subject.CommonName
+	case config.PRINCIPAL_SUBJECT:
+		principal = cert.Subject.String()
+	case config.PRINCIPAL_SAN_DNS:
+		if len(cert.DNSNames) > 0 {
+			principal = cert.DNSNames[0]
+		}
+	case config.PRINCIPAL_SAN_EMAIL:
+		if len(cert.EmailAddresses) > 0 {
+			principal = cert.EmailAddresses[0]
+		}
+	case config.PRINCIPAL_SAN_URI:
+		if len(cert.URIs) > 0 {
+			principal = cert.URIs[0].String()
+		}
+	default:
+		return "", fmt.Errorf("unknown principal source %q", from)
+	}
+
+	if principal == "" {
+		return "", errors.New("client certificate has no " + from)
+	}
+	return principal, nil
+}
+
+// Principal of the first verified client certificate in the TLS state, or
+// an empty string if the client didn't present a verified certificate.
+func VerifiedCertPrincipal(state *tls.ConnectionState, from string) (string, error) {
+	if state == nil || len(state.VerifiedChains) == 0 || len(state.VerifiedChains[0]) == 0 {
+		return "", nil
+	}
+	return ClientCertPrincipal(state.VerifiedChains[0][0], from)
+}
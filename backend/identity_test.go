@@ -0,0 +1,182 @@
+/*
+Copyright (c) 2021 Memgraph Ltd. [https://memgraph.com]
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package backend
+
+import (
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"errors"
+	"io/ioutil"
+	"log"
+	"net/url"
+	"os"
+	"testing"
+
+	"github.com/memgraph/bolt-proxy/bolt"
+	"github.com/memgraph/bolt-proxy/config"
+	"github.com/memgraph/bolt-proxy/proxy_logger"
+)
+
+func TestMain(m *testing.M) {
+	proxy_logger.DebugLog = log.New(ioutil.Discard, "", 0)
+	proxy_logger.InfoLog = log.New(ioutil.Discard, "", 0)
+	proxy_logger.WarnLog = log.New(ioutil.Discard, "", 0)
+	proxy_logger.AuditLog = log.New(ioutil.Discard, "", 0)
+	os.Exit(m.Run())
+}
+
+// Build a Bolt v1 style INIT/HELLO message carrying the given auth map
+func helloMessage(t *testing.T, authData map[string]interface{}) *bolt.Message {
+	userAgent, err := bolt.StringToBytes("test-client/1.0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	authMap, err := bolt.TinyMapToBytes(authData)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data := append([]byte{0x00, 0x00, 0xb2, 0x01}, userAgent...)
+	data = append(data, authMap...)
+	data = append(data, 0x00, 0x00)
+	return &bolt.Message{T: bolt.HelloMsg, Data: data}
+}
+
+// Accepts or rejects everything, remembering the last token it saw.
+type staticAuth struct {
+	err   error
+	token *AuthToken
+}
+
+func (a *staticAuth) Authenticate(token *AuthToken) (*Identity, error) {
+	a.token = token
+	if a.err != nil {
+		return nil, a.err
+	}
+	return &Identity{Principal: token.Principal}, nil
+}
+
+func TestClientCertPrincipal(t *testing.T) {
+	spiffe, _ := url.Parse("spiffe://mesh/service")
+	cert := &x509.Certificate{
+		Subject:        pkix.Name{CommonName: "service-a", Organization: []string{"Memgraph"}},
+		DNSNames:       []string{"service-a.mesh.local"},
+		EmailAddresses: []string{"service-a@mesh.local"},
+		URIs:           []*url.URL{spiffe},
+	}
+
+	tests := map[string]string{
+		config.PRINCIPAL_SUBJECT_CN: "service-a",
+		config.PRINCIPAL_SUBJECT:    "CN=service-a,O=Memgraph",
+		config.PRINCIPAL_SAN_DNS:    "service-a.mesh.local",
+		config.PRINCIPAL_SAN_EMAIL:  "service-a@mesh.local",
+		config.PRINCIPAL_SAN_URI:    "spiffe://mesh/service",
+	}
+	for from, expected := range tests {
+		principal, err := ClientCertPrincipal(cert, from)
+		if err != nil {
+			t.Fatalf("%s: %v", from, err)
+		}
+		if principal != expected {
+			t.Fatalf("%s: expected %q, got %q", from, expected, principal)
+		}
+	}
+
+	_, err := ClientCertPrincipal(&x509.Certificate{}, config.PRINCIPAL_SAN_DNS)
+	if err == nil {
+		t.Fatal("expected missing SAN to fail")
+	}
+}
+
+func TestAuthenticateWithClientCert(t *testing.T) {
+	hello := helloMessage(t, map[string]interface{}{
+		"scheme":      "basic",
+		"principal":   "user",
+		"credentials": "creds",
+	})
+	rejecting := &staticAuth{err: errors.New("bad creds")}
+	withCert := ClientInfo{CertPrincipal: "service-a"}
+
+	// a certificate stands in for rejected HELLO credentials
+	identity, err := Authenticate(rejecting, config.CERT_AUTH_SUFFICIENT, hello, withCert)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if identity.Principal != "service-a" {
+		t.Fatalf("expected certificate principal, got %q", identity.Principal)
+	}
+
+	// without a certificate the HELLO credentials decide
+	_, err = Authenticate(rejecting, config.CERT_AUTH_SUFFICIENT, hello, ClientInfo{})
+	if err == nil {
+		t.Fatal("expected HELLO credentials to be checked without a certificate")
+	}
+
+	// required needs both the certificate and the HELLO credentials
+	_, err = Authenticate(nil, config.CERT_AUTH_REQUIRED, hello, ClientInfo{})
+	if err == nil {
+		t.Fatal("expected missing certificate to fail")
+	}
+	_, err = Authenticate(rejecting, config.CERT_AUTH_REQUIRED, hello, withCert)
+	if err == nil {
+		t.Fatal("expected rejected HELLO credentials to fail")
+	}
+	identity, err = Authenticate(&staticAuth{}, config.CERT_AUTH_REQUIRED, hello, withCert)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if identity.Principal != "service-a" {
+		t.Fatalf("expected certificate principal, got %q", identity.Principal)
+	}
+
+	// ignoring the certificate falls back to the HELLO principal
+	identity, err = Authenticate(&staticAuth{}, config.CERT_AUTH_IGNORE, hello, withCert)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if identity.Principal != "user" {
+		t.Fatalf("expected HELLO principal, got %q", identity.Principal)
+	}
+}
+
+func TestAuthenticateBearerHello(t *testing.T) {
+	// modern drivers send no principal with bearer tokens
+	hello := helloMessage(t, map[string]interface{}{
+		"scheme":      "bearer",
+		"credentials": "token",
+	})
+	auth := &staticAuth{}
+	if _, err := Authenticate(auth, config.CERT_AUTH_IGNORE, hello, ClientInfo{}); err != nil {
+		t.Fatal(err)
+	}
+	if auth.token.Scheme != SCHEME_BEARER || auth.token.Credentials != "token" {
+		t.Fatalf("unexpected token %#v", auth.token)
+	}
+
+	logon := helloMessage(t, map[string]interface{}{
+		"scheme":      "kerberos",
+		"credentials": "ticket",
+	})
+	logon.T = bolt.LogonMsg
+	logon.Data = append([]byte{0x00, 0x00, 0xb1, 0x6a}, logon.Data[4+len("test-client/1.0")+1:]...)
+	if _, err := Authenticate(auth, config.CERT_AUTH_IGNORE, logon, ClientInfo{}); err != nil {
+		t.Fatal(err)
+	}
+	if auth.token.Scheme != SCHEME_KERBEROS || auth.token.Credentials != "ticket" {
+		t.Fatalf("unexpected token from LOGON %#v", auth.token)
+	}
+}
@@ -0,0 +1,199 @@
+/*
+Copyright (c) 2021 Memgraph Ltd. [https://memgraph.com]
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package backend
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/memgraph/bolt-proxy/proxy_logger"
+	"gopkg.in/square/go-jose.v2"
+)
+
+// Minimum time between two fetches triggered by tokens signed with a key
+// id we don't know, so bogus tokens can't make us hammer the IdP.
+const JWKS_MIN_REFRESH_INTERVAL = 10 * time.Second
+
+// Signing keys of an IdP, fetched from its JWKS endpoint and kept in
+// memory. The keys are refetched periodically in the background and
+// whenever a token refers to a key id that isn't cached, which is what
+// happens right after the IdP rotates its keys.
+//
+// Implements oidc.KeySet.
+type keyCache struct {
+	url        string
+	client     *http.Client
+	minRefresh time.Duration
+
+	mu   sync.RWMutex
+	keys []jose.JSONWebKey
+
+	// serializes fetches so concurrent logins trigger at most one
+	fetchMu     sync.Mutex
+	lastAttempt time.Time
+
+	stop     chan struct{}
+	stopOnce sync.Once
+}
+
+// Create the cache and start refreshing it every interval. Nothing is
+// fetched until the first token has to be verified.
+func newKeyCache(url string, client *http.Client, interval time.Duration) *keyCache {
+	c := &keyCache{
+		url:        url,
+		client:     client,
+		minRefresh: JWKS_MIN_REFRESH_INTERVAL,
+		stop:       make(chan struct{}),
+	}
+	go c.refreshLoop(interval)
+	return c
+}
+
+func (c *keyCache) refreshLoop(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-c.stop:
+			return
+		case <-ticker.C:
+			c.fetchMu.Lock()
+			c.lastAttempt = time.Now()
+			err := c.fetch(context.Background())
+			c.fetchMu.Unlock()
+			if err != nil {
+				// keep using the cached keys until the IdP is back
+				proxy_logger.WarnLog.Printf("failed to refresh signing keys from %s: %v", c.url, err)
+			}
+		}
+	}
+}
+
+// Stop the background refresh.
+func (c *keyCache) close() {
+	c.stopOnce.Do(func() { close(c.stop) })
+}
+
+func (c *keyCache) VerifySignature(ctx context.Context, jwt string) ([]byte, error) {
+	jws, kid, err := parseJWS(jwt)
+	if err != nil {
+		return nil, err
+	}
+
+	keys := c.lookup(kid)
+	if len(keys) == 0 {
+		// the IdP may have rotated its keys since we last fetched them
+		err = c.refreshIfStale(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("failed to fetch signing keys: %v", err)
+		}
+		keys = c.lookup(kid)
+		if len(keys) == 0 {
+			return nil, fmt.Errorf("unknown signing key %q", kid)
+		}
+	}
+	return verifyJWS(jws, keys)
+}
+
+func (c *keyCache) lookup(kid string) []jose.JSONWebKey {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return matchKeys(c.keys, kid)
+}
+
+func (c *keyCache) refreshIfStale(ctx context.Context) error {
+	c.fetchMu.Lock()
+	defer c.fetchMu.Unlock()
+
+	// somebody else may have just fetched while we were waiting
+	if time.Since(c.lastAttempt) < c.minRefresh {
+		return nil
+	}
+	c.lastAttempt = time.Now()
+	return c.fetch(ctx)
+}
+
+// Must be called with fetchMu held.
+func (c *keyCache) fetch(ctx context.Context) error {
+	req, err := http.NewRequestWithContext(ctx, "GET", c.url, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("unexpected status %s", resp.Status)
+	}
+
+	var keySet jose.JSONWebKeySet
+	err = json.NewDecoder(resp.Body).Decode(&keySet)
+	if err != nil {
+		return fmt.Errorf("malformed key set: %v", err)
+	}
+
+	c.mu.Lock()
+	c.keys = keySet.Keys
+	c.mu.Unlock()
+	proxy_logger.DebugLog.Printf("fetched %d signing keys from %s", len(keySet.Keys), c.url)
+	return nil
+}
+
+func parseJWS(jwt string) (*jose.JSONWebSignature, string, error) {
+	jws, err := jose.ParseSigned(jwt)
+	if err != nil {
+		return nil, "", fmt.Errorf("malformed jwt: %v", err)
+	}
+	kid := ""
+	if len(jws.Signatures) > 0 {
+		kid = jws.Signatures[0].Header.KeyID
+	}
+	return jws, kid, nil
+}
+
+// Keys that may have signed a token with the given key id: the ones with
+// that id and the ones without any. All keys if the token has no id.
+func matchKeys(keys []jose.JSONWebKey, kid string) []jose.JSONWebKey {
+	if kid == "" {
+		return keys
+	}
+	var matching []jose.JSONWebKey
+	for _, key := range keys {
+		if key.KeyID == kid || key.KeyID == "" {
+			matching = append(matching, key)
+		}
+	}
+	return matching
+}
+
+func verifyJWS(jws *jose.JSONWebSignature, keys []jose.JSONWebKey) ([]byte, error) {
+	for _, key := range keys {
+		payload, err := jws.Verify(&key)
+		if err == nil {
+			return payload, nil
+		}
+	}
+	return nil, errors.New("failed to verify token signature")
+}
@@ -0,0 +1,378 @@
+/*
+Copyright (c) 2021 Memgraph Ltd. [https://memgraph.com]
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package backend
+
+import (
+	"context"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/coreos/go-oidc/v3/oidc"
+	"github.com/memgraph/bolt-proxy/config"
+	"gopkg.in/square/go-jose.v2"
+	"gopkg.in/square/go-jose.v2/jwt"
+)
+
+// Signature algorithms accepted when the issuer doesn't advertise its own
+// through discovery. Only asymmetric ones, a key can't be shared secret.
+var SIGNING_ALGORITHMS = []string{
+	oidc.RS256, oidc.RS384, oidc.RS512,
+	oidc.ES256, oidc.ES384, oidc.ES512,
+	oidc.PS256, oidc.PS384, oidc.PS512,
+}
+
+// Verifies JWTs sent as the HELLO credentials. Any OIDC issuer works
+// (Azure AD, Keycloak, Okta...), and keys can also be given statically for
+// offline use.
+type JWTAuth struct {
+	issuers   map[string]*jwtIssuer
+	audiences []string
+	claims    []config.ClaimRule
+}
+
+// A trusted issuer. Unless its keys are configured statically, provider
+// discovery happens once, on the first login, and the provider's signing
+// keys are cached; see keyCache.
+type jwtIssuer struct {
+	issuer      string
+	client      *http.Client
+	jwksRefresh time.Duration
+
+	mu       sync.Mutex
+	verifier *oidc.IDTokenVerifier
+	keys     *keyCache
+}
+
+func NewJWTAuth(conf config.JWTAuth) (*JWTAuth, error) {
+	if len(conf.Issuers) == 0 {
+		return nil, errors.New("at least one issuer must be set when using jwt auth")
+	}
+	if len(conf.Audiences) == 0 {
+		return nil, errors.New("at least one audience must be set when using jwt auth")
+	}
+	jwksRefresh := conf.JWKSRefresh.Duration
+	if jwksRefresh <= 0 {
+		jwksRefresh = config.DEFAULT_JWKS_REFRESH
+	}
+	client := &http.Client{Timeout: config.DEFAULT_AUTH_TIMEOUT}
+
+	auth := &JWTAuth{
+		issuers:   make(map[string]*jwtIssuer),
+		audiences: conf.Audiences,
+		claims:    conf.RequiredClaims,
+	}
+	for _, issuerConf := range conf.Issuers {
+		issuer, err := newJWTIssuer(issuerConf, client, jwksRefresh)
+		if err != nil {
+			auth.Close()
+			return nil, fmt.Errorf("issuer %s: %v", issuerConf.Issuer, err)
+		}
+		auth.issuers[issuerConf.Issuer] = issuer
+	}
+	return auth, nil
+}
+
+func newJWTIssuer(conf config.JWTIssuer, client *http.Client, jwksRefresh time.Duration) (*jwtIssuer, error) {
+	issuer := &jwtIssuer{
+		issuer:      conf.Issuer,
+		client:      client,
+		jwksRefresh: jwksRefresh,
+	}
+
+	switch {
+	case conf.JWKSFile != "":
+		keys, err := loadJWKSFile(conf.JWKSFile)
+		if err != nil {
+			return nil, err
+		}
+		issuer.verifier = newVerifier(conf.Issuer, &staticKeySet{keys}, nil)
+	case len(conf.PublicKeyFiles) > 0:
+		var keys []jose.JSONWebKey
+		for _, path := range conf.PublicKeyFiles {
+			fileKeys, err := loadPublicKeys(path)
+			if err != nil {
+				return nil, err
+			}
+			keys = append(keys, fileKeys...)
+		}
+		issuer.verifier = newVerifier(conf.Issuer, &staticKeySet{keys}, nil)
+	case conf.JWKSURL != "":
+		issuer.keys = newKeyCache(conf.JWKSURL, client, jwksRefresh)
+		issuer.verifier = newVerifier(conf.Issuer, issuer.keys, nil)
+	}
+	return issuer, nil
+}
+
+// The audience is checked by JWTAuth since more than one can be accepted.
+func newVerifier(issuer string, keys oidc.KeySet, algorithms []string) *oidc.IDTokenVerifier {
+	if len(algorithms) == 0 {
+		algorithms = SIGNING_ALGORITHMS
+	}
+	return oidc.NewVerifier(issuer, keys, &oidc.Config{
+		SkipClientIDCheck:    true,
+		SupportedSigningAlgs: algorithms,
+	})
+}
+
+// Bearer tokens are verified, and so are basic credentials for clients
+// that can only send the token as their password. The identity is the
+// token subject.
+func (auth *JWTAuth) Authenticate(authToken *AuthToken) (*Identity, error) {
+	if authToken.Scheme != SCHEME_BEARER && authToken.Scheme != SCHEME_BASIC {
+		return nil, unsupportedScheme(authToken.Scheme)
+	}
+	token := authToken.Credentials
+	if token == "" {
+		return nil, errNoCredentials
+	}
+
+	// the issuer is only trusted to pick the keys, the verifier checks it
+	// again once the signature is verified
+	var unverified jwt.Claims
+	parsed, err := jwt.ParseSigned(token)
+	if err == nil {
+		err = parsed.UnsafeClaimsWithoutVerification(&unverified)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("malformed jwt: %v", err)
+	}
+	issuer, found := auth.issuers[unverified.Issuer]
+	if !found {
+		return nil, fmt.Errorf("untrusted issuer %q", unverified.Issuer)
+	}
+
+	verifier, err := issuer.getVerifier()
+	if err != nil {
+		return nil, err
+	}
+	idToken, err := verifier.Verify(context.Background(), token)
+	if err != nil {
+		return nil, err
+	}
+
+	if !auth.acceptsAudience(idToken.Audience) {
+		return nil, fmt.Errorf("token audience %v not accepted", idToken.Audience)
+	}
+	var claims map[string]interface{}
+	err = idToken.Claims(&claims)
+	if err != nil {
+		return nil, err
+	}
+	for _, rule := range auth.claims {
+		if !claimMatches(claims, rule.Claim, rule.Values) {
+			return nil, fmt.Errorf("token claim %s doesn't match %v", rule.Claim, rule.Values)
+		}
+	}
+
+	// the principal in the HELLO isn't verified by anything
+	if idToken.Subject == "" {
+		return nil, errors.New("token has no subject")
+	}
+	return &Identity{Principal: idToken.Subject, Claims: claims}, nil
+}
+
+func (auth *JWTAuth) acceptsAudience(audiences []string) bool {
+	for _, audience := range audiences {
+		for _, accepted := range auth.audiences {
+			if audience == accepted {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Stop refreshing signing keys in the background.
+func (auth *JWTAuth) Close() {
+	for _, issuer := range auth.issuers {
+		issuer.close()
+	}
+}
+
+// Run provider discovery the first time it's needed. A failed discovery
+// isn't cached, so logins recover as soon as the IdP is reachable again.
+func (issuer *jwtIssuer) getVerifier() (*oidc.IDTokenVerifier, error) {
+	issuer.mu.Lock()
+	defer issuer.mu.Unlock()
+
+	if issuer.verifier != nil {
+		return issuer.verifier, nil
+	}
+
+	ctx := oidc.ClientContext(context.Background(), issuer.client)
+	provider, err := oidc.NewProvider(ctx, issuer.issuer)
+	if err != nil {
+		return nil, fmt.Errorf("provider discovery failed: %v", err)
+	}
+	var discovery struct {
+		JWKSURL    string   `json:"jwks_uri"`
+		Algorithms []string `json:"id_token_signing_alg_values_supported"`
+	}
+	err = provider.Claims(&discovery)
+	if err != nil {
+		return nil, err
+	}
+	if discovery.JWKSURL == "" {
+		return nil, errors.New("provider discovery document has no jwks_uri")
+	}
+
+	issuer.keys = newKeyCache(discovery.JWKSURL, issuer.client, issuer.jwksRefresh)
+	issuer.verifier = newVerifier(issuer.issuer, issuer.keys, discovery.Algorithms)
+	return issuer.verifier, nil
+}
+
+func (issuer *jwtIssuer) close() {
+	issuer.mu.Lock()
+	defer issuer.mu.Unlock()
+
+	if issuer.keys != nil {
+		issuer.keys.close()
+	}
+}
+
+// Check the claim at the dotted path holds one of the accepted values.
+func claimMatches(claims map[string]interface{}, path string, values []string) bool {
+	for _, value := range claimValues(claims, path) {
+		for _, accepted := range values {
+			if value == accepted {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Values of the claim at path: the elements of a list, or a string both
+// as a whole and split on spaces, the way OAuth2 scopes are.
+func claimValues(claims map[string]interface{}, path string) []string {
+	var value interface{} = claims
+	for _, part := range strings.Split(path, ".") {
+		object, ok := value.(map[string]interface{})
+		if !ok {
+			return nil
+		}
+		value, ok = object[part]
+		if !ok {
+			return nil
+		}
+	}
+
+	switch v := value.(type) {
+	case nil:
+		return nil
+	case string:
+		return append([]string{v}, strings.Fields(v)...)
+	case []interface{}:
+		values := make([]string, 0, len(v))
+		for _, element := range v {
+			values = append(values, fmt.Sprint(element))
+		}
+		return values
+	default:
+		return []string{fmt.Sprint(v)}
+	}
+}
+
+// Keys given out-of-band, which never change.
+//
+// Implements oidc.KeySet.
+type staticKeySet struct {
+	keys []jose.JSONWebKey
+}
+
+func (s *staticKeySet) VerifySignature(ctx context.Context, token string) ([]byte, error) {
+	jws, kid, err := parseJWS(token)
+	if err != nil {
+		return nil, err
+	}
+	keys := matchKeys(s.keys, kid)
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("unknown signing key %q", kid)
+	}
+	return verifyJWS(jws, keys)
+}
+
+func loadJWKSFile(path string) ([]jose.JSONWebKey, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var keySet jose.JSONWebKeySet
+	err = json.Unmarshal(data, &keySet)
+	if err != nil {
+		return nil, fmt.Errorf("%s: malformed key set: %v", path, err)
+	}
+	if len(keySet.Keys) == 0 {
+		return nil, fmt.Errorf("%s: no keys found", path)
+	}
+	for i, key := range keySet.Keys {
+		if !key.IsPublic() {
+			keySet.Keys[i] = key.Public()
+		}
+	}
+	return keySet.Keys, nil
+}
+
+// Read PEM encoded public keys or certificates. The keys have no key id,
+// so they're tried on any token of the issuer.
+func loadPublicKeys(path string) ([]jose.JSONWebKey, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var keys []jose.JSONWebKey
+	for {
+		var block *pem.Block
+		block, data = pem.Decode(data)
+		if block == nil {
+			break
+		}
+
+		var key interface{}
+		switch block.Type {
+		case "PUBLIC KEY":
+			key, err = x509.ParsePKIXPublicKey(block.Bytes)
+		case "RSA PUBLIC KEY":
+			key, err = x509.ParsePKCS1PublicKey(block.Bytes)
+		case "CERTIFICATE":
+			var cert *x509.Certificate
+			cert, err = x509.ParseCertificate(block.Bytes)
+			if err == nil {
+				key = cert.PublicKey
+			}
+		default:
+			err = fmt.Errorf("unexpected PEM block %q", block.Type)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", path, err)
+		}
+		keys = append(keys, jose.JSONWebKey{Key: key})
+	}
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("%s: no public keys found", path)
+	}
+	return keys, nil
+}
@@ -0,0 +1,186 @@
+/*
+Copyright (c) 2021 Memgraph Ltd. [https://memgraph.com]
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package backend
+
+import (
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/memgraph/bolt-proxy/config"
+	"gopkg.in/square/go-jose.v2"
+)
+
+func newTestJWTAuth(t *testing.T, conf config.JWTAuth) *JWTAuth {
+	auth, err := NewJWTAuth(conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(auth.Close)
+	return auth
+}
+
+func TestJWTAuthIssuersAndAudiences(t *testing.T) {
+	keycloak := newTestIdP(t)
+	okta := newTestIdP(t)
+
+	auth := newTestJWTAuth(t, config.JWTAuth{
+		Issuers: []config.JWTIssuer{
+			{Issuer: keycloak.URL},
+			{Issuer: okta.URL, JWKSURL: okta.URL + "/keys"},
+		},
+		Audiences: []string{"graph", "api://graph"},
+	})
+
+	if _, err := auth.Authenticate(tokenAuthData(keycloak.token("graph"))); err != nil {
+		t.Fatalf("token from discovered issuer rejected: %v", err)
+	}
+	if _, err := auth.Authenticate(tokenAuthData(okta.token("api://graph"))); err != nil {
+		t.Fatalf("token from issuer with jwks url rejected: %v", err)
+	}
+	if discovery, _ := okta.counts(); discovery != 0 {
+		t.Fatal("expected no discovery for an issuer with a jwks url")
+	}
+
+	if _, err := auth.Authenticate(tokenAuthData(okta.token("billing"))); err == nil {
+		t.Fatal("expected token for another audience to be rejected")
+	}
+	untrusted := newTestIdP(t)
+	if _, err := auth.Authenticate(tokenAuthData(untrusted.token("graph"))); err == nil {
+		t.Fatal("expected token from untrusted issuer to be rejected")
+	}
+}
+
+func TestJWTAuthRequiresSubject(t *testing.T) {
+	idp := newTestIdP(t)
+	auth := newTestJWTAuth(t, config.JWTAuth{
+		Issuers:   []config.JWTIssuer{{Issuer: idp.URL}},
+		Audiences: []string{"graph"},
+	})
+
+	identity, err := auth.Authenticate(tokenAuthData(idp.token("graph")))
+	if err != nil || identity.Principal != "user" {
+		t.Fatalf("expected the subject as principal, got %v, %v", identity, err)
+	}
+	// a signed token without a subject can't pick its own principal
+	token := tokenAuthData(idp.tokenWithClaims("graph", map[string]interface{}{"sub": ""}))
+	token.Principal = "admin"
+	if _, err := auth.Authenticate(token); err == nil {
+		t.Fatal("expected a token without a subject to be rejected")
+	}
+}
+
+func TestJWTAuthStaticKeys(t *testing.T) {
+	idp := newTestIdP(t)
+	dir, err := ioutil.TempDir("", "bolt-proxy-jwt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+
+	der, err := x509.MarshalPKIXPublicKey(&idp.key.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pemFile := filepath.Join(dir, "issuer.pem")
+	err = ioutil.WriteFile(pemFile, pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// a private key in the key set only contributes its public half
+	jwks, err := json.Marshal(jose.JSONWebKeySet{Keys: []jose.JSONWebKey{{
+		Key:   idp.key,
+		KeyID: idp.kid,
+	}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	jwksFile := filepath.Join(dir, "jwks.json")
+	if err = ioutil.WriteFile(jwksFile, jwks, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	for name, issuer := range map[string]config.JWTIssuer{
+		"pem":  {Issuer: idp.URL, PublicKeyFiles: []string{pemFile}},
+		"jwks": {Issuer: idp.URL, JWKSFile: jwksFile},
+	} {
+		auth := newTestJWTAuth(t, config.JWTAuth{
+			Issuers:   []config.JWTIssuer{issuer},
+			Audiences: []string{"graph"},
+		})
+		if _, err := auth.Authenticate(tokenAuthData(idp.token("graph"))); err != nil {
+			t.Fatalf("%s: token rejected: %v", name, err)
+		}
+	}
+	if discovery, jwks := idp.counts(); discovery != 0 || jwks != 0 {
+		t.Fatalf("expected static keys to work offline, got %d discovery and %d jwks requests", discovery, jwks)
+	}
+
+	// tokens signed by another key are still rejected
+	idp.rotate("key-2")
+	auth := newTestJWTAuth(t, config.JWTAuth{
+		Issuers:   []config.JWTIssuer{{Issuer: idp.URL, PublicKeyFiles: []string{pemFile}}},
+		Audiences: []string{"graph"},
+	})
+	if _, err := auth.Authenticate(tokenAuthData(idp.token("graph"))); err == nil {
+		t.Fatal("expected token signed by an unknown key to be rejected")
+	}
+}
+
+func TestJWTAuthRequiredClaims(t *testing.T) {
+	idp := newTestIdP(t)
+	auth := newTestJWTAuth(t, config.JWTAuth{
+		Issuers:   []config.JWTIssuer{{Issuer: idp.URL}},
+		Audiences: []string{"graph"},
+		RequiredClaims: []config.ClaimRule{
+			{Claim: "groups", Values: []string{"graph-admins", "graph-users"}},
+			{Claim: "scp", Values: []string{"graph.read"}},
+			{Claim: "realm_access.roles", Values: []string{"memgraph"}},
+		},
+		JWKSRefresh: config.Duration{Duration: time.Hour},
+	})
+
+	claims := map[string]interface{}{
+		"groups":       []string{"staff", "graph-users"},
+		"scp":          "openid graph.read",
+		"realm_access": map[string]interface{}{"roles": []string{"memgraph"}},
+	}
+	if _, err := auth.Authenticate(tokenAuthData(idp.tokenWithClaims("graph", claims))); err != nil {
+		t.Fatalf("token with required claims rejected: %v", err)
+	}
+
+	for claim, value := range map[string]interface{}{
+		"groups":       []string{"staff"},
+		"scp":          "openid graph.write",
+		"realm_access": map[string]interface{}{"roles": "other"},
+	} {
+		wrong := make(map[string]interface{})
+		for k, v := range claims {
+			wrong[k] = v
+		}
+		wrong[claim] = value
+		if _, err := auth.Authenticate(tokenAuthData(idp.tokenWithClaims("graph", wrong))); err == nil {
+			t.Fatalf("expected token with wrong %s to be rejected", claim)
+		}
+	}
+}
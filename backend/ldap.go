@@ -0,0 +1,249 @@
+/*
+Copyright (c) 2021 Memgraph Ltd. [https://memgraph.com]
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package backend
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/go-ldap/ldap/v3"
+	"github.com/memgraph/bolt-proxy/config"
+)
+
+// Authenticates basic credentials against an LDAP or Active Directory
+// server, see config.LDAPAuth. Every authentication uses its own
+// connection.
+type LDAPAuth struct {
+	url       string
+	startTLS  bool
+	tlsConfig *tls.Config
+	timeout   time.Duration
+
+	userDN       string
+	bindDN       string
+	bindPassword string
+	baseDN       string
+	userFilter   string
+
+	groupBaseDN    string
+	groupFilter    string
+	groupAttribute string
+}
+
+func NewLDAPAuth(conf config.LDAPAuth) (*LDAPAuth, error) {
+	u, err := url.Parse(conf.URL)
+	if err != nil {
+		return nil, err
+	}
+	if u.Scheme != "ldap" && u.Scheme != "ldaps" {
+		return nil, fmt.Errorf("expected an ldap:// or ldaps:// url, got %q", conf.URL)
+	}
+	if conf.UserDN == "" && conf.BaseDN == "" {
+		return nil, errors.New("ldap user dn or base dn must be set when using ldap auth")
+	}
+
+	// StartTLS needs the server name, ldaps would otherwise derive it
+	// from the address the same way
+	tlsConfig := &tls.Config{
+		ServerName: conf.ServerName,
+		MinVersion: tls.VersionTLS12,
+	}
+	if tlsConfig.ServerName == "" {
+		tlsConfig.ServerName = u.Hostname()
+	}
+	if conf.CAFile != "" {
+		pool, err := loadCertPool(conf.CAFile)
+		if err != nil {
+			return nil, err
+		}
+		tlsConfig.RootCAs = pool
+	}
+
+	timeout := conf.Timeout.Duration
+	if timeout <= 0 {
+		timeout = config.DEFAULT_AUTH_TIMEOUT
+	}
+
+	return &LDAPAuth{
+		url:            conf.URL,
+		startTLS:       conf.StartTLS,
+		tlsConfig:      tlsConfig,
+		timeout:        timeout,
+		userDN:         conf.UserDN,
+		bindDN:         conf.BindDN,
+		bindPassword:   conf.BindPassword,
+		baseDN:         conf.BaseDN,
+		userFilter:     conf.UserFilter,
+		groupBaseDN:    conf.GroupBaseDN,
+		groupFilter:    conf.GroupFilter,
+		groupAttribute: conf.GroupAttribute,
+	}, nil
+}
+
+func (auth *LDAPAuth) Authenticate(token *AuthToken) (*Identity, error) {
+	if token.Scheme != SCHEME_BASIC {
+		return nil, unsupportedScheme(token.Scheme)
+	}
+	if token.Principal == "" {
+		return nil, errors.New("no principal")
+	}
+	// an empty password would be an unauthenticated bind, which most
+	// servers accept for any DN
+	if token.Credentials == "" {
+		return nil, errNoCredentials
+	}
+
+	conn, err := auth.dial()
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	dn, err := auth.findUser(conn, token.Principal)
+	if err != nil {
+		return nil, err
+	}
+	err = conn.Bind(dn, token.Credentials)
+	if ldap.IsErrorWithCode(err, ldap.LDAPResultInvalidCredentials) {
+		return nil, errors.New("unauthorized creds")
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	identity := &Identity{
+		Principal: token.Principal,
+		Claims:    map[string]interface{}{"dn": dn},
+	}
+	if auth.groupBaseDN != "" {
+		groups, err := auth.findGroups(conn, dn, token.Principal)
+		if err != nil {
+			return nil, err
+		}
+		identity.Claims["groups"] = groups
+	}
+	return identity, nil
+}
+
+func (auth *LDAPAuth) dial() (*ldap.Conn, error) {
+	conn, err := ldap.DialURL(auth.url,
+		ldap.DialWithDialer(&net.Dialer{Timeout: auth.timeout}),
+		ldap.DialWithTLSConfig(auth.tlsConfig))
+	if err != nil {
+		return nil, err
+	}
+	conn.SetTimeout(auth.timeout)
+
+	if auth.startTLS {
+		err = conn.StartTLS(auth.tlsConfig)
+		if err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+	return conn, nil
+}
+
+// The DN of the user, either from the template or by searching for
+// exactly one matching entry.
+func (auth *LDAPAuth) findUser(conn *ldap.Conn, username string) (string, error) {
+	if auth.userDN != "" {
+		return strings.ReplaceAll(auth.userDN, "{username}", escapeDN(username)), nil
+	}
+
+	err := auth.bindService(conn)
+	if err != nil {
+		return "", err
+	}
+	filter := strings.ReplaceAll(auth.userFilter, "{username}", ldap.EscapeFilter(username))
+	result, err := conn.Search(ldap.NewSearchRequest(
+		auth.baseDN, ldap.ScopeWholeSubtree, ldap.NeverDerefAliases,
+		2, int(auth.timeout/time.Second), false, filter, []string{"dn"}, nil))
+	if err != nil {
+		return "", err
+	}
+	if len(result.Entries) != 1 {
+		return "", fmt.Errorf("expected one ldap entry for %q, found %d", username, len(result.Entries))
+	}
+	return result.Entries[0].DN, nil
+}
+
+// Names of the groups the user is a member of. When searching for users
+// as a service account, the groups are looked up as that account too.
+func (auth *LDAPAuth) findGroups(conn *ldap.Conn, dn, username string) ([]interface{}, error) {
+	if auth.userDN == "" {
+		err := auth.bindService(conn)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	filter := strings.ReplaceAll(auth.groupFilter, "{dn}", ldap.EscapeFilter(dn))
+	filter = strings.ReplaceAll(filter, "{username}", ldap.EscapeFilter(username))
+	result, err := conn.Search(ldap.NewSearchRequest(
+		auth.groupBaseDN, ldap.ScopeWholeSubtree, ldap.NeverDerefAliases,
+		0, int(auth.timeout/time.Second), false, filter, []string{auth.groupAttribute}, nil))
+	if err != nil {
+		return nil, err
+	}
+
+	groups := []interface{}{}
+	for _, entry := range result.Entries {
+		for _, name := range entry.GetAttributeValues(auth.groupAttribute) {
+			groups = append(groups, name)
+		}
+	}
+	return groups, nil
+}
+
+// Bind as the configured service account, or stay anonymous if there's
+// none.
+func (auth *LDAPAuth) bindService(conn *ldap.Conn) error {
+	if auth.bindDN == "" {
+		return nil
+	}
+	err := conn.Bind(auth.bindDN, auth.bindPassword)
+	if err != nil {
+		return fmt.Errorf("ldap service bind: %v", err)
+	}
+	return nil
+}
+
+// Escape a value for use in a DN attribute, as described in RFC 4514.
+func escapeDN(value string) string {
+	var escaped strings.Builder
+	for i := 0; i < len(value); i++ {
+		c := value[i]
+		switch {
+		case c == 0:
+			escaped.WriteString(`\00`)
+		case strings.IndexByte(`"+,;<>\=`, c) >= 0,
+			(c == ' ' || c == '#') && i == 0,
+			c == ' ' && i == len(value)-1:
+			escaped.WriteByte('\\')
+			escaped.WriteByte(c)
+		default:
+			escaped.WriteByte(c)
+		}
+	}
+	return escaped.String()
+}
@@ -0,0 +1,316 @@
+/*
+Copyright (c) 2021 Memgraph Ltd. [https://memgraph.com]
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package backend
+
+import (
+	"crypto/tls"
+	"net"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+
+	ber "github.com/go-asn1-ber/asn1-ber"
+	"github.com/go-ldap/ldap/v3"
+	"github.com/memgraph/bolt-proxy/config"
+)
+
+type ldapEntry struct {
+	dn       string
+	password string
+	attrs    map[string][]string
+}
+
+// Just enough of an LDAP server for the authenticator: simple binds,
+// StartTLS and subtree searches with a single equality filter, which
+// only bound users may run.
+type testLDAP struct {
+	entries []ldapEntry
+	cert    tls.Certificate
+
+	mu    sync.Mutex
+	binds []string
+}
+
+func newTestLDAP(t *testing.T, cert tls.Certificate, ldaps bool) (*testLDAP, string) {
+	server := &testLDAP{
+		cert: cert,
+		entries: []ldapEntry{
+			{"cn=proxy,dc=example,dc=com", "service-secret", nil},
+			{"uid=alice,ou=people,dc=example,dc=com", "alice-secret", map[string][]string{"uid": {"alice"}}},
+			{"uid=bob,ou=people,dc=example,dc=com", "bob-secret", map[string][]string{"uid": {"bob"}}},
+			{"cn=analysts,ou=groups,dc=example,dc=com", "", map[string][]string{
+				"cn":     {"analysts"},
+				"member": {"uid=alice,ou=people,dc=example,dc=com"},
+			}},
+			{"cn=users,ou=groups,dc=example,dc=com", "", map[string][]string{
+				"cn":     {"users"},
+				"member": {"uid=alice,ou=people,dc=example,dc=com", "uid=bob,ou=people,dc=example,dc=com"},
+			}},
+		},
+	}
+
+	var ln net.Listener
+	var err error
+	if ldaps {
+		ln, err = tls.Listen("tcp", "127.0.0.1:0", &tls.Config{Certificates: []tls.Certificate{cert}})
+	} else {
+		ln, err = net.Listen("tcp", "127.0.0.1:0")
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go server.serve(conn)
+		}
+	}()
+
+	return server, ln.Addr().String()
+}
+
+func (s *testLDAP) serve(conn net.Conn) {
+	defer func() { conn.Close() }()
+
+	bound := ""
+	for {
+		request, err := ber.ReadPacket(conn)
+		if err != nil || len(request.Children) < 2 {
+			return
+		}
+		id := request.Children[0].Value.(int64)
+		op := request.Children[1]
+
+		switch op.Tag {
+		case ldap.ApplicationBindRequest:
+			dn := op.Children[1].Value.(string)
+			password := op.Children[2].Data.String()
+			s.mu.Lock()
+			s.binds = append(s.binds, dn)
+			s.mu.Unlock()
+			code := uint16(ldap.LDAPResultInvalidCredentials)
+			if entry := s.find(dn); entry != nil && password != "" && entry.password == password {
+				code = ldap.LDAPResultSuccess
+				bound = dn
+			}
+			s.reply(conn, id, ldap.ApplicationBindResponse, code)
+		case ldap.ApplicationUnbindRequest:
+			return
+		case ldap.ApplicationExtendedRequest:
+			s.reply(conn, id, ldap.ApplicationExtendedResponse, ldap.LDAPResultSuccess)
+			tlsConn := tls.Server(conn, &tls.Config{Certificates: []tls.Certificate{s.cert}})
+			if tlsConn.Handshake() != nil {
+				return
+			}
+			conn = tlsConn
+		case ldap.ApplicationSearchRequest:
+			if bound == "" {
+				s.reply(conn, id, ldap.ApplicationSearchResultDone, ldap.LDAPResultInsufficientAccessRights)
+				continue
+			}
+			base := op.Children[0].Value.(string)
+			filter := op.Children[6]
+			attr := filter.Children[0].Data.String()
+			value := filter.Children[1].Data.String()
+			for _, entry := range s.entries {
+				if !strings.HasSuffix(entry.dn, base) || !contains(entry.attrs[attr], value) {
+					continue
+				}
+				result := ber.Encode(ber.ClassApplication, ber.TypeConstructed, ldap.ApplicationSearchResultEntry, nil, "")
+				result.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, entry.dn, ""))
+				attributes := ber.NewSequence("")
+				for name, values := range entry.attrs {
+					attribute := ber.NewSequence("")
+					attribute.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, name, ""))
+					set := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSet, nil, "")
+					for _, v := range values {
+						set.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, v, ""))
+					}
+					attribute.AppendChild(set)
+					attributes.AppendChild(attribute)
+				}
+				result.AppendChild(attributes)
+				s.send(conn, id, result)
+			}
+			s.reply(conn, id, ldap.ApplicationSearchResultDone, ldap.LDAPResultSuccess)
+		default:
+			return
+		}
+	}
+}
+
+func (s *testLDAP) find(dn string) *ldapEntry {
+	for i := range s.entries {
+		if s.entries[i].dn == dn {
+			return &s.entries[i]
+		}
+	}
+	return nil
+}
+
+func (s *testLDAP) reply(conn net.Conn, id int64, tag ber.Tag, code uint16) {
+	result := ber.Encode(ber.ClassApplication, ber.TypeConstructed, tag, nil, "")
+	result.AppendChild(ber.NewInteger(ber.ClassUniversal, ber.TypePrimitive, ber.TagEnumerated, int64(code), ""))
+	result.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, "", ""))
+	result.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, "", ""))
+	s.send(conn, id, result)
+}
+
+func (s *testLDAP) send(conn net.Conn, id int64, op *ber.Packet) {
+	envelope := ber.NewSequence("")
+	envelope.AppendChild(ber.NewInteger(ber.ClassUniversal, ber.TypePrimitive, ber.TagInteger, id, ""))
+	envelope.AppendChild(op)
+	_, _ = conn.Write(envelope.Bytes())
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+func basicToken(principal, credentials string) *AuthToken {
+	return &AuthToken{Scheme: SCHEME_BASIC, Principal: principal, Credentials: credentials}
+}
+
+func TestLDAPAuthDirectBind(t *testing.T) {
+	cert, _ := selfSignedCert(t)
+	server, addr := newTestLDAP(t, cert, false)
+	auth, err := NewLDAPAuth(config.LDAPAuth{
+		URL:    "ldap://" + addr,
+		UserDN: "uid={username},ou=people,dc=example,dc=com",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	identity, err := auth.Authenticate(basicToken("alice", "alice-secret"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if identity.Principal != "alice" || identity.Claims["dn"] != "uid=alice,ou=people,dc=example,dc=com" {
+		t.Fatalf("unexpected identity %#v", identity)
+	}
+	if _, found := identity.Claims["groups"]; found {
+		t.Fatal("expected no groups without group_base_dn")
+	}
+
+	if _, err := auth.Authenticate(basicToken("alice", "wrong")); err == nil {
+		t.Fatal("expected wrong password to be rejected")
+	}
+	if _, err := auth.Authenticate(basicToken("alice", "")); err == nil {
+		t.Fatal("expected empty password to be rejected")
+	}
+	if _, err := auth.Authenticate(basicToken("bob,ou=people", "bob-secret")); err == nil {
+		t.Fatal("expected DN injection to be rejected")
+	}
+	if _, err := auth.Authenticate(&AuthToken{Scheme: SCHEME_BEARER, Credentials: "token"}); err == nil {
+		t.Fatal("expected bearer scheme to be rejected")
+	}
+
+	expected := []string{
+		"uid=alice,ou=people,dc=example,dc=com",
+		"uid=alice,ou=people,dc=example,dc=com",
+		`uid=bob\,ou\=people,ou=people,dc=example,dc=com`,
+	}
+	server.mu.Lock()
+	defer server.mu.Unlock()
+	if !reflect.DeepEqual(server.binds, expected) {
+		t.Fatalf("unexpected binds %q", server.binds)
+	}
+}
+
+func TestLDAPAuthSearchAndGroups(t *testing.T) {
+	cert, caFile := selfSignedCert(t)
+	_, plainAddr := newTestLDAP(t, cert, false)
+	_, tlsAddr := newTestLDAP(t, cert, true)
+
+	tests := []struct {
+		name     string
+		url      string
+		startTLS bool
+	}{
+		{"starttls", "ldap://" + plainAddr, true},
+		{"ldaps", "ldaps://" + tlsAddr, false},
+	}
+	for _, test := range tests {
+		auth, err := NewLDAPAuth(config.LDAPAuth{
+			URL:            test.url,
+			StartTLS:       test.startTLS,
+			CAFile:         caFile,
+			ServerName:     "memgraph.local",
+			BindDN:         "cn=proxy,dc=example,dc=com",
+			BindPassword:   "service-secret",
+			BaseDN:         "ou=people,dc=example,dc=com",
+			UserFilter:     config.DEFAULT_LDAP_USER_FILTER,
+			GroupBaseDN:    "ou=groups,dc=example,dc=com",
+			GroupFilter:    config.DEFAULT_LDAP_GROUP_FILTER,
+			GroupAttribute: config.DEFAULT_LDAP_GROUP_ATTRIBUTE,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		identity, err := auth.Authenticate(basicToken("alice", "alice-secret"))
+		if err != nil {
+			t.Fatalf("%s: %v", test.name, err)
+		}
+		groups := identity.Claims["groups"]
+		if !reflect.DeepEqual(groups, []interface{}{"analysts", "users"}) {
+			t.Fatalf("%s: unexpected groups %#v", test.name, groups)
+		}
+		if !claimMatches(identity.Claims, "groups", []string{"analysts"}) {
+			t.Fatalf("%s: expected groups to be usable in claim rules", test.name)
+		}
+
+		identity, err = auth.Authenticate(basicToken("bob", "bob-secret"))
+		if err != nil {
+			t.Fatalf("%s: %v", test.name, err)
+		}
+		if !reflect.DeepEqual(identity.Claims["groups"], []interface{}{"users"}) {
+			t.Fatalf("%s: unexpected groups %#v", test.name, identity.Claims["groups"])
+		}
+
+		if _, err := auth.Authenticate(basicToken("carol", "secret")); err == nil {
+			t.Fatalf("%s: expected unknown user to be rejected", test.name)
+		}
+		if _, err := auth.Authenticate(basicToken("*", "alice-secret")); err == nil {
+			t.Fatalf("%s: expected filter injection to be rejected", test.name)
+		}
+	}
+
+	// the server certificate must be trusted
+	auth, err := NewLDAPAuth(config.LDAPAuth{
+		URL:    "ldaps://" + tlsAddr,
+		UserDN: "uid={username},ou=people,dc=example,dc=com",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := auth.Authenticate(basicToken("alice", "alice-secret")); err == nil {
+		t.Fatal("expected untrusted certificate to be rejected")
+	}
+}
@@ -0,0 +1,275 @@
+/*
+Copyright (c) 2021 Memgraph Ltd. [https://memgraph.com]
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package backend
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/memgraph/bolt-proxy/audit"
+	"github.com/memgraph/bolt-proxy/config"
+	"github.com/memgraph/bolt-proxy/metrics"
+	"github.com/memgraph/bolt-proxy/proxy_logger"
+)
+
+// Kinds of lockout
+const (
+	LOCKOUT_PRINCIPAL = "principal"
+	LOCKOUT_ADDRESS   = "address"
+)
+
+// Failure counters are swept for stale entries once there are this many
+const LOCKOUT_SWEEP_SIZE = 1024
+
+var (
+	authFailures = metrics.NewCounter("bolt_proxy_auth_failures_total",
+		"Failed client authentications.")
+	authLockouts = metrics.NewCounter("bolt_proxy_auth_lockouts_total",
+		"Principals and client addresses locked out after repeated auth failures, by kind (principal or address).", "kind")
+	authLockedOut = metrics.NewCounter("bolt_proxy_auth_locked_out_attempts_total",
+		"Authentication attempts refused because of a lockout, by kind (principal or address).", "kind")
+)
+
+// Recent failures of one principal or client address.
+type lockoutState struct {
+	failures    int
+	lastFailure time.Time
+	// Lockouts so far, each one lasting twice as long as the previous one
+	lockouts    int
+	lockedUntil time.Time
+}
+
+// One of the failure counters, for principals or client addresses.
+type lockoutCounter struct {
+	kind        string
+	maxFailures int
+	states      map[string]*lockoutState
+	sweepAt     int
+}
+
+// Delays failed authentications and locks out principals and client
+// addresses with too many failures, see config.AuthLockout. Locked out
+// clients are refused without asking the wrapped Authenticator.
+type LockoutAuth struct {
+	// Where lockouts are recorded besides the log, if set, as lockouts of
+	// the named listener
+	Audit    *audit.Logger
+	Listener string
+
+	auth        Authenticator
+	window      time.Duration
+	duration    time.Duration
+	maxDuration time.Duration
+	delay       time.Duration
+	maxDelay    time.Duration
+	now         func() time.Time
+	sleep       func(time.Duration)
+
+	mu         sync.Mutex
+	principals *lockoutCounter
+	addresses  *lockoutCounter
+}
+
+func NewLockoutAuth(auth Authenticator, conf config.AuthLockout) *LockoutAuth {
+	newCounter := func(kind string, maxFailures int) *lockoutCounter {
+		return &lockoutCounter{
+			kind:        kind,
+			maxFailures: maxFailures,
+			states:      make(map[string]*lockoutState),
+			sweepAt:     LOCKOUT_SWEEP_SIZE,
+		}
+	}
+
+	return &LockoutAuth{
+		auth:        auth,
+		window:      conf.Window.Duration,
+		duration:    conf.Duration.Duration,
+		maxDuration: conf.MaxDuration.Duration,
+		delay:       conf.Delay.Duration,
+		maxDelay:    conf.MaxDelay.Duration,
+		now:         time.Now,
+		sleep:       time.Sleep,
+		principals:  newCounter(LOCKOUT_PRINCIPAL, conf.MaxFailures),
+		addresses:   newCounter(LOCKOUT_ADDRESS, conf.MaxAddressFailures),
+	}
+}
+
+func (a *LockoutAuth) Authenticate(token *AuthToken) (*Identity, error) {
+	keys := []struct {
+		counter *lockoutCounter
+		key     string
+	}{
+		{a.principals, token.Principal},
+		{a.addresses, token.Client.Host()},
+	}
+
+	a.mu.Lock()
+	now := a.now()
+	for _, k := range keys {
+		state := k.counter.get(k.key)
+		if state != nil && now.Before(state.lockedUntil) {
+			delay := a.failureDelay(state.failures)
+			a.mu.Unlock()
+			authLockedOut.Inc(k.counter.kind)
+			a.sleep(delay)
+			return nil, fmt.Errorf("%s %q is locked out for another %v",
+				k.counter.kind, k.key, state.lockedUntil.Sub(now).Round(time.Second))
+		}
+	}
+	a.mu.Unlock()
+
+	identity, err := a.auth.Authenticate(token)
+	if err == nil {
+		a.mu.Lock()
+		a.principals.forget(token.Principal)
+		a.mu.Unlock()
+		return identity, nil
+	}
+
+	authFailures.Inc()
+	failures := 0
+	lockouts := make(map[string]time.Duration)
+	a.mu.Lock()
+	now = a.now()
+	for _, k := range keys {
+		n, lockout := a.fail(k.counter, k.key, now)
+		if n > failures {
+			failures = n
+		}
+		if lockout > 0 {
+			lockouts[k.counter.kind] = lockout
+		}
+	}
+	a.mu.Unlock()
+
+	for kind, lockout := range lockouts {
+		a.record(kind, token, now, lockout)
+	}
+	a.sleep(a.failureDelay(failures))
+	return nil, err
+}
+
+// Count a failure for key, locking it out if it had too many. Returns the
+// failures counted so far and how long key is locked out for, if it is
+// now. Must be called with the lock held.
+func (a *LockoutAuth) fail(counter *lockoutCounter, key string, now time.Time) (int, time.Duration) {
+	if counter.maxFailures <= 0 || key == "" {
+		return 0, 0
+	}
+	counter.sweep(now, a.window)
+
+	state := counter.states[key]
+	if state == nil {
+		state = &lockoutState{}
+		counter.states[key] = state
+	}
+	if state.stale(now, a.window) {
+		*state = lockoutState{}
+	}
+	state.failures++
+	state.lastFailure = now
+	failures := state.failures
+	if state.failures < counter.maxFailures {
+		return failures, 0
+	}
+
+	lockout := a.duration << uint(state.lockouts)
+	if lockout > a.maxDuration || lockout <= 0 {
+		lockout = a.maxDuration
+	}
+	state.lockouts++
+	state.failures = 0
+	state.lockedUntil = now.Add(lockout)
+	authLockouts.Inc(counter.kind)
+	proxy_logger.AuditLog.Printf("%s %q locked out for %v after %d failed authentications",
+		counter.kind, key, lockout, counter.maxFailures)
+	return failures, lockout
+}
+
+// Record a lockout in the audit log, if there is one.
+func (a *LockoutAuth) record(kind string, token *AuthToken, now time.Time, lockout time.Duration) {
+	if a.Audit == nil {
+		return
+	}
+	e := audit.Event{
+		Time:       now,
+		Type:       audit.LOCKOUT,
+		Listener:   a.Listener,
+		Principal:  token.Principal,
+		DurationMs: float64(lockout) / float64(time.Millisecond),
+		LockedOut:  kind,
+	}
+	if token.Client.RemoteAddr != nil {
+		e.ClientAddress = token.Client.RemoteAddr.String()
+	}
+	a.Audit.Log(e)
+}
+
+// How long to hold back the FAILURE after the given number of failures,
+// doubling with every failure.
+func (a *LockoutAuth) failureDelay(failures int) time.Duration {
+	if failures <= 0 || a.delay <= 0 {
+		return a.delay
+	}
+	delay := a.delay << uint(failures-1)
+	if delay > a.maxDelay || delay <= 0 {
+		delay = a.maxDelay
+	}
+	return delay
+}
+
+// Must be called with the lock held.
+func (c *lockoutCounter) get(key string) *lockoutState {
+	if c.maxFailures <= 0 || key == "" {
+		return nil
+	}
+	return c.states[key]
+}
+
+// Must be called with the lock held.
+func (c *lockoutCounter) forget(key string) {
+	delete(c.states, key)
+}
+
+// Drop states nobody failed with for a while, so clients making up
+// principals can't grow the counter without bounds. Must be called with
+// the lock held.
+func (c *lockoutCounter) sweep(now time.Time, window time.Duration) {
+	if len(c.states) < c.sweepAt {
+		return
+	}
+	for key, state := range c.states {
+		if state.stale(now, window) {
+			delete(c.states, key)
+		}
+	}
+	c.sweepAt = 2 * len(c.states)
+	if c.sweepAt < LOCKOUT_SWEEP_SIZE {
+		c.sweepAt = LOCKOUT_SWEEP_SIZE
+	}
+}
+
+// A state is stale once it neither failed nor was locked out for a window,
+// after which its failures and lockouts start from scratch.
+func (s *lockoutState) stale(now time.Time, window time.Duration) bool {
+	last := s.lastFailure
+	if s.lockedUntil.After(last) {
+		last = s.lockedUntil
+	}
+	return now.Sub(last) >= window
+}
@@ -0,0 +1,189 @@
+/*
+Copyright (c) 2021 Memgraph Ltd. [https://memgraph.com]
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package backend
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/memgraph/bolt-proxy/audit"
+	"github.com/memgraph/bolt-proxy/config"
+	"github.com/memgraph/bolt-proxy/redact"
+)
+
+func newTestLockout(auth Authenticator, conf config.AuthLockout) (*LockoutAuth, *time.Time, *[]time.Duration) {
+	lockout := NewLockoutAuth(auth, conf)
+	now := time.Now()
+	var delays []time.Duration
+	lockout.now = func() time.Time { return now }
+	lockout.sleep = func(d time.Duration) { delays = append(delays, d) }
+	return lockout, &now, &delays
+}
+
+func tokenFrom(principal, credentials, ip string) *AuthToken {
+	token := basicToken(principal, credentials)
+	token.Client.RemoteAddr = &net.TCPAddr{IP: net.ParseIP(ip), Port: 40000}
+	return token
+}
+
+func TestLockoutAuth(t *testing.T) {
+	auth := &countingAuth{}
+	lockout, now, delays := newTestLockout(auth, config.AuthLockout{
+		MaxFailures: 3,
+		Window:      config.Duration{Duration: 10 * time.Minute},
+		Duration:    config.Duration{Duration: time.Minute},
+		MaxDuration: config.Duration{Duration: 3 * time.Minute},
+		Delay:       config.Duration{Duration: time.Second},
+		MaxDelay:    config.Duration{Duration: 3 * time.Second},
+	})
+	lockouts := authLockouts.Value(LOCKOUT_PRINCIPAL)
+
+	// failures back off exponentially, the third one locks alice out
+	for i := 0; i < 3; i++ {
+		if _, err := lockout.Authenticate(tokenFrom("alice", "wrong", "10.0.0.1")); err == nil {
+			t.Fatal("expected wrong password to be rejected")
+		}
+	}
+	if !reflect.DeepEqual(*delays, []time.Duration{time.Second, 2 * time.Second, 3 * time.Second}) {
+		t.Fatalf("unexpected delays %v", *delays)
+	}
+	if authLockouts.Value(LOCKOUT_PRINCIPAL)-lockouts != 1 {
+		t.Fatal("expected a lockout to be counted")
+	}
+
+	// even the right password is refused without asking auth
+	if _, err := lockout.Authenticate(tokenFrom("alice", "secret", "10.0.0.2")); err == nil {
+		t.Fatal("expected locked out principal to be refused")
+	}
+	if auth.count() != 3 {
+		t.Fatalf("expected locked out attempts not to reach auth, got %d calls", auth.count())
+	}
+	// other principals aren't affected
+	if _, err := lockout.Authenticate(tokenFrom("bob", "secret", "10.0.0.1")); err != nil {
+		t.Fatal(err)
+	}
+
+	// the lockout ends, and the next one lasts twice as long
+	*now = now.Add(time.Minute)
+	for i := 0; i < 3; i++ {
+		_, _ = lockout.Authenticate(tokenFrom("alice", "wrong", "10.0.0.1"))
+	}
+	*now = now.Add(time.Minute)
+	if _, err := lockout.Authenticate(tokenFrom("alice", "secret", "10.0.0.1")); err == nil {
+		t.Fatal("expected second lockout to last longer")
+	}
+	*now = now.Add(time.Minute)
+	if _, err := lockout.Authenticate(tokenFrom("alice", "secret", "10.0.0.1")); err != nil {
+		t.Fatal(err)
+	}
+
+	// a success resets the failures
+	for i := 0; i < 2; i++ {
+		_, _ = lockout.Authenticate(tokenFrom("alice", "wrong", "10.0.0.1"))
+	}
+	_, _ = lockout.Authenticate(tokenFrom("alice", "secret", "10.0.0.1"))
+	_, _ = lockout.Authenticate(tokenFrom("alice", "wrong", "10.0.0.1"))
+	if _, err := lockout.Authenticate(tokenFrom("alice", "secret", "10.0.0.1")); err != nil {
+		t.Fatalf("expected no lockout after a success: %v", err)
+	}
+}
+
+func TestLockoutAuthByAddress(t *testing.T) {
+	auth := &countingAuth{}
+	lockout, now, _ := newTestLockout(auth, config.AuthLockout{
+		MaxAddressFailures: 2,
+		Window:             config.Duration{Duration: time.Minute},
+		Duration:           config.Duration{Duration: time.Minute},
+		MaxDuration:        config.Duration{Duration: time.Minute},
+	})
+
+	// spraying passwords over principals locks the address out
+	_, _ = lockout.Authenticate(tokenFrom("alice", "wrong", "10.0.0.1"))
+	_, _ = lockout.Authenticate(tokenFrom("bob", "wrong", "10.0.0.1"))
+	if _, err := lockout.Authenticate(tokenFrom("carol", "secret", "10.0.0.1")); err == nil {
+		t.Fatal("expected locked out address to be refused")
+	}
+	if _, err := lockout.Authenticate(tokenFrom("carol", "secret", "10.0.0.2")); err != nil {
+		t.Fatal(err)
+	}
+
+	// failures further apart than the window don't add up
+	*now = now.Add(2 * time.Minute)
+	_, _ = lockout.Authenticate(tokenFrom("alice", "wrong", "10.0.0.3"))
+	*now = now.Add(2 * time.Minute)
+	_, _ = lockout.Authenticate(tokenFrom("alice", "wrong", "10.0.0.3"))
+	if _, err := lockout.Authenticate(tokenFrom("alice", "secret", "10.0.0.3")); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestLockoutAudit(t *testing.T) {
+	dir, err := ioutil.TempDir("", "bolt-proxy-lockout")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	path := filepath.Join(dir, "audit.log")
+	log, err := audit.New(config.Audit{Sink: config.AUDIT_SINK_FILE, File: config.AuditFile{Path: path}}, redact.Default())
+	if err != nil {
+		t.Fatal(err)
+	}
+	lockout, _, _ := newTestLockout(&countingAuth{}, config.AuthLockout{
+		MaxFailures:        2,
+		MaxAddressFailures: 3,
+		Window:             config.Duration{Duration: time.Minute},
+		Duration:           config.Duration{Duration: time.Minute},
+		MaxDuration:        config.Duration{Duration: time.Hour},
+	})
+	lockout.Audit, lockout.Listener = log, "public"
+
+	// alice is locked out, then the address trying bob as well
+	_, _ = lockout.Authenticate(tokenFrom("alice", "wrong", "10.0.0.1"))
+	_, _ = lockout.Authenticate(tokenFrom("alice", "wrong", "10.0.0.1"))
+	_, _ = lockout.Authenticate(tokenFrom("bob", "wrong", "10.0.0.1"))
+	log.Close()
+
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := bytes.Split(bytes.TrimSpace(data), []byte("\n"))
+	if len(lines) != 2 {
+		t.Fatalf("expected two lockouts, got %s", data)
+	}
+	expected := []audit.Event{
+		{Type: audit.LOCKOUT, Listener: "public", Principal: "alice", ClientAddress: "10.0.0.1:40000", DurationMs: 60000, LockedOut: LOCKOUT_PRINCIPAL},
+		{Type: audit.LOCKOUT, Listener: "public", Principal: "bob", ClientAddress: "10.0.0.1:40000", DurationMs: 60000, LockedOut: LOCKOUT_ADDRESS},
+	}
+	for i, line := range lines {
+		var e audit.Event
+		if err := json.Unmarshal(line, &e); err != nil {
+			t.Fatal(err)
+		}
+		e.Time, e.PrevHash, e.Hash = time.Time{}, "", ""
+		if !reflect.DeepEqual(e, expected[i]) {
+			t.Errorf("expected %+v, got %+v", expected[i], e)
+		}
+	}
+}
@@ -17,11 +17,13 @@ limitations under the License.
 package backend
 
 import (
+	"crypto/tls"
 	"fmt"
 	"net/url"
 	"strings"
 
 	"github.com/memgraph/bolt-proxy/bolt"
+	"github.com/memgraph/bolt-proxy/config"
 	"github.com/neo4j/neo4j-go-driver/v4/neo4j"
 )
 
@@ -34,7 +36,9 @@ type Monitor struct {
 // Our default Driver configuration provides:
 // - custom user-agent name
 // - ability to add in specific list of hosts to use for address resolution
-func newConfigurer(hosts []string) func(c *neo4j.Config) {
+// - connection pool limits from the configuration
+// - custom CAs to verify the backend certificate against
+func newConfigurer(hosts []string, pool config.Pool, tlsConfig *tls.Config) func(c *neo4j.Config) {
 	return func(c *neo4j.Config) {
 		c.AddressResolver = func(addr neo4j.ServerAddress) []neo4j.ServerAddress {
 			if len(hosts) == 0 {
@@ -53,14 +57,25 @@ func newConfigurer(hosts []string) func(c *neo4j.Config) {
 		}
 		// TODO: wire into global version string
 		c.UserAgent = "bolt-proxy/v0.3.0"
+		if pool.MaxSize > 0 {
+			c.MaxConnectionPoolSize = pool.MaxSize
+		}
+		if pool.AcquisitionTimeout.Duration > 0 {
+			c.ConnectionAcquisitionTimeout = pool.AcquisitionTimeout.Duration
+		}
+		// The driver handles +s/+ssc itself and has no client certificate
+		// support, so only the trusted CAs can be passed along.
+		if tlsConfig != nil && tlsConfig.RootCAs != nil {
+			c.RootCAs = tlsConfig.RootCAs
+		}
 	}
 }
 
 // The Monitor server to provide the data about the used backend service (Memgraph or Neo4j)
-func NewMonitor(user, password, uri string, hosts ...string) (*Monitor, error) {
+func NewMonitor(user, password, uri string, pool config.Pool, tlsConfig *tls.Config, hosts ...string) (*Monitor, error) {
 	// Try immediately to connect to Neo4j
 	auth := neo4j.BasicAuth(user, password, "")
-	driver, err := neo4j.NewDriver(uri, auth, newConfigurer(hosts))
+	driver, err := neo4j.NewDriver(uri, auth, newConfigurer(hosts, pool, tlsConfig))
 	if err != nil {
 		return nil, err
 	}
@@ -0,0 +1,237 @@
+/*
+Copyright (c) 2021 Memgraph Ltd. [https://memgraph.com]
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package backend
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"time"
+
+	"github.com/memgraph/bolt-proxy/config"
+	"github.com/memgraph/bolt-proxy/metrics"
+	"github.com/memgraph/bolt-proxy/proxy_logger"
+	"github.com/open-policy-agent/opa/rego"
+)
+
+var policyDecisions = metrics.NewCounter("bolt_proxy_policy_decisions_total",
+	"Queries admitted or refused by the policy, by decision (allow, deny or error).", "decision")
+
+// What a policy decides on: who runs which query.
+type PolicyInput struct {
+	Principal string                 `json:"principal"`
+	Claims    map[string]interface{} `json:"claims,omitempty"`
+	// Roles of the client, if the proxy authorizes queries by role
+	Roles         []string `json:"roles,omitempty"`
+	ClientAddress string   `json:"client_address,omitempty"`
+	// Empty for the default database
+	Database   string   `json:"database,omitempty"`
+	Query      string   `json:"query"`
+	Parameters []string `json:"parameters"`
+}
+
+type PolicyDecision struct {
+	Allow  bool   `json:"allow"`
+	Reason string `json:"reason,omitempty"`
+}
+
+// Admits or refuses queries, see config.Policy.
+type PolicyDecisionPoint interface {
+	Decide(input *PolicyInput) (*PolicyDecision, error)
+}
+
+// Returns nil if no policy is configured.
+func NewPolicy(conf config.Policy) (PolicyDecisionPoint, error) {
+	var pdp PolicyDecisionPoint
+	timeout := conf.Timeout.Duration
+	if timeout <= 0 {
+		timeout = config.DEFAULT_POLICY_TIMEOUT
+	}
+	switch conf.Kind {
+	case "":
+		return nil, nil
+	case config.POLICY_REGO:
+		var err error
+		pdp, err = NewRegoPolicy(conf.Modules, conf.Decision, timeout)
+		if err != nil {
+			return nil, err
+		}
+	case config.POLICY_OPA, config.POLICY_WEBHOOK:
+		if conf.URL == "" {
+			return nil, errors.New("policy url must be set")
+		}
+		pdp = &HTTPPolicy{
+			url:     conf.URL,
+			headers: conf.Headers,
+			opa:     conf.Kind == config.POLICY_OPA,
+			client:  &http.Client{Timeout: timeout},
+		}
+	default:
+		return nil, fmt.Errorf("unknown policy kind %q", conf.Kind)
+	}
+
+	return &enforcedPolicy{pdp: pdp, auditOnly: conf.AuditOnly, failOpen: conf.FailOpen}, nil
+}
+
+// Evaluates Rego policies in the proxy, without an OPA server.
+type RegoPolicy struct {
+	query   rego.PreparedEvalQuery
+	timeout time.Duration
+}
+
+// Compiles the Rego modules in the given files, to evaluate decision, e.g.
+// data.memgraph.admission, with each PolicyInput.
+func NewRegoPolicy(files []string, decision string, timeout time.Duration) (*RegoPolicy, error) {
+	if len(files) == 0 {
+		return nil, errors.New("policy modules must be set")
+	}
+	options := []func(*rego.Rego){rego.Query(decision)}
+	for _, file := range files {
+		module, err := ioutil.ReadFile(file)
+		if err != nil {
+			return nil, err
+		}
+		options = append(options, rego.Module(file, string(module)))
+	}
+	query, err := rego.New(options...).PrepareForEval(context.Background())
+	if err != nil {
+		return nil, fmt.Errorf("invalid policy: %v", err)
+	}
+	return &RegoPolicy{query: query, timeout: timeout}, nil
+}
+
+func (p *RegoPolicy) Decide(input *PolicyInput) (*PolicyDecision, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), p.timeout)
+	defer cancel()
+	results, err := p.query.Eval(ctx, rego.EvalInput(input))
+	if err != nil {
+		return nil, err
+	}
+	if len(results) == 0 || len(results[0].Expressions) == 0 {
+		return opaDecision(nil)
+	}
+	result, err := json.Marshal(results[0].Expressions[0].Value)
+	if err != nil {
+		return nil, err
+	}
+	return opaDecision(result)
+}
+
+// Asks a webhook or an OPA server to decide.
+type HTTPPolicy struct {
+	url     string
+	headers map[string]string
+	// The input and decision are wrapped the way OPA's data API wants
+	opa    bool
+	client *http.Client
+}
+
+func (p *HTTPPolicy) Decide(input *PolicyInput) (*PolicyDecision, error) {
+	var request interface{} = input
+	if p.opa {
+		request = map[string]interface{}{"input": input}
+	}
+	body, err := json.Marshal(request)
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequest(http.MethodPost, p.url, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	for name, value := range p.headers {
+		req.Header.Set(name, value)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != 200 {
+		return nil, fmt.Errorf("policy answered %s", resp.Status)
+	}
+
+	decoder := json.NewDecoder(io.LimitReader(resp.Body, MAX_WEBHOOK_RESPONSE))
+	if !p.opa {
+		decision := &PolicyDecision{}
+		if err := decoder.Decode(decision); err != nil {
+			return nil, fmt.Errorf("invalid policy response: %v", err)
+		}
+		return decision, nil
+	}
+
+	var result struct {
+		Result json.RawMessage `json:"result"`
+	}
+	if err := decoder.Decode(&result); err != nil {
+		return nil, fmt.Errorf("invalid OPA response: %v", err)
+	}
+	return opaDecision(result.Result)
+}
+
+// A Rego decision, either a boolean or an object, and missing if the
+// policy didn't define it for the input.
+func opaDecision(result json.RawMessage) (*PolicyDecision, error) {
+	if len(result) == 0 {
+		return &PolicyDecision{Reason: "policy is undefined"}, nil
+	}
+	var allow bool
+	if err := json.Unmarshal(result, &allow); err == nil {
+		return &PolicyDecision{Allow: allow}, nil
+	}
+	decision := &PolicyDecision{}
+	if err := json.Unmarshal(result, decision); err != nil {
+		return nil, fmt.Errorf("invalid OPA decision: %v", err)
+	}
+	return decision, nil
+}
+
+// Turns a policy's errors into decisions, and only logs refusals in audit
+// only mode.
+type enforcedPolicy struct {
+	pdp       PolicyDecisionPoint
+	auditOnly bool
+	failOpen  bool
+}
+
+func (p *enforcedPolicy) Decide(input *PolicyInput) (*PolicyDecision, error) {
+	start := time.Now()
+	decision, err := p.pdp.Decide(input)
+	if err != nil {
+		policyDecisions.Inc("error")
+		proxy_logger.WarnLog.Printf("policy failed after %v: %v", time.Since(start), err)
+		decision = &PolicyDecision{Allow: p.failOpen, Reason: "policy unavailable"}
+	} else if decision.Allow {
+		policyDecisions.Inc("allow")
+	} else {
+		policyDecisions.Inc("deny")
+	}
+
+	if !decision.Allow && p.auditOnly {
+		proxy_logger.AuditLog.Printf("policy would refuse query of %q from %s: %s",
+			input.Principal, input.ClientAddress, proxy_logger.Redact(decision.Reason))
+		return &PolicyDecision{Allow: true}, nil
+	}
+	return decision, nil
+}
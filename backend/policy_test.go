@@ -0,0 +1,233 @@
+/*
+Copyright (c) 2021 Memgraph Ltd. [https://memgraph.com]
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package backend
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/memgraph/bolt-proxy/config"
+)
+
+func TestWebhookPolicy(t *testing.T) {
+	var got PolicyInput
+	ts := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-Api-Key") != "key" {
+			rw.WriteHeader(http.StatusForbidden)
+			return
+		}
+		if err := json.NewDecoder(r.Body).Decode(&got); err != nil {
+			t.Error(err)
+		}
+		decision := PolicyDecision{Allow: got.Principal == "alice", Reason: "only alice"}
+		_ = json.NewEncoder(rw).Encode(decision)
+	}))
+	defer ts.Close()
+
+	pdp, err := NewPolicy(config.Policy{
+		Kind:    config.POLICY_WEBHOOK,
+		URL:     ts.URL,
+		Headers: map[string]string{"X-Api-Key": "key"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	input := &PolicyInput{
+		Principal:  "alice",
+		Database:   "memgraph",
+		Query:      "MATCH (n) WHERE n.id = $id RETURN n",
+		Parameters: []string{"id"},
+	}
+	decision, err := pdp.Decide(input)
+	if err != nil || !decision.Allow {
+		t.Fatalf("expected alice to be allowed, got %v, %v", decision, err)
+	}
+	if !reflect.DeepEqual(&got, input) {
+		t.Fatalf("unexpected policy input %#v", got)
+	}
+
+	input.Principal = "bob"
+	decision, err = pdp.Decide(input)
+	if err != nil || decision.Allow || decision.Reason != "only alice" {
+		t.Fatalf("expected bob to be refused, got %v, %v", decision, err)
+	}
+}
+
+func TestOPAPolicy(t *testing.T) {
+	results := map[string]string{
+		"alice": `{"result": true}`,
+		"bob":   `{"result": {"allow": false, "reason": "no writes after hours"}}`,
+		"carol": `{}`,
+	}
+	ts := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		var request struct {
+			Input PolicyInput `json:"input"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+			t.Error(err)
+		}
+		_, _ = rw.Write([]byte(results[request.Input.Principal]))
+	}))
+	defer ts.Close()
+
+	pdp, err := NewPolicy(config.Policy{Kind: config.POLICY_OPA, URL: ts.URL + "/v1/data/memgraph/admission"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		principal string
+		allow     bool
+		reason    string
+	}{
+		{"alice", true, ""},
+		{"bob", false, "no writes after hours"},
+		{"carol", false, "policy is undefined"},
+	}
+	for _, test := range tests {
+		decision, err := pdp.Decide(&PolicyInput{Principal: test.principal, Query: "RETURN 1"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if decision.Allow != test.allow || decision.Reason != test.reason {
+			t.Errorf("%s: unexpected decision %#v", test.principal, decision)
+		}
+	}
+}
+
+const admission = `package memgraph
+
+default admission = {"allow": false, "reason": "unknown principal"}
+
+admission = true {
+	input.principal == "alice"
+}
+
+admission = {"allow": false, "reason": "no deletes"} {
+	input.principal == "bob"
+	contains(lower(input.query), "delete")
+}
+
+admission = true {
+	input.principal == "bob"
+	not contains(lower(input.query), "delete")
+	input.parameters == ["id"]
+}
+`
+
+func TestRegoPolicy(t *testing.T) {
+	dir, err := ioutil.TempDir("", "bolt-proxy-policy")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	path := filepath.Join(dir, "admission.rego")
+	if err := ioutil.WriteFile(path, []byte(admission), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	pdp, err := NewPolicy(config.Policy{
+		Kind:     config.POLICY_REGO,
+		Modules:  []string{path},
+		Decision: "data.memgraph.admission",
+		Timeout:  config.Duration{Duration: time.Second},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		principal  string
+		query      string
+		parameters []string
+		allow      bool
+		reason     string
+	}{
+		{"alice", "MATCH (n) DETACH DELETE n", nil, true, ""},
+		{"bob", "MATCH (n) DETACH DELETE n", nil, false, "no deletes"},
+		{"bob", "MATCH (n) WHERE n.id = $id RETURN n", []string{"id"}, true, ""},
+		{"carol", "RETURN 1", nil, false, "unknown principal"},
+	}
+	for _, test := range tests {
+		decision, err := pdp.Decide(&PolicyInput{Principal: test.principal, Query: test.query, Parameters: test.parameters})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if decision.Allow != test.allow || decision.Reason != test.reason {
+			t.Errorf("%s %q: unexpected decision %#v", test.principal, test.query, decision)
+		}
+	}
+
+	// undefined decisions refuse, broken modules aren't loaded
+	undefined, err := NewRegoPolicy([]string{path}, "data.memgraph.missing", time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if decision, err := undefined.Decide(&PolicyInput{Principal: "alice"}); err != nil || decision.Allow || decision.Reason != "policy is undefined" {
+		t.Fatalf("expected an undefined decision to refuse, got %v, %v", decision, err)
+	}
+	broken := filepath.Join(dir, "broken.rego")
+	if err := ioutil.WriteFile(broken, []byte("package memgraph\n\nadmission {"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := NewRegoPolicy([]string{path, broken}, "data.memgraph.admission", time.Second); err == nil {
+		t.Fatal("expected a broken module to be refused")
+	}
+}
+
+func TestPolicyAuditOnlyAndFailures(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/down" {
+			rw.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		_, _ = rw.Write([]byte(`{"allow": false, "reason": "nope"}`))
+	}))
+	defer ts.Close()
+
+	tests := []struct {
+		name  string
+		conf  config.Policy
+		allow bool
+	}{
+		{"enforced", config.Policy{URL: ts.URL}, false},
+		{"audit only", config.Policy{URL: ts.URL, AuditOnly: true}, true},
+		{"fail closed", config.Policy{URL: ts.URL + "/down"}, false},
+		{"fail open", config.Policy{URL: ts.URL + "/down", FailOpen: true}, true},
+	}
+	for _, test := range tests {
+		test.conf.Kind = config.POLICY_WEBHOOK
+		test.conf.Timeout = config.Duration{Duration: time.Second}
+		pdp, err := NewPolicy(test.conf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		decision, err := pdp.Decide(&PolicyInput{Principal: "alice", Query: "RETURN 1"})
+		if err != nil || decision.Allow != test.allow {
+			t.Errorf("%s: expected allow %t, got %v, %v", test.name, test.allow, decision, err)
+		}
+	}
+
+	if pdp, err := NewPolicy(config.Policy{}); pdp != nil || err != nil {
+		t.Fatalf("expected no policy by default, got %v, %v", pdp, err)
+	}
+}
@@ -0,0 +1,192 @@
+/*
+Copyright (c) 2021 Memgraph Ltd. [https://memgraph.com]
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package backend
+
+import (
+	"math"
+	"sync"
+	"time"
+
+	"github.com/memgraph/bolt-proxy/config"
+	"github.com/memgraph/bolt-proxy/metrics"
+)
+
+// Kinds of rate limit
+const (
+	RATE_LIMIT_CONNECTION = "connection"
+	RATE_LIMIT_QUERY      = "query"
+)
+
+// Token buckets are swept for full ones once there are this many
+const RATE_LIMIT_SWEEP_SIZE = 1024
+
+var rateLimited = metrics.NewCounter("bolt_proxy_rate_limited_total",
+	"Connections and queries refused for going over a rate limit, by kind (connection or query).", "kind")
+
+// The tokens left to one client address or principal.
+type tokenBucket struct {
+	tokens  float64
+	updated time.Time
+	// full again from this time on, unless tokens are taken
+	full time.Time
+}
+
+// Token buckets of one kind, by client address or principal.
+type bucketSet struct {
+	kind    string
+	buckets map[string]*tokenBucket
+	sweepAt int
+}
+
+// Limits how many connections each client address and how many queries
+// each principal may start, see config.RateLimits.
+type RateLimiter struct {
+	connections config.RateLimit
+	queries     config.RateLimit
+	// rate limits of the roles setting their own
+	roles map[string]config.RateLimit
+	now   func() time.Time
+
+	mu                sync.Mutex
+	connectionBuckets *bucketSet
+	queryBuckets      *bucketSet
+}
+
+// Returns nil if neither connections nor queries are limited.
+func NewRateLimiter(conf config.Auth) *RateLimiter {
+	if !conf.RateLimited() {
+		return nil
+	}
+	roles := make(map[string]config.RateLimit)
+	for _, role := range conf.Authorization.Roles {
+		if role.RateLimit != nil {
+			roles[role.Name] = *role.RateLimit
+		}
+	}
+	return &RateLimiter{
+		connections:       conf.RateLimits.Connections,
+		queries:           conf.RateLimits.Queries,
+		roles:             roles,
+		now:               time.Now,
+		connectionBuckets: newBucketSet(RATE_LIMIT_CONNECTION),
+		queryBuckets:      newBucketSet(RATE_LIMIT_QUERY),
+	}
+}
+
+func newBucketSet(kind string) *bucketSet {
+	return &bucketSet{kind: kind, buckets: make(map[string]*tokenBucket), sweepAt: RATE_LIMIT_SWEEP_SIZE}
+}
+
+// The query limit of a client with the given roles: the most permissive
+// one of its roles, or rate_limits.queries for roles without their own.
+func (r *RateLimiter) QueryLimit(roles []string) config.RateLimit {
+	if len(roles) == 0 {
+		return r.queries
+	}
+	var limit config.RateLimit
+	for i, name := range roles {
+		role, found := r.roles[name]
+		if !found {
+			role = r.queries
+		}
+		if !role.Enabled() {
+			return role
+		}
+		if i == 0 || role.Rate > limit.Rate {
+			limit.Rate = role.Rate
+		}
+		if b := burst(role); b > limit.Burst {
+			limit.Burst = b
+		}
+	}
+	return limit
+}
+
+// Whether a new connection from the client address may go ahead.
+func (r *RateLimiter) AllowConnection(address string) bool {
+	return r.take(r.connectionBuckets, address, r.connections)
+}
+
+// Whether a client, known by its principal or else its address, may run
+// another query within the limit from QueryLimit.
+func (r *RateLimiter) AllowQuery(key string, limit config.RateLimit) bool {
+	return r.take(r.queryBuckets, key, limit)
+}
+
+func (r *RateLimiter) take(set *bucketSet, key string, limit config.RateLimit) bool {
+	if !limit.Enabled() {
+		return true
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	now := r.now()
+	set.sweep(now)
+	bucket := set.buckets[key]
+	if bucket == nil {
+		bucket = &tokenBucket{tokens: float64(burst(limit)), updated: now}
+		set.buckets[key] = bucket
+	}
+	if !bucket.take(now, limit) {
+		rateLimited.Inc(set.kind)
+		return false
+	}
+	return true
+}
+
+// The most tokens a bucket holds, at least one so anything goes through.
+func burst(limit config.RateLimit) int {
+	if limit.Burst > 0 {
+		return limit.Burst
+	}
+	return int(math.Max(1, math.Ceil(limit.Rate)))
+}
+
+// Refill the bucket for the time since it was last used and take a token,
+// if there is one.
+func (b *tokenBucket) take(now time.Time, limit config.RateLimit) bool {
+	max := float64(burst(limit))
+	if elapsed := now.Sub(b.updated).Seconds(); elapsed > 0 {
+		b.tokens = math.Min(max, b.tokens+elapsed*limit.Rate)
+	}
+	b.updated = now
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	missing := (max - b.tokens) / limit.Rate
+	b.full = now.Add(time.Duration(missing * float64(time.Second)))
+	return true
+}
+
+// Drop buckets that filled up again, as fresh ones start out full anyway,
+// so clients making up principals or addresses can't grow the set
+// without bounds. Must be called with the lock held.
+func (s *bucketSet) sweep(now time.Time) {
+	if len(s.buckets) < s.sweepAt {
+		return
+	}
+	for key, bucket := range s.buckets {
+		if !now.Before(bucket.full) {
+			delete(s.buckets, key)
+		}
+	}
+	s.sweepAt = 2 * len(s.buckets)
+	if s.sweepAt < RATE_LIMIT_SWEEP_SIZE {
+		s.sweepAt = RATE_LIMIT_SWEEP_SIZE
+	}
+}
@@ -0,0 +1,144 @@
+/*
+Copyright (c) 2021 Memgraph Ltd. [https://memgraph.com]
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package backend
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/memgraph/bolt-proxy/config"
+)
+
+func TestRateLimiter(t *testing.T) {
+	if NewRateLimiter(config.Default().Auth) != nil {
+		t.Fatal("expected no rate limiter by default")
+	}
+
+	conf := config.Default().Auth
+	conf.RateLimits.Connections = config.RateLimit{Rate: 2, Burst: 3}
+	limiter := NewRateLimiter(conf)
+	now := time.Now()
+	limiter.now = func() time.Time { return now }
+	limited := rateLimited.Value(RATE_LIMIT_CONNECTION)
+
+	// the burst goes through, then the bucket is empty
+	for i := 0; i < 3; i++ {
+		if !limiter.AllowConnection("10.0.0.1") {
+			t.Fatalf("expected connection %d to be allowed", i)
+		}
+	}
+	if limiter.AllowConnection("10.0.0.1") {
+		t.Fatal("expected the fourth connection to be refused")
+	}
+	if rateLimited.Value(RATE_LIMIT_CONNECTION)-limited != 1 {
+		t.Fatal("expected the refused connection to be counted")
+	}
+	// other addresses have their own bucket
+	if !limiter.AllowConnection("10.0.0.2") {
+		t.Fatal("expected another address to be allowed")
+	}
+
+	// two tokens a second come back, up to the burst
+	now = now.Add(500 * time.Millisecond)
+	if !limiter.AllowConnection("10.0.0.1") || limiter.AllowConnection("10.0.0.1") {
+		t.Fatal("expected one token after half a second")
+	}
+	now = now.Add(time.Hour)
+	for i := 0; i < 3; i++ {
+		if !limiter.AllowConnection("10.0.0.1") {
+			t.Fatalf("expected connection %d to be allowed after a while", i)
+		}
+	}
+	if limiter.AllowConnection("10.0.0.1") {
+		t.Fatal("expected the bucket not to grow past the burst")
+	}
+
+	// queries aren't limited
+	for i := 0; i < 10; i++ {
+		if !limiter.AllowQuery("alice", limiter.QueryLimit(nil)) {
+			t.Fatal("expected queries to be allowed")
+		}
+	}
+}
+
+func TestQueryLimit(t *testing.T) {
+	conf := config.Default().Auth
+	conf.RateLimits.Queries = config.RateLimit{Rate: 10}
+	conf.Authorization.Roles = []config.Role{
+		{Name: "batch", Access: config.ACCESS_WRITE, RateLimit: &config.RateLimit{Rate: 1, Burst: 50}},
+		{Name: "slow", Access: config.ACCESS_READ, RateLimit: &config.RateLimit{Rate: 0.5}},
+		{Name: "admin", Access: config.ACCESS_ADMIN, RateLimit: &config.RateLimit{}},
+		{Name: "reader", Access: config.ACCESS_READ},
+	}
+	limiter := NewRateLimiter(conf)
+
+	tests := []struct {
+		roles    []string
+		expected config.RateLimit
+	}{
+		{nil, config.RateLimit{Rate: 10}},
+		{[]string{"reader"}, config.RateLimit{Rate: 10, Burst: 10}},
+		{[]string{"slow"}, config.RateLimit{Rate: 0.5, Burst: 1}},
+		// the highest rate and the largest burst
+		{[]string{"batch", "reader"}, config.RateLimit{Rate: 10, Burst: 50}},
+		{[]string{"admin", "batch"}, config.RateLimit{}},
+	}
+	for _, test := range tests {
+		if got := limiter.QueryLimit(test.roles); got != test.expected {
+			t.Errorf("%v: expected %+v, got %+v", test.roles, test.expected, got)
+		}
+	}
+
+	// a rate below one still lets a query through
+	now := time.Now()
+	limiter.now = func() time.Time { return now }
+	slow := limiter.QueryLimit([]string{"slow"})
+	if !limiter.AllowQuery("carol", slow) || limiter.AllowQuery("carol", slow) {
+		t.Fatal("expected a single query to be allowed")
+	}
+	now = now.Add(2 * time.Second)
+	if !limiter.AllowQuery("carol", slow) {
+		t.Fatal("expected a query to be allowed two seconds later")
+	}
+	if !limiter.AllowQuery("admin", limiter.QueryLimit([]string{"admin"})) {
+		t.Fatal("expected admins not to be limited")
+	}
+}
+
+func TestRateLimiterSweep(t *testing.T) {
+	conf := config.Default().Auth
+	conf.RateLimits.Connections = config.RateLimit{Rate: 1, Burst: 2}
+	limiter := NewRateLimiter(conf)
+	now := time.Now()
+	limiter.now = func() time.Time { return now }
+
+	for i := 0; i < RATE_LIMIT_SWEEP_SIZE; i++ {
+		limiter.AllowConnection(fmt.Sprintf("10.0.%d.%d", i/256, i%256))
+	}
+	// once they filled up again, the buckets go
+	now = now.Add(1500 * time.Millisecond)
+	limiter.AllowConnection("10.1.0.1")
+
+	buckets := limiter.connectionBuckets.buckets
+	if len(buckets) != 1 || buckets["10.1.0.1"] == nil {
+		t.Fatalf("expected full buckets to be swept, %d left", len(buckets))
+	}
+	if limiter.connectionBuckets.sweepAt != RATE_LIMIT_SWEEP_SIZE {
+		t.Fatalf("unexpected next sweep at %d", limiter.connectionBuckets.sweepAt)
+	}
+}
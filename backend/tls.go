@@ -0,0 +1,82 @@
+/*
+Copyright (c) 2021 Memgraph Ltd. [https://memgraph.com]
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package backend
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+
+	"github.com/memgraph/bolt-proxy/config"
+)
+
+// Build the client side TLS config used to dial the backend, honoring the
+// bolt URI scheme: +s verifies the server certificate, +ssc accepts
+// self-signed ones without verification.
+func newTLSConfig(scheme string, conf config.BackendTLS) (*tls.Config, error) {
+	useTLS, verify := config.SchemeTLS(scheme)
+	if !useTLS {
+		return nil, nil
+	}
+
+	tlsConfig := &tls.Config{
+		ServerName:         conf.ServerName,
+		InsecureSkipVerify: !verify,
+	}
+
+	minVersion, err := config.TLSVersion(conf.MinVersion)
+	if err != nil {
+		return nil, err
+	}
+	tlsConfig.MinVersion = minVersion
+
+	if conf.CAFile != "" {
+		pool, err := loadCertPool(conf.CAFile)
+		if err != nil {
+			return nil, err
+		}
+		tlsConfig.RootCAs = pool
+	}
+
+	if conf.CertFile != "" || conf.KeyFile != "" {
+		cert, err := tls.LoadX509KeyPair(conf.CertFile, conf.KeyFile)
+		if err != nil {
+			return nil, err
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+
+	return tlsConfig, nil
+}
+
+// The system roots extended with the certificates from a PEM bundle.
+func loadCertPool(path string) (*x509.CertPool, error) {
+	pem, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	pool, err := x509.SystemCertPool()
+	if err != nil || pool == nil {
+		pool = x509.NewCertPool()
+	}
+	if !pool.AppendCertsFromPEM(pem) {
+		return nil, fmt.Errorf("no certificates found in %s", path)
+	}
+	return pool, nil
+}
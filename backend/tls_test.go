@@ -0,0 +1,170 @@
+/*
+Copyright (c) 2021 Memgraph Ltd. [https://memgraph.com]
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package backend
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/memgraph/bolt-proxy/config"
+)
+
+// Self-signed certificate for "memgraph.local", returned along with the
+// path of its PEM file.
+func selfSignedCert(t *testing.T) (tls.Certificate, string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "memgraph.local"},
+		DNSNames:              []string{"memgraph.local"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dir, err := ioutil.TempDir("", "bolt-proxy-backend-tls")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	path := filepath.Join(dir, "memgraph.pem")
+	err = ioutil.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}, path
+}
+
+// A TLS server that accepts the bolt handshake and any HELLO.
+func fakeTLSBolt(t *testing.T, cert tls.Certificate) string {
+	ln, err := tls.Listen("tcp", "127.0.0.1:0", &tls.Config{Certificates: []tls.Certificate{cert}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func(conn net.Conn) {
+				defer conn.Close()
+				handshake := make([]byte, 20)
+				if _, err := io.ReadFull(conn, handshake); err != nil {
+					return
+				}
+				if _, err := conn.Write([]byte{0x00, 0x00, 0x00, 0x01}); err != nil {
+					return
+				}
+				hello := make([]byte, 1024)
+				if _, err := conn.Read(hello); err != nil {
+					return
+				}
+				_, _ = conn.Write([]byte{0x00, 0x03, 0xb1, 0x70, 0xa0, 0x00, 0x00})
+			}(conn)
+		}
+	}()
+
+	return ln.Addr().String()
+}
+
+func connectTo(t *testing.T, uri string, conf config.BackendTLS) error {
+	cfg := config.Default()
+	back, err := NewBackend(config.Backend{URI: uri, TLS: conf}, cfg.Pool, cfg.Timeouts)
+	if err != nil {
+		return err
+	}
+	hello := helloMessage(t, map[string]interface{}{"scheme": "none"})
+	conn, err := back.InitBoltConnection(hello.Data, nil, "tcp")
+	if err != nil {
+		return err
+	}
+	return conn.Close()
+}
+
+func TestBackendTLSSchemes(t *testing.T) {
+	cert, caFile := selfSignedCert(t)
+	addr := fakeTLSBolt(t, cert)
+
+	// +ssc accepts the self-signed certificate
+	if err := connectTo(t, "bolt+ssc://"+addr, config.BackendTLS{}); err != nil {
+		t.Fatalf("expected bolt+ssc to skip verification: %v", err)
+	}
+
+	// +s verifies it, so it's rejected without the right CA and name
+	if err := connectTo(t, "bolt+s://"+addr, config.BackendTLS{}); err == nil {
+		t.Fatal("expected bolt+s to reject an unknown certificate")
+	}
+	if err := connectTo(t, "bolt+s://"+addr, config.BackendTLS{CAFile: caFile}); err == nil {
+		t.Fatal("expected bolt+s to reject a certificate for another name")
+	}
+
+	err := connectTo(t, "bolt+s://"+addr, config.BackendTLS{
+		CAFile:     caFile,
+		ServerName: "memgraph.local",
+		MinVersion: "1.2",
+	})
+	if err != nil {
+		t.Fatalf("expected custom CA and SNI override to verify: %v", err)
+	}
+}
+
+func TestNewTLSConfig(t *testing.T) {
+	conf, err := newTLSConfig("bolt", config.BackendTLS{})
+	if err != nil || conf != nil {
+		t.Fatalf("expected no tls for plain bolt, got %v, %v", conf, err)
+	}
+
+	conf, err = newTLSConfig("neo4j+s", config.BackendTLS{MinVersion: "1.3", ServerName: "db"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if conf.InsecureSkipVerify || conf.MinVersion != tls.VersionTLS13 || conf.ServerName != "db" {
+		t.Fatalf("unexpected tls config: %#v", conf)
+	}
+
+	_, err = newTLSConfig("bolt+s", config.BackendTLS{MinVersion: "2.0"})
+	if err == nil {
+		t.Fatal("expected invalid min version to fail")
+	}
+}
@@ -48,6 +48,8 @@ const (
 	BeginMsg    Type = "BEGIN"
 	CommitMsg   Type = "COMMIT"
 	RollbackMsg Type = "ROLLBACK"
+	LogonMsg    Type = "LOGON"
+	LogoffMsg   Type = "LOGOFF"
 	UnknownMsg  Type = "?UNKNOWN?"
 	NopMsg      Type = "NOP"
 	ChunkedMsg  Type = "CHUNKED" // not a true bolt message
@@ -82,6 +84,10 @@ func TypeFromByte(b byte) Type {
 		return CommitMsg
 	case 0x13:
 		return RollbackMsg
+	case 0x6a:
+		return LogonMsg
+	case 0x6b:
+		return LogoffMsg
 	default:
 		return UnknownMsg
 	}
@@ -98,6 +104,68 @@ func IdentifyType(buf []byte) Type {
 	return TypeFromByte(buf[3])
 }
 
+// Whether msg, as read by a BoltConn, ends a message: it is a whole
+// message in a single chunk, the last chunk of a larger one, or a NOOP.
+// Otherwise the next chunk continues it, whatever it looks like.
+func IsComplete(msg *Message) bool {
+	data := msg.Data
+	if len(data) < 2 {
+		return true
+	}
+	size := int(binary.BigEndian.Uint16(data[:2]))
+	return size == 0 || (len(data) == size+4 && bytes.HasSuffix(data, []byte{0x00, 0x00}))
+}
+
+// Whether the type of msg, the first chunk of a message, is what the
+// server will take it for. A chunk too short to hold the structure's tag
+// gets its type from bytes read ahead, which belong to the next chunk.
+func IsIdentified(msg *Message) bool {
+	switch msg.T {
+	case UnknownMsg, ChunkedMsg, NopMsg:
+		return false
+	}
+	data := msg.Data
+	return len(data) >= 4 && binary.BigEndian.Uint16(data[:2]) >= 2 &&
+		data[2]>>4 == 0xb && TypeFromByte(data[3]) == msg.T
+}
+
+// Extract the auth map from a HELLO or LOGON message. Bolt v1 and v2 INIT
+// carries a user agent string followed by the auth map, v3+ HELLO a single
+// map with both, and from v5.1 the auth map moved into LOGON. The INIT
+// user agent is returned as user_agent, the way HELLO carries it.
+func ParseAuth(msg *Message) (map[string]interface{}, error) {
+	if msg.T != HelloMsg && msg.T != LogonMsg {
+		return nil, fmt.Errorf("no auth in %s message", msg.T)
+	}
+	if len(msg.Data) < 5 {
+		return nil, errors.New("message too short")
+	}
+
+	data := msg.Data[4:]
+	userAgent := ""
+	if data[0]>>4 == 0x8 || (data[0] >= 0xd0 && data[0] <= 0xd2) {
+		var pos int
+		var err error
+		userAgent, pos, err = ParseString(data)
+		if err != nil {
+			return nil, err
+		}
+		data = data[pos:]
+	}
+	if len(data) == 0 {
+		return nil, errors.New("missing auth map")
+	}
+
+	auth, _, err := ParseMap(data)
+	if err != nil {
+		return nil, err
+	}
+	if _, found := auth["user_agent"]; !found && userAgent != "" {
+		auth["user_agent"] = userAgent
+	}
+	return auth, nil
+}
+
 // Try parsing some bytes into a Packstream Map, returning it as a map
 // of strings to their values as byte arrays.
 //
@@ -279,6 +347,9 @@ func ParseTinyString(buf []byte) (string, int, error) {
 	if size == 0 {
 		return "", 1, nil
 	}
+	if size+1 > len(buf) {
+		return "", 0, errors.New("tiny-string longer than the byte slice")
+	}
 
 	return string(buf[1 : size+1]), size + 1, nil
 }
@@ -303,12 +374,19 @@ func ParseString(buf []byte) (string, int, error) {
 	pos++
 
 	// decode the amount of bytes to read to get the string length
+	if pos+readAhead > len(buf) {
+		return "", 0, errors.New("string length beyond the byte slice")
+	}
 	sizeBytes := buf[pos : pos+readAhead]
 	sizeBytes = append(make([]byte, 8), sizeBytes...)
 	pos = pos + readAhead
 
 	// decode the actual string length
 	size := int(binary.BigEndian.Uint64(sizeBytes[len(sizeBytes)-8:]))
+	if size > len(buf)-pos {
+		// e.g. a query continuing in the next chunk
+		return "", 0, errors.New("string longer than the byte slice")
+	}
 	return string(buf[pos : pos+size]), pos + size, nil
 }
 
@@ -550,6 +628,12 @@ func (v Version) String() string {
 		v.Patch)
 }
 
+// Whether clients authenticate with LOGON after HELLO, as they do from
+// Bolt 5.1 on.
+func (v Version) HasLogon() bool {
+	return v.Major > 5 || (v.Major == 5 && v.Minor >= 1)
+}
+
 func (v Version) Bytes() []byte {
 	return []byte{
 		0x00, 0x00,
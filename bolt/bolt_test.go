@@ -151,6 +151,13 @@ func TestParsingString(t *testing.T) {
 	if n != (2 + 0x32) {
 		t.Fatal("expected 2 + 0x32 for length, got", n)
 	}
+
+	// strings cut short, e.g. by the end of a chunk
+	for _, short := range [][]byte{msg[:20], {0xd1, 0x01}, {0xd2, 0x00, 0x01, 0x11, 0x75, 0x61}, {0x85, 0x61, 0x62}} {
+		if _, _, err := ParseString(short); err == nil {
+			t.Fatalf("expected an error for % x", short)
+		}
+	}
 }
 
 func TestParsingTinymap(t *testing.T) {
@@ -411,3 +418,89 @@ func TestHorribleThings(t *testing.T) {
 		}
 	}
 }
+
+func TestParseAuth(t *testing.T) {
+	authMap, err := TinyMapToBytes(map[string]interface{}{
+		"scheme":      "bearer",
+		"credentials": "token",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	userAgent, err := StringToBytes("test/1.0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	messages := map[string]*Message{
+		// v1 INIT: user agent, auth map
+		"init": {T: HelloMsg, Data: append(append([]byte{0x00, 0x00, 0xb2, 0x01}, userAgent...), authMap...)},
+		// v3 HELLO: a single map
+		"hello": {T: HelloMsg, Data: append([]byte{0x00, 0x00, 0xb1, 0x01}, authMap...)},
+		// v5.1 LOGON
+		"logon": {T: LogonMsg, Data: append([]byte{0x00, 0x00, 0xb1, 0x6a}, authMap...)},
+	}
+	for name, msg := range messages {
+		auth, err := ParseAuth(msg)
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if auth["scheme"] != "bearer" || auth["credentials"] != "token" {
+			t.Fatalf("%s: unexpected auth %#v", name, auth)
+		}
+		if _, found := auth["user_agent"]; found != (name == "init") {
+			t.Fatalf("%s: unexpected user agent in %#v", name, auth)
+		}
+	}
+
+	if _, err = ParseAuth(&Message{T: RunMsg, Data: []byte{0x00, 0x00, 0xb1, 0x10, 0xa0}}); err == nil {
+		t.Fatal("expected RUN to be rejected")
+	}
+	if TypeFromByte(0x6b) != LogoffMsg {
+		t.Fatal("expected 0x6b to be LOGOFF")
+	}
+}
+
+func TestVersionHasLogon(t *testing.T) {
+	tests := []struct {
+		version []byte
+		logon   bool
+	}{
+		{[]byte{0x00, 0x00, 0x04, 0x04}, false},
+		{[]byte{0x00, 0x00, 0x00, 0x05}, false},
+		{[]byte{0x00, 0x00, 0x01, 0x05}, true},
+		{[]byte{0x00, 0x00, 0x00, 0x06}, true},
+	}
+	for _, test := range tests {
+		v, err := ParseVersion(test.version)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if v.HasLogon() != test.logon {
+			t.Fatalf("expected HasLogon %t for %s", test.logon, v)
+		}
+	}
+}
+
+func TestChunks(t *testing.T) {
+	tests := []struct {
+		msg                  Message
+		complete, identified bool
+	}{
+		{Message{T: RunMsg, Data: []byte{0x00, 0x03, 0xb3, 0x10, 0x80, 0x00, 0x00}}, true, true},
+		// the first chunk of a large RUN
+		{Message{T: RunMsg, Data: []byte{0x00, 0x03, 0xb3, 0x10, 0x80}}, false, true},
+		// a chunk ending in zeros is still followed by another one
+		{Message{T: ChunkedMsg, Data: []byte{0x00, 0x03, 0x80, 0x00, 0x00}}, false, false},
+		// the tag is in the next chunk, the type comes from its length
+		{Message{T: RunMsg, Data: []byte{0x00, 0x01, 0xb3}}, false, false},
+		{Message{T: UnknownMsg, Data: []byte{0x00, 0x01, 0xb3}}, false, false},
+		{Message{T: PullMsg, Data: []byte{0x00, 0x02, 0xa0, 0x3f}}, false, false},
+		{Message{T: NopMsg, Data: []byte{0x00, 0x00}}, true, false},
+	}
+	for i, test := range tests {
+		if IsComplete(&test.msg) != test.complete || IsIdentified(&test.msg) != test.identified {
+			t.Errorf("%d: expected complete %t and identified %t for % x", i, test.complete, test.identified, test.msg.Data)
+		}
+	}
+}
@@ -0,0 +1,358 @@
+/*
+Copyright (c) 2021 Memgraph Ltd. [https://memgraph.com]
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package bolt
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+// The message has no metadata map, e.g. a RUN of Bolt v1 or v2
+var ErrNoMetadata = errors.New("message has no metadata")
+
+// Size in bytes of the Packstream value buf starts with, whatever its
+// type, without decoding it.
+func ValueSize(buf []byte) (int, error) {
+	if len(buf) == 0 {
+		return 0, errors.New("missing value")
+	}
+	marker := buf[0]
+
+	// size of the marker and length, how many bytes and nested values
+	// follow
+	header, bytesLen, values := 1, 0, 0
+	var err error
+	switch {
+	case marker < 0x80 || marker >= 0xf0, marker == 0xc0, marker == 0xc2, marker == 0xc3:
+		return 1, nil
+	case marker == 0xc1:
+		bytesLen = 8
+	case marker >= 0xc8 && marker <= 0xcb:
+		bytesLen = 1 << (marker - 0xc8)
+	case marker>>4 == 0x8:
+		bytesLen = int(marker & 0xf)
+	case marker>>4 == 0x9:
+		values = int(marker & 0xf)
+	case marker>>4 == 0xa:
+		values = 2 * int(marker&0xf)
+	case marker>>4 == 0xb:
+		// the tag byte, then the fields
+		header, values = 2, int(marker&0xf)
+	case marker >= 0xcc && marker <= 0xce:
+		header, bytesLen, err = sizedHeader(buf, marker-0xcc)
+	case marker >= 0xd0 && marker <= 0xd2:
+		header, bytesLen, err = sizedHeader(buf, marker-0xd0)
+	case marker >= 0xd4 && marker <= 0xd6:
+		header, values, err = sizedHeader(buf, marker-0xd4)
+	case marker >= 0xd8 && marker <= 0xda:
+		header, values, err = sizedHeader(buf, marker-0xd8)
+		values *= 2
+	default:
+		return 0, fmt.Errorf("unsupported marker %#x", marker)
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	size := header + bytesLen
+	if size > len(buf) || size < header {
+		return 0, errors.New("value is truncated")
+	}
+	for i := 0; i < values; i++ {
+		n, err := ValueSize(buf[size:])
+		if err != nil {
+			return 0, err
+		}
+		size += n
+	}
+	return size, nil
+}
+
+// Marker and length of a string, bytes, list or map with an 8, 16 or 32
+// bit length, for width 0, 1 or 2.
+func sizedHeader(buf []byte, width byte) (int, int, error) {
+	n := 1 << width
+	if len(buf) < 1+n {
+		return 0, 0, errors.New("value is truncated")
+	}
+	length := make([]byte, 4)
+	copy(length[4-n:], buf[1:1+n])
+	return 1 + n, int(binary.BigEndian.Uint32(length)), nil
+}
+
+// The decoded fields of a single chunk message, see Unpack.
+func Fields(msg *Message) ([]interface{}, error) {
+	if err := singleChunk(msg); err != nil {
+		return nil, err
+	}
+	data := msg.Data
+	fields, _, err := unpackList(data[:len(data)-2], 4, int(data[2]&0xf))
+	if err != nil {
+		return nil, err
+	}
+	return fields.([]interface{}), nil
+}
+
+func singleChunk(msg *Message) error {
+	data := msg.Data
+	if len(data) < 6 || !bytes.Equal(data[len(data)-2:], []byte{0x00, 0x00}) ||
+		int(binary.BigEndian.Uint16(data[:2])) != len(data)-4 {
+		return errors.New("message is not a single chunk")
+	}
+	return nil
+}
+
+// Start and end of the metadata map in a single chunk BEGIN, RUN, PULL,
+// DISCARD, SUCCESS or FAILURE message.
+func metadataBounds(msg *Message) (int, int, error) {
+	switch msg.T {
+	case BeginMsg, SuccessMsg, FailureMsg, PullMsg, DiscardMsg:
+		return mapBounds(msg, 0)
+	case RunMsg:
+		return mapBounds(msg, 2)
+	default:
+		return 0, 0, fmt.Errorf("no metadata in %s message", msg.T)
+	}
+}
+
+// Start and end of a map field of a single chunk message, returning
+// ErrNoMetadata if the message has fewer fields.
+func mapBounds(msg *Message, field int) (int, int, error) {
+	if err := singleChunk(msg); err != nil {
+		return 0, 0, err
+	}
+	data := msg.Data
+	if field >= int(data[2]&0xf) {
+		return 0, 0, ErrNoMetadata
+	}
+
+	start := 4
+	for i := 0; i < field; i++ {
+		n, err := ValueSize(data[start : len(data)-2])
+		if err != nil {
+			return 0, 0, err
+		}
+		start += n
+	}
+	if data[start]>>4 != 0xa && (data[start] < 0xd8 || data[start] > 0xda) {
+		return 0, 0, errors.New("expected a map")
+	}
+	n, err := ValueSize(data[start : len(data)-2])
+	if err != nil {
+		return 0, 0, err
+	}
+	return start, start + n, nil
+}
+
+// Entries of the map at buf: the raw key and value of each.
+func mapEntries(buf []byte) ([][2][]byte, error) {
+	header, size := 1, int(buf[0]&0xf)
+	if buf[0]>>4 != 0xa {
+		var err error
+		header, size, err = sizedHeader(buf, buf[0]-0xd8)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// every entry takes at least two bytes, whatever the header claims
+	if size > len(buf)/2 {
+		return nil, errors.New("map is truncated")
+	}
+	entries := make([][2][]byte, 0, size)
+	pos := header
+	for i := 0; i < size; i++ {
+		var entry [2][]byte
+		for j := range entry {
+			n, err := ValueSize(buf[pos:])
+			if err != nil {
+				return nil, err
+			}
+			entry[j] = buf[pos : pos+n]
+			pos += n
+		}
+		entries = append(entries, entry)
+	}
+	return entries, nil
+}
+
+// The raw Packstream value of key in the metadata of a message, nil if
+// it's not there. Unlike ParseMap this copes with any kind of value, e.g.
+// the floats in Memgraph's query summaries.
+func MetadataValue(msg *Message, key string) ([]byte, error) {
+	start, end, err := metadataBounds(msg)
+	if err != nil {
+		return nil, err
+	}
+	entries, err := mapEntries(msg.Data[start:end])
+	if err != nil {
+		return nil, err
+	}
+	for _, entry := range entries {
+		name, _, err := ParseString(entry[0])
+		if err == nil && name == key {
+			return entry[1], nil
+		}
+	}
+	return nil, nil
+}
+
+// The string value of key in the metadata of a message, "" if it's not
+// there.
+func MetadataString(msg *Message, key string) (string, error) {
+	value, err := MetadataValue(msg, key)
+	if err != nil || value == nil {
+		return "", err
+	}
+	s, _, err := ParseString(value)
+	if err != nil {
+		return "", fmt.Errorf("%s: %v", key, err)
+	}
+	return s, nil
+}
+
+// Set key in the metadata of a message, keeping everything else as the
+// client sent it.
+func SetMetadata(msg *Message, key string, value interface{}) (*Message, error) {
+	packedValue, err := Pack(value)
+	if err != nil {
+		return nil, err
+	}
+	return rewriteMetadata(msg, key, packedValue)
+}
+
+// Remove key from the metadata of a message, keeping everything else as
+// the client sent it.
+func DeleteMetadata(msg *Message, key string) (*Message, error) {
+	return rewriteMetadata(msg, key, nil)
+}
+
+// Replace the entry for key with the packed value, or remove it if value
+// is nil.
+func rewriteMetadata(msg *Message, key string, value []byte) (*Message, error) {
+	start, end, err := metadataBounds(msg)
+	if err != nil {
+		return nil, err
+	}
+	entries, err := mapEntries(msg.Data[start:end])
+	if err != nil {
+		return nil, err
+	}
+	packedKey, err := Pack(key)
+	if err != nil {
+		return nil, err
+	}
+
+	kept := entries[:0]
+	for _, entry := range entries {
+		if !bytes.Equal(entry[0], packedKey) {
+			kept = append(kept, entry)
+		}
+	}
+	size := len(kept)
+	if value != nil {
+		size++
+	}
+	metadata := new(bytes.Buffer)
+	packHeader(metadata, size, 0xa0, 0xd8)
+	for _, entry := range kept {
+		metadata.Write(entry[0])
+		metadata.Write(entry[1])
+	}
+	if value != nil {
+		metadata.Write(packedKey)
+		metadata.Write(value)
+	}
+	return replaceRange(msg, start, end, metadata.Bytes()), nil
+}
+
+// Replace the query of a single chunk RUN message, keeping everything
+// else as the client sent it.
+func SetQuery(msg *Message, query string) (*Message, error) {
+	if msg.T != RunMsg {
+		return nil, fmt.Errorf("no query in %s message", msg.T)
+	}
+	if _, _, err := mapBounds(msg, 1); err != nil {
+		return nil, err
+	}
+	n, err := ValueSize(msg.Data[4 : len(msg.Data)-2])
+	if err != nil {
+		return nil, err
+	}
+	packed, err := Pack(query)
+	if err != nil {
+		return nil, err
+	}
+	return replaceRange(msg, 4, 4+n, packed), nil
+}
+
+// A copy of a single chunk message with Data[start:end] replaced,
+// chunked anew.
+func replaceRange(msg *Message, start, end int, replacement []byte) *Message {
+	body := append([]byte{}, msg.Data[2:start]...)
+	body = append(body, replacement...)
+	body = append(body, msg.Data[end:len(msg.Data)-2]...)
+	return &Message{T: msg.T, Data: frame(body)}
+}
+
+// Names of the parameters of a single chunk RUN message.
+func ParameterNames(msg *Message) ([]string, error) {
+	entries, err := parameterEntries(msg)
+	if err != nil {
+		return nil, err
+	}
+	names := make([]string, 0, len(entries))
+	for _, entry := range entries {
+		name, _, err := ParseString(entry[0])
+		if err != nil {
+			return nil, err
+		}
+		names = append(names, name)
+	}
+	return names, nil
+}
+
+// The raw Packstream value of each parameter of a single chunk RUN
+// message, by name.
+func Parameters(msg *Message) (map[string][]byte, error) {
+	entries, err := parameterEntries(msg)
+	if err != nil {
+		return nil, err
+	}
+	values := make(map[string][]byte, len(entries))
+	for _, entry := range entries {
+		name, _, err := ParseString(entry[0])
+		if err != nil {
+			return nil, err
+		}
+		values[name] = entry[1]
+	}
+	return values, nil
+}
+
+func parameterEntries(msg *Message) ([][2][]byte, error) {
+	if msg.T != RunMsg {
+		return nil, fmt.Errorf("no parameters in %s message", msg.T)
+	}
+	start, end, err := mapBounds(msg, 1)
+	if err != nil {
+		return nil, err
+	}
+	return mapEntries(msg.Data[start:end])
+}
@@ -0,0 +1,199 @@
+/*
+Copyright (c) 2021 Memgraph Ltd. [https://memgraph.com]
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package bolt
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestValueSize(t *testing.T) {
+	long, err := Pack(strings.Repeat("x", 300))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := [][]byte{
+		{0x01},
+		{0xc0},
+		{0xc1, 0x3f, 0xf8, 0, 0, 0, 0, 0, 0},
+		{0xca, 0, 0, 1, 0},
+		{0x83, 0x61, 0x62, 0x63},
+		long,
+		{0xcc, 0x02, 0xff, 0xff},
+		// [1.5, "a"]
+		{0x92, 0xc1, 0x3f, 0xf8, 0, 0, 0, 0, 0, 0, 0x81, 0x61},
+		// {"d": Date(19000)}
+		{0xa1, 0x81, 0x64, 0xb1, 0x44, 0xc9, 0x4a, 0x38},
+	}
+	for _, value := range tests {
+		// trailing bytes aren't part of the value
+		n, err := ValueSize(append(append([]byte{}, value...), 0x00, 0x00))
+		if err != nil || n != len(value) {
+			t.Errorf("%#v: expected size %d, got %d, %v", value, len(value), n, err)
+		}
+	}
+
+	for _, truncated := range [][]byte{{}, {0x83, 0x61}, {0xd0}, {0x92, 0x01}, {0xc1, 0x00}} {
+		if _, err := ValueSize(truncated); err == nil {
+			t.Errorf("%#v: expected truncated value to fail", truncated)
+		}
+	}
+}
+
+func TestSetMetadata(t *testing.T) {
+	run, err := NewMessage(0x10, "RETURN $x", map[string]interface{}{"x": 1.5},
+		map[string]interface{}{"db": "memgraph", "tx_metadata": map[string]interface{}{"weight": 0.5}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rewritten, err := SetMetadata(run, "mode", "r")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if mode, err := MetadataString(rewritten, "mode"); err != nil || mode != "r" {
+		t.Fatalf("expected mode r, got %q, %v", mode, err)
+	}
+	if db, err := MetadataString(rewritten, "db"); err != nil || db != "memgraph" {
+		t.Fatalf("expected the other metadata to be kept, got %q, %v", db, err)
+	}
+	// the query (10 bytes) and its parameters (11 bytes) are kept byte for
+	// byte
+	if !bytes.Equal(rewritten.Data[4:25], run.Data[4:25]) {
+		t.Fatalf("expected the query to be kept:\n%#v\n%#v", run.Data, rewritten.Data)
+	}
+	if mode, err := ValidateMode(rewritten.Data); err != nil || mode != ReadMode {
+		t.Fatalf("expected a read mode RUN, got %v, %v", mode, err)
+	}
+
+	if names, err := ParameterNames(rewritten); err != nil || len(names) != 1 || names[0] != "x" {
+		t.Fatalf("expected parameter x, got %v, %v", names, err)
+	}
+
+	// setting it again replaces the entry
+	again, err := SetMetadata(rewritten, "mode", "w")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(again.Data) != len(rewritten.Data) {
+		t.Fatalf("expected the mode to be replaced, got %#v", again.Data)
+	}
+
+	// Bolt v1 and v2 RUN messages have no metadata
+	legacy, err := NewMessage(0x10, "RETURN 1", map[string]interface{}{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := SetMetadata(legacy, "mode", "r"); err != ErrNoMetadata {
+		t.Fatalf("expected no metadata, got %v", err)
+	}
+}
+
+func TestMetadataOfSummary(t *testing.T) {
+	// Memgraph's summaries have floats ParseMap can't handle
+	success, err := NewMessage(0x70, map[string]interface{}{
+		"type":                "rw",
+		"plan_execution_time": 0.000123,
+		"stats":               map[string]interface{}{"nodes-created": 1},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if queryType, err := MetadataString(success, "type"); err != nil || queryType != "rw" {
+		t.Fatalf("expected type rw, got %q, %v", queryType, err)
+	}
+	if value, err := MetadataValue(success, "bookmark"); err != nil || value != nil {
+		t.Fatalf("expected no bookmark, got %#v, %v", value, err)
+	}
+}
+
+func TestRewriteQuery(t *testing.T) {
+	run, err := NewMessage(0x10, "", map[string]interface{}{"id": 1},
+		map[string]interface{}{"query_id": "person_by_id", "db": "memgraph"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rewritten, err := DeleteMetadata(run, "query_id")
+	if err != nil {
+		t.Fatal(err)
+	}
+	rewritten, err = SetQuery(rewritten, "MATCH (p:Person {id: $id}) RETURN p")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if value, err := MetadataValue(rewritten, "query_id"); err != nil || value != nil {
+		t.Fatalf("expected query_id to be removed, got %#v, %v", value, err)
+	}
+	if db, err := MetadataString(rewritten, "db"); err != nil || db != "memgraph" {
+		t.Fatalf("expected the other metadata to be kept, got %q, %v", db, err)
+	}
+	query, _, err := ParseString(rewritten.Data[4:])
+	if err != nil || query != "MATCH (p:Person {id: $id}) RETURN p" {
+		t.Fatalf("expected the new query, got %q, %v", query, err)
+	}
+	if names, err := ParameterNames(rewritten); err != nil || len(names) != 1 || names[0] != "id" {
+		t.Fatalf("expected parameter id, got %v, %v", names, err)
+	}
+
+	begin, err := NewMessage(0x11, map[string]interface{}{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := SetQuery(begin, "RETURN 1"); err == nil {
+		t.Fatal("expected a BEGIN to have no query")
+	}
+}
+
+func TestParameters(t *testing.T) {
+	run, err := NewMessage(0x10, "MATCH (p) WHERE p.name = $name AND p.age > $age RETURN p",
+		map[string]interface{}{"name": "alice", "age": 30}, map[string]interface{}{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	values, err := Parameters(run)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(values) != 2 || !bytes.Equal(values["name"], []byte{0x85, 'a', 'l', 'i', 'c', 'e'}) || !bytes.Equal(values["age"], []byte{30}) {
+		t.Fatalf("expected the packed values, got %#v", values)
+	}
+
+	if _, err := Parameters(&Message{T: PullMsg, Data: run.Data}); err == nil {
+		t.Fatal("expected a PULL to have no parameters")
+	}
+}
+
+func TestFields(t *testing.T) {
+	run, err := NewMessage(0x10, "RETURN $x", map[string]interface{}{"x": 1.5}, map[string]interface{}{"db": "memgraph"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	fields, err := Fields(run)
+	if err != nil || len(fields) != 3 || fields[0] != "RETURN $x" || fields[1].(map[string]interface{})["x"] != 1.5 {
+		t.Fatalf("unexpected fields %#v, %v", fields, err)
+	}
+
+	large, err := NewMessage(0x10, strings.Repeat("x", MAX_CHUNK_SIZE), map[string]interface{}{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Fields(large); err == nil {
+		t.Fatal("expected a chunked message to be refused")
+	}
+}
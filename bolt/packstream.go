@@ -0,0 +1,349 @@
+/*
+Copyright (c) 2021 Memgraph Ltd. [https://memgraph.com]
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package bolt
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+)
+
+// Largest chunk a Bolt message can be split into
+const MAX_CHUNK_SIZE = 0xffff
+
+// Serialize a value to Packstream. Supports nil, bools, ints, floats,
+// strings, lists and string keyed maps of those, in any size. Map keys are
+// written in sorted order so the output is deterministic.
+func Pack(value interface{}) ([]byte, error) {
+	buf := new(bytes.Buffer)
+	err := pack(buf, value)
+	if err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func pack(buf *bytes.Buffer, value interface{}) error {
+	switch v := value.(type) {
+	case nil:
+		buf.WriteByte(0xc0)
+	case bool:
+		if v {
+			buf.WriteByte(0xc3)
+		} else {
+			buf.WriteByte(0xc2)
+		}
+	case int:
+		packInt(buf, int64(v))
+	case int64:
+		packInt(buf, v)
+	case float64:
+		buf.WriteByte(0xc1)
+		_ = binary.Write(buf, binary.BigEndian, math.Float64bits(v))
+	case string:
+		packHeader(buf, len(v), 0x80, 0xd0)
+		buf.WriteString(v)
+	case []string:
+		packHeader(buf, len(v), 0x90, 0xd4)
+		for _, s := range v {
+			_ = pack(buf, s)
+		}
+	case []interface{}:
+		packHeader(buf, len(v), 0x90, 0xd4)
+		for _, element := range v {
+			err := pack(buf, element)
+			if err != nil {
+				return err
+			}
+		}
+	case map[string]interface{}:
+		keys := make([]string, 0, len(v))
+		for key := range v {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+
+		packHeader(buf, len(v), 0xa0, 0xd8)
+		for _, key := range keys {
+			_ = pack(buf, key)
+			err := pack(buf, v[key])
+			if err != nil {
+				return err
+			}
+		}
+	default:
+		return fmt.Errorf("can't pack %T", value)
+	}
+	return nil
+}
+
+func packInt(buf *bytes.Buffer, i int64) {
+	switch {
+	case i >= -16 && i <= 127:
+		buf.WriteByte(byte(int8(i)))
+	case i >= math.MinInt8 && i <= math.MaxInt8:
+		buf.WriteByte(0xc8)
+		buf.WriteByte(byte(int8(i)))
+	case i >= math.MinInt16 && i <= math.MaxInt16:
+		buf.WriteByte(0xc9)
+		_ = binary.Write(buf, binary.BigEndian, int16(i))
+	case i >= math.MinInt32 && i <= math.MaxInt32:
+		buf.WriteByte(0xca)
+		_ = binary.Write(buf, binary.BigEndian, int32(i))
+	default:
+		buf.WriteByte(0xcb)
+		_ = binary.Write(buf, binary.BigEndian, i)
+	}
+}
+
+// Write the marker of a string, list or map of the given size: the tiny
+// marker for sizes below 16, otherwise the 8, 16 or 32 bit one.
+func packHeader(buf *bytes.Buffer, size int, tiny, marker byte) {
+	switch {
+	case size < 0x10:
+		buf.WriteByte(tiny + byte(size))
+	case size <= math.MaxUint8:
+		buf.WriteByte(marker)
+		buf.WriteByte(byte(size))
+	case size <= math.MaxUint16:
+		buf.WriteByte(marker + 1)
+		_ = binary.Write(buf, binary.BigEndian, uint16(size))
+	default:
+		buf.WriteByte(marker + 2)
+		_ = binary.Write(buf, binary.BigEndian, uint32(size))
+	}
+}
+
+// A Packstream structure, e.g. a node or relationship in a RECORD.
+type Structure struct {
+	Tag    byte
+	Fields []interface{}
+}
+
+// Decode the Packstream value buf starts with, returning it and its size.
+// Unlike the Parse functions it copes with any value: ints come back as
+// int64, floats as float64, bytes as []byte, lists as []interface{}, maps
+// as map[string]interface{} and structures as Structure.
+func Unpack(buf []byte) (interface{}, int, error) {
+	if len(buf) == 0 {
+		return nil, 0, errors.New("missing value")
+	}
+	marker := buf[0]
+
+	switch {
+	case marker < 0x80:
+		return int64(marker), 1, nil
+	case marker >= 0xf0:
+		return int64(int8(marker)), 1, nil
+	case marker == 0xc0:
+		return nil, 1, nil
+	case marker == 0xc2:
+		return false, 1, nil
+	case marker == 0xc3:
+		return true, 1, nil
+	case marker == 0xc1:
+		if len(buf) < 9 {
+			return nil, 0, errors.New("value is truncated")
+		}
+		return math.Float64frombits(binary.BigEndian.Uint64(buf[1:9])), 9, nil
+	case marker >= 0xc8 && marker <= 0xcb:
+		n := 1 << (marker - 0xc8)
+		if len(buf) < 1+n {
+			return nil, 0, errors.New("value is truncated")
+		}
+		switch n {
+		case 1:
+			return int64(int8(buf[1])), 2, nil
+		case 2:
+			return int64(int16(binary.BigEndian.Uint16(buf[1:]))), 3, nil
+		case 4:
+			return int64(int32(binary.BigEndian.Uint32(buf[1:]))), 5, nil
+		default:
+			return int64(binary.BigEndian.Uint64(buf[1:])), 9, nil
+		}
+	case marker>>4 == 0x8:
+		return unpackBytes(buf, 1, int(marker&0xf), true)
+	case marker >= 0xd0 && marker <= 0xd2:
+		header, length, err := sizedHeader(buf, marker-0xd0)
+		if err != nil {
+			return nil, 0, err
+		}
+		return unpackBytes(buf, header, length, true)
+	case marker >= 0xcc && marker <= 0xce:
+		header, length, err := sizedHeader(buf, marker-0xcc)
+		if err != nil {
+			return nil, 0, err
+		}
+		return unpackBytes(buf, header, length, false)
+	case marker>>4 == 0x9:
+		return unpackList(buf, 1, int(marker&0xf))
+	case marker >= 0xd4 && marker <= 0xd6:
+		header, length, err := sizedHeader(buf, marker-0xd4)
+		if err != nil {
+			return nil, 0, err
+		}
+		return unpackList(buf, header, length)
+	case marker>>4 == 0xa:
+		return unpackMap(buf, 1, int(marker&0xf))
+	case marker >= 0xd8 && marker <= 0xda:
+		header, length, err := sizedHeader(buf, marker-0xd8)
+		if err != nil {
+			return nil, 0, err
+		}
+		return unpackMap(buf, header, length)
+	case marker>>4 == 0xb:
+		if len(buf) < 2 {
+			return nil, 0, errors.New("value is truncated")
+		}
+		fields, n, err := unpackList(buf, 2, int(marker&0xf))
+		if err != nil {
+			return nil, 0, err
+		}
+		return Structure{Tag: buf[1], Fields: fields.([]interface{})}, n, nil
+	default:
+		return nil, 0, fmt.Errorf("unsupported marker %#x", marker)
+	}
+}
+
+// A string or bytes of length after the header.
+func unpackBytes(buf []byte, header, length int, text bool) (interface{}, int, error) {
+	size := header + length
+	if size > len(buf) || size < header {
+		return nil, 0, errors.New("value is truncated")
+	}
+	if text {
+		return string(buf[header:size]), size, nil
+	}
+	return append([]byte{}, buf[header:size]...), size, nil
+}
+
+func unpackList(buf []byte, header, length int) (interface{}, int, error) {
+	// every value takes at least a byte
+	if length > len(buf)-header {
+		return nil, 0, errors.New("value is truncated")
+	}
+	list := make([]interface{}, 0, length)
+	size := header
+	for i := 0; i < length; i++ {
+		value, n, err := Unpack(buf[size:])
+		if err != nil {
+			return nil, 0, err
+		}
+		list = append(list, value)
+		size += n
+	}
+	return list, size, nil
+}
+
+func unpackMap(buf []byte, header, length int) (interface{}, int, error) {
+	if length > (len(buf)-header)/2 {
+		return nil, 0, errors.New("value is truncated")
+	}
+	entries := make(map[string]interface{}, length)
+	size := header
+	for i := 0; i < length; i++ {
+		key, n, err := Unpack(buf[size:])
+		if err != nil {
+			return nil, 0, err
+		}
+		name, ok := key.(string)
+		if !ok {
+			return nil, 0, fmt.Errorf("map key %v is not a string", key)
+		}
+		size += n
+		value, n, err := Unpack(buf[size:])
+		if err != nil {
+			return nil, 0, err
+		}
+		entries[name] = value
+		size += n
+	}
+	return entries, size, nil
+}
+
+// Build a message of type tag with the given fields, chunked and
+// terminated the way it goes on the wire.
+func NewMessage(tag byte, fields ...interface{}) (*Message, error) {
+	if len(fields) > 0xf {
+		return nil, errors.New("too many fields for a message")
+	}
+
+	body := bytes.NewBuffer([]byte{0xb0 + byte(len(fields)), tag})
+	for _, field := range fields {
+		err := pack(body, field)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return &Message{T: TypeFromByte(tag), Data: frame(body.Bytes())}, nil
+}
+
+// Split a message body into chunks and terminate it, the way it goes on
+// the wire.
+func frame(raw []byte) []byte {
+	data := new(bytes.Buffer)
+	for len(raw) > 0 {
+		size := len(raw)
+		if size > MAX_CHUNK_SIZE {
+			size = MAX_CHUNK_SIZE
+		}
+		_ = binary.Write(data, binary.BigEndian, uint16(size))
+		data.Write(raw[:size])
+		raw = raw[size:]
+	}
+	data.Write([]byte{0x00, 0x00})
+	return data.Bytes()
+}
+
+// Replace the auth entries of a HELLO or LOGON message, keeping the rest
+// of it (user agent, routing context...) as the client sent it.
+func RewriteAuth(msg *Message, auth map[string]interface{}) (*Message, error) {
+	if msg.T != HelloMsg && msg.T != LogonMsg {
+		return nil, fmt.Errorf("no auth in %s message", msg.T)
+	}
+	if len(msg.Data) < 5 {
+		return nil, errors.New("message too short")
+	}
+	tag := msg.Data[3]
+	data := msg.Data[4:]
+
+	// v1 INIT: user agent, auth map
+	if data[0]>>4 == 0x8 || (data[0] >= 0xd0 && data[0] <= 0xd2) {
+		userAgent, _, err := ParseString(data)
+		if err != nil {
+			return nil, err
+		}
+		return NewMessage(tag, userAgent, auth)
+	}
+
+	// v3+ HELLO and LOGON: a single map
+	extra, _, err := ParseMap(data)
+	if err != nil {
+		return nil, err
+	}
+	for _, key := range []string{"scheme", "principal", "credentials", "realm", "parameters"} {
+		delete(extra, key)
+	}
+	for key, value := range auth {
+		extra[key] = value
+	}
+	return NewMessage(tag, extra)
+}
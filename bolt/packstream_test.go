@@ -0,0 +1,184 @@
+/*
+Copyright (c) 2021 Memgraph Ltd. [https://memgraph.com]
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package bolt
+
+import (
+	"bytes"
+	"encoding/binary"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestPackScalars(t *testing.T) {
+	tests := []struct {
+		value    interface{}
+		expected []byte
+	}{
+		{nil, []byte{0xc0}},
+		{true, []byte{0xc3}},
+		{false, []byte{0xc2}},
+		{-16, []byte{0xf0}},
+		{-17, []byte{0xc8, 0xef}},
+		{127, []byte{0x7f}},
+		{200, []byte{0xc9, 0x00, 0xc8}},
+		{1.5, []byte{0xc1, 0x3f, 0xf8, 0, 0, 0, 0, 0, 0}},
+		{"dave", []byte{0x84, 0x64, 0x61, 0x76, 0x65}},
+		{[]string{"a"}, []byte{0x91, 0x81, 0x61}},
+	}
+	for _, test := range tests {
+		buf, err := Pack(test.value)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(buf, test.expected) {
+			t.Fatalf("%#v: expected %#v, got %#v", test.value, test.expected, buf)
+		}
+	}
+
+	if _, err := Pack(struct{}{}); err == nil {
+		t.Fatal("expected unsupported type to fail")
+	}
+}
+
+func TestPackRoundTrip(t *testing.T) {
+	token := strings.Repeat("x", 1200)
+	value := map[string]interface{}{
+		"scheme":      "bearer",
+		"credentials": token,
+		"routing":     nil,
+		"patch_bolt":  []interface{}{"utc"},
+		"count":       70000,
+		"flag":        true,
+	}
+	buf, err := Pack(value)
+	if err != nil {
+		t.Fatal(err)
+	}
+	parsed, n, err := ParseMap(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != len(buf) || !reflect.DeepEqual(parsed, value) {
+		t.Fatalf("round trip mismatch: %#v", parsed)
+	}
+}
+
+func TestUnpack(t *testing.T) {
+	values := []interface{}{
+		nil, true, false, int64(-16), int64(-17), int64(127), int64(200), int64(-40000),
+		int64(1) << 40, 1.5, "dave", strings.Repeat("é", 200),
+		[]interface{}{int64(1), "a", nil},
+		map[string]interface{}{"name": "alice", "tags": []interface{}{"x"}, "score": 0.25},
+	}
+	for _, value := range values {
+		buf, err := Pack(value)
+		if err != nil {
+			t.Fatal(err)
+		}
+		unpacked, n, err := Unpack(append(buf, 0xc0))
+		if err != nil || n != len(buf) || !reflect.DeepEqual(unpacked, value) {
+			t.Errorf("%#v: got %#v (%d of %d bytes), %v", value, unpacked, n, len(buf), err)
+		}
+	}
+
+	// a node with id 1, label Person and no properties, and some bytes
+	node := []byte{0xb3, 0x4e, 0x01, 0x91, 0x86, 'P', 'e', 'r', 's', 'o', 'n', 0xa0}
+	unpacked, _, err := Unpack(node)
+	expected := Structure{Tag: 0x4e, Fields: []interface{}{int64(1), []interface{}{"Person"}, map[string]interface{}{}}}
+	if err != nil || !reflect.DeepEqual(unpacked, expected) {
+		t.Errorf("expected a node, got %#v, %v", unpacked, err)
+	}
+	if unpacked, _, err := Unpack([]byte{0xcc, 0x02, 0xca, 0xfe}); err != nil || !bytes.Equal(unpacked.([]byte), []byte{0xca, 0xfe}) {
+		t.Errorf("expected bytes, got %#v, %v", unpacked, err)
+	}
+
+	for _, broken := range [][]byte{{}, {0x85, 'a'}, {0xd4, 0xff}, {0xdb}, {0xa1, 0x01, 0x01}, {0xc1, 0x00}, {0xd6, 0xff, 0xff, 0xff, 0xff}} {
+		if _, _, err := Unpack(broken); err == nil {
+			t.Errorf("%#v: expected an error", broken)
+		}
+	}
+}
+
+func TestNewMessageChunks(t *testing.T) {
+	msg, err := NewMessage(0x10, strings.Repeat("q", MAX_CHUNK_SIZE+10))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if msg.T != RunMsg {
+		t.Fatalf("unexpected type %s", msg.T)
+	}
+
+	first := int(binary.BigEndian.Uint16(msg.Data[:2]))
+	if first != MAX_CHUNK_SIZE {
+		t.Fatalf("expected a full first chunk, got %d", first)
+	}
+	rest := msg.Data[2+first:]
+	second := int(binary.BigEndian.Uint16(rest[:2]))
+	if len(rest) != 2+second+2 || !bytes.HasSuffix(rest, []byte{0x00, 0x00}) {
+		t.Fatalf("unexpected second chunk of %d bytes in %d", second, len(rest))
+	}
+}
+
+func TestRewriteAuth(t *testing.T) {
+	auth := map[string]interface{}{
+		"scheme":      "basic",
+		"principal":   "memgraph",
+		"credentials": "secret",
+	}
+
+	init, err := NewMessage(0x01, "driver/1.0", map[string]interface{}{
+		"scheme":      "bearer",
+		"credentials": "token",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	hello, err := NewMessage(0x01, map[string]interface{}{
+		"user_agent":  "driver/5.0",
+		"routing":     nil,
+		"scheme":      "bearer",
+		"credentials": "token",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for name, msg := range map[string]*Message{"init": init, "hello": hello} {
+		rewritten, err := RewriteAuth(msg, auth)
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		parsed, err := ParseAuth(rewritten)
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if parsed["principal"] != "memgraph" || parsed["credentials"] != "secret" || parsed["scheme"] != "basic" {
+			t.Fatalf("%s: unexpected auth %#v", name, parsed)
+		}
+	}
+
+	rewritten, _ := RewriteAuth(hello, auth)
+	parsed, _ := ParseAuth(rewritten)
+	if parsed["user_agent"] != "driver/5.0" {
+		t.Fatalf("expected the rest of HELLO to be kept, got %#v", parsed)
+	}
+	rewritten, _ = RewriteAuth(init, auth)
+	if user, _, _ := ParseString(rewritten.Data[4:]); user != "driver/1.0" {
+		t.Fatalf("expected the user agent to be kept, got %q", user)
+	}
+}
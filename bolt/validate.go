@@ -102,23 +102,20 @@ func ValidateHandshake(client, server []byte) ([]byte, error) {
 	return chosen, nil
 }
 
-// Try to find and validate the Mode for some given bytes, returning
-// the Mode if found or if valid looking Bolt chatter. Otherwise,
-// returns nil and an error.
+// Find the access mode a BEGIN or RUN message asks for, which is
+// WriteMode unless its metadata has mode "r". Other messages are taken
+// for WriteMode, and an error is returned if the metadata can't be read.
 func ValidateMode(buf []byte) (Mode, error) {
-	if IdentifyType(buf) == BeginMsg {
-		tinyMap, _, err := ParseMap(buf[4:])
-		if err != nil {
-			panic(err)
-		}
-
-		value, found := tinyMap["mode"]
-		if found {
-			mode, ok := value.(string)
-			if ok && mode == "r" {
-				return ReadMode, nil
-			}
-		}
+	msg := &Message{T: IdentifyType(buf), Data: buf}
+	if msg.T != BeginMsg && msg.T != RunMsg {
+		return WriteMode, nil
+	}
+	mode, err := MetadataString(msg, "mode")
+	if err != nil && err != ErrNoMetadata {
+		return "", err
+	}
+	if mode == "r" {
+		return ReadMode, nil
 	}
 	return WriteMode, nil
 }
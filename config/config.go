@@ -0,0 +1,1021 @@
+/*
+Copyright (c) 2021 Memgraph Ltd. [https://memgraph.com]
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package config
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	DEFAULT_BIND string = "localhost:8888"
+	DEFAULT_URI  string = "bolt://localhost:7687"
+	DEFAULT_USER string = "neo4j"
+
+	DEFAULT_HELLO_TIMEOUT    time.Duration = 30 * time.Second
+	DEFAULT_IDLE_TIMEOUT     time.Duration = 30 * time.Minute
+	DEFAULT_DIAL_TIMEOUT     time.Duration = 10 * time.Second
+	DEFAULT_HALT_TIMEOUT     time.Duration = 5 * time.Second
+	DEFAULT_AUTH_TIMEOUT     time.Duration = 5 * time.Second
+	DEFAULT_AUTH_RETRY_DELAY time.Duration = 200 * time.Millisecond
+	DEFAULT_JWKS_REFRESH     time.Duration = time.Hour
+	DEFAULT_POLICY_TIMEOUT   time.Duration = time.Second
+
+	DEFAULT_AUTH_CACHE_SIZE int = 10000
+
+	DEFAULT_LOCKOUT_WINDOW       time.Duration = 15 * time.Minute
+	DEFAULT_LOCKOUT_DURATION     time.Duration = time.Minute
+	DEFAULT_LOCKOUT_MAX_DURATION time.Duration = time.Hour
+	DEFAULT_LOCKOUT_DELAY        time.Duration = 500 * time.Millisecond
+	DEFAULT_LOCKOUT_MAX_DELAY    time.Duration = 8 * time.Second
+
+	DEFAULT_LDAP_USER_FILTER     string = "(uid={username})"
+	DEFAULT_LDAP_GROUP_FILTER    string = "(member={dn})"
+	DEFAULT_LDAP_GROUP_ATTRIBUTE string = "cn"
+
+	DEFAULT_AUDIT_MAX_SIZE_MB int    = 100
+	DEFAULT_AUDIT_MAX_FILES   int    = 10
+	DEFAULT_AUDIT_SYSLOG_TAG  string = "bolt-proxy"
+
+	DEFAULT_REDACTION_MAX_LENGTH int = 64
+)
+
+// Supported values for Auth.Method
+const (
+	AUTH_NONE      string = "none"
+	AUTH_BASIC     string = "basic"
+	AUTH_AAD_TOKEN string = "aad_token"
+	AUTH_JWT       string = "jwt"
+	AUTH_LDAP      string = "ldap"
+	AUTH_HTPASSWD  string = "htpasswd"
+	// A verified client certificate, mostly useful as a chain step
+	AUTH_CERT string = "cert"
+	// Several of the above, see AuthStep
+	AUTH_CHAIN string = "chain"
+)
+
+// Supported values for AuthStep.Control
+const (
+	CONTROL_SUFFICIENT string = "sufficient"
+	CONTROL_REQUIRED   string = "required"
+	CONTROL_OPTIONAL   string = "optional"
+)
+
+// Supported values for Role.Access, each one granting what the previous
+// one does
+const (
+	// Queries that don't change data, and CALLs to the role's procedures
+	ACCESS_READ string = "read"
+	// Also queries that change data
+	ACCESS_WRITE string = "write"
+	// Also schema changes, user management and any other administration,
+	// and CALLs to any procedure
+	ACCESS_ADMIN string = "admin"
+)
+
+// Supported values for Policy.Kind
+const (
+	// Rego policies evaluated by the proxy itself
+	POLICY_REGO string = "rego"
+	// Rego policies evaluated by an OPA server, via its data API
+	POLICY_OPA string = "opa"
+	// Any service answering with a PolicyDecision
+	POLICY_WEBHOOK string = "webhook"
+)
+
+// Supported values for CredentialMapping.Mode
+const (
+	CREDENTIALS_PASSTHROUGH string = "passthrough"
+	CREDENTIALS_SERVICE     string = "service"
+	CREDENTIALS_MAP         string = "map"
+	CREDENTIALS_SSO         string = "sso"
+)
+
+// Supported values for TLS.ClientAuth, mirroring tls.ClientAuthType
+const (
+	CLIENT_AUTH_NONE               string = "none"
+	CLIENT_AUTH_REQUEST            string = "request"
+	CLIENT_AUTH_REQUIRE            string = "require"
+	CLIENT_AUTH_VERIFY_IF_GIVEN    string = "verify_if_given"
+	CLIENT_AUTH_REQUIRE_AND_VERIFY string = "require_and_verify"
+)
+
+// Supported values for TLS.PrincipalFrom
+const (
+	PRINCIPAL_SUBJECT_CN string = "subject_cn"
+	PRINCIPAL_SUBJECT    string = "subject"
+	PRINCIPAL_SAN_DNS    string = "san_dns"
+	PRINCIPAL_SAN_EMAIL  string = "san_email"
+	PRINCIPAL_SAN_URI    string = "san_uri"
+)
+
+// Supported values for TLS.CertAuth
+const (
+	// The client certificate plays no part in authentication
+	CERT_AUTH_IGNORE string = "ignore"
+	// A verified client certificate stands in for HELLO credentials
+	CERT_AUTH_SUFFICIENT string = "sufficient"
+	// A verified client certificate is required on top of HELLO credentials
+	CERT_AUTH_REQUIRED string = "required"
+)
+
+// Supported values for Listener.Transports
+const (
+	TRANSPORT_BOLT      string = "bolt"
+	TRANSPORT_WEBSOCKET string = "websocket"
+)
+
+// Supported values for Audit.Sink
+const (
+	// A JSON lines file, rotated by size
+	AUDIT_SINK_FILE string = "file"
+	// One syslog message per entry
+	AUDIT_SINK_SYSLOG string = "syslog"
+)
+
+// Supported values for Audit.Parameters
+const (
+	// Only the parameter names are recorded
+	AUDIT_PARAMETERS_REDACT string = "redact"
+	// The names and a SHA-256 of each value, to match known values later
+	AUDIT_PARAMETERS_HASH string = "hash"
+	// The names and values, masked by the redaction rules
+	AUDIT_PARAMETERS_VALUES string = "values"
+)
+
+// Built-in patterns for Redaction.Patterns
+const (
+	REDACT_PATTERN_EMAIL string = "email"
+	// JSON Web Tokens, e.g. OIDC ID and access tokens
+	REDACT_PATTERN_JWT string = "jwt"
+	// "Bearer <token>" as in HTTP Authorization headers
+	REDACT_PATTERN_BEARER string = "bearer"
+)
+
+// Supported values for Redaction.Action
+const (
+	REDACT_ACTION_MASK string = "mask"
+	// A short HMAC-SHA256 of the value keyed with Redaction.HashKey, to
+	// tell values apart without showing them
+	REDACT_ACTION_HASH string = "hash"
+)
+
+// Name given to the listener and backend created when none are configured
+const DEFAULT_NAME string = "default"
+
+// Complete bolt-proxy configuration. It can be read from a YAML or TOML
+// file and is then overridden by environment variables and command line
+// flags, in that order.
+type Config struct {
+	Listeners []Listener `yaml:"listeners" toml:"listeners"`
+	Backends  []Backend  `yaml:"backends" toml:"backends"`
+	// Default auth for listeners that don't define their own
+	Auth     Auth     `yaml:"auth" toml:"auth"`
+	Pool     Pool     `yaml:"pool" toml:"pool"`
+	Timeouts Timeouts `yaml:"timeouts" toml:"timeouts"`
+	Logging  Logging  `yaml:"logging" toml:"logging"`
+	Admin    Admin    `yaml:"admin" toml:"admin"`
+	Audit    Audit    `yaml:"audit" toml:"audit"`
+	// What's hidden of the messages written to debug logs and of the
+	// queries written to the audit log
+	Redaction Redaction `yaml:"redaction" toml:"redaction"`
+}
+
+// HTTP endpoint serving health, metrics and TLS certificate status.
+// Disabled unless a bind address is set.
+type Admin struct {
+	Bind string `yaml:"bind" toml:"bind"`
+}
+
+// Frontend listener the Bolt clients connect to. Each listener has its own
+// TLS settings, auth policy, allowed transports and backend target.
+type Listener struct {
+	Name string `yaml:"name" toml:"name"`
+	Bind string `yaml:"bind" toml:"bind"`
+	TLS  TLS    `yaml:"tls" toml:"tls"`
+	// Overrides the top-level auth when set, use method "none" to disable
+	// proxy auth on this listener only
+	Auth       *Auth    `yaml:"auth" toml:"auth"`
+	Transports []string `yaml:"transports" toml:"transports"`
+	// Name of the backend the clients are proxied to, defaults to the
+	// first configured backend
+	Backend string `yaml:"backend" toml:"backend"`
+}
+
+func (l Listener) Allows(transport string) bool {
+	for _, t := range l.Transports {
+		if t == transport {
+			return true
+		}
+	}
+	return false
+}
+
+// TLS is enabled only if both the certificate and the key are provided.
+type TLS struct {
+	CertFile string `yaml:"cert_file" toml:"cert_file"`
+	KeyFile  string `yaml:"key_file" toml:"key_file"`
+
+	// PEM bundle of the CAs client certificates are verified against
+	ClientCAFile string `yaml:"client_ca_file" toml:"client_ca_file"`
+	ClientAuth   string `yaml:"client_auth" toml:"client_auth"`
+	// Which part of a verified client certificate is the principal
+	PrincipalFrom string `yaml:"principal_from" toml:"principal_from"`
+	CertAuth      string `yaml:"cert_auth" toml:"cert_auth"`
+
+	// One of 1.0, 1.1, 1.2 or 1.3, defaults to 1.2
+	MinVersion string `yaml:"min_version" toml:"min_version"`
+	// Names as listed by crypto/tls, e.g. TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256.
+	// Only applies to TLS 1.2 and older, 1.3 suites aren't configurable.
+	CipherSuites []string `yaml:"cipher_suites" toml:"cipher_suites"`
+	// Protocols offered during ALPN negotiation
+	ALPN []string `yaml:"alpn" toml:"alpn"`
+	// DER encoded OCSP response stapled to the handshake, re-read when
+	// the file changes
+	OCSPStapleFile string `yaml:"ocsp_staple_file" toml:"ocsp_staple_file"`
+}
+
+func (t TLS) Enabled() bool {
+	return t.CertFile != "" && t.KeyFile != ""
+}
+
+// Memgraph instance the proxy forwards to. The user and password are used
+// by the backend monitor, not by the proxied clients.
+type Backend struct {
+	Name     string     `yaml:"name" toml:"name"`
+	URI      string     `yaml:"uri" toml:"uri"`
+	User     string     `yaml:"user" toml:"user"`
+	Password string     `yaml:"password" toml:"password"`
+	Hosts    []string   `yaml:"hosts" toml:"hosts"`
+	// host:port of the replicas of the main instance. Auto-commit queries
+	// the proxy can tell only read are sent to them in turn, reached with
+	// the scheme and TLS settings of the URI.
+	Replicas []string   `yaml:"replicas" toml:"replicas"`
+	TLS      BackendTLS `yaml:"tls" toml:"tls"`
+}
+
+// TLS settings used towards a backend with a bolt+s, bolt+ssc, neo4j+s or
+// neo4j+ssc URI. The +ssc schemes accept self-signed certificates, so the
+// server certificate is not verified for them.
+type BackendTLS struct {
+	// PEM bundle of additional CAs to trust besides the system ones
+	CAFile string `yaml:"ca_file" toml:"ca_file"`
+	// Client certificate and key for mutual TLS with Memgraph
+	CertFile string `yaml:"cert_file" toml:"cert_file"`
+	KeyFile  string `yaml:"key_file" toml:"key_file"`
+	// Overrides the server name used for SNI and certificate verification
+	ServerName string `yaml:"server_name" toml:"server_name"`
+	// One of 1.0, 1.1, 1.2 or 1.3
+	MinVersion string `yaml:"min_version" toml:"min_version"`
+}
+
+func (t BackendTLS) IsSet() bool {
+	return t != BackendTLS{}
+}
+
+// Whether the bolt URI scheme asks for TLS, and if so whether the server
+// certificate should be verified.
+func SchemeTLS(scheme string) (useTLS, verify bool) {
+	switch scheme {
+	case "bolt+s", "neo4j+s":
+		return true, true
+	case "bolt+ssc", "neo4j+ssc":
+		return true, false
+	default:
+		return false, false
+	}
+}
+
+// Authentication the proxy performs on the client HELLO before any
+// connection to the backend is made.
+type Auth struct {
+	Method string `yaml:"method" toml:"method"`
+	// Method for clients using the bearer scheme, if not Method: jwt or
+	// aad_token
+	BearerMethod string `yaml:"bearer_method" toml:"bearer_method"`
+	// Steps of the chain method, run in order
+	Chain   []AuthStep  `yaml:"chain" toml:"chain"`
+	Cache   AuthCache   `yaml:"cache" toml:"cache"`
+	Lockout AuthLockout `yaml:"lockout" toml:"lockout"`
+	// What authenticated clients may do once connected
+	Authorization Authorization `yaml:"authorization" toml:"authorization"`
+	// How many connections and queries clients may start
+	RateLimits RateLimits `yaml:"rate_limits" toml:"rate_limits"`
+
+	Basic    BasicAuth    `yaml:"basic" toml:"basic"`
+	AADToken AADTokenAuth `yaml:"aad_token" toml:"aad_token"`
+	JWT      JWTAuth      `yaml:"jwt" toml:"jwt"`
+	LDAP     LDAPAuth     `yaml:"ldap" toml:"ldap"`
+	Htpasswd HtpasswdAuth `yaml:"htpasswd" toml:"htpasswd"`
+
+	// What the proxy sends to Memgraph once a client is authenticated
+	BackendCredentials CredentialMapping `yaml:"backend_credentials" toml:"backend_credentials"`
+}
+
+// Whether clients can be authenticated with the given method, directly
+// or as a step of the chain.
+func (a Auth) Uses(method string) bool {
+	if a.Method == method || a.BearerMethod == method {
+		return true
+	}
+	if a.Method != AUTH_CHAIN {
+		return false
+	}
+	for _, step := range a.Chain {
+		if step.Method == method {
+			return true
+		}
+	}
+	return false
+}
+
+// One step of an auth chain, using the settings of its method from the
+// enclosing Auth. Control decides what its outcome means for the chain:
+//
+//  sufficient: success ends the chain successfully, failure is ignored
+//    required: failure ends the chain unsuccessfully
+//    optional: failure is ignored, success only adds to the identity
+//
+// Steps after a successful sufficient one don't run. A chain that runs
+// to its end succeeds if it has at least one required step, all of which
+// passed. The principal comes from the first successful step,
+// claims from all of them.
+type AuthStep struct {
+	Method  string `yaml:"method" toml:"method"`
+	Control string `yaml:"control" toml:"control"`
+}
+
+// Remembers authentication decisions, keyed by a salted hash of the
+// client's credentials, so reconnecting clients don't hit the auth
+// service every time. Disabled unless a TTL is set.
+type AuthCache struct {
+	// How long successes are cached. Tokens that expire sooner are only
+	// cached until they expire.
+	TTL Duration `yaml:"ttl" toml:"ttl"`
+	// How long failures are cached, including those caused by the auth
+	// service being unreachable, so keep it short
+	NegativeTTL Duration `yaml:"negative_ttl" toml:"negative_ttl"`
+	// Least recently used decisions are evicted beyond this
+	MaxEntries int `yaml:"max_entries" toml:"max_entries"`
+}
+
+// Slows down and locks out principals and client addresses that keep
+// failing to authenticate. Every failure delays the FAILURE sent to the
+// client, twice as long as the previous one, and too many failures
+// within the window lock the principal or address out. Each lockout lasts
+// twice as long as the previous one. Disabled unless a maximum is set.
+type AuthLockout struct {
+	// Failures of one principal before it's locked out, 0 for no limit
+	MaxFailures int `yaml:"max_failures" toml:"max_failures"`
+	// Failures from one client IP address before it's locked out, 0 for
+	// no limit
+	MaxAddressFailures int `yaml:"max_address_failures" toml:"max_address_failures"`
+	// Failures are forgotten once there were none for this long
+	Window      Duration `yaml:"window" toml:"window"`
+	Duration    Duration `yaml:"duration" toml:"duration"`
+	MaxDuration Duration `yaml:"max_duration" toml:"max_duration"`
+	Delay       Duration `yaml:"delay" toml:"delay"`
+	MaxDelay    Duration `yaml:"max_delay" toml:"max_delay"`
+}
+
+func (l AuthLockout) Enabled() bool {
+	return l.MaxFailures > 0 || l.MaxAddressFailures > 0
+}
+
+// Token bucket limits on new connections per client IP address and on
+// RUN messages per principal, or per client address without
+// authentication. Clients over a limit get a TransientError FAILURE, which
+// drivers retry with backoff. Principals with roles get the most
+// permissive limit of their roles. Disabled unless a rate is set.
+type RateLimits struct {
+	Connections RateLimit `yaml:"connections" toml:"connections"`
+	Queries     RateLimit `yaml:"queries" toml:"queries"`
+}
+
+// A token bucket refilled with Rate tokens a second and holding at most
+// Burst of them, one taken per connection or query.
+type RateLimit struct {
+	// Tokens a second, 0 for no limit
+	Rate float64 `yaml:"rate" toml:"rate"`
+	// 0 for the rate rounded up
+	Burst int `yaml:"burst" toml:"burst"`
+}
+
+func (r RateLimit) Enabled() bool {
+	return r.Rate > 0
+}
+
+// Whether any connections or queries are limited, including by roles.
+func (a Auth) RateLimited() bool {
+	if a.RateLimits.Connections.Enabled() || a.RateLimits.Queries.Enabled() {
+		return true
+	}
+	for _, role := range a.Authorization.Roles {
+		if role.RateLimit != nil && role.RateLimit.Enabled() {
+			return true
+		}
+	}
+	return false
+}
+
+func (c AuthCache) Enabled() bool {
+	return c.TTL.Duration > 0 || c.NegativeTTL.Duration > 0
+}
+
+// Which queries clients may run, going by their roles: those listed for
+// their principal, those found in their identity's role claims, and the
+// default roles. The most permissive role wins. Clients without any role
+// can't run queries at all. Disabled unless roles are defined.
+type Authorization struct {
+	Roles []Role `yaml:"roles" toml:"roles"`
+	// Dotted paths of the claims holding role names, e.g. roles (htpasswd
+	// and webhooks), groups (LDAP) or realm_access.roles (Keycloak)
+	RoleClaims []string `yaml:"role_claims" toml:"role_claims"`
+	// Roles of principals, on top of those from their claims
+	Principals   map[string][]string `yaml:"principals" toml:"principals"`
+	DefaultRoles []string            `yaml:"default_roles" toml:"default_roles"`
+	// Consulted for every query the roles allow
+	Policy Policy `yaml:"policy" toml:"policy"`
+	// Only approved queries may run, if set
+	Allowlist QueryAllowlist `yaml:"allowlist" toml:"allowlist"`
+}
+
+// The queries clients may run, from Path: either a YAML file mapping query
+// ids to queries, or a directory of .cypher files named after their ids.
+// It's reloaded when it changes.
+//
+// Clients either send an approved query, which is compared after
+// normalizing whitespace and comments, or just the id of one in the
+// query_id entry of the RUN metadata, which the proxy replaces with the
+// query. Any other query is refused.
+type QueryAllowlist struct {
+	Path string `yaml:"path" toml:"path"`
+}
+
+func (a QueryAllowlist) Enabled() bool {
+	return a.Path != ""
+}
+
+// A policy decision point admitting or refusing each query. It gets the
+// client's principal, claims, roles and address, the database, the query
+// and the names of its parameters as JSON, see backend.PolicyInput.
+//
+// A webhook answers with {"allow": true} or {"allow": false, "reason":
+// "..."}. Rego policies get them as their input and define a decision,
+// e.g. data.memgraph.admission, which is either a boolean or an object like
+// the webhook's answer. The proxy evaluates the Rego modules itself, or
+// leaves it to an OPA server at URL, e.g.
+// http://localhost:8181/v1/data/memgraph/admission.
+type Policy struct {
+	// rego, opa or webhook, disabled if not set
+	Kind string `yaml:"kind" toml:"kind"`
+	// Files of the Rego modules and the decision to evaluate, for rego
+	Modules  []string `yaml:"modules" toml:"modules"`
+	Decision string   `yaml:"decision" toml:"decision"`
+	// Where to send the input, for opa and webhook
+	URL     string            `yaml:"url" toml:"url"`
+	Headers map[string]string `yaml:"headers" toml:"headers"`
+	Timeout Duration          `yaml:"timeout" toml:"timeout"`
+	// Log what the policy would refuse instead of refusing it, to try
+	// out a policy
+	AuditOnly bool `yaml:"audit_only" toml:"audit_only"`
+	// Admit queries if the policy can't be consulted, instead of refusing
+	// them
+	FailOpen bool `yaml:"fail_open" toml:"fail_open"`
+}
+
+func (p Policy) Enabled() bool {
+	return p.Kind != ""
+}
+
+type Role struct {
+	Name   string `yaml:"name" toml:"name"`
+	Access string `yaml:"access" toml:"access"`
+	// Procedures the role may CALL, as patterns like mg.* or
+	// nxalg.betweenness_centrality. Admins may call any procedure.
+	Procedures []string `yaml:"procedures" toml:"procedures"`
+	// Queries of each principal with the role, instead of
+	// rate_limits.queries. A rate of 0 lifts the limit.
+	RateLimit *RateLimit `yaml:"rate_limit" toml:"rate_limit"`
+}
+
+func (a Authorization) Enabled() bool {
+	return len(a.Roles) > 0
+}
+
+// A webhook deciding on basic credentials, which it gets in an
+// Authorization header. With POST or PUT it also gets a JSON body
+// describing the client. Any 200 accepts the credentials, and a JSON
+// response may add the roles, backend credentials and session TTL to use.
+type BasicAuth struct {
+	URL    string `yaml:"url" toml:"url"`
+	Method string `yaml:"method" toml:"method"`
+	// Extra request headers, e.g. an API key for the webhook
+	Headers map[string]string `yaml:"headers" toml:"headers"`
+	Timeout Duration          `yaml:"timeout" toml:"timeout"`
+	// Extra attempts after network errors and 5xx responses
+	Retries    int      `yaml:"retries" toml:"retries"`
+	RetryDelay Duration `yaml:"retry_delay" toml:"retry_delay"`
+}
+
+type AADTokenAuth struct {
+	ClientID string `yaml:"client_id" toml:"client_id"`
+	Provider string `yaml:"provider" toml:"provider"`
+	// How often the provider's signing keys are refetched in the background
+	JWKSRefresh Duration `yaml:"jwks_refresh" toml:"jwks_refresh"`
+}
+
+// Bearer tokens (JWTs) sent as the HELLO credentials, signed by one of the
+// trusted issuers.
+type JWTAuth struct {
+	Issuers []JWTIssuer `yaml:"issuers" toml:"issuers"`
+	// The token must be meant for at least one of these
+	Audiences []string `yaml:"audiences" toml:"audiences"`
+	// Every rule must match for the token to be accepted
+	RequiredClaims []ClaimRule `yaml:"required_claims" toml:"required_claims"`
+	// How often signing keys fetched over HTTP are refreshed
+	JWKSRefresh Duration `yaml:"jwks_refresh" toml:"jwks_refresh"`
+}
+
+// A trusted token issuer. Its signing keys come from at most one of
+// JWKSURL, JWKSFile or PublicKeyFiles; if none is set they're found via
+// OIDC discovery on the issuer URL.
+type JWTIssuer struct {
+	Issuer         string   `yaml:"issuer" toml:"issuer"`
+	JWKSURL        string   `yaml:"jwks_url" toml:"jwks_url"`
+	JWKSFile       string   `yaml:"jwks_file" toml:"jwks_file"`
+	PublicKeyFiles []string `yaml:"public_key_files" toml:"public_key_files"`
+}
+
+// Requires the claim at the dotted path Claim (e.g. realm_access.roles)
+// to hold one of Values. List claims and space separated strings such as
+// scp match if any of their elements does.
+type ClaimRule struct {
+	Claim  string   `yaml:"claim" toml:"claim"`
+	Values []string `yaml:"values" toml:"values"`
+}
+
+// Checks credentials by binding to an LDAP or Active Directory server as
+// the user. The user's DN is either built from UserDN, or found by
+// searching BaseDN with UserFilter, bound as BindDN if set.
+//
+// In filters, {username} is replaced with the client's principal and
+// {dn} with the user's DN, both escaped.
+type LDAPAuth struct {
+	// ldap:// or ldaps:// URL of the directory server
+	URL string `yaml:"url" toml:"url"`
+	// Upgrade ldap:// connections with StartTLS before binding
+	StartTLS bool `yaml:"start_tls" toml:"start_tls"`
+	// PEM bundle of additional CAs to trust besides the system ones
+	CAFile string `yaml:"ca_file" toml:"ca_file"`
+	// Overrides the server name used for certificate verification
+	ServerName string   `yaml:"server_name" toml:"server_name"`
+	Timeout    Duration `yaml:"timeout" toml:"timeout"`
+
+	// DN template for binding directly, e.g.
+	// uid={username},ou=people,dc=example,dc=com
+	UserDN string `yaml:"user_dn" toml:"user_dn"`
+
+	// Search then bind, used if UserDN is not set
+	BindDN       string `yaml:"bind_dn" toml:"bind_dn"`
+	BindPassword string `yaml:"bind_password" toml:"bind_password"`
+	BaseDN       string `yaml:"base_dn" toml:"base_dn"`
+	UserFilter   string `yaml:"user_filter" toml:"user_filter"`
+
+	// Group membership is resolved only if GroupBaseDN is set. The names
+	// end up in the "groups" claim of the client's identity.
+	GroupBaseDN    string `yaml:"group_base_dn" toml:"group_base_dn"`
+	GroupFilter    string `yaml:"group_filter" toml:"group_filter"`
+	GroupAttribute string `yaml:"group_attribute" toml:"group_attribute"`
+}
+
+// Checks credentials against a local htpasswd style file with bcrypt or
+// argon2 hashes, one user:hash[:role,role...] entry per line. The file is
+// reloaded when it changes.
+type HtpasswdAuth struct {
+	File string `yaml:"file" toml:"file"`
+}
+
+// Credentials presented to Memgraph on behalf of authenticated clients,
+// depending on Mode:
+//
+//  passthrough: the client's own HELLO is forwarded
+//      service: every client uses the Service account
+//          map: the first matching rule picks the account, falling back
+//               to Service if it's set
+//          sso: the client's token is passed on using Memgraph's SSO scheme
+type CredentialMapping struct {
+	Mode    string             `yaml:"mode" toml:"mode"`
+	Service MemgraphCredential `yaml:"service" toml:"service"`
+	Rules   []CredentialRule   `yaml:"rules" toml:"rules"`
+	SSO     SSOCredential      `yaml:"sso" toml:"sso"`
+}
+
+type MemgraphCredential struct {
+	User     string `yaml:"user" toml:"user"`
+	Password string `yaml:"password" toml:"password"`
+}
+
+// Maps clients to a Memgraph account, either by principal or by a token
+// claim matched like a ClaimRule.
+type CredentialRule struct {
+	Principal string   `yaml:"principal" toml:"principal"`
+	Claim     string   `yaml:"claim" toml:"claim"`
+	Values    []string `yaml:"values" toml:"values"`
+
+	User     string `yaml:"user" toml:"user"`
+	Password string `yaml:"password" toml:"password"`
+}
+
+// Memgraph SSO scheme (e.g. oidc-entra-id) and the credentials sent with
+// it, where ${token} is replaced with the client's token.
+type SSOCredential struct {
+	Scheme      string `yaml:"scheme" toml:"scheme"`
+	Credentials string `yaml:"credentials" toml:"credentials"`
+}
+
+// Settings for the driver pool used to monitor the backend.
+type Pool struct {
+	MaxSize            int      `yaml:"max_size" toml:"max_size"`
+	AcquisitionTimeout Duration `yaml:"acquisition_timeout" toml:"acquisition_timeout"`
+}
+
+type Timeouts struct {
+	// How long a new client has to send its HELLO
+	Hello Duration `yaml:"hello" toml:"hello"`
+	// How long a client or server connection may stay silent
+	Idle Duration `yaml:"idle" toml:"idle"`
+	// How long to wait for a backend connection to be established
+	Dial Duration `yaml:"dial" toml:"dial"`
+	// How long to wait for a transaction handler to acknowledge a halt
+	Halt Duration `yaml:"halt" toml:"halt"`
+}
+
+type Logging struct {
+	Debug bool `yaml:"debug" toml:"debug"`
+	// Optional path of a file to write all logs to instead of stdout/stderr
+	File string `yaml:"file" toml:"file"`
+}
+
+// Tamper-evident record of the client sessions, their queries and
+// transactions. Disabled unless a sink is set.
+type Audit struct {
+	// Where entries go, see AUDIT_SINK_*
+	Sink   string      `yaml:"sink" toml:"sink"`
+	File   AuditFile   `yaml:"file" toml:"file"`
+	Syslog AuditSyslog `yaml:"syslog" toml:"syslog"`
+	// How the parameters of queries are recorded, see AUDIT_PARAMETERS_*
+	Parameters string `yaml:"parameters" toml:"parameters"`
+}
+
+type AuditFile struct {
+	Path string `yaml:"path" toml:"path"`
+	// Size the file is rotated at, 0 to never rotate it
+	MaxSizeMB int `yaml:"max_size_mb" toml:"max_size_mb"`
+	// How many rotated files to keep, as path.1 (newest) to path.N
+	MaxFiles int `yaml:"max_files" toml:"max_files"`
+}
+
+type AuditSyslog struct {
+	// udp, tcp or unix, the local syslog daemon when both are empty
+	Network string `yaml:"network" toml:"network"`
+	Address string `yaml:"address" toml:"address"`
+	Tag     string `yaml:"tag" toml:"tag"`
+}
+
+// Rules masking sensitive values wherever messages and queries are logged.
+type Redaction struct {
+	// Parameters, and keys of any other map such as node properties, whose
+	// values are masked. Compared case insensitively, * matches anything.
+	// HELLO and LOGON credentials and passwords are always masked, never
+	// hashed.
+	Parameters []string `yaml:"parameters" toml:"parameters"`
+	// Built-in REDACT_PATTERN_* names or regular expressions, masked
+	// wherever they appear in strings and query text
+	Patterns []string `yaml:"patterns" toml:"patterns"`
+	// How masked values are shown, see REDACT_ACTION_*
+	Action string `yaml:"action" toml:"action"`
+	// Secret the hashes are keyed with, so values can't be found by
+	// hashing guesses; required for REDACT_ACTION_HASH
+	HashKey string `yaml:"hash_key" toml:"hash_key"`
+	// Longer strings are truncated in debug logs, 0 to keep them whole
+	MaxLength int `yaml:"max_length" toml:"max_length"`
+}
+
+func (l *AuthLockout) fillDefaults() {
+	defaults := []struct {
+		field *Duration
+		value time.Duration
+	}{
+		{&l.Window, DEFAULT_LOCKOUT_WINDOW},
+		{&l.Duration, DEFAULT_LOCKOUT_DURATION},
+		{&l.MaxDuration, DEFAULT_LOCKOUT_MAX_DURATION},
+		{&l.Delay, DEFAULT_LOCKOUT_DELAY},
+		{&l.MaxDelay, DEFAULT_LOCKOUT_MAX_DELAY},
+	}
+	for _, d := range defaults {
+		if d.field.Duration == 0 {
+			d.field.Duration = d.value
+		}
+	}
+}
+
+// The claims htpasswd, webhooks and LDAP put roles in
+func defaultRoleClaims() []string {
+	return []string{"roles", "groups"}
+}
+
+// A time.Duration that can be written as "30s" or "5m" in config files.
+type Duration struct {
+	time.Duration
+}
+
+func (d *Duration) UnmarshalText(text []byte) error {
+	parsed, err := time.ParseDuration(string(text))
+	if err != nil {
+		return err
+	}
+	d.Duration = parsed
+	return nil
+}
+
+func (d Duration) MarshalText() ([]byte, error) {
+	return []byte(d.Duration.String()), nil
+}
+
+// Configuration used when nothing is set in a file, the environment or
+// on the command line.
+func Default() *Config {
+	return &Config{
+		Listeners: []Listener{{
+			Name:       DEFAULT_NAME,
+			Bind:       DEFAULT_BIND,
+			Transports: []string{TRANSPORT_BOLT, TRANSPORT_WEBSOCKET},
+			Backend:    DEFAULT_NAME,
+			TLS: TLS{
+				ClientAuth:    CLIENT_AUTH_NONE,
+				PrincipalFrom: PRINCIPAL_SUBJECT_CN,
+				CertAuth:      CERT_AUTH_IGNORE,
+				MinVersion:    DEFAULT_MIN_TLS_VERSION,
+			},
+		}},
+		Backends: []Backend{{
+			Name: DEFAULT_NAME,
+			URI:  DEFAULT_URI,
+			User: DEFAULT_USER,
+		}},
+		Auth: Auth{
+			Method: AUTH_NONE,
+			Cache: AuthCache{
+				MaxEntries: DEFAULT_AUTH_CACHE_SIZE,
+			},
+			Lockout: AuthLockout{
+				Window:      Duration{DEFAULT_LOCKOUT_WINDOW},
+				Duration:    Duration{DEFAULT_LOCKOUT_DURATION},
+				MaxDuration: Duration{DEFAULT_LOCKOUT_MAX_DURATION},
+				Delay:       Duration{DEFAULT_LOCKOUT_DELAY},
+				MaxDelay:    Duration{DEFAULT_LOCKOUT_MAX_DELAY},
+			},
+			Authorization: Authorization{
+				RoleClaims: defaultRoleClaims(),
+				Policy: Policy{
+					Timeout: Duration{DEFAULT_POLICY_TIMEOUT},
+				},
+			},
+			BackendCredentials: CredentialMapping{
+				Mode: CREDENTIALS_PASSTHROUGH,
+			},
+			Basic: BasicAuth{
+				Method:     http.MethodGet,
+				Timeout:    Duration{DEFAULT_AUTH_TIMEOUT},
+				RetryDelay: Duration{DEFAULT_AUTH_RETRY_DELAY},
+			},
+			AADToken: AADTokenAuth{
+				JWKSRefresh: Duration{DEFAULT_JWKS_REFRESH},
+			},
+			JWT: JWTAuth{
+				JWKSRefresh: Duration{DEFAULT_JWKS_REFRESH},
+			},
+			LDAP: LDAPAuth{
+				Timeout:        Duration{DEFAULT_AUTH_TIMEOUT},
+				UserFilter:     DEFAULT_LDAP_USER_FILTER,
+				GroupFilter:    DEFAULT_LDAP_GROUP_FILTER,
+				GroupAttribute: DEFAULT_LDAP_GROUP_ATTRIBUTE,
+			},
+		},
+		Timeouts: Timeouts{
+			Hello: Duration{DEFAULT_HELLO_TIMEOUT},
+			Idle:  Duration{DEFAULT_IDLE_TIMEOUT},
+			Dial:  Duration{DEFAULT_DIAL_TIMEOUT},
+			Halt:  Duration{DEFAULT_HALT_TIMEOUT},
+		},
+		Audit: Audit{
+			File: AuditFile{
+				MaxSizeMB: DEFAULT_AUDIT_MAX_SIZE_MB,
+				MaxFiles:  DEFAULT_AUDIT_MAX_FILES,
+			},
+			Syslog: AuditSyslog{
+				Tag: DEFAULT_AUDIT_SYSLOG_TAG,
+			},
+			Parameters: AUDIT_PARAMETERS_REDACT,
+		},
+		Redaction: Redaction{
+			Parameters: []string{"password", "credentials", "*secret*", "*token*"},
+			Patterns:   []string{REDACT_PATTERN_EMAIL, REDACT_PATTERN_JWT, REDACT_PATTERN_BEARER},
+			Action:     REDACT_ACTION_MASK,
+			MaxLength:  DEFAULT_REDACTION_MAX_LENGTH,
+		},
+	}
+}
+
+// Read the configuration file at path on top of the defaults. The format
+// is picked based on the file extension: .yaml, .yml or .toml.
+//
+// Unknown keys are reported as errors so typos don't go unnoticed. The
+// result is not validated, see Validate().
+func Load(path string) (*Config, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	cfg := Default()
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		err = decodeYAML(data, cfg)
+	case ".toml":
+		err = decodeTOML(data, cfg)
+	default:
+		return nil, fmt.Errorf("%s: unsupported config format, expected .yaml, .yml or .toml", path)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+
+	cfg.fillDefaults()
+	return cfg, nil
+}
+
+// Lists replace the default listener and backend entirely, so fill in
+// whatever was left out of the individual entries.
+func (c *Config) fillDefaults() {
+	if len(c.Listeners) == 0 {
+		c.Listeners = Default().Listeners
+	}
+	if len(c.Backends) == 0 {
+		c.Backends = Default().Backends
+	}
+
+	for i := range c.Backends {
+		b := &c.Backends[i]
+		if b.Name == "" {
+			b.Name = fmt.Sprintf("backend-%d", i)
+		}
+		if b.URI == "" {
+			b.URI = DEFAULT_URI
+		}
+		if b.User == "" {
+			b.User = DEFAULT_USER
+		}
+	}
+
+	for i := range c.Listeners {
+		l := &c.Listeners[i]
+		if l.Name == "" {
+			l.Name = fmt.Sprintf("listener-%d", i)
+		}
+		if l.Bind == "" {
+			l.Bind = DEFAULT_BIND
+		}
+		if len(l.Transports) == 0 {
+			l.Transports = []string{TRANSPORT_BOLT, TRANSPORT_WEBSOCKET}
+		}
+		if l.Backend == "" {
+			l.Backend = c.Backends[0].Name
+		}
+		if l.TLS.ClientAuth == "" {
+			l.TLS.ClientAuth = CLIENT_AUTH_NONE
+		}
+		if l.TLS.PrincipalFrom == "" {
+			l.TLS.PrincipalFrom = PRINCIPAL_SUBJECT_CN
+		}
+		if l.TLS.CertAuth == "" {
+			l.TLS.CertAuth = CERT_AUTH_IGNORE
+		}
+		if l.TLS.MinVersion == "" {
+			l.TLS.MinVersion = DEFAULT_MIN_TLS_VERSION
+		}
+		if l.Auth != nil && l.Auth.Basic.Method == "" {
+			l.Auth.Basic.Method = http.MethodGet
+		}
+		if l.Auth != nil && l.Auth.Basic.Timeout.Duration == 0 {
+			l.Auth.Basic.Timeout = Duration{DEFAULT_AUTH_TIMEOUT}
+		}
+		if l.Auth != nil && l.Auth.Basic.RetryDelay.Duration == 0 {
+			l.Auth.Basic.RetryDelay = Duration{DEFAULT_AUTH_RETRY_DELAY}
+		}
+		if l.Auth != nil && l.Auth.AADToken.JWKSRefresh.Duration == 0 {
+			l.Auth.AADToken.JWKSRefresh = Duration{DEFAULT_JWKS_REFRESH}
+		}
+		if l.Auth != nil && l.Auth.JWT.JWKSRefresh.Duration == 0 {
+			l.Auth.JWT.JWKSRefresh = Duration{DEFAULT_JWKS_REFRESH}
+		}
+		if l.Auth != nil && l.Auth.LDAP.Timeout.Duration == 0 {
+			l.Auth.LDAP.Timeout = Duration{DEFAULT_AUTH_TIMEOUT}
+		}
+		if l.Auth != nil && l.Auth.LDAP.UserFilter == "" {
+			l.Auth.LDAP.UserFilter = DEFAULT_LDAP_USER_FILTER
+		}
+		if l.Auth != nil && l.Auth.LDAP.GroupFilter == "" {
+			l.Auth.LDAP.GroupFilter = DEFAULT_LDAP_GROUP_FILTER
+		}
+		if l.Auth != nil && l.Auth.LDAP.GroupAttribute == "" {
+			l.Auth.LDAP.GroupAttribute = DEFAULT_LDAP_GROUP_ATTRIBUTE
+		}
+		if l.Auth != nil && l.Auth.Cache.MaxEntries == 0 {
+			l.Auth.Cache.MaxEntries = DEFAULT_AUTH_CACHE_SIZE
+		}
+		if l.Auth != nil {
+			l.Auth.Lockout.fillDefaults()
+		}
+		if l.Auth != nil && l.Auth.Authorization.RoleClaims == nil {
+			l.Auth.Authorization.RoleClaims = defaultRoleClaims()
+		}
+		if l.Auth != nil && l.Auth.Authorization.Policy.Timeout.Duration == 0 {
+			l.Auth.Authorization.Policy.Timeout = Duration{DEFAULT_POLICY_TIMEOUT}
+		}
+		if l.Auth != nil && l.Auth.BackendCredentials.Mode == "" {
+			l.Auth.BackendCredentials.Mode = CREDENTIALS_PASSTHROUGH
+		}
+	}
+}
+
+// Auth settings for the given listener, falling back to the top-level
+// ones if the listener has none of its own.
+func (c *Config) ListenerAuth(l Listener) Auth {
+	if l.Auth != nil {
+		return *l.Auth
+	}
+	return c.Auth
+}
+
+// Look up a backend by name, returning nil if there's no such backend.
+func (c *Config) Backend(name string) *Backend {
+	for i := range c.Backends {
+		if c.Backends[i].Name == name {
+			return &c.Backends[i]
+		}
+	}
+	return nil
+}
+
+func decodeYAML(data []byte, cfg *Config) error {
+	decoder := yaml.NewDecoder(bytes.NewReader(data))
+	decoder.KnownFields(true)
+	err := decoder.Decode(cfg)
+	if err == io.EOF {
+		// empty file, keep the defaults
+		return nil
+	}
+	return err
+}
+
+func decodeTOML(data []byte, cfg *Config) error {
+	meta, err := toml.Decode(string(data), cfg)
+	if err != nil {
+		return err
+	}
+
+	undecoded := meta.Undecoded()
+	if len(undecoded) > 0 {
+		keys := make([]string, len(undecoded))
+		for i, key := range undecoded {
+			keys[i] = key.String()
+		}
+		return fmt.Errorf("unknown keys: %s", strings.Join(keys, ", "))
+	}
+	return nil
+}
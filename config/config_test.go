@@ -0,0 +1,786 @@
+/*
+Copyright (c) 2021 Memgraph Ltd. [https://memgraph.com]
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package config
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func writeConfig(t *testing.T, name, content string) string {
+	dir, err := ioutil.TempDir("", "bolt-proxy-config")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+
+	path := filepath.Join(dir, name)
+	err = ioutil.WriteFile(path, []byte(content), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoadYAML(t *testing.T) {
+	path := writeConfig(t, "proxy.yaml", `
+listeners:
+  - bind: 0.0.0.0:7687
+backends:
+  - uri: bolt+s://memgraph:7687
+    user: monitor
+auth:
+  method: basic
+  basic:
+    url: http://auth.local/check
+    timeout: 2s
+timeouts:
+  idle: 10m
+logging:
+  debug: true
+`)
+	cfg, err := Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Listeners[0].Bind != "0.0.0.0:7687" {
+		t.Fatalf("unexpected bind: %s", cfg.Listeners[0].Bind)
+	}
+	if cfg.Auth.Method != AUTH_BASIC || cfg.Auth.Basic.Timeout.Duration != 2*time.Second {
+		t.Fatalf("unexpected auth: %#v", cfg.Auth)
+	}
+	if cfg.Timeouts.Idle.Duration != 10*time.Minute {
+		t.Fatalf("unexpected idle timeout: %v", cfg.Timeouts.Idle)
+	}
+	// untouched values keep their defaults
+	if cfg.Timeouts.Hello.Duration != DEFAULT_HELLO_TIMEOUT {
+		t.Fatalf("expected default hello timeout, got %v", cfg.Timeouts.Hello)
+	}
+	if !cfg.Logging.Debug {
+		t.Fatal("expected debug logging")
+	}
+	if err = cfg.Validate(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestLoadTOML(t *testing.T) {
+	path := writeConfig(t, "proxy.toml", `
+[[listeners]]
+bind = "127.0.0.1:9999"
+
+[auth]
+method = "aad_token"
+
+[auth.aad_token]
+client_id = "client"
+provider = "https://login.microsoftonline.com/tenant"
+
+[timeouts]
+hello = "5s"
+`)
+	cfg, err := Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Listeners[0].Bind != "127.0.0.1:9999" {
+		t.Fatalf("unexpected bind: %s", cfg.Listeners[0].Bind)
+	}
+	if cfg.Auth.AADToken.ClientID != "client" {
+		t.Fatalf("unexpected aad config: %#v", cfg.Auth.AADToken)
+	}
+	if cfg.Timeouts.Hello.Duration != 5*time.Second {
+		t.Fatalf("unexpected hello timeout: %v", cfg.Timeouts.Hello)
+	}
+	if err = cfg.Validate(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestLoadUnknownKeys(t *testing.T) {
+	path := writeConfig(t, "proxy.yaml", "listeners:\n  - bnid: localhost:1234\n")
+	if _, err := Load(path); err == nil {
+		t.Fatal("expected unknown yaml key to fail")
+	}
+
+	path = writeConfig(t, "proxy.toml", "[[listeners]]\nbnid = \"localhost:1234\"\n")
+	if _, err := Load(path); err == nil {
+		t.Fatal("expected unknown toml key to fail")
+	}
+
+	path = writeConfig(t, "proxy.json", "{}")
+	if _, err := Load(path); err == nil {
+		t.Fatal("expected unsupported format to fail")
+	}
+}
+
+func TestEnvOverridesFile(t *testing.T) {
+	path := writeConfig(t, "proxy.yaml", `
+listeners:
+  - bind: 0.0.0.0:7687
+auth:
+  method: none
+`)
+	cfg, err := Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	env := map[string]string{
+		"BOLT_PROXY_BIND":  "0.0.0.0:8080",
+		"AUTH_METHOD":      "BASIC_AUTH",
+		"BASIC_AUTH_URL":   "http://auth.local",
+		"BOLT_PROXY_DEBUG": "",
+	}
+	cfg.ApplyEnv(func(key string) (string, bool) {
+		value, found := env[key]
+		return value, found
+	})
+
+	if cfg.Listeners[0].Bind != "0.0.0.0:8080" {
+		t.Fatalf("expected env to override bind, got %s", cfg.Listeners[0].Bind)
+	}
+	if cfg.Auth.Method != AUTH_BASIC || cfg.Auth.Basic.URL != "http://auth.local" {
+		t.Fatalf("expected env to override auth, got %#v", cfg.Auth)
+	}
+	if !cfg.Logging.Debug {
+		t.Fatal("expected BOLT_PROXY_DEBUG to enable debug logging")
+	}
+}
+
+func TestValidate(t *testing.T) {
+	cfg := Default()
+	if err := cfg.Validate(); err != nil {
+		t.Fatalf("defaults should be valid: %v", err)
+	}
+
+	cfg.Listeners[0].Bind = "nope"
+	cfg.Listeners[0].TLS.CertFile = "cert.pem"
+	cfg.Backends[0].URI = "http://localhost:7687"
+	cfg.Backends[0].Replicas = []string{"replica:7687", "replica"}
+	cfg.Auth.Method = AUTH_BASIC
+	cfg.Auth.BearerMethod = AUTH_BASIC + "x"
+	cfg.Timeouts.Idle = Duration{}
+	cfg.Audit.Sink = AUDIT_SINK_FILE
+	cfg.Audit.Parameters = "plain"
+	cfg.Redaction.Patterns = append(cfg.Redaction.Patterns, "(unclosed")
+	cfg.Redaction.Action = REDACT_ACTION_HASH
+	cfg.Auth.RateLimits.Queries = RateLimit{Rate: 10, Burst: -1}
+
+	err := cfg.Validate()
+	verr, ok := err.(*ValidationError)
+	if !ok {
+		t.Fatalf("expected a *ValidationError, got %v", err)
+	}
+
+	expected := []string{
+		"listeners[0].bind",
+		"cert_file and key_file must be set together",
+		"listeners[0].tls.cert_file",
+		"backends[0].uri",
+		"backends[0].replicas",
+		"auth.basic.url",
+		"auth.bearer_method",
+		"timeouts.idle",
+		"audit.file.path",
+		"audit.parameters",
+		"redaction.patterns[3]",
+		"redaction.hash_key",
+		"auth.rate_limits.queries",
+	}
+	for _, problem := range expected {
+		if !strings.Contains(verr.Error(), problem) {
+			t.Errorf("expected %q to be reported in:\n%s", problem, verr)
+		}
+	}
+}
+
+func TestMultipleListeners(t *testing.T) {
+	path := writeConfig(t, "proxy.yaml", `
+listeners:
+  - name: internal
+    bind: 10.0.0.1:7687
+    transports: [bolt]
+    auth:
+      method: none
+  - name: public
+    bind: 0.0.0.0:7687
+    backend: replica
+backends:
+  - name: main
+    uri: bolt://memgraph-main:7687
+  - name: replica
+    uri: bolt://memgraph-replica:7687
+auth:
+  method: aad_token
+  aad_token:
+    client_id: client
+    provider: https://login.microsoftonline.com/tenant
+`)
+	cfg, err := Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = cfg.Validate(); err != nil {
+		t.Fatal(err)
+	}
+
+	internal, public := cfg.Listeners[0], cfg.Listeners[1]
+	if internal.Backend != "main" || public.Backend != "replica" {
+		t.Fatalf("unexpected backends: %s, %s", internal.Backend, public.Backend)
+	}
+	if !internal.Allows(TRANSPORT_BOLT) || internal.Allows(TRANSPORT_WEBSOCKET) {
+		t.Fatalf("unexpected internal transports: %v", internal.Transports)
+	}
+	if !public.Allows(TRANSPORT_WEBSOCKET) {
+		t.Fatalf("expected websocket to be allowed by default: %v", public.Transports)
+	}
+	if cfg.ListenerAuth(internal).Method != AUTH_NONE {
+		t.Fatal("expected internal listener to override auth")
+	}
+	if cfg.ListenerAuth(public).Method != AUTH_AAD_TOKEN {
+		t.Fatal("expected public listener to inherit auth")
+	}
+
+	cfg.Listeners[1].Backend = "missing"
+	cfg.Listeners[1].Bind = cfg.Listeners[0].Bind
+	err = cfg.Validate()
+	if err == nil {
+		t.Fatal("expected unknown backend and duplicate bind to fail")
+	}
+	for _, problem := range []string{"unknown backend", "already used"} {
+		if !strings.Contains(err.Error(), problem) {
+			t.Errorf("expected %q to be reported in:\n%s", problem, err)
+		}
+	}
+}
+
+func TestLoadJWT(t *testing.T) {
+	path := writeConfig(t, "proxy.yaml", `
+auth:
+  method: jwt
+  jwt:
+    issuers:
+      - issuer: https://keycloak.local/realms/graph
+      - issuer: https://okta.local
+        jwks_url: https://okta.local/v1/keys
+        jwks_file: /etc/keys.json
+    audiences: [graph]
+    required_claims:
+      - claim: realm_access.roles
+        values: [memgraph]
+      - claim: scp
+`)
+	cfg, err := Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	jwt := cfg.Auth.JWT
+	if len(jwt.Issuers) != 2 || jwt.Issuers[1].JWKSURL != "https://okta.local/v1/keys" {
+		t.Fatalf("unexpected issuers: %#v", jwt.Issuers)
+	}
+	if jwt.JWKSRefresh.Duration != DEFAULT_JWKS_REFRESH {
+		t.Fatalf("expected default jwks refresh, got %v", jwt.JWKSRefresh)
+	}
+
+	err = cfg.Validate()
+	if err == nil {
+		t.Fatal("expected validation to fail")
+	}
+	for _, problem := range []string{
+		"auth.jwt.issuers[1]: only one of jwks_url, jwks_file and public_key_files",
+		"auth.jwt.issuers[1].jwks_file",
+		"auth.jwt.required_claims[1]: claim and values must be set",
+	} {
+		if !strings.Contains(err.Error(), problem) {
+			t.Errorf("expected %q to be reported in:\n%s", problem, err)
+		}
+	}
+
+	cfg = Default()
+	cfg.ApplyEnv(func(key string) (string, bool) {
+		value, found := map[string]string{
+			"AUTH_METHOD":   "JWT_AUTH",
+			"JWT_ISSUER":    "https://okta.local",
+			"JWT_AUDIENCES": "graph, api://graph",
+		}[key]
+		return value, found
+	})
+	if cfg.Auth.Method != AUTH_JWT || len(cfg.Auth.JWT.Audiences) != 2 || cfg.Auth.JWT.Issuers[0].Issuer != "https://okta.local" {
+		t.Fatalf("unexpected jwt config from env: %#v", cfg.Auth)
+	}
+	if err = cfg.Validate(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestBackendCredentials(t *testing.T) {
+	path := writeConfig(t, "proxy.toml", `
+[[listeners]]
+name = "internal"
+bind = "localhost:7687"
+
+[[listeners]]
+name = "sso"
+bind = "localhost:7688"
+[listeners.auth]
+method = "jwt"
+[listeners.auth.jwt]
+issuers = [{ issuer = "https://idp.local" }]
+audiences = ["graph"]
+[listeners.auth.backend_credentials]
+mode = "map"
+[[listeners.auth.backend_credentials.rules]]
+claim = "groups"
+values = ["analysts"]
+user = "analyst"
+password = "secret"
+[[listeners.auth.backend_credentials.rules]]
+principal = "admin"
+claim = "groups"
+user = "admin"
+
+[auth.backend_credentials]
+mode = "service"
+service = { user = "proxy" }
+`)
+	cfg, err := Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rules := cfg.Listeners[1].Auth.BackendCredentials.Rules
+	if len(rules) != 2 || rules[0].User != "analyst" || rules[0].Password != "secret" {
+		t.Fatalf("unexpected rules: %#v", rules)
+	}
+
+	err = cfg.Validate()
+	if err == nil {
+		t.Fatal("expected validation to fail")
+	}
+	for _, problem := range []string{
+		"listeners[0]: backend_credentials mode service needs the proxy to authenticate clients",
+		"listeners[1].auth.backend_credentials.rules[1]: exactly one of principal and claim",
+	} {
+		if !strings.Contains(err.Error(), problem) {
+			t.Errorf("expected %q to be reported in:\n%s", problem, err)
+		}
+	}
+}
+
+func TestLoadLDAP(t *testing.T) {
+	path := writeConfig(t, "proxy.yaml", `
+listeners:
+  - name: directory
+    bind: localhost:7687
+    auth:
+      method: ldap
+      ldap:
+        url: ldap://ldap.local
+        start_tls: true
+        bind_dn: cn=proxy,dc=example,dc=com
+        bind_password: secret
+        base_dn: ou=people,dc=example,dc=com
+        group_base_dn: ou=groups,dc=example,dc=com
+  - name: broken
+    bind: localhost:7688
+    auth:
+      method: ldap
+      ldap:
+        url: ldaps://ldap.local
+        start_tls: true
+        user_dn: uid=someone,dc=example,dc=com
+        bind_dn: cn=proxy,dc=example,dc=com
+`)
+	cfg, err := Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ldap := cfg.Listeners[0].Auth.LDAP
+	if ldap.UserFilter != DEFAULT_LDAP_USER_FILTER || ldap.GroupFilter != DEFAULT_LDAP_GROUP_FILTER ||
+		ldap.GroupAttribute != DEFAULT_LDAP_GROUP_ATTRIBUTE || ldap.Timeout.Duration != DEFAULT_AUTH_TIMEOUT {
+		t.Fatalf("expected ldap defaults, got %#v", ldap)
+	}
+
+	err = cfg.Validate()
+	if err == nil {
+		t.Fatal("expected validation to fail")
+	}
+	for _, problem := range []string{
+		"listeners[1].auth.ldap.start_tls can't be used with an ldaps:// url",
+		"listeners[1].auth.ldap.user_dn must contain {username}",
+		"listeners[1].auth.ldap: bind_dn and bind_password must be set together",
+	} {
+		if !strings.Contains(err.Error(), problem) {
+			t.Errorf("expected %q to be reported in:\n%s", problem, err)
+		}
+	}
+	if strings.Contains(err.Error(), "listeners[0]") {
+		t.Errorf("expected listeners[0] to be valid:\n%s", err)
+	}
+}
+
+func TestBasicAuthWebhook(t *testing.T) {
+	path := writeConfig(t, "proxy.yaml", `
+listeners:
+  - name: legacy
+    bind: localhost:7687
+    auth:
+      method: basic
+      basic:
+        url: https://auth.local/check
+  - name: webhook
+    bind: localhost:7688
+    auth:
+      method: basic
+      basic:
+        url: https://auth.local/bolt
+        method: POST
+        headers:
+          X-Api-Key: secret
+        retries: 2
+        retry_delay: 1s
+  - name: broken
+    bind: localhost:7689
+    auth:
+      method: basic
+      basic:
+        url: https://auth.local/bolt
+        method: DELETE
+        retries: -1
+`)
+	cfg, err := Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	legacy := cfg.Listeners[0].Auth.Basic
+	if legacy.Method != "GET" || legacy.RetryDelay.Duration != DEFAULT_AUTH_RETRY_DELAY || legacy.Retries != 0 {
+		t.Fatalf("expected basic auth defaults, got %#v", legacy)
+	}
+	webhook := cfg.Listeners[1].Auth.Basic
+	if webhook.Method != "POST" || webhook.Headers["X-Api-Key"] != "secret" ||
+		webhook.Retries != 2 || webhook.RetryDelay.Duration != time.Second {
+		t.Fatalf("unexpected webhook config %#v", webhook)
+	}
+
+	err = cfg.Validate()
+	if err == nil {
+		t.Fatal("expected validation to fail")
+	}
+	for _, problem := range []string{
+		`listeners[2].auth.basic.method: must be GET, POST or PUT, got "DELETE"`,
+		"listeners[2].auth.basic: retries and retry_delay must not be negative",
+	} {
+		if !strings.Contains(err.Error(), problem) {
+			t.Errorf("expected %q to be reported in:\n%s", problem, err)
+		}
+	}
+	if strings.Contains(err.Error(), "listeners[0]") || strings.Contains(err.Error(), "listeners[1]") {
+		t.Errorf("expected listeners[0] and listeners[1] to be valid:\n%s", err)
+	}
+}
+
+func TestAuthLockout(t *testing.T) {
+	path := writeConfig(t, "proxy.yaml", `
+auth:
+  lockout:
+    max_failures: 5
+    max_address_failures: 50
+    max_delay: 4s
+listeners:
+  - name: default
+    bind: localhost:7687
+  - name: broken
+    bind: localhost:7688
+    auth:
+      lockout:
+        max_failures: 3
+        duration: 10m
+        max_duration: 5m
+`)
+	cfg, err := Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lockout := cfg.Auth.Lockout
+	if !lockout.Enabled() || lockout.Window.Duration != DEFAULT_LOCKOUT_WINDOW ||
+		lockout.Delay.Duration != DEFAULT_LOCKOUT_DELAY || lockout.MaxDelay.Duration != 4*time.Second {
+		t.Fatalf("unexpected lockout config %#v", lockout)
+	}
+	if Default().Auth.Lockout.Enabled() {
+		t.Fatal("expected lockout to be disabled by default")
+	}
+
+	err = cfg.Validate()
+	if err == nil || !strings.Contains(err.Error(), "listeners[1].auth.lockout.max_duration must be at least duration") {
+		t.Fatalf("expected short max_duration to be reported, got %v", err)
+	}
+	if strings.Contains(err.Error(), "listeners[0]") || strings.Contains(err.Error(), "  - auth.") {
+		t.Errorf("expected the top-level lockout to be valid:\n%s", err)
+	}
+}
+
+func TestRateLimits(t *testing.T) {
+	path := writeConfig(t, "proxy.yaml", `
+auth:
+  rate_limits:
+    connections:
+      rate: 5
+      burst: 20
+    queries:
+      rate: 100
+  authorization:
+    roles:
+      - name: batch
+        access: write
+        rate_limit:
+          rate: 2.5
+      - name: admin
+        access: admin
+        rate_limit:
+          rate: 0
+      - name: reader
+        access: read
+listeners:
+  - name: default
+    bind: localhost:7687
+  - name: broken
+    bind: localhost:7688
+    auth:
+      rate_limits:
+        connections:
+          rate: -1
+`)
+	cfg, err := Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	limits := cfg.Auth.RateLimits
+	if limits.Connections != (RateLimit{Rate: 5, Burst: 20}) || limits.Queries != (RateLimit{Rate: 100}) {
+		t.Fatalf("unexpected rate limits %#v", limits)
+	}
+	roles := cfg.Auth.Authorization.Roles
+	if *roles[0].RateLimit != (RateLimit{Rate: 2.5}) || roles[1].RateLimit == nil || roles[1].RateLimit.Enabled() || roles[2].RateLimit != nil {
+		t.Fatalf("unexpected role rate limits %#v", roles)
+	}
+	if !cfg.Auth.RateLimited() || Default().Auth.RateLimited() {
+		t.Fatal("expected rate limits to be disabled by default only")
+	}
+
+	err = cfg.Validate()
+	if err == nil || !strings.Contains(err.Error(), "listeners[1].auth.rate_limits.connections: rate and burst must not be negative") {
+		t.Fatalf("expected the negative rate to be reported, got %v", err)
+	}
+	if strings.Contains(err.Error(), "listeners[0]") || strings.Contains(err.Error(), "  - auth.") {
+		t.Errorf("expected the top-level rate limits to be valid:\n%s", err)
+	}
+}
+
+func TestAuthorization(t *testing.T) {
+	path := writeConfig(t, "proxy.yaml", `
+auth:
+  authorization:
+    roles:
+      - name: analyst
+        access: read
+        procedures: ["nxalg.*", "mg.procedures"]
+      - name: admin
+        access: admin
+    principals:
+      alice: [admin]
+    default_roles: [analyst]
+listeners:
+  - name: default
+    bind: localhost:7687
+  - name: broken
+    bind: localhost:7688
+    auth:
+      authorization:
+        roles:
+          - name: editor
+            access: readwrite
+          - name: editor
+            access: write
+            procedures: ["[mg"]
+        role_claims: [realm_access.roles]
+        principals:
+          bob: [owner]
+        policy:
+          kind: rego
+          timeout: 0s
+`)
+	cfg, err := Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	authz := cfg.Auth.Authorization
+	if !authz.Enabled() || len(authz.Roles) != 2 || authz.Roles[0].Procedures[0] != "nxalg.*" ||
+		!reflect.DeepEqual(authz.RoleClaims, []string{"roles", "groups"}) {
+		t.Fatalf("unexpected authorization config %#v", authz)
+	}
+	if claims := cfg.Listeners[1].Auth.Authorization.RoleClaims; !reflect.DeepEqual(claims, []string{"realm_access.roles"}) {
+		t.Fatalf("expected the listener's role claims to be kept, got %v", claims)
+	}
+	if Default().Auth.Authorization.Enabled() || Default().Auth.Authorization.Policy.Enabled() {
+		t.Fatal("expected authorization to be disabled by default")
+	}
+	if timeout := cfg.Listeners[1].Auth.Authorization.Policy.Timeout.Duration; timeout != DEFAULT_POLICY_TIMEOUT {
+		t.Fatalf("expected the default policy timeout, got %v", timeout)
+	}
+
+	err = cfg.Validate()
+	if err == nil {
+		t.Fatal("expected invalid roles to be reported")
+	}
+	for _, problem := range []string{
+		`listeners[1].auth.authorization.roles[0].access: must be read, write or admin, got "readwrite"`,
+		`listeners[1].auth.authorization.roles[1]: duplicate role name "editor"`,
+		`listeners[1].auth.authorization.roles[1].procedures: "[mg"`,
+		`listeners[1].auth.authorization.principals.bob: unknown role "owner"`,
+		`listeners[1].auth.authorization.policy.modules must be set when using a rego policy`,
+		`listeners[1].auth.authorization.policy.decision: expected a decision like data.memgraph.admission, got ""`,
+	} {
+		if !strings.Contains(err.Error(), problem) {
+			t.Errorf("expected %q to be reported:\n%s", problem, err)
+		}
+	}
+	if strings.Contains(err.Error(), "listeners[0]") || strings.Contains(err.Error(), "  - auth.") {
+		t.Errorf("expected the top-level authorization to be valid:\n%s", err)
+	}
+}
+
+func TestHtpasswdFromEnv(t *testing.T) {
+	cfg := Default()
+	cfg.ApplyEnv(func(key string) (string, bool) {
+		value, found := map[string]string{
+			"AUTH_METHOD":   "HTPASSWD_AUTH",
+			"HTPASSWD_FILE": "/nonexistent/users",
+		}[key]
+		return value, found
+	})
+	if cfg.Auth.Method != AUTH_HTPASSWD || cfg.Auth.Htpasswd.File != "/nonexistent/users" {
+		t.Fatalf("unexpected htpasswd config from env: %#v", cfg.Auth)
+	}
+	err := cfg.Validate()
+	if err == nil || !strings.Contains(err.Error(), "auth.htpasswd.file: stat /nonexistent/users") {
+		t.Fatalf("expected missing htpasswd file to be reported, got %v", err)
+	}
+}
+
+func TestAuthChain(t *testing.T) {
+	path := writeConfig(t, "proxy.yaml", `
+listeners:
+  - name: public
+    bind: localhost:7687
+    auth:
+      method: chain
+      chain:
+        - method: jwt
+          control: sufficient
+        - method: ldap
+          control: sufficient
+      jwt:
+        issuers: [{issuer: https://idp.local}]
+        audiences: [graph]
+      ldap:
+        url: ldaps://ldap.local
+        user_dn: uid={username},dc=example,dc=com
+  - name: mtls
+    bind: localhost:7688
+    auth:
+      method: chain
+      chain:
+        - method: cert
+          control: required
+        - method: basic
+          control: maybe
+        - method: kerberos
+          control: optional
+`)
+	cfg, err := Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !cfg.ListenerAuth(cfg.Listeners[0]).Uses(AUTH_LDAP) || cfg.ListenerAuth(cfg.Listeners[0]).Uses(AUTH_BASIC) {
+		t.Fatalf("unexpected chain methods: %#v", cfg.Listeners[0].Auth.Chain)
+	}
+
+	err = cfg.Validate()
+	if err == nil {
+		t.Fatal("expected validation to fail")
+	}
+	for _, problem := range []string{
+		"listeners[1]: cert auth needs tls.client_auth",
+		"listeners[1].auth.basic.url must be set",
+		"listeners[1].auth.chain[1].control: must be sufficient, required or optional",
+		`listeners[1].auth.chain[2].method: unknown method "kerberos"`,
+	} {
+		if !strings.Contains(err.Error(), problem) {
+			t.Errorf("expected %q to be reported in:\n%s", problem, err)
+		}
+	}
+	if strings.Contains(err.Error(), "listeners[0]") {
+		t.Errorf("expected listeners[0] to be valid:\n%s", err)
+	}
+}
+
+func TestAuthCache(t *testing.T) {
+	path := writeConfig(t, "proxy.toml", `
+[[listeners]]
+name = "cached"
+bind = "localhost:7687"
+[listeners.auth]
+method = "basic"
+basic = { url = "http://auth.local" }
+cache = { ttl = "5m", negative_ttl = "5s" }
+
+[auth]
+method = "basic"
+basic = { url = "http://auth.local" }
+cache = { ttl = "-1s", max_entries = -1 }
+`)
+	cfg, err := Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cache := cfg.Listeners[0].Auth.Cache
+	if !cache.Enabled() || cache.TTL.Duration != 5*time.Minute || cache.MaxEntries != DEFAULT_AUTH_CACHE_SIZE {
+		t.Fatalf("unexpected cache config %#v", cache)
+	}
+	if Default().Auth.Cache.Enabled() {
+		t.Fatal("expected the cache to be disabled by default")
+	}
+
+	err = cfg.Validate()
+	if err == nil {
+		t.Fatal("expected validation to fail")
+	}
+	for _, problem := range []string{
+		"auth.cache: ttl and negative_ttl must not be negative",
+	} {
+		if !strings.Contains(err.Error(), problem) {
+			t.Errorf("expected %q to be reported in:\n%s", problem, err)
+		}
+	}
+	if strings.Contains(err.Error(), "listeners[0]") {
+		t.Errorf("expected listeners[0] to be valid:\n%s", err)
+	}
+}
@@ -0,0 +1,106 @@
+/*
+Copyright (c) 2021 Memgraph Ltd. [https://memgraph.com]
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package config
+
+import "strings"
+
+// Signature of os.LookupEnv, swappable for testing
+type LookupFunc func(key string) (string, bool)
+
+// Override configuration values with the ones set in the environment.
+//
+// The variable names are the ones bolt-proxy has always used, so existing
+// deployments keep working with or without a config file. Listener and
+// backend variables apply to the first listener and backend.
+func (c *Config) ApplyEnv(lookup LookupFunc) {
+	setString := func(key string, target *string) {
+		if value, found := lookup(key); found {
+			*target = value
+		}
+	}
+
+	setString("BOLT_PROXY_BIND", &c.Listeners[0].Bind)
+	setString("BOLT_PROXY_CERT", &c.Listeners[0].TLS.CertFile)
+	setString("BOLT_PROXY_KEY", &c.Listeners[0].TLS.KeyFile)
+	setString("BOLT_PROXY_URI", &c.Backends[0].URI)
+	setString("BOLT_PROXY_USER", &c.Backends[0].User)
+	setString("BOLT_PROXY_PASSWORD", &c.Backends[0].Password)
+	if _, found := lookup("BOLT_PROXY_DEBUG"); found {
+		c.Logging.Debug = true
+	}
+
+	if method, found := lookup("AUTH_METHOD"); found {
+		c.Auth.Method = authMethodFromEnv(method)
+	}
+	if method, found := lookup("BEARER_AUTH_METHOD"); found {
+		c.Auth.BearerMethod = authMethodFromEnv(method)
+	}
+	setString("BASIC_AUTH_URL", &c.Auth.Basic.URL)
+	setString("BASIC_AUTH_METHOD", &c.Auth.Basic.Method)
+	setString("AAD_TOKEN_CLIENT_ID", &c.Auth.AADToken.ClientID)
+	setString("AAD_TOKEN_PROVIDER", &c.Auth.AADToken.Provider)
+
+	if issuer, found := lookup("JWT_ISSUER"); found {
+		c.Auth.JWT.Issuers = []JWTIssuer{{Issuer: issuer}}
+	}
+	if len(c.Auth.JWT.Issuers) > 0 {
+		setString("JWT_JWKS_URL", &c.Auth.JWT.Issuers[0].JWKSURL)
+	}
+	if audiences, found := lookup("JWT_AUDIENCES"); found {
+		c.Auth.JWT.Audiences = splitList(audiences)
+	}
+
+	setString("LDAP_URL", &c.Auth.LDAP.URL)
+	setString("LDAP_USER_DN", &c.Auth.LDAP.UserDN)
+	setString("LDAP_BASE_DN", &c.Auth.LDAP.BaseDN)
+	setString("LDAP_BIND_DN", &c.Auth.LDAP.BindDN)
+	setString("LDAP_BIND_PASSWORD", &c.Auth.LDAP.BindPassword)
+	setString("HTPASSWD_FILE", &c.Auth.Htpasswd.File)
+}
+
+// Split a comma separated list, dropping empty elements.
+func splitList(value string) []string {
+	var list []string
+	for _, element := range strings.Split(value, ",") {
+		element = strings.TrimSpace(element)
+		if element != "" {
+			list = append(list, element)
+		}
+	}
+	return list
+}
+
+// AUTH_METHOD historically uses BASIC_AUTH and AAD_TOKEN_AUTH, map them
+// to the names used in config files.
+func authMethodFromEnv(method string) string {
+	switch method {
+	case "":
+		return AUTH_NONE
+	case "BASIC_AUTH":
+		return AUTH_BASIC
+	case "AAD_TOKEN_AUTH":
+		return AUTH_AAD_TOKEN
+	case "JWT_AUTH":
+		return AUTH_JWT
+	case "LDAP_AUTH":
+		return AUTH_LDAP
+	case "HTPASSWD_AUTH":
+		return AUTH_HTPASSWD
+	default:
+		return strings.ToLower(method)
+	}
+}
@@ -0,0 +1,84 @@
+/*
+Copyright (c) 2021 Memgraph Ltd. [https://memgraph.com]
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package config
+
+import (
+	"crypto/tls"
+	"fmt"
+)
+
+// Minimum TLS version of listeners that don't configure one
+const DEFAULT_MIN_TLS_VERSION string = "1.2"
+
+// Map a "1.2" style version string to its crypto/tls constant. An empty
+// string maps to 0, which lets crypto/tls pick its default.
+func TLSVersion(version string) (uint16, error) {
+	switch version {
+	case "":
+		return 0, nil
+	case "1.0":
+		return tls.VersionTLS10, nil
+	case "1.1":
+		return tls.VersionTLS11, nil
+	case "1.2":
+		return tls.VersionTLS12, nil
+	case "1.3":
+		return tls.VersionTLS13, nil
+	default:
+		return 0, fmt.Errorf("unknown tls version %q", version)
+	}
+}
+
+// The "1.2" style name of a crypto/tls version constant.
+func TLSVersionName(version uint16) string {
+	switch version {
+	case tls.VersionTLS10:
+		return "1.0"
+	case tls.VersionTLS11:
+		return "1.1"
+	case tls.VersionTLS12:
+		return "1.2"
+	case tls.VersionTLS13:
+		return "1.3"
+	default:
+		return fmt.Sprintf("0x%04x", version)
+	}
+}
+
+// Map cipher suite names to their crypto/tls IDs. Only the suites crypto/tls
+// considers secure are accepted. An empty list maps to nil, which lets
+// crypto/tls pick its defaults.
+func CipherSuites(names []string) ([]uint16, error) {
+	if len(names) == 0 {
+		return nil, nil
+	}
+
+	known := make(map[string]uint16)
+	for _, suite := range tls.CipherSuites() {
+		known[suite.Name] = suite.ID
+	}
+
+	ids := make([]uint16, len(names))
+	for i, name := range names {
+		id, found := known[name]
+		if !found {
+			return nil, fmt.Errorf("unknown or insecure cipher suite %q", name)
+		}
+		ids[i] = id
+	}
+	return ids, nil
+}
@@ -0,0 +1,620 @@
+/*
+Copyright (c) 2021 Memgraph Ltd. [https://memgraph.com]
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package config
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// All the problems found while validating a Config, so they can be fixed
+// in one go instead of one per restart.
+type ValidationError struct {
+	Problems []string
+}
+
+func (e *ValidationError) Error() string {
+	return fmt.Sprintf("invalid configuration:\n  - %s",
+		strings.Join(e.Problems, "\n  - "))
+}
+
+func (e *ValidationError) add(format string, args ...interface{}) {
+	e.Problems = append(e.Problems, fmt.Sprintf(format, args...))
+}
+
+// Check the configuration for missing or inconsistent values, returning
+// a *ValidationError describing every problem found.
+func (c *Config) Validate() error {
+	v := &ValidationError{}
+
+	if len(c.Listeners) == 0 {
+		v.add("at least one listener must be configured")
+	}
+	if len(c.Backends) == 0 {
+		v.add("at least one backend must be configured")
+	}
+
+	backendNames := make(map[string]bool, len(c.Backends))
+	for i := range c.Backends {
+		b := &c.Backends[i]
+		prefix := fmt.Sprintf("backends[%d]", i)
+		if backendNames[b.Name] {
+			v.add("%s: duplicate backend name %q", prefix, b.Name)
+		}
+		backendNames[b.Name] = true
+		b.validate(v, prefix)
+	}
+
+	listenerNames := make(map[string]bool, len(c.Listeners))
+	binds := make(map[string]bool, len(c.Listeners))
+	for i := range c.Listeners {
+		l := &c.Listeners[i]
+		prefix := fmt.Sprintf("listeners[%d]", i)
+		if listenerNames[l.Name] {
+			v.add("%s: duplicate listener name %q", prefix, l.Name)
+		}
+		listenerNames[l.Name] = true
+		if binds[l.Bind] {
+			v.add("%s: %s is already used by another listener", prefix, l.Bind)
+		}
+		binds[l.Bind] = true
+		if !backendNames[l.Backend] {
+			v.add("%s.backend: unknown backend %q", prefix, l.Backend)
+		}
+		l.validate(v, prefix)
+
+		// mapping an unverified principal would hand out Memgraph accounts
+		auth := c.ListenerAuth(*l)
+		mapped := auth.BackendCredentials.Mode != CREDENTIALS_PASSTHROUGH && auth.BackendCredentials.Mode != ""
+		unauthenticated := (auth.Method == AUTH_NONE || auth.Method == "") && auth.BearerMethod == "" &&
+			(l.TLS.CertAuth == CERT_AUTH_IGNORE || l.TLS.CertAuth == "")
+		if mapped && unauthenticated {
+			v.add("%s: backend_credentials mode %s needs the proxy to authenticate clients", prefix, auth.BackendCredentials.Mode)
+		}
+		verifying := l.TLS.ClientAuth == CLIENT_AUTH_VERIFY_IF_GIVEN || l.TLS.ClientAuth == CLIENT_AUTH_REQUIRE_AND_VERIFY
+		if auth.Uses(AUTH_CERT) && !verifying {
+			v.add("%s: %s auth needs tls.client_auth %s or %s", prefix, AUTH_CERT,
+				CLIENT_AUTH_VERIFY_IF_GIVEN, CLIENT_AUTH_REQUIRE_AND_VERIFY)
+		}
+	}
+
+	c.Auth.validate(v, "auth")
+
+	if c.Admin.Bind != "" {
+		if _, _, err := net.SplitHostPort(c.Admin.Bind); err != nil {
+			v.add("admin.bind: %v", err)
+		}
+		if binds[c.Admin.Bind] {
+			v.add("admin.bind: %s is already used by a listener", c.Admin.Bind)
+		}
+	}
+
+	c.Audit.validate(v)
+	c.Redaction.validate(v)
+
+	if c.Pool.MaxSize < 0 {
+		v.add("pool.max_size must not be negative")
+	}
+	if c.Pool.AcquisitionTimeout.Duration < 0 {
+		v.add("pool.acquisition_timeout must not be negative")
+	}
+
+	timeouts := []struct {
+		name  string
+		value Duration
+	}{
+		{"hello", c.Timeouts.Hello},
+		{"idle", c.Timeouts.Idle},
+		{"dial", c.Timeouts.Dial},
+		{"halt", c.Timeouts.Halt},
+	}
+	for _, timeout := range timeouts {
+		if timeout.value.Duration <= 0 {
+			v.add("timeouts.%s must be positive", timeout.name)
+		}
+	}
+
+	if len(v.Problems) > 0 {
+		return v
+	}
+	return nil
+}
+
+func (a *Audit) validate(v *ValidationError) {
+	switch a.Sink {
+	case "":
+	case AUDIT_SINK_FILE:
+		if a.File.Path == "" {
+			v.add("audit.file.path is required with sink %s", AUDIT_SINK_FILE)
+		}
+		if a.File.MaxSizeMB < 0 {
+			v.add("audit.file.max_size_mb must not be negative")
+		}
+		if a.File.MaxFiles < 0 {
+			v.add("audit.file.max_files must not be negative")
+		}
+	case AUDIT_SINK_SYSLOG:
+		if (a.Syslog.Network == "") != (a.Syslog.Address == "") {
+			v.add("audit.syslog: network and address must be set together")
+		}
+	default:
+		v.add("audit.sink: unknown sink %q, expected %s or %s", a.Sink, AUDIT_SINK_FILE, AUDIT_SINK_SYSLOG)
+	}
+	switch a.Parameters {
+	case AUDIT_PARAMETERS_REDACT, AUDIT_PARAMETERS_HASH, AUDIT_PARAMETERS_VALUES:
+	default:
+		v.add("audit.parameters: unknown value %q, expected %s, %s or %s", a.Parameters,
+			AUDIT_PARAMETERS_REDACT, AUDIT_PARAMETERS_HASH, AUDIT_PARAMETERS_VALUES)
+	}
+}
+
+func (r *Redaction) validate(v *ValidationError) {
+	for i, name := range r.Parameters {
+		if _, err := path.Match(name, ""); err != nil {
+			v.add("redaction.parameters[%d]: %v", i, err)
+		}
+	}
+	for i, pattern := range r.Patterns {
+		switch pattern {
+		case REDACT_PATTERN_EMAIL, REDACT_PATTERN_JWT, REDACT_PATTERN_BEARER:
+			continue
+		}
+		if _, err := regexp.Compile(pattern); err != nil {
+			v.add("redaction.patterns[%d]: %v", i, err)
+		}
+	}
+	switch r.Action {
+	case REDACT_ACTION_MASK:
+	case REDACT_ACTION_HASH:
+		if r.HashKey == "" {
+			v.add("redaction.hash_key must be set when hashing values")
+		}
+	default:
+		v.add("redaction.action: unknown value %q, expected %s or %s", r.Action,
+			REDACT_ACTION_MASK, REDACT_ACTION_HASH)
+	}
+	if r.MaxLength < 0 {
+		v.add("redaction.max_length must not be negative")
+	}
+}
+
+func (l *Listener) validate(v *ValidationError, prefix string) {
+	if _, _, err := net.SplitHostPort(l.Bind); err != nil {
+		v.add("%s.bind: %v", prefix, err)
+	}
+
+	if (l.TLS.CertFile == "") != (l.TLS.KeyFile == "") {
+		v.add("%s.tls: cert_file and key_file must be set together", prefix)
+	}
+	checkFile(v, prefix+".tls.cert_file", l.TLS.CertFile)
+	checkFile(v, prefix+".tls.key_file", l.TLS.KeyFile)
+	checkFile(v, prefix+".tls.ocsp_staple_file", l.TLS.OCSPStapleFile)
+	if _, err := TLSVersion(l.TLS.MinVersion); err != nil {
+		v.add("%s.tls.min_version: %v", prefix, err)
+	}
+	if _, err := CipherSuites(l.TLS.CipherSuites); err != nil {
+		v.add("%s.tls.cipher_suites: %v", prefix, err)
+	}
+	l.TLS.validateClientAuth(v, prefix+".tls")
+
+	if len(l.Transports) == 0 {
+		v.add("%s.transports: at least one transport must be allowed", prefix)
+	}
+	for _, transport := range l.Transports {
+		switch transport {
+		case TRANSPORT_BOLT, TRANSPORT_WEBSOCKET:
+		default:
+			v.add("%s.transports: unknown transport %q", prefix, transport)
+		}
+	}
+
+	if l.Auth != nil {
+		l.Auth.validate(v, prefix+".auth")
+	}
+}
+
+func (t *TLS) validateClientAuth(v *ValidationError, prefix string) {
+	verifying := false
+	switch t.ClientAuth {
+	case CLIENT_AUTH_NONE, "":
+	case CLIENT_AUTH_REQUEST, CLIENT_AUTH_REQUIRE:
+	case CLIENT_AUTH_VERIFY_IF_GIVEN, CLIENT_AUTH_REQUIRE_AND_VERIFY:
+		verifying = true
+		if t.ClientCAFile == "" {
+			v.add("%s.client_ca_file must be set when client_auth is %s", prefix, t.ClientAuth)
+		}
+	default:
+		v.add("%s.client_auth: unknown value %q", prefix, t.ClientAuth)
+	}
+	if t.ClientAuth != CLIENT_AUTH_NONE && t.ClientAuth != "" && !t.Enabled() {
+		v.add("%s.client_auth requires cert_file and key_file", prefix)
+	}
+	checkFile(v, prefix+".client_ca_file", t.ClientCAFile)
+
+	switch t.PrincipalFrom {
+	case PRINCIPAL_SUBJECT_CN, PRINCIPAL_SUBJECT, PRINCIPAL_SAN_DNS, PRINCIPAL_SAN_EMAIL, PRINCIPAL_SAN_URI, "":
+	default:
+		v.add("%s.principal_from: unknown value %q", prefix, t.PrincipalFrom)
+	}
+
+	switch t.CertAuth {
+	case CERT_AUTH_IGNORE, "":
+	case CERT_AUTH_SUFFICIENT, CERT_AUTH_REQUIRED:
+		if !verifying {
+			v.add("%s.cert_auth %s needs client_auth %s or %s", prefix, t.CertAuth,
+				CLIENT_AUTH_VERIFY_IF_GIVEN, CLIENT_AUTH_REQUIRE_AND_VERIFY)
+		}
+	default:
+		v.add("%s.cert_auth: unknown value %q", prefix, t.CertAuth)
+	}
+}
+
+func (b *Backend) validate(v *ValidationError, prefix string) {
+	u, err := url.Parse(b.URI)
+	if err != nil {
+		v.add("%s.uri: %v", prefix, err)
+		return
+	}
+	switch u.Scheme {
+	case "bolt", "bolt+s", "bolt+ssc", "neo4j", "neo4j+s", "neo4j+ssc":
+	default:
+		v.add("%s.uri: invalid bolt connection scheme %q", prefix, u.Scheme)
+	}
+
+	for _, host := range b.Hosts {
+		if _, _, err := net.SplitHostPort(host); err != nil {
+			v.add("%s.hosts: %v", prefix, err)
+		}
+	}
+	for _, replica := range b.Replicas {
+		if _, _, err := net.SplitHostPort(replica); err != nil {
+			v.add("%s.replicas: %v", prefix, err)
+		}
+	}
+
+	if useTLS, _ := SchemeTLS(u.Scheme); !useTLS && b.TLS.IsSet() {
+		v.add("%s.tls is only used with bolt+s, bolt+ssc, neo4j+s or neo4j+ssc uris", prefix)
+	}
+	if (b.TLS.CertFile == "") != (b.TLS.KeyFile == "") {
+		v.add("%s.tls: cert_file and key_file must be set together", prefix)
+	}
+	checkFile(v, prefix+".tls.ca_file", b.TLS.CAFile)
+	checkFile(v, prefix+".tls.cert_file", b.TLS.CertFile)
+	checkFile(v, prefix+".tls.key_file", b.TLS.KeyFile)
+	if _, err := TLSVersion(b.TLS.MinVersion); err != nil {
+		v.add("%s.tls.min_version: %v", prefix, err)
+	}
+}
+
+func (a *Auth) validate(v *ValidationError, prefix string) {
+	a.validateMethod(v, prefix, a.Method)
+	a.BackendCredentials.validate(v, prefix+".backend_credentials")
+	if a.Cache.TTL.Duration < 0 || a.Cache.NegativeTTL.Duration < 0 {
+		v.add("%s.cache: ttl and negative_ttl must not be negative", prefix)
+	}
+	if a.Cache.Enabled() && a.Cache.MaxEntries <= 0 {
+		v.add("%s.cache.max_entries must be positive", prefix)
+	}
+	a.Lockout.validate(v, prefix+".lockout")
+	a.Authorization.validate(v, prefix+".authorization")
+	a.RateLimits.Connections.validate(v, prefix+".rate_limits.connections")
+	a.RateLimits.Queries.validate(v, prefix+".rate_limits.queries")
+
+	switch a.BearerMethod {
+	case "", a.Method:
+	case AUTH_JWT, AUTH_AAD_TOKEN:
+		a.validateMethod(v, prefix, a.BearerMethod)
+	default:
+		v.add("%s.bearer_method: must be %s or %s, got %q", prefix, AUTH_JWT, AUTH_AAD_TOKEN, a.BearerMethod)
+	}
+}
+
+func (l AuthLockout) validate(v *ValidationError, prefix string) {
+	if l.MaxFailures < 0 || l.MaxAddressFailures < 0 {
+		v.add("%s: max_failures and max_address_failures must not be negative", prefix)
+	}
+	if !l.Enabled() {
+		return
+	}
+	if l.Window.Duration <= 0 || l.Duration.Duration <= 0 || l.Delay.Duration < 0 {
+		v.add("%s: window and duration must be positive, delay must not be negative", prefix)
+	}
+	if l.MaxDuration.Duration < l.Duration.Duration {
+		v.add("%s.max_duration must be at least duration", prefix)
+	}
+	if l.MaxDelay.Duration < l.Delay.Duration {
+		v.add("%s.max_delay must be at least delay", prefix)
+	}
+}
+
+func (r RateLimit) validate(v *ValidationError, prefix string) {
+	if r.Rate < 0 || r.Burst < 0 {
+		v.add("%s: rate and burst must not be negative", prefix)
+	}
+}
+
+func (a Authorization) validate(v *ValidationError, prefix string) {
+	roles := make(map[string]bool, len(a.Roles))
+	for i, role := range a.Roles {
+		p := fmt.Sprintf("%s.roles[%d]", prefix, i)
+		if role.Name == "" {
+			v.add("%s.name must be set", p)
+		} else if roles[role.Name] {
+			v.add("%s: duplicate role name %q", p, role.Name)
+		}
+		roles[role.Name] = true
+		switch role.Access {
+		case ACCESS_READ, ACCESS_WRITE, ACCESS_ADMIN:
+		default:
+			v.add("%s.access: must be %s, %s or %s, got %q", p, ACCESS_READ, ACCESS_WRITE, ACCESS_ADMIN, role.Access)
+		}
+		for _, pattern := range role.Procedures {
+			if _, err := path.Match(pattern, ""); err != nil {
+				v.add("%s.procedures: %q: %v", p, pattern, err)
+			}
+		}
+		if role.RateLimit != nil {
+			role.RateLimit.validate(v, p+".rate_limit")
+		}
+	}
+
+	switch a.Policy.Kind {
+	case "":
+	case POLICY_REGO:
+		if len(a.Policy.Modules) == 0 {
+			v.add("%s.policy.modules must be set when using a rego policy", prefix)
+		}
+		for i, module := range a.Policy.Modules {
+			checkFile(v, fmt.Sprintf("%s.policy.modules[%d]", prefix, i), module)
+		}
+		if !strings.HasPrefix(a.Policy.Decision, "data.") {
+			v.add("%s.policy.decision: expected a decision like data.memgraph.admission, got %q", prefix, a.Policy.Decision)
+		}
+	case POLICY_OPA, POLICY_WEBHOOK:
+		if a.Policy.URL == "" {
+			v.add("%s.policy.url must be set when using a policy", prefix)
+		} else if u, err := url.Parse(a.Policy.URL); err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+			v.add("%s.policy.url: expected an http or https URL, got %q", prefix, a.Policy.URL)
+		}
+	default:
+		v.add("%s.policy.kind: must be %s, %s or %s, got %q", prefix, POLICY_REGO, POLICY_OPA, POLICY_WEBHOOK, a.Policy.Kind)
+	}
+	if a.Policy.Enabled() && a.Policy.Timeout.Duration <= 0 {
+		v.add("%s.policy.timeout must be positive", prefix)
+	}
+
+	checkFile(v, prefix+".allowlist.path", a.Allowlist.Path)
+
+	for _, claim := range a.RoleClaims {
+		if claim == "" {
+			v.add("%s.role_claims must not contain empty claims", prefix)
+		}
+	}
+	for _, role := range a.DefaultRoles {
+		if !roles[role] {
+			v.add("%s.default_roles: unknown role %q", prefix, role)
+		}
+	}
+	principals := make([]string, 0, len(a.Principals))
+	for principal := range a.Principals {
+		principals = append(principals, principal)
+	}
+	sort.Strings(principals)
+	for _, principal := range principals {
+		for _, role := range a.Principals[principal] {
+			if !roles[role] {
+				v.add("%s.principals.%s: unknown role %q", prefix, principal, role)
+			}
+		}
+	}
+}
+
+func (a *Auth) validateMethod(v *ValidationError, prefix, method string) {
+	switch method {
+	case AUTH_NONE, "":
+	case AUTH_BASIC:
+		if a.Basic.URL == "" {
+			v.add("%s.basic.url must be set when using %s auth", prefix, AUTH_BASIC)
+		}
+		switch a.Basic.Method {
+		case http.MethodGet, http.MethodPost, http.MethodPut:
+		default:
+			v.add("%s.basic.method: must be GET, POST or PUT, got %q", prefix, a.Basic.Method)
+		}
+		if a.Basic.Timeout.Duration <= 0 {
+			v.add("%s.basic.timeout must be positive", prefix)
+		}
+		if a.Basic.Retries < 0 || a.Basic.RetryDelay.Duration < 0 {
+			v.add("%s.basic: retries and retry_delay must not be negative", prefix)
+		}
+	case AUTH_AAD_TOKEN:
+		if a.AADToken.ClientID == "" || a.AADToken.Provider == "" {
+			v.add("%s.aad_token.client_id and %s.aad_token.provider must be set when using %s auth",
+				prefix, prefix, AUTH_AAD_TOKEN)
+		}
+		if a.AADToken.JWKSRefresh.Duration <= 0 {
+			v.add("%s.aad_token.jwks_refresh must be positive", prefix)
+		}
+	case AUTH_JWT:
+		a.JWT.validate(v, prefix+".jwt")
+	case AUTH_LDAP:
+		a.LDAP.validate(v, prefix+".ldap")
+	case AUTH_CERT:
+	case AUTH_CHAIN:
+		a.validateChain(v, prefix)
+	case AUTH_HTPASSWD:
+		if a.Htpasswd.File == "" {
+			v.add("%s.htpasswd.file must be set when using %s auth", prefix, AUTH_HTPASSWD)
+		}
+		checkFile(v, prefix+".htpasswd.file", a.Htpasswd.File)
+	default:
+		v.add("%s.method: unknown method %q", prefix, method)
+	}
+}
+
+func (a *Auth) validateChain(v *ValidationError, prefix string) {
+	decisive := false
+	for i, step := range a.Chain {
+		p := fmt.Sprintf("%s.chain[%d]", prefix, i)
+		switch step.Method {
+		case AUTH_NONE, AUTH_CHAIN, "":
+			v.add("%s.method: %q can't be a chain step", p, step.Method)
+		case AUTH_BASIC, AUTH_AAD_TOKEN, AUTH_JWT, AUTH_LDAP, AUTH_HTPASSWD, AUTH_CERT:
+			a.validateMethod(v, prefix, step.Method)
+		default:
+			v.add("%s.method: unknown method %q", p, step.Method)
+		}
+		switch step.Control {
+		case CONTROL_SUFFICIENT, CONTROL_REQUIRED:
+			decisive = true
+		case CONTROL_OPTIONAL:
+		default:
+			v.add("%s.control: must be %s, %s or %s, got %q", p,
+				CONTROL_SUFFICIENT, CONTROL_REQUIRED, CONTROL_OPTIONAL, step.Control)
+		}
+	}
+	if !decisive {
+		v.add("%s.chain: at least one sufficient or required step must be set when using %s auth", prefix, AUTH_CHAIN)
+	}
+}
+
+func (m *CredentialMapping) validate(v *ValidationError, prefix string) {
+	switch m.Mode {
+	case CREDENTIALS_PASSTHROUGH, "":
+	case CREDENTIALS_SERVICE:
+		if m.Service.User == "" {
+			v.add("%s.service.user must be set when using %s mode", prefix, CREDENTIALS_SERVICE)
+		}
+	case CREDENTIALS_MAP:
+		if len(m.Rules) == 0 {
+			v.add("%s.rules: at least one rule must be set when using %s mode", prefix, CREDENTIALS_MAP)
+		}
+		for i, rule := range m.Rules {
+			p := fmt.Sprintf("%s.rules[%d]", prefix, i)
+			if (rule.Principal == "") == (rule.Claim == "") {
+				v.add("%s: exactly one of principal and claim must be set", p)
+			}
+			if rule.Claim != "" && len(rule.Values) == 0 {
+				v.add("%s.values must be set with claim", p)
+			}
+			if rule.User == "" {
+				v.add("%s.user must be set", p)
+			}
+		}
+	case CREDENTIALS_SSO:
+		if m.SSO.Scheme == "" {
+			v.add("%s.sso.scheme must be set when using %s mode", prefix, CREDENTIALS_SSO)
+		}
+	default:
+		v.add("%s.mode: unknown mode %q", prefix, m.Mode)
+	}
+}
+
+func (j *JWTAuth) validate(v *ValidationError, prefix string) {
+	if len(j.Issuers) == 0 {
+		v.add("%s.issuers: at least one issuer must be set when using %s auth", prefix, AUTH_JWT)
+	}
+	seen := make(map[string]bool)
+	for i, issuer := range j.Issuers {
+		p := fmt.Sprintf("%s.issuers[%d]", prefix, i)
+		if issuer.Issuer == "" {
+			v.add("%s.issuer must be set", p)
+		} else if seen[issuer.Issuer] {
+			v.add("%s.issuer: duplicate issuer %q", p, issuer.Issuer)
+		}
+		seen[issuer.Issuer] = true
+
+		sources := 0
+		if issuer.JWKSURL != "" {
+			sources++
+		}
+		if issuer.JWKSFile != "" {
+			sources++
+		}
+		if len(issuer.PublicKeyFiles) > 0 {
+			sources++
+		}
+		if sources > 1 {
+			v.add("%s: only one of jwks_url, jwks_file and public_key_files can be set", p)
+		}
+		checkFile(v, p+".jwks_file", issuer.JWKSFile)
+		for k, path := range issuer.PublicKeyFiles {
+			checkFile(v, fmt.Sprintf("%s.public_key_files[%d]", p, k), path)
+		}
+	}
+	if len(j.Audiences) == 0 {
+		v.add("%s.audiences: at least one audience must be set when using %s auth", prefix, AUTH_JWT)
+	}
+	for i, rule := range j.RequiredClaims {
+		if rule.Claim == "" || len(rule.Values) == 0 {
+			v.add("%s.required_claims[%d]: claim and values must be set", prefix, i)
+		}
+	}
+	if j.JWKSRefresh.Duration <= 0 {
+		v.add("%s.jwks_refresh must be positive", prefix)
+	}
+}
+
+func (l *LDAPAuth) validate(v *ValidationError, prefix string) {
+	u, err := url.Parse(l.URL)
+	switch {
+	case l.URL == "":
+		v.add("%s.url must be set when using %s auth", prefix, AUTH_LDAP)
+	case err != nil:
+		v.add("%s.url: %v", prefix, err)
+	case u.Scheme != "ldap" && u.Scheme != "ldaps":
+		v.add("%s.url: expected an ldap:// or ldaps:// url, got %q", prefix, l.URL)
+	case u.Scheme == "ldaps" && l.StartTLS:
+		v.add("%s.start_tls can't be used with an ldaps:// url", prefix)
+	}
+	checkFile(v, prefix+".ca_file", l.CAFile)
+	if l.Timeout.Duration <= 0 {
+		v.add("%s.timeout must be positive", prefix)
+	}
+
+	if (l.UserDN == "") == (l.BaseDN == "") {
+		v.add("%s: exactly one of user_dn and base_dn must be set", prefix)
+	}
+	if l.UserDN != "" && !strings.Contains(l.UserDN, "{username}") {
+		v.add("%s.user_dn must contain {username}", prefix)
+	}
+	if l.BaseDN != "" && !strings.Contains(l.UserFilter, "{username}") {
+		v.add("%s.user_filter must contain {username}", prefix)
+	}
+	if (l.BindDN == "") != (l.BindPassword == "") {
+		v.add("%s: bind_dn and bind_password must be set together", prefix)
+	}
+	if l.GroupBaseDN != "" && l.GroupAttribute == "" {
+		v.add("%s.group_attribute must be set with group_base_dn", prefix)
+	}
+}
+
+func checkFile(v *ValidationError, name, path string) {
+	if path == "" {
+		return
+	}
+	if _, err := os.Stat(path); err != nil {
+		v.add("%s: %v", name, err)
+	}
+}
@@ -0,0 +1,75 @@
+//go:build go1.18
+// +build go1.18
+
+/*
+Copyright (c) 2021 Memgraph Ltd. [https://memgraph.com]
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cypher
+
+import (
+	"testing"
+)
+
+var fuzzSeeds = []string{
+	"MATCH (n:Person {name: $name})-[r:KNOWS|:LIKES*1..3]->(m) RETURN n, r, m",
+	"MERGE (p:P {id: 1}) ON CREATE SET p.x = 1.5e-3 ON MATCH SET p.y = .5",
+	"CALL `nx alg`.pagerank() YIELD * RETURN *; DROP INDEX ON :A(b);",
+	`RETURN 'it\'s', "é\U0001F600", ` + "`a``b`" + ` // comment`,
+	"LOAD CSV FROM 'x' WITH HEADER AS row CREATE (:R) /* unterminated",
+	"RETURN '\\",
+	"\xff\xfe$\x00`",
+}
+
+// Tokens stay in order within the query, keep the text of unquoted tokens,
+// and normalizing is stable.
+func FuzzTokenize(f *testing.F) {
+	for _, seed := range fuzzSeeds {
+		f.Add(seed)
+	}
+	f.Fuzz(func(t *testing.T, query string) {
+		tokens, _ := Tokenize(query)
+		end := 0
+		for _, token := range tokens {
+			if token.Pos < end || token.End <= token.Pos || token.End > len(query) {
+				t.Fatalf("token %+v out of place after %d", token, end)
+			}
+			switch token.Kind {
+			case Word, Number, Symbol:
+				if token.Text != query[token.Pos:token.End] {
+					t.Fatalf("token %+v doesn't match %q", token, query[token.Pos:token.End])
+				}
+			}
+			end = token.End
+		}
+
+		normalized := Normalize(query)
+		if again := Normalize(normalized); again != normalized {
+			t.Fatalf("normalizing %q again gives %q", normalized, again)
+		}
+	})
+}
+
+func FuzzAnalyze(f *testing.F) {
+	for _, seed := range fuzzSeeds {
+		f.Add(seed)
+	}
+	f.Fuzz(func(t *testing.T, query string) {
+		q := Analyze(query)
+		if q.Admin && q.ReadOnly() {
+			t.Fatalf("administrative query %q taken for read only", query)
+		}
+	})
+}
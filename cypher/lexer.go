@@ -0,0 +1,281 @@
+/*
+Copyright (c) 2021 Memgraph Ltd. [https://memgraph.com]
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Tokenizer and lightweight analysis of Cypher queries, enough for the
+// proxy to tell what a query does without fully parsing it.
+package cypher
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// A string, quoted name or comment runs to the end of the query
+var ErrUnterminated = errors.New("unterminated string, name or comment")
+
+type TokenKind int
+
+const (
+	// Keywords, variables, labels, function names and so on
+	Word TokenKind = iota
+	// Names in backticks, which are never keywords
+	QuotedName
+	String
+	Number
+	// $name or $0
+	Parameter
+	// Punctuation and operators
+	Symbol
+)
+
+func (k TokenKind) String() string {
+	switch k {
+	case Word:
+		return "word"
+	case QuotedName:
+		return "quoted name"
+	case String:
+		return "string"
+	case Number:
+		return "number"
+	case Parameter:
+		return "parameter"
+	case Symbol:
+		return "symbol"
+	}
+	return "TokenKind(" + strconv.Itoa(int(k)) + ")"
+}
+
+type Token struct {
+	Kind TokenKind
+	// The name of a quoted name or parameter and the value of a string,
+	// the token as written otherwise
+	Text string
+	// Byte offsets of the token in the query
+	Pos, End int
+	// Whitespace or a comment comes before the token
+	Spaced bool
+}
+
+// Operators of two characters, anything else is a symbol of its own
+var operators = map[string]bool{
+	"<>": true,
+	"!=": true,
+	"<=": true,
+	">=": true,
+	"=~": true,
+	"->": true,
+	"<-": true,
+	"..": true,
+	"+=": true,
+	"||": true,
+	"::": true,
+}
+
+// Split a query into tokens, dropping whitespace and comments. A string or
+// quoted name missing its closing quote runs to the end of the query, and
+// ErrUnterminated is returned along with all the tokens.
+func Tokenize(query string) ([]Token, error) {
+	var tokens []Token
+	var err error
+	spaced := false
+	for pos := 0; pos < len(query); {
+		r, size := utf8.DecodeRuneInString(query[pos:])
+		token := Token{Pos: pos, Spaced: spaced}
+		end := pos + size
+		switch {
+		case unicode.IsSpace(r):
+			spaced = true
+			pos = end
+			continue
+		case strings.HasPrefix(query[pos:], "//"):
+			end = strings.IndexByte(query[pos:], '\n')
+			if end < 0 {
+				end = len(query) - pos
+			}
+			spaced = true
+			pos += end
+			continue
+		case strings.HasPrefix(query[pos:], "/*"):
+			end = strings.Index(query[pos+2:], "*/")
+			if end < 0 {
+				err = ErrUnterminated
+				pos = len(query)
+			} else {
+				pos += 2 + end + 2
+			}
+			spaced = true
+			continue
+		case r == '\'' || r == '"':
+			token.Kind = String
+			token.Text, end = quoted(query, pos, r)
+		case r == '`':
+			token.Kind = QuotedName
+			token.Text, end = quoted(query, pos, r)
+		case r == '$' && end < len(query) && startsWord(query[end:]):
+			token.Kind = Parameter
+			end = wordEnd(query, end)
+			token.Text = query[pos+1 : end]
+		case isDigit(r) || (r == '.' && end < len(query) && isDigit(rune(query[end]))):
+			token.Kind = Number
+			end = numberEnd(query, pos)
+		case isWordRune(r):
+			token.Kind = Word
+			end = wordEnd(query, pos)
+		default:
+			token.Kind = Symbol
+			if end < len(query) && operators[query[pos:end+1]] {
+				end++
+			}
+		}
+		if end > len(query) {
+			// missing the closing quote
+			end = len(query)
+			err = ErrUnterminated
+		}
+		token.End = end
+		if token.Kind != String && token.Kind != QuotedName && token.Kind != Parameter {
+			token.Text = query[pos:end]
+		}
+		tokens = append(tokens, token)
+		spaced = false
+		pos = end
+	}
+	return tokens, err
+}
+
+// The unescaped contents of the string or name quoted by quote at pos, and
+// the end of it, past the end of query if the closing quote is missing.
+func quoted(query string, pos int, quote rune) (string, int) {
+	var text strings.Builder
+	for i := pos + 1; i < len(query); {
+		r, size := utf8.DecodeRuneInString(query[i:])
+		switch {
+		case r == quote && quote == '`' && strings.HasPrefix(query[i+1:], "`"):
+			// doubled backticks stand for one
+			text.WriteRune(r)
+			i += 2
+		case r == quote:
+			return text.String(), i + 1
+		case r == '\\' && quote != '`' && i+1 < len(query):
+			n := unescape(&text, query[i+1:])
+			i += 1 + n
+		default:
+			text.WriteString(query[i : i+size])
+			i += size
+		}
+	}
+	return text.String(), len(query) + 1
+}
+
+var escapes = map[byte]string{
+	'b': "\b",
+	'f': "\f",
+	'n': "\n",
+	'r': "\r",
+	't': "\t",
+}
+
+// Write the character escaped at the start of s, returning how many bytes
+// of s the escape takes.
+func unescape(text *strings.Builder, s string) int {
+	if escaped, found := escapes[s[0]]; found {
+		text.WriteString(escaped)
+		return 1
+	}
+	if s[0] == 'u' || s[0] == 'U' {
+		digits := 4
+		if s[0] == 'U' {
+			digits = 8
+		}
+		if len(s) > digits {
+			if code, err := strconv.ParseUint(s[1:1+digits], 16, 32); err == nil {
+				text.WriteRune(rune(code))
+				return 1 + digits
+			}
+		}
+	}
+	// \\, \', \" and anything unknown stand for the character itself
+	r, size := utf8.DecodeRuneInString(s)
+	if r == utf8.RuneError {
+		text.WriteString(s[:size])
+	} else {
+		text.WriteRune(r)
+	}
+	return size
+}
+
+// End of the number at pos: digits, letters for hexadecimal numbers and
+// exponents, a fraction and a signed exponent. 1..2 is a range, not a
+// number.
+func numberEnd(query string, pos int) int {
+	end := wordEnd(query, pos)
+	if end < len(query)-1 && query[end] == '.' && isDigit(rune(query[end+1])) {
+		end = wordEnd(query, end+1)
+	}
+	if last := query[end-1]; (last == 'e' || last == 'E') && end < len(query)-1 &&
+		(query[end] == '+' || query[end] == '-') && isDigit(rune(query[end+1])) {
+		end = wordEnd(query, end+1)
+	}
+	return end
+}
+
+// End of the word runes starting at pos.
+func wordEnd(query string, pos int) int {
+	for pos < len(query) {
+		r, size := utf8.DecodeRuneInString(query[pos:])
+		if !isWordRune(r) {
+			break
+		}
+		pos += size
+	}
+	return pos
+}
+
+func startsWord(s string) bool {
+	r, _ := utf8.DecodeRuneInString(s)
+	return isWordRune(r)
+}
+
+func isWordRune(r rune) bool {
+	return r == '_' || unicode.IsLetter(r) || unicode.IsDigit(r)
+}
+
+func isDigit(r rune) bool {
+	return r >= '0' && r <= '9'
+}
+
+// A query reduced to what matters for comparing it: comments dropped,
+// whitespace between tokens collapsed into single spaces, and trailing
+// semicolons dropped. Strings and quoted names are kept as written.
+func Normalize(query string) string {
+	tokens, _ := Tokenize(query)
+	for len(tokens) > 0 && tokens[len(tokens)-1].Kind == Symbol && tokens[len(tokens)-1].Text == ";" {
+		tokens = tokens[:len(tokens)-1]
+	}
+
+	var normalized strings.Builder
+	for i, token := range tokens {
+		if token.Spaced && i > 0 {
+			normalized.WriteByte(' ')
+		}
+		normalized.WriteString(query[token.Pos:token.End])
+	}
+	return normalized.String()
+}
@@ -0,0 +1,105 @@
+/*
+Copyright (c) 2021 Memgraph Ltd. [https://memgraph.com]
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cypher
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// Tokens as kind:text, for comparing them easily.
+func describe(tokens []Token) []string {
+	described := make([]string, 0, len(tokens))
+	for _, token := range tokens {
+		described = append(described, fmt.Sprintf("%s:%s", token.Kind, token.Text))
+	}
+	return described
+}
+
+func TestTokenize(t *testing.T) {
+	tests := []struct {
+		query  string
+		tokens string
+	}{
+		{"MATCH (n:Person) RETURN n", "word:MATCH symbol:( word:n symbol:: word:Person symbol:) word:RETURN word:n"},
+		{"RETURN 1, 1.5, .5, 1e-3, 0x1F, 1..3", "word:RETURN number:1 symbol:, number:1.5 symbol:, number:.5 symbol:, number:1e-3 symbol:, number:0x1F symbol:, number:1 symbol:.. number:3"},
+		{`RETURN 'it\'s', "tab\there", '\u00e9'`, "word:RETURN string:it's symbol:, string:tab\there symbol:, string:é"},
+		{"MATCH (`my node`)-[:`KNOWS``S`]->(m) RETURN $name, $0", "word:MATCH symbol:( quoted name:my node symbol:) symbol:- symbol:[ symbol:: quoted name:KNOWS`S symbol:] symbol:-> symbol:( word:m symbol:) word:RETURN parameter:name symbol:, parameter:0"},
+		{"RETURN 1 // CREATE (n)\n/* DELETE\n n */ ;", "word:RETURN number:1 symbol:;"},
+		{"WHERE a <> b AND c <= d AND e =~ 'x'", "word:WHERE word:a symbol:<> word:b word:AND word:c symbol:<= word:d word:AND word:e symbol:=~ string:x"},
+		{"RETURN größe", "word:RETURN word:größe"},
+	}
+	for _, test := range tests {
+		tokens, err := Tokenize(test.query)
+		if err != nil {
+			t.Errorf("%s: %v", test.query, err)
+			continue
+		}
+		if got := strings.Join(describe(tokens), " "); got != test.tokens {
+			t.Errorf("%s:\nexpected %s\n     got %s", test.query, test.tokens, got)
+		}
+	}
+
+	// positions point back into the query
+	query := "MATCH (n) WHERE n.name = 'a b' RETURN n"
+	tokens, _ := Tokenize(query)
+	if value := tokens[9]; query[value.Pos:value.End] != "'a b'" || !value.Spaced || tokens[6].Spaced {
+		t.Fatalf("unexpected token %+v", value)
+	}
+}
+
+func TestTokenizeUnterminated(t *testing.T) {
+	for _, query := range []string{"RETURN 'abc", "RETURN `abc", `RETURN "abc\`} {
+		tokens, err := Tokenize(query)
+		if err != ErrUnterminated || len(tokens) != 2 || tokens[1].End != len(query) {
+			t.Errorf("%s: expected the last token to run to the end, got %v, %v", query, describe(tokens), err)
+		}
+	}
+	if tokens, err := Tokenize("RETURN 1 /* abc"); err != ErrUnterminated || len(tokens) != 2 {
+		t.Errorf("expected the comment to run to the end, got %v, %v", describe(tokens), err)
+	}
+}
+
+func TestNormalize(t *testing.T) {
+	tests := []struct {
+		query, normalized string
+	}{
+		{"MATCH (n)\n\tRETURN n;", "MATCH (n) RETURN n"},
+		{"  MATCH (n) // all of them\nRETURN /* just */ n ; ; ", "MATCH (n) RETURN n"},
+		{"RETURN 'a  b', \"c // d\", `e  f`", "RETURN 'a  b', \"c // d\", `e  f`"},
+		{`RETURN 'it\'s  here'`, `RETURN 'it\'s  here'`},
+		{"RETURN 1/**/+2", "RETURN 1 +2"},
+	}
+	for _, test := range tests {
+		if normalized := Normalize(test.query); normalized != test.normalized {
+			t.Errorf("%q: expected %q, got %q", test.query, test.normalized, normalized)
+		}
+	}
+}
+
+func TestTokenKindString(t *testing.T) {
+	kinds := []string{}
+	for kind := Word; kind <= Symbol+1; kind++ {
+		kinds = append(kinds, kind.String())
+	}
+	expected := []string{"word", "quoted name", "string", "number", "parameter", "symbol", "TokenKind(6)"}
+	if !reflect.DeepEqual(kinds, expected) {
+		t.Fatalf("unexpected kinds %v", kinds)
+	}
+}
@@ -0,0 +1,336 @@
+/*
+Copyright (c) 2021 Memgraph Ltd. [https://memgraph.com]
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cypher
+
+import (
+	"strings"
+)
+
+// Keywords starting a clause
+var clauseKeywords = map[string]bool{
+	"MATCH":    true,
+	"OPTIONAL": true,
+	"CREATE":   true,
+	"MERGE":    true,
+	"SET":      true,
+	"DELETE":   true,
+	"DETACH":   true,
+	"REMOVE":   true,
+	"WITH":     true,
+	"UNWIND":   true,
+	"RETURN":   true,
+	"CALL":     true,
+	"FOREACH":  true,
+	"LOAD":     true,
+	"UNION":    true,
+}
+
+// Clauses that change data
+var writeClauses = map[string]bool{
+	"CREATE":        true,
+	"MERGE":         true,
+	"SET":           true,
+	"DELETE":        true,
+	"DETACH DELETE": true,
+	"REMOVE":        true,
+}
+
+// Clauses that only read, unless they CALL a procedure that writes
+var readClauses = map[string]bool{
+	"MATCH":          true,
+	"OPTIONAL MATCH": true,
+	"WITH":           true,
+	"UNWIND":         true,
+	"RETURN":         true,
+	"CALL":           true,
+	"UNION":          true,
+}
+
+// Statements starting with one of these change the schema, users, storage
+// or replication, and so on (Memgraph extensions)
+var adminStatements = map[string]bool{
+	"DROP":       true,
+	"ALTER":      true,
+	"GRANT":      true,
+	"REVOKE":     true,
+	"DENY":       true,
+	"SET":        true,
+	"REGISTER":   true,
+	"UNREGISTER": true,
+	"ADD":        true,
+	"REMOVE":     true,
+	"DEMOTE":     true,
+	"PROMOTE":    true,
+	"STORAGE":    true,
+	"FREE":       true,
+	"ANALYZE":    true,
+	"LOCK":       true,
+	"UNLOCK":     true,
+	"RECOVER":    true,
+	"START":      true,
+	"STOP":       true,
+	"CHECK":      true,
+	"TERMINATE":  true,
+	"DUMP":       true,
+	"ENABLE":     true,
+	"DISABLE":    true,
+	"FORCE":      true,
+}
+
+// Things a statement may CREATE besides data, all of them administrative
+var adminCreates = map[string]bool{
+	"INDEX":      true,
+	"EDGE":       true,
+	"POINT":      true,
+	"TEXT":       true,
+	"VECTOR":     true,
+	"CONSTRAINT": true,
+	"TRIGGER":    true,
+	"USER":       true,
+	"ROLE":       true,
+	"STREAM":     true,
+	"KAFKA":      true,
+	"PULSAR":     true,
+	"DATABASE":   true,
+	"SNAPSHOT":   true,
+	"ENUM":       true,
+}
+
+// What a query does. Anything that merely looks like a write is taken for
+// one, erring on the side of caution.
+type Query struct {
+	// Clauses of all statements in order, e.g. "OPTIONAL MATCH" or
+	// "DETACH DELETE". Administrative statements only have their command,
+	// e.g. "DROP" or "CREATE INDEX", and so do statements starting with
+	// anything but a clause, e.g. "SHOW".
+	Clauses []string
+	// Changes the schema, users or anything else beyond data
+	Admin bool
+	// Dotted names of the procedures it CALLs
+	Procedures []string
+	// Labels and relationship types it mentions, in order of appearance
+	Labels            []string
+	RelationshipTypes []string
+}
+
+// Whether the query changes data.
+func (q *Query) Writes() bool {
+	for _, clause := range q.Clauses {
+		// LOAD CSV, JSONL, PARQUET and whatever else may come import data
+		if writeClauses[clause] || clause == "LOAD" || strings.HasPrefix(clause, "LOAD ") {
+			return true
+		}
+	}
+	return false
+}
+
+// Whether the query is known to only read, i.e. all its clauses only
+// read. The procedures it calls may still write.
+func (q *Query) ReadOnly() bool {
+	if q.Admin || len(q.Clauses) == 0 {
+		return false
+	}
+	for _, clause := range q.Clauses {
+		if !readClauses[clause] {
+			return false
+		}
+	}
+	return true
+}
+
+// Find out what a query does. Words are only taken for keywords where they
+// can be ones, so labels, property keys, map keys and quoted names like
+// `delete` don't count. Strings, parameters and comments are ignored. The
+// query doesn't need to be valid; an unterminated string just runs to the
+// end.
+func Analyze(query string) *Query {
+	tokens, _ := Tokenize(query)
+	a := &analysis{tokens: tokens, q: &Query{}}
+	for start := 0; start < len(tokens); {
+		end := start
+		for end < len(tokens) && !a.is(end, ";") {
+			end++
+		}
+		a.statement(start, end)
+		start = end + 1
+	}
+	return a.q
+}
+
+type analysis struct {
+	tokens []Token
+	q      *Query
+}
+
+// Whether token i is the symbol s.
+func (a *analysis) is(i int, s string) bool {
+	return i >= 0 && i < len(a.tokens) && a.tokens[i].Kind == Symbol && a.tokens[i].Text == s
+}
+
+// The upper case keyword token i can be, "" if it can't be one: n.delete,
+// (n:Set) and {create: 1} aren't keywords.
+func (a *analysis) keyword(i int) string {
+	if i < 0 || i >= len(a.tokens) || a.tokens[i].Kind != Word {
+		return ""
+	}
+	if a.is(i-1, ".") || a.is(i-1, ":") || a.is(i+1, ":") {
+		return ""
+	}
+	return strings.ToUpper(a.tokens[i].Text)
+}
+
+// Analyze the statement of tokens [start, end).
+func (a *analysis) statement(start, end int) {
+	// EXPLAIN and PROFILE come before the statement
+	for start < end && (a.keyword(start) == "EXPLAIN" || a.keyword(start) == "PROFILE") {
+		start++
+	}
+	if start == end {
+		return
+	}
+
+	command := a.keyword(start)
+	if command == "CREATE" && adminCreates[a.keyword(start+1)] {
+		command += " " + a.keyword(start+1)
+	} else if !adminStatements[command] {
+		command = ""
+	}
+	if command != "" {
+		a.q.Admin = true
+		a.q.Clauses = append(a.q.Clauses, command)
+		a.names(start, end)
+		return
+	}
+	if first := a.keyword(start); !clauseKeywords[first] {
+		// e.g. SHOW or USE of Memgraph, or not a valid query at all
+		if first == "" {
+			first = strings.ToUpper(a.tokens[start].Text)
+		}
+		a.q.Clauses = append(a.q.Clauses, first)
+	}
+
+	for i := start; i < end; i++ {
+		word := a.keyword(i)
+		if !clauseKeywords[word] {
+			continue
+		}
+		previous := a.keyword(i - 1)
+		switch {
+		case previous == "ON" && (word == "CREATE" || word == "MATCH"):
+			// ON CREATE SET and ON MATCH SET of MERGE
+			continue
+		case word == "WITH" && (previous == "STARTS" || previous == "ENDS" || a.keyword(i+1) == "HEADER"):
+			// string operators and LOAD CSV ... WITH HEADER
+			continue
+		case word == "OPTIONAL" || word == "DETACH" || word == "LOAD":
+			if next := a.keyword(i + 1); next != "" {
+				word += " " + next
+				i++
+			}
+		case word == "CALL":
+			if name := a.procedureName(i+1, end); name != "" {
+				a.q.Procedures = appendNew(a.q.Procedures, name)
+			}
+		}
+		a.q.Clauses = append(a.q.Clauses, word)
+	}
+	a.names(start, end)
+}
+
+// The dotted procedure name starting at token i, if any; CALL { ... }
+// subqueries have none.
+func (a *analysis) procedureName(i, end int) string {
+	var parts []string
+	for ; i < end; i += 2 {
+		token := a.tokens[i]
+		if token.Kind != Word && token.Kind != QuotedName {
+			break
+		}
+		parts = append(parts, token.Text)
+		if !a.is(i+1, ".") {
+			break
+		}
+	}
+	return strings.Join(parts, ".")
+}
+
+// Collect the labels and relationship types of tokens [start, end). A name
+// after a colon is a label, unless the colon separates a map key from its
+// value or it's in the brackets of a relationship, where names after a
+// colon or a bar are relationship types.
+func (a *analysis) names(start, end int) {
+	// the open brackets, with '-' for those of relationships
+	var brackets []byte
+	for i := start; i < end; i++ {
+		token := a.tokens[i]
+		if token.Kind != Symbol {
+			continue
+		}
+		innermost := byte(0)
+		if len(brackets) > 0 {
+			innermost = brackets[len(brackets)-1]
+		}
+		switch token.Text {
+		case "(", "{":
+			brackets = append(brackets, token.Text[0])
+		case "[":
+			if a.is(i-1, "-") || a.is(i-1, "<-") {
+				brackets = append(brackets, '-')
+			} else {
+				brackets = append(brackets, '[')
+			}
+		case ")", "}", "]":
+			if len(brackets) > 0 {
+				brackets = brackets[:len(brackets)-1]
+			}
+		case ":", "|":
+			name := a.name(i + 1)
+			switch {
+			case name == "" || innermost == '{':
+			case innermost == '-':
+				a.q.RelationshipTypes = appendNew(a.q.RelationshipTypes, name)
+			case token.Text == ":":
+				a.q.Labels = appendNew(a.q.Labels, name)
+			}
+		}
+	}
+}
+
+// The name token i is, if it's one.
+func (a *analysis) name(i int) string {
+	if a.is(i, ":") {
+		// [:A|:B]
+		i++
+	}
+	if i >= len(a.tokens) {
+		return ""
+	}
+	token := a.tokens[i]
+	if token.Kind == Word || token.Kind == QuotedName {
+		return token.Text
+	}
+	return ""
+}
+
+func appendNew(list []string, s string) []string {
+	for _, existing := range list {
+		if existing == s {
+			return list
+		}
+	}
+	return append(list, s)
+}
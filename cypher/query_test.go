@@ -0,0 +1,110 @@
+/*
+Copyright (c) 2021 Memgraph Ltd. [https://memgraph.com]
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cypher
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestAnalyze(t *testing.T) {
+	tests := []struct {
+		query string
+		want  Query
+	}{
+		{"MATCH (n) RETURN n", Query{Clauses: []string{"MATCH", "RETURN"}}},
+		{"match (n) set n.x = 1", Query{Clauses: []string{"MATCH", "SET"}}},
+		{"MATCH (n) DETACH DELETE n", Query{Clauses: []string{"MATCH", "DETACH DELETE"}}},
+		{"OPTIONAL MATCH (n) WHERE n.name STARTS WITH 'a' RETURN n",
+			Query{Clauses: []string{"OPTIONAL MATCH", "RETURN"}}},
+		{"UNWIND $rows AS row MERGE (p:Person {id: row.id}) ON CREATE SET p.new = true",
+			Query{Clauses: []string{"UNWIND", "MERGE", "SET"}, Labels: []string{"Person"}}},
+		{"LOAD CSV FROM '/x.csv' WITH HEADER AS row CREATE (:Row {id: row.id})",
+			Query{Clauses: []string{"LOAD CSV", "CREATE"}, Labels: []string{"Row"}}},
+		// labels, property keys, map keys, strings, parameters, comments
+		// and quoted names aren't clauses
+		{"MATCH (n:Set)-[:CREATE]->(m) WHERE n.delete = 'CREATE (x)' RETURN {merge: m}",
+			Query{Clauses: []string{"MATCH", "RETURN"}, Labels: []string{"Set"}, RelationshipTypes: []string{"CREATE"}}},
+		{"MATCH (n) WHERE n.id = $remove RETURN n // SET n.x = 1", Query{Clauses: []string{"MATCH", "RETURN"}}},
+		{"MATCH (`delete`) /* DELETE */ RETURN `delete`", Query{Clauses: []string{"MATCH", "RETURN"}}},
+		{`RETURN "it\"s; DROP INDEX ON :A(b)"`, Query{Clauses: []string{"RETURN"}}},
+		{"MATCH (a:Person:`Big Spender`)<-[r:KNOWS|:LIKES*1..2]-(b) WHERE b:Admin RETURN a",
+			Query{Clauses: []string{"MATCH", "RETURN"}, Labels: []string{"Person", "Big Spender", "Admin"},
+				RelationshipTypes: []string{"KNOWS", "LIKES"}}},
+		{"RETURN [x IN $list | x.name], {a: 1, b: [1]}", Query{Clauses: []string{"RETURN"}}},
+		// Memgraph's administrative queries
+		{"CREATE INDEX ON :Person(id)", Query{Clauses: []string{"CREATE INDEX"}, Admin: true, Labels: []string{"Person"}}},
+		{"EXPLAIN DROP CONSTRAINT ON (n:A) ASSERT n.id IS UNIQUE", Query{Clauses: []string{"DROP"}, Admin: true, Labels: []string{"A"}}},
+		{"CREATE USER eve IDENTIFIED BY 'x'", Query{Clauses: []string{"CREATE USER"}, Admin: true}},
+		{"GRANT CREATE, DELETE TO eve", Query{Clauses: []string{"GRANT"}, Admin: true}},
+		{"SET PASSWORD FOR eve TO 'y'", Query{Clauses: []string{"SET"}, Admin: true}},
+		{"RETURN 1; DROP GRAPH;", Query{Clauses: []string{"RETURN", "DROP"}, Admin: true}},
+		{"DUMP DATABASE", Query{Clauses: []string{"DUMP"}, Admin: true}},
+		{"ENABLE TTL EVERY '1d' AT '00:00:00'", Query{Clauses: []string{"ENABLE"}, Admin: true}},
+		{"DISABLE TTL", Query{Clauses: []string{"DISABLE"}, Admin: true}},
+		{"STOP TTL", Query{Clauses: []string{"STOP"}, Admin: true}},
+		{"FORCE RESET CLUSTER STATE", Query{Clauses: []string{"FORCE"}, Admin: true}},
+		{"LOAD JSONL FROM '/x.jsonl' AS row CREATE (:Row {id: row.id})",
+			Query{Clauses: []string{"LOAD JSONL", "CREATE"}, Labels: []string{"Row"}}},
+		{"LOAD PARQUET FROM '/x.parquet' AS row RETURN row", Query{Clauses: []string{"LOAD PARQUET", "RETURN"}}},
+		{"CALL mg.procedures() YIELD name RETURN name",
+			Query{Clauses: []string{"CALL", "RETURN"}, Procedures: []string{"mg.procedures"}}},
+		{"MATCH (n) CALL `nx alg`.pagerank(n) YIELD * RETURN *",
+			Query{Clauses: []string{"MATCH", "CALL", "RETURN"}, Procedures: []string{"nx alg.pagerank"}}},
+		{"MATCH (n) CALL { WITH n RETURN n.x AS x } RETURN x",
+			Query{Clauses: []string{"MATCH", "CALL", "WITH", "RETURN", "RETURN"}}},
+		{"MATCH (n) FOREACH (x IN n.list | CREATE (:Item {x: x}))",
+			Query{Clauses: []string{"MATCH", "FOREACH", "CREATE"}, Labels: []string{"Item"}}},
+		{"SHOW STORAGE INFO; (n)", Query{Clauses: []string{"SHOW", "("}}},
+		{"", Query{}},
+		{"RETURN 'unterminated", Query{Clauses: []string{"RETURN"}}},
+	}
+	for _, test := range tests {
+		if got := Analyze(test.query); !reflect.DeepEqual(*got, test.want) {
+			t.Errorf("%s:\nexpected %+v\n     got %+v", test.query, test.want, *got)
+		}
+	}
+}
+
+func TestWritesAndReadOnly(t *testing.T) {
+	tests := []struct {
+		query            string
+		writes, readOnly bool
+	}{
+		{"MATCH (n) RETURN n", false, true},
+		{"MATCH (n) SET n.x = 1", true, false},
+		{"MATCH (n) WITH n MERGE (n)-[:R]->(:M)", true, false},
+		{"LOAD CSV FROM '/x.csv' NO HEADER AS row RETURN row", true, false},
+		{"LOAD JSONL FROM '/x.jsonl' AS row RETURN row", true, false},
+		{"load parquet from '/x.parquet' as row return row", true, false},
+		{"LOAD FROM '/x' AS row RETURN row", true, false},
+		{"DUMP DATABASE", false, false},
+		{"DROP INDEX ON :A(b)", false, false},
+		{"CALL mg.procedures() YIELD name RETURN name", false, true},
+		{"MATCH (n) CALL { WITH n RETURN n.x AS x } RETURN x", false, true},
+		{"RETURN 1; DUMP DATABASE", false, false},
+		{"SHOW REPLICAS", false, false},
+		{"FOREACH (x IN [1] | CREATE ())", true, false},
+		{"", false, false},
+	}
+	for _, test := range tests {
+		q := Analyze(test.query)
+		if q.Writes() != test.writes || q.ReadOnly() != test.readOnly {
+			t.Errorf("%s: expected writes %t and read only %t", test.query, test.writes, test.readOnly)
+		}
+	}
+}
@@ -0,0 +1,42 @@
+/*
+Copyright (c) 2021 Memgraph Ltd. [https://memgraph.com]
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package frontend
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/memgraph/bolt-proxy/metrics"
+)
+
+// HTTP handler for the admin endpoint:
+//
+//            /health: liveness check
+//           /metrics: metrics in the Prometheus text format
+//  /tls/certificates: subject, SANs and expiry of listener certificates
+func AdminHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/health", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+	mux.Handle("/metrics", metrics.Handler())
+	mux.HandleFunc("/tls/certificates", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(CertificateStatuses())
+	})
+	return mux
+}
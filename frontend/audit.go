@@ -0,0 +1,284 @@
+/*
+Copyright (c) 2021 Memgraph Ltd. [https://memgraph.com]
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package frontend
+
+import (
+	"crypto/rand"
+	"crypto/tls"
+	"encoding/hex"
+	"sync"
+	"time"
+
+	"github.com/memgraph/bolt-proxy/audit"
+	"github.com/memgraph/bolt-proxy/bolt"
+	"github.com/memgraph/bolt-proxy/config"
+)
+
+// Follows the requests of a client session and the server's answers to
+// them, recording the session, its queries and transactions in the
+// listener's audit log. A nil trail records nothing.
+//
+// The server answers requests in order, so every summary belongs to the
+// oldest request without one yet.
+type auditTrail struct {
+	log    *audit.Logger
+	sess   *session
+	id     string
+	opened time.Time
+
+	mu sync.Mutex
+	// requests sent to the server without a summary back yet, oldest first
+	requests []auditRequest
+	// the query whose records are being pulled
+	streaming *auditRequest
+	rows      int64
+	// the explicit transaction, nil outside of one
+	tx *auditRequest
+}
+
+type auditRequest struct {
+	t     bolt.Type
+	sent  time.Time
+	event audit.Event
+}
+
+// The audit trail of a session, nil if the listener has no audit log.
+func newAuditTrail(sess *session) *auditTrail {
+	if sess.listener.Audit == nil {
+		return nil
+	}
+	id := make([]byte, 8)
+	_, _ = rand.Read(id)
+	return &auditTrail{log: sess.listener.Audit, sess: sess, id: hex.EncodeToString(id), opened: sess.now()}
+}
+
+// An event of the session, with who the client is and how it connected.
+func (t *auditTrail) event(eventType string) audit.Event {
+	s := t.sess
+	e := audit.Event{
+		Type:       eventType,
+		Session:    t.id,
+		Listener:   s.listener.Name,
+		Principal:  s.principal(),
+		AuthMethod: s.listener.AuthMethod,
+	}
+	if s.listener.CertAuth == config.CERT_AUTH_SUFFICIENT && s.info.CertPrincipal != "" {
+		e.AuthMethod = config.AUTH_CERT
+	}
+	if s.info.RemoteAddr != nil {
+		e.ClientAddress = s.info.RemoteAddr.String()
+	}
+	if state := s.info.TLS; state != nil {
+		e.TLS = &audit.TLS{
+			Version:     config.TLSVersionName(state.Version),
+			CipherSuite: tls.CipherSuiteName(state.CipherSuite),
+			ClientCert:  s.info.CertPrincipal,
+		}
+	}
+	return e
+}
+
+// Record the client authenticating when it connects, or failing to.
+func (t *auditTrail) open(err error) {
+	if t == nil {
+		return
+	}
+	t.log.Log(withError(t.event(audit.SESSION_OPEN), err))
+}
+
+// Record the client authenticating again with a LOGON, or failing to.
+func (t *auditTrail) logon(err error) {
+	if t == nil {
+		return
+	}
+	t.log.Log(withError(t.event(audit.LOGON), err))
+}
+
+func (t *auditTrail) logoff() {
+	if t == nil {
+		return
+	}
+	t.log.Log(t.event(audit.LOGOFF))
+}
+
+func (t *auditTrail) close() {
+	if t == nil {
+		return
+	}
+	e := t.event(audit.SESSION_CLOSE)
+	e.DurationMs = milliseconds(t.sess.now().Sub(t.opened))
+	t.log.Log(e)
+}
+
+func withError(e audit.Event, err error) audit.Event {
+	if err != nil {
+		e.Outcome, e.Error = audit.OUTCOME_FAILURE, err.Error()
+	} else {
+		e.Outcome = audit.OUTCOME_SUCCESS
+	}
+	return e
+}
+
+// Record a query the proxy refused to send to the server. Messages it
+// couldn't tell the type of are recorded as queries without one, since they
+// may have been.
+func (t *auditTrail) refused(msg *bolt.Message, inTx bool, err error) {
+	if t == nil || (msg.T != bolt.RunMsg && bolt.IsIdentified(msg)) {
+		return
+	}
+	var e audit.Event
+	if msg.T == bolt.RunMsg && bolt.IsIdentified(msg) {
+		e = t.query(msg, inTx)
+	} else {
+		e = t.event(audit.QUERY)
+		e.Transaction = inTx
+	}
+	e.Outcome, e.Error = audit.OUTCOME_REFUSED, err.Error()
+	t.log.Log(e)
+}
+
+// Remember a request sent to the server, to match it with its summary.
+func (t *auditTrail) sent(msg *bolt.Message, inTx bool) {
+	if t == nil || msg.T == bolt.ChunkedMsg || msg.T == bolt.GoodbyeMsg {
+		return
+	}
+	request := auditRequest{t: msg.T, sent: t.sess.now()}
+	switch msg.T {
+	case bolt.RunMsg:
+		request.event = t.query(msg, inTx)
+	case bolt.BeginMsg:
+		request.event = t.event(audit.TRANSACTION)
+		request.event.Database, _ = bolt.MetadataString(msg, "db")
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.requests = append(t.requests, request)
+}
+
+// The event of a RUN, without its outcome. The parameters of one too large
+// for a single chunk can't be read and are left out, as is its query if it
+// doesn't fit in the first chunk.
+func (t *auditTrail) query(run *bolt.Message, inTx bool) audit.Event {
+	e := t.event(audit.QUERY)
+	e.Transaction = inTx
+	if query, _, err := bolt.ParseString(run.Data[4:]); err == nil {
+		e.Query = query
+	}
+	if values, err := bolt.Parameters(run); err == nil {
+		e.Parameters = t.log.Parameters(values)
+	}
+	e.Database, _ = bolt.MetadataString(run, "db")
+	if inTx {
+		t.mu.Lock()
+		if t.tx != nil {
+			e.Database = t.tx.event.Database
+		}
+		t.mu.Unlock()
+	}
+	return e
+}
+
+// Match a message from the server with the request it answers, recording
+// queries once their last record is out and transactions once they end.
+func (t *auditTrail) received(msg *bolt.Message) {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	switch msg.T {
+	case bolt.RecordMsg:
+		t.rows++
+		return
+	case bolt.SuccessMsg, bolt.FailureMsg, bolt.IgnoreMsg:
+	default:
+		return
+	}
+	if len(t.requests) == 0 {
+		return
+	}
+	request := t.requests[0]
+	t.requests = t.requests[1:]
+
+	outcome, code := audit.OUTCOME_SUCCESS, ""
+	switch msg.T {
+	case bolt.FailureMsg:
+		outcome = audit.OUTCOME_FAILURE
+		code, _ = bolt.MetadataString(msg, "code")
+	case bolt.IgnoreMsg:
+		outcome = audit.OUTCOME_IGNORED
+	}
+
+	switch request.t {
+	case bolt.RunMsg:
+		if outcome == audit.OUTCOME_SUCCESS {
+			t.streaming, t.rows = &request, 0
+		} else {
+			t.logQuery(&request, outcome, code)
+		}
+	case bolt.PullMsg, bolt.DiscardMsg:
+		if t.streaming == nil {
+			break
+		}
+		if hasMore, _ := bolt.MetadataValue(msg, "has_more"); outcome == audit.OUTCOME_SUCCESS && len(hasMore) == 1 && hasMore[0] == 0xc3 {
+			break
+		}
+		t.logQuery(t.streaming, outcome, code)
+		t.streaming = nil
+	case bolt.BeginMsg:
+		if outcome == audit.OUTCOME_SUCCESS {
+			t.tx = &request
+		}
+	case bolt.CommitMsg:
+		if outcome == audit.OUTCOME_SUCCESS {
+			outcome = audit.OUTCOME_COMMITTED
+		}
+		t.logTransaction(outcome, code)
+	case bolt.RollbackMsg, bolt.ResetMsg:
+		if outcome == audit.OUTCOME_SUCCESS {
+			outcome = audit.OUTCOME_ROLLED_BACK
+		}
+		t.logTransaction(outcome, code)
+	}
+}
+
+func (t *auditTrail) logQuery(request *auditRequest, outcome, code string) {
+	e := request.event
+	e.Outcome, e.Error = outcome, code
+	if request == t.streaming {
+		e.Rows = t.rows
+	}
+	e.DurationMs = milliseconds(t.sess.now().Sub(request.sent))
+	t.log.Log(e)
+}
+
+func (t *auditTrail) logTransaction(outcome, code string) {
+	if t.tx == nil {
+		return
+	}
+	e := t.tx.event
+	e.Outcome, e.Error = outcome, code
+	e.DurationMs = milliseconds(t.sess.now().Sub(t.tx.sent))
+	t.log.Log(e)
+	t.tx = nil
+}
+
+func milliseconds(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
+}
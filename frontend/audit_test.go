@@ -0,0 +1,223 @@
+/*
+Copyright (c) 2021 Memgraph Ltd. [https://memgraph.com]
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package frontend
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/memgraph/bolt-proxy/audit"
+	"github.com/memgraph/bolt-proxy/backend"
+	"github.com/memgraph/bolt-proxy/bolt"
+	"github.com/memgraph/bolt-proxy/config"
+	"github.com/memgraph/bolt-proxy/redact"
+)
+
+func TestAuditTrail(t *testing.T) {
+	dir, err := ioutil.TempDir("", "bolt-proxy-audit")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	path := filepath.Join(dir, "audit.log")
+	log, err := audit.New(config.Audit{
+		Sink:       config.AUDIT_SINK_FILE,
+		File:       config.AuditFile{Path: path},
+		Parameters: config.AUDIT_PARAMETERS_REDACT,
+	}, redact.Default())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	l := NewListener(config.Listener{Name: "test"}, nil, expiringAuth{}, config.Default().Timeouts)
+	l.Audit, l.AuthMethod = log, config.AUTH_JWT
+	client := &net.TCPAddr{IP: net.IPv4(10, 0, 0, 1), Port: 5000}
+	sess := newSession(l, backend.ClientInfo{RemoteAddr: client})
+	sess.audit = newAuditTrail(sess)
+	if _, err := sess.authenticate(logon(t, "fresh")); err != nil {
+		t.Fatal(err)
+	}
+	trail := sess.audit
+	trail.open(nil)
+
+	none := map[string]interface{}{}
+	success := message(t, 0x70, none)
+	record := message(t, 0x71, []interface{}{1})
+
+	// an auto-commit query pulled in two batches
+	trail.sent(message(t, 0x10, "MATCH (n) WHERE n.name = $name RETURN n",
+		map[string]interface{}{"name": "alice"}, map[string]interface{}{"db": "memgraph"}), false)
+	trail.sent(message(t, 0x3f, map[string]interface{}{"n": 1}), false)
+	trail.received(message(t, 0x70, map[string]interface{}{"fields": []interface{}{"n"}}))
+	trail.received(record)
+	trail.received(message(t, 0x70, map[string]interface{}{"has_more": true}))
+	trail.sent(message(t, 0x3f, map[string]interface{}{"n": 1}), false)
+	trail.received(record)
+	trail.received(success)
+
+	// a transaction with a failing query, reset by the client
+	trail.sent(message(t, 0x11, map[string]interface{}{"db": "tenant"}), true)
+	trail.received(success)
+	trail.sent(message(t, 0x10, "CREATE (n)", none, none), true)
+	trail.sent(message(t, 0x3f, map[string]interface{}{"n": -1}), true)
+	trail.received(message(t, 0x7f, map[string]interface{}{"code": "Memgraph.ClientError.MemgraphError.MemgraphError", "message": "x"}))
+	trail.received(message(t, 0x7e))
+	trail.sent(message(t, 0x0f), false)
+	trail.received(success)
+
+	trail.refused(message(t, 0x10, "MATCH (n) DETACH DELETE n", none, none), false, errors.New("writes not allowed"))
+	trail.close()
+	log.Close()
+
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := audit.Verify(bytes.NewReader(data), ""); err != nil {
+		t.Fatalf("expected a valid chain: %v", err)
+	}
+	var events []audit.Event
+	for _, line := range bytes.Split(bytes.TrimSpace(data), []byte("\n")) {
+		var e audit.Event
+		if err := json.Unmarshal(line, &e); err != nil {
+			t.Fatal(err)
+		}
+		if e.Session != trail.id || e.Principal != "alice" || e.ClientAddress != "10.0.0.1:5000" || e.AuthMethod != config.AUTH_JWT {
+			t.Errorf("expected the session details in %+v", e)
+		}
+		events = append(events, e)
+	}
+
+	expected := []struct {
+		eventType, outcome string
+		rows               int64
+	}{
+		{audit.SESSION_OPEN, audit.OUTCOME_SUCCESS, 0},
+		{audit.QUERY, audit.OUTCOME_SUCCESS, 2},
+		{audit.QUERY, audit.OUTCOME_FAILURE, 0},
+		{audit.TRANSACTION, audit.OUTCOME_ROLLED_BACK, 0},
+		{audit.QUERY, audit.OUTCOME_REFUSED, 0},
+		{audit.SESSION_CLOSE, "", 0},
+	}
+	if len(events) != len(expected) {
+		t.Fatalf("expected %d events, got %+v", len(expected), events)
+	}
+	for i, want := range expected {
+		e := events[i]
+		if e.Type != want.eventType || e.Outcome != want.outcome || e.Rows != want.rows {
+			t.Errorf("event %d: expected %s %s with %d rows, got %+v", i, want.eventType, want.outcome, want.rows, e)
+		}
+	}
+
+	query := events[1]
+	if query.Query != "MATCH (n) WHERE n.name = $name RETURN n" || query.Database != "memgraph" ||
+		query.Parameters["name"] != audit.REDACTED || query.Transaction {
+		t.Errorf("unexpected auto-commit query %+v", query)
+	}
+	failed := events[2]
+	if !failed.Transaction || failed.Database != "tenant" || failed.Error != "Memgraph.ClientError.MemgraphError.MemgraphError" {
+		t.Errorf("unexpected failed query %+v", failed)
+	}
+	if events[3].Database != "tenant" {
+		t.Errorf("expected the database of the transaction, got %+v", events[3])
+	}
+	if events[4].Error != "writes not allowed" {
+		t.Errorf("expected why the query was refused, got %+v", events[4])
+	}
+
+	// listeners without an audit log have no trail, which records nothing
+	l.Audit = nil
+	disabled := newAuditTrail(newSession(l, backend.ClientInfo{}))
+	if disabled != nil {
+		t.Fatal("expected no trail without an audit log")
+	}
+	disabled.open(nil)
+	disabled.sent(success, false)
+	disabled.received(success)
+}
+
+func TestAuditTrailLargeQuery(t *testing.T) {
+	dir, err := ioutil.TempDir("", "bolt-proxy-audit")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	path := filepath.Join(dir, "audit.log")
+	log, err := audit.New(config.Audit{Sink: config.AUDIT_SINK_FILE, File: config.AuditFile{Path: path}}, redact.Default())
+	if err != nil {
+		t.Fatal(err)
+	}
+	l := NewListener(config.Listener{Name: "test"}, nil, nil, config.Default().Timeouts)
+	l.Audit = log
+	trail := newAuditTrail(newSession(l, backend.ClientInfo{}))
+
+	// the server only sees the first chunk of the RUN as a message, the
+	// query continues in the next one
+	none := map[string]interface{}{}
+	large := chunks(message(t, 0x10, "RETURN '"+strings.Repeat("x", bolt.MAX_CHUNK_SIZE)+"'", none, none))
+	for _, chunk := range large {
+		trail.sent(chunk, false)
+	}
+	trail.sent(message(t, 0x3f, map[string]interface{}{"n": -1}), false)
+	trail.received(message(t, 0x70, none))
+	trail.received(message(t, 0x70, none))
+	// a large query fitting in the first chunk is still recorded
+	params := map[string]interface{}{"blob": strings.Repeat("x", bolt.MAX_CHUNK_SIZE)}
+	trail.refused(chunks(message(t, 0x10, "CREATE (n {blob: $blob})", params, none))[0], false, errors.New("no"))
+	// one split before its tag is refused, and recorded without a query
+	client, server := newFakeConn(), newFakeConn()
+	for _, chunk := range splitAt(message(t, 0x10, "MATCH (n) DETACH DELETE n", none, none), 1, bolt.UnknownMsg) {
+		client.r <- chunk
+	}
+	close(client.r)
+	sess := newSession(l, backend.ClientInfo{})
+	sess.audit = newAuditTrail(sess)
+	proxyListen(client, server, nil, config.Default().Timeouts, sess)
+	log.Close()
+
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := bytes.Split(bytes.TrimSpace(data), []byte("\n"))
+	if len(lines) != 3 {
+		t.Fatalf("expected three queries, got %s", data)
+	}
+	var events [3]audit.Event
+	for i, line := range lines {
+		if err := json.Unmarshal(line, &events[i]); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if events[0].Type != audit.QUERY || events[0].Outcome != audit.OUTCOME_SUCCESS || events[0].Query != "" || events[0].Parameters != nil {
+		t.Errorf("expected the query to be left out, got %+v", events[0])
+	}
+	if events[1].Query != "CREATE (n {blob: $blob})" || events[1].Parameters != nil {
+		t.Errorf("expected the query without parameters, got %+v", events[1])
+	}
+	if events[2].Type != audit.QUERY || events[2].Outcome != audit.OUTCOME_REFUSED || events[2].Query != "" ||
+		!strings.HasPrefix(events[2].Error, "unreadable message") {
+		t.Errorf("expected the split query to be refused, got %+v", events[2])
+	}
+}
@@ -0,0 +1,202 @@
+/*
+Copyright (c) 2021 Memgraph Ltd. [https://memgraph.com]
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package frontend
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"io/ioutil"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/memgraph/bolt-proxy/config"
+	"github.com/memgraph/bolt-proxy/metrics"
+	"github.com/memgraph/bolt-proxy/proxy_logger"
+	"golang.org/x/crypto/ocsp"
+)
+
+// How often the OCSP staple file is checked for changes
+const OCSP_CHECK_INTERVAL = time.Minute
+
+var (
+	certExpiry = metrics.NewGauge("bolt_proxy_tls_certificate_expiry_timestamp_seconds",
+		"Unix time the listener certificate expires at", "listener", "subject")
+	ocspNextUpdate = metrics.NewGauge("bolt_proxy_tls_ocsp_next_update_timestamp_seconds",
+		"Unix time the stapled OCSP response should be refreshed by", "listener")
+
+	certStatusMu sync.Mutex
+	certStatuses = make(map[string]CertificateStatus)
+)
+
+// Details of a certificate loaded by a listener, as reported by the admin
+// endpoint.
+type CertificateStatus struct {
+	Listener       string     `json:"listener"`
+	Subject        string     `json:"subject"`
+	Issuer         string     `json:"issuer"`
+	SANs           []string   `json:"sans"`
+	NotBefore      time.Time  `json:"not_before"`
+	NotAfter       time.Time  `json:"not_after"`
+	OCSPStapled    bool       `json:"ocsp_stapled"`
+	OCSPNextUpdate *time.Time `json:"ocsp_next_update,omitempty"`
+}
+
+// Status of every listener certificate, sorted by listener name.
+func CertificateStatuses() []CertificateStatus {
+	certStatusMu.Lock()
+	defer certStatusMu.Unlock()
+
+	statuses := make([]CertificateStatus, 0, len(certStatuses))
+	for _, status := range certStatuses {
+		statuses = append(statuses, status)
+	}
+	sort.Slice(statuses, func(i, j int) bool {
+		return statuses[i].Listener < statuses[j].Listener
+	})
+	return statuses
+}
+
+func recordCertStatus(status CertificateStatus) {
+	certStatusMu.Lock()
+	certStatuses[status.Listener] = status
+	certStatusMu.Unlock()
+
+	certExpiry.Set(float64(status.NotAfter.Unix()), status.Listener, status.Subject)
+	if status.OCSPNextUpdate != nil {
+		ocspNextUpdate.Set(float64(status.OCSPNextUpdate.Unix()), status.Listener)
+	}
+}
+
+// Serves a listener's certificate, keeping its OCSP staple up to date
+// with the configured staple file.
+type certStore struct {
+	listener   string
+	staplePath string
+
+	mu          sync.Mutex
+	cert        tls.Certificate
+	status      CertificateStatus
+	stapleMtime time.Time
+	lastCheck   time.Time
+}
+
+func newCertStore(listener string, conf config.TLS) (*certStore, error) {
+	cert, err := tls.LoadX509KeyPair(conf.CertFile, conf.KeyFile)
+	if err != nil {
+		return nil, err
+	}
+	leaf, err := x509.ParseCertificate(cert.Certificate[0])
+	if err != nil {
+		return nil, err
+	}
+	cert.Leaf = leaf
+
+	store := &certStore{
+		listener:   listener,
+		staplePath: conf.OCSPStapleFile,
+		cert:       cert,
+		status:     newCertificateStatus(listener, leaf),
+	}
+	if store.staplePath != "" {
+		err = store.loadStaple()
+		if err != nil {
+			return nil, err
+		}
+	}
+	recordCertStatus(store.status)
+	return store, nil
+}
+
+func newCertificateStatus(listener string, leaf *x509.Certificate) CertificateStatus {
+	sans := make([]string, 0, len(leaf.DNSNames)+len(leaf.IPAddresses)+len(leaf.EmailAddresses)+len(leaf.URIs))
+	sans = append(sans, leaf.DNSNames...)
+	for _, ip := range leaf.IPAddresses {
+		sans = append(sans, ip.String())
+	}
+	sans = append(sans, leaf.EmailAddresses...)
+	for _, uri := range leaf.URIs {
+		sans = append(sans, uri.String())
+	}
+
+	return CertificateStatus{
+		Listener:  listener,
+		Subject:   leaf.Subject.String(),
+		Issuer:    leaf.Issuer.String(),
+		SANs:      sans,
+		NotBefore: leaf.NotBefore,
+		NotAfter:  leaf.NotAfter,
+	}
+}
+
+// Read the OCSP staple file, verifying it against the issuer if the
+// certificate chain includes it. Must be called with the lock held or
+// before the store is shared.
+func (s *certStore) loadStaple() error {
+	info, err := os.Stat(s.staplePath)
+	if err != nil {
+		return err
+	}
+	staple, err := ioutil.ReadFile(s.staplePath)
+	if err != nil {
+		return err
+	}
+
+	var issuer *x509.Certificate
+	if len(s.cert.Certificate) > 1 {
+		issuer, err = x509.ParseCertificate(s.cert.Certificate[1])
+		if err != nil {
+			return err
+		}
+	}
+	resp, err := ocsp.ParseResponseForCert(staple, s.cert.Leaf, issuer)
+	if err != nil {
+		return err
+	}
+
+	s.cert.OCSPStaple = staple
+	s.stapleMtime = info.ModTime()
+	s.status.OCSPStapled = true
+	nextUpdate := resp.NextUpdate
+	s.status.OCSPNextUpdate = &nextUpdate
+	return nil
+}
+
+func (s *certStore) getCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.staplePath != "" && time.Since(s.lastCheck) > OCSP_CHECK_INTERVAL {
+		s.lastCheck = time.Now()
+		info, err := os.Stat(s.staplePath)
+		if err == nil && !info.ModTime().Equal(s.stapleMtime) {
+			err = s.loadStaple()
+			if err == nil {
+				recordCertStatus(s.status)
+				proxy_logger.InfoLog.Printf("[%s] reloaded OCSP staple from %s", s.listener, s.staplePath)
+			}
+		}
+		if err != nil {
+			// keep serving the previous staple
+			proxy_logger.WarnLog.Printf("[%s] failed to reload OCSP staple: %v", s.listener, err)
+		}
+	}
+
+	cert := s.cert
+	return &cert, nil
+}
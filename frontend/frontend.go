@@ -18,17 +18,19 @@ package frontend
 
 import (
 	"bytes"
+	"errors"
+	"fmt"
 	"io"
 	"net"
 	"time"
 
+	"github.com/gobwas/ws"
 	"github.com/memgraph/bolt-proxy/backend"
 	"github.com/memgraph/bolt-proxy/bolt"
+	"github.com/memgraph/bolt-proxy/config"
 	"github.com/memgraph/bolt-proxy/proxy_logger"
 )
 
-const MAX_IDLE_MINS int = 30
-
 type CommunicationChannels struct {
 	halt chan bool
 	ack  chan bool
@@ -46,12 +48,19 @@ func newCommChans(size int) CommunicationChannels {
 //
 // If so, wrap the incoming conn into a BoltConn and pass it off to
 // a client handler
-func HandleClient(conn net.Conn, backend_server *backend.Backend) {
+func HandleClient(conn net.Conn, l *Listener) {
 	defer func() {
 		proxy_logger.DebugLog.Printf("closing client connection from %s",
 			conn.RemoteAddr())
 		conn.Close()
 	}()
+	info, err := clientInfo(conn, l.PrincipalFrom, l.Timeouts.Hello.Duration)
+	if err != nil {
+		proxy_logger.WarnLog.Printf("[%s] tls handshake with %s failed: %v",
+			l.Name, conn.RemoteAddr(), err)
+		return
+	}
+
 	// XXX why 1024? I've observed long user-agents that make this
 	// pass the 512 mark easily, so let's be safe and go a full 1kb
 	buf := make([]byte, 1024)
@@ -63,6 +72,11 @@ func HandleClient(conn net.Conn, backend_server *backend.Backend) {
 	}
 	if bytes.Equal(buf[:4], bolt.BoltSignature[:]) {
 		// First case: we have a direct bolt client connection
+		if !l.AllowBolt {
+			proxy_logger.InfoLog.Printf("[%s] bolt transport not allowed, rejecting %s",
+				l.Name, conn.RemoteAddr())
+			return
+		}
 		handshake := make([]byte, 16)
 		n, err := io.ReadFull(conn, handshake)
 		proxy_logger.DebugLog.Printf("read %v number of bytes", n)
@@ -73,7 +87,7 @@ func HandleClient(conn net.Conn, backend_server *backend.Backend) {
 		}
 		// Make sure we try to use the version we're using the best
 		// version based on the backend server
-		server_version := backend_server.Version().Bytes()
+		server_version := l.Backend.Version().Bytes()
 		proxy_logger.DebugLog.Printf("received %v", handshake)
 		clientVersion, err := bolt.ValidateHandshake(handshake, server_version)
 		if err != nil {
@@ -87,10 +101,11 @@ func HandleClient(conn net.Conn, backend_server *backend.Backend) {
 		}
 		// regular bolt
 		proxy_logger.InfoLog.Println("regular bolt")
-		handleBoltConn(bolt.NewDirectConn(conn), clientVersion, backend_server)
+		handleBoltConn(bolt.NewDirectConn(conn), clientVersion, l, info)
 
 	} else if bytes.Equal(buf[:4], bolt.HttpSignature[:]) {
-		// Second case, we have an HTTP which only support health checks.
+		// Second case, we have an HTTP connection that might just be a
+		// WebSocket upgrade OR a health check.
 		// Read the rest of the request
 		n, err := conn.Read(buf[4:])
 		if err != nil {
@@ -107,6 +122,21 @@ func HandleClient(conn net.Conn, backend_server *backend.Backend) {
 			return
 		}
 
+		if !l.AllowWebSocket {
+			proxy_logger.InfoLog.Printf("[%s] websocket transport not allowed, rejecting %s",
+				l.Name, conn.RemoteAddr())
+			_, _ = conn.Write([]byte(BAD_RESPONSE))
+			return
+		}
+
+		clientVersion, err := upgradeWebSocket(conn, buf[:n+4], l.Backend.Version().Bytes())
+		if err != nil {
+			proxy_logger.DebugLog.Printf("websocket upgrade of %s failed: %v", conn.RemoteAddr(), err)
+			return
+		}
+		proxy_logger.InfoLog.Println("bolt over websocket")
+		handleBoltConn(bolt.NewWsConn(conn), clientVersion, l, info)
+
 	} else {
 		// not bolt, not http...something else?
 		proxy_logger.InfoLog.Printf("client %s is speaking gibberish: %#v",
@@ -114,74 +144,86 @@ func HandleClient(conn net.Conn, backend_server *backend.Backend) {
 	}
 }
 
+// Finish the WebSocket upgrade of an HTTP request we've already read into
+// req, then perform the Bolt handshake over the first WebSocket frame.
+// Returns the negotiated Bolt version.
+func upgradeWebSocket(conn net.Conn, req []byte, serverVersion []byte) ([]byte, error) {
+	// The upgrader wants to read the request and write its response, so
+	// give it our buffered request and collect the response separately.
+	response := new(bytes.Buffer)
+	rw := struct {
+		io.Reader
+		io.Writer
+	}{bytes.NewReader(req), response}
+	_, err := ws.Upgrade(rw)
+	if err != nil {
+		return nil, err
+	}
+	_, err = conn.Write(response.Bytes())
+	if err != nil {
+		return nil, err
+	}
+
+	// The bolt signature and version proposals come in a single frame
+	header, err := ws.ReadHeader(conn)
+	if err != nil {
+		return nil, err
+	}
+	if header.Length != 20 {
+		return nil, fmt.Errorf("unexpected bolt handshake frame length %d", header.Length)
+	}
+	handshake := make([]byte, header.Length)
+	_, err = io.ReadFull(conn, handshake)
+	if err != nil {
+		return nil, err
+	}
+	if header.Masked {
+		ws.Cipher(handshake, header.Mask, 0)
+	}
+	if !bytes.Equal(handshake[:4], bolt.BoltSignature[:]) {
+		return nil, errors.New("missing bolt signature in websocket handshake")
+	}
+
+	clientVersion, err := bolt.ValidateHandshake(handshake[4:], serverVersion)
+	if err != nil {
+		return nil, err
+	}
+	err = ws.WriteFrame(conn, ws.NewBinaryFrame(clientVersion))
+	if err != nil {
+		return nil, err
+	}
+	return clientVersion, nil
+}
+
 // Primary Transaction client-side event handler, collecting Messages from
 // the Bolt client and finding ways to switch them to the proper backend.
-func handleBoltConn(client bolt.BoltConn, clientVersion []byte, back *backend.Backend) {
+func handleBoltConn(conn bolt.BoltConn, clientVersion []byte, l *Listener, info backend.ClientInfo) {
+	back, timeouts := l.Backend, l.Timeouts
+	client := &lockedConn{BoltConn: conn}
+	v, _ := bolt.ParseVersion(clientVersion)
+	// Clients over the limit are told so once they say HELLO
+	limited := l.RateLimiter != nil && !l.RateLimiter.AllowConnection(info.Host())
+
 	// Intercept HELLO message for authentication and hold onto it
 	// for use in backend authentication
-	var hello *bolt.Message
 	proxy_logger.InfoLog.Printf("version: %v", clientVersion)
-	proxy_logger.InfoLog.Printf("client: %v", client)
-	select {
-	case msg, ok := <-client.R():
-		if !ok {
-			proxy_logger.DebugLog.Println("failed to read expected Hello from client", msg, ok)
-			return
-		}
-		hello = msg
-	case <-time.After(30 * time.Second):
-		proxy_logger.DebugLog.Println("timed out waiting for client to auth")
+	proxy_logger.InfoLog.Printf("client: %v", conn)
+	hello := nextMessage(client, timeouts.Hello.Duration)
+	if hello == nil {
 		return
 	}
-	proxy_logger.LogMessage("C->P", hello)
 
 	if hello.T != bolt.HelloMsg {
 		proxy_logger.DebugLog.Println("expected HelloMsg, got:", hello.T)
 		return
 	}
 	proxy_logger.DebugLog.Println("expected HelloMsg, got:", hello.T)
-
-	if back.IsAuthEnabled() {
-		err := back.Authenticate(hello)
-		if err != nil {
-			proxy_logger.WarnLog.Printf("not authorized to use proxy: %v", err)
-			// TODO clients wont recognize unless it is specifically from Memgraph
-			errorMsgSerialized, err := bolt.TinyMapToBytes(map[string]interface{}{
-				"code":    "Memgraph.ClientError.Security.Unauthenticated",
-				"message": "Authentication Failure",
-			})
-			if err != nil {
-				proxy_logger.WarnLog.Printf("failed to serialize error message: %v", err)
-			}
-			// TODO all of these header and ends appends to bolt transformation
-			failureData := append([]byte{
-				0x00, 0x57, 0xb1, 0x7f}, errorMsgSerialized...)
-			failureData = append(failureData, []byte{0x00, 0x00}...)
-			failure_msg := bolt.Message{
-				T:    bolt.FailureMsg,
-				Data: failureData,
-			}
-
-			err = client.WriteMessage(&failure_msg)
-			if err != nil {
-				proxy_logger.DebugLog.Printf("failed to write message: %v", err)
-			}
-			return
-		}
-	}
-	server_conn, err := back.InitBoltConnection(hello.Data, "tcp")
-	if err != nil {
-		proxy_logger.DebugLog.Println(err)
+	if limited {
+		proxy_logger.DebugLog.Printf("[%s] too many connections from %s", l.Name, info.RemoteAddr)
+		writeFailure(client, RATE_LIMITED_CODE, "Too many connections, retry later")
 		return
 	}
 
-	v, _ := bolt.ParseVersion(clientVersion)
-	proxy_logger.InfoLog.Printf("authenticated client %s speaking %s to %s server",
-		client, v, back.MainInstance().Host)
-	defer func() {
-		proxy_logger.InfoLog.Printf("goodbye to client %s", client)
-	}()
-
 	// TODO: Replace hardcoded Success message with dynamic one
 	success_msg := bolt.Message{
 		T: bolt.SuccessMsg,
@@ -194,24 +236,148 @@ func handleBoltConn(client bolt.BoltConn, clientVersion []byte, back *backend.Ba
 			0x8d, 0x63, 0x6f, 0x6e, 0x6e, 0x65, 0x63, 0x74, 0x69, 0x6f, 0x6e, 0x5f, 0x69, 0x64,
 			0x86, 0x62, 0x6f, 0x6c, 0x74, 0x2d, 0x34,
 			0x00, 0x00}}
-	proxy_logger.LogMessage("P->C", &success_msg)
-	err = client.WriteMessage(&success_msg)
+
+	// From Bolt 5.1 the credentials follow the HELLO in a LOGON
+	authMsg := hello
+	if v.HasLogon() {
+		proxy_logger.LogMessage("P->C", &success_msg)
+		err := client.WriteMessage(&success_msg)
+		if err != nil {
+			proxy_logger.DebugLog.Printf("failed to write message: %v", err)
+			return
+		}
+		authMsg = nextMessage(client, timeouts.Hello.Duration)
+		if authMsg == nil {
+			return
+		}
+		if authMsg.T != bolt.LogonMsg {
+			proxy_logger.DebugLog.Println("expected LogonMsg, got:", authMsg.T)
+			return
+		}
+	}
+
+	sess := newSession(l, info)
+	sess.audit = newAuditTrail(sess)
+	backendAuth, err := sess.authenticate(authMsg)
+	sess.audit.open(err)
+	if err != nil {
+		proxy_logger.WarnLog.Printf("[%s] client %s: %v", l.Name, info.RemoteAddr, err)
+		writeFailure(client, UNAUTHENTICATED_CODE, "Authentication Failure")
+		return
+	}
+	defer sess.audit.close()
+	identity := sess.identity
+
+	backendHello, backendLogon := backendAuth.Data, []byte(nil)
+	if authMsg != hello {
+		backendHello, backendLogon = hello.Data, backendAuth.Data
+	}
+	server_conn, err := back.InitBoltConnection(backendHello, backendLogon, "tcp")
+	if err != nil {
+		proxy_logger.DebugLog.Println(err)
+		return
+	}
+	if back.HasReplicas() {
+		main := trackConn(server_conn)
+		server_conn = main
+		sess.router = newRouter(main, backendHello, backendLogon, timeouts.Idle.Duration,
+			func(hello, logon []byte) (bolt.BoltConn, string, error) {
+				return back.InitReplicaConnection(hello, logon, "tcp")
+			})
+		defer sess.router.closeReplica()
+	}
+
+	// The authenticator may limit how long the session lasts
+	if identity.SessionTTL > 0 {
+		expiry := time.AfterFunc(identity.SessionTTL, func() {
+			proxy_logger.InfoLog.Printf("[%s] session of client %s expired after %v",
+				l.Name, info.RemoteAddr, identity.SessionTTL)
+			client.Close()
+			server_conn.Close()
+		})
+		defer expiry.Stop()
+	}
+
+	proxy_logger.InfoLog.Printf("authenticated client %s speaking %s to %s server",
+		conn, v, back.MainInstance().Host)
+	defer func() {
+		proxy_logger.InfoLog.Printf("goodbye to client %s", conn)
+	}()
+
+	success := &success_msg
+	if v.HasLogon() {
+		success = &emptySuccess
+	}
+	proxy_logger.LogMessage("P->C", success)
+	err = client.WriteMessage(success)
 	if err != nil {
 		proxy_logger.DebugLog.Fatal(err)
 	}
 
-	proxyListen(client, server_conn, back)
+	proxyListen(client, server_conn, back, timeouts, sess)
+}
+
+// Wait for the client's next message, returning nil if it hangs up or
+// sends nothing within the timeout.
+func nextMessage(client bolt.BoltConn, timeout time.Duration) *bolt.Message {
+	select {
+	case msg, ok := <-client.R():
+		if !ok {
+			proxy_logger.DebugLog.Println("failed to read expected message from client", msg, ok)
+			return nil
+		}
+		proxy_logger.LogMessage("C->P", msg)
+		return msg
+	case <-time.After(timeout):
+		proxy_logger.DebugLog.Println("timed out waiting for client to auth")
+		return nil
+	}
+}
+
+// A FAILURE with the given code and message.
+func failureMessage(code, message string) (*bolt.Message, error) {
+	return bolt.NewMessage(0x7f, map[string]interface{}{
+		"code":    code,
+		"message": message,
+	})
+}
+
+// Send the client a FAILURE with the given code and message.
+func writeFailure(client bolt.BoltConn, code, message string) {
+	failure, err := failureMessage(code, message)
+	if err != nil {
+		proxy_logger.WarnLog.Printf("failed to serialize error message: %v", err)
+		return
+	}
+	writeMessage(client, failure)
+}
+
+func writeMessage(client bolt.BoltConn, msg *bolt.Message) {
+	proxy_logger.LogMessage("P->C", msg)
+	err := client.WriteMessage(msg)
+	if err != nil {
+		proxy_logger.DebugLog.Printf("failed to write message: %v", err)
+	}
 }
 
 // Time to begin the client-side event loop!
-func proxyListen(client bolt.BoltConn, server bolt.BoltConn, back *backend.Backend) {
+func proxyListen(client bolt.BoltConn, server bolt.BoltConn, back *backend.Backend, timeouts config.Timeouts, sess *session) {
 	var (
 		startingTx = false
 		manualTx   = false
-		err        error
+		// After a FAILURE of the proxy's own everything but RESET is
+		// IGNORED, as a server would
+		failed = false
+		// Whether the last message continues in the next chunk
+		inMessage = false
+		err       error
 	)
 	comm_chans := newCommChans(1)
 
+	// Relay what the server answers outside of transactions, e.g. to a
+	// LOGON, until the first transaction starts
+	go handleClientServerCommunication(client, server, &comm_chans, timeouts, sess)
+
 	for {
 		var msg *bolt.Message
 		select {
@@ -229,7 +395,7 @@ func proxyListen(client bolt.BoltConn, server bolt.BoltConn, back *backend.Backe
 				}
 				return
 			}
-		case <-time.After(time.Duration(MAX_IDLE_MINS) * time.Minute):
+		case <-time.After(timeouts.Idle.Duration):
 			proxy_logger.DebugLog.Println("client idle timeout")
 			return
 		}
@@ -239,6 +405,55 @@ func proxyListen(client bolt.BoltConn, server bolt.BoltConn, back *backend.Backe
 			panic("msg is nil")
 		}
 
+		// Only the first chunk says what a message is, the chunks
+		// continuing it are passed on with it whatever they look like
+		continued := inMessage
+		inMessage = !bolt.IsComplete(msg)
+		if continued {
+			msg.T = bolt.ChunkedMsg
+		}
+
+		if failed {
+			switch msg.T {
+			case bolt.ResetMsg:
+				failed = false
+			case bolt.GoodbyeMsg:
+			case bolt.ChunkedMsg:
+				// the rest of a refused message, already answered
+				continue
+			default:
+				writeMessage(client, &ignored)
+				continue
+			}
+		}
+
+		// A message split before its tag would reach the server unchecked
+		if !continued && !bolt.IsIdentified(msg) && sess.inspects() {
+			proxy_logger.AuditLog.Printf("[%s] refused unreadable message of %q from %s",
+				sess.listener.Name, sess.principal(), sess.info.RemoteAddr)
+			err = errors.New("unreadable message, its type has to be in its first chunk")
+			sess.audit.refused(msg, manualTx, err)
+			writeFailure(client, FORBIDDEN_CODE, err.Error())
+			startingTx, manualTx, failed = false, false, true
+			continue
+		}
+
+		// Bolt 5.1+ clients may log on again, e.g. with a fresh token
+		switch msg.T {
+		case bolt.LogoffMsg:
+			sess.audit.logoff()
+			sess.logoff()
+		case bolt.LogonMsg:
+			backendLogon, err := sess.authenticate(msg)
+			sess.audit.logon(err)
+			if err != nil {
+				proxy_logger.WarnLog.Printf("[%s] client %s: %v", sess.listener.Name, sess.info.RemoteAddr, err)
+				writeFailure(client, UNAUTHENTICATED_CODE, "Authentication Failure")
+				return
+			}
+			msg = backendLogon
+		}
+
 		// Inspect the client's message to discern transaction state
 		// We need to figure out if a transaction is starting and
 		// what kind of transaction (manual, auto, etc.) it might be.
@@ -255,20 +470,59 @@ func proxyListen(client bolt.BoltConn, server bolt.BoltConn, back *backend.Backe
 			startingTx = false
 		}
 
+		if startingTx && sess.expired() {
+			proxy_logger.InfoLog.Printf("[%s] token of client %s expired", sess.listener.Name, sess.info.RemoteAddr)
+			writeFailure(client, TOKEN_EXPIRED_CODE, "Token expired, log on again")
+			startingTx, manualTx, failed = false, false, true
+			continue
+		}
+		if msg.T == bolt.RunMsg && !sess.allowQuery() {
+			proxy_logger.DebugLog.Printf("[%s] too many queries of %q from %s",
+				sess.listener.Name, sess.principal(), sess.info.RemoteAddr)
+			writeFailure(client, RATE_LIMITED_CODE, "Too many queries, retry later")
+			startingTx, manualTx, failed = false, false, true
+			continue
+		}
+		request := msg
+		msg, err = sess.authorize(msg, msg.T == bolt.RunMsg && !manualTx)
+		if err != nil {
+			proxy_logger.AuditLog.Printf("[%s] refused query of %q from %s: %s",
+				sess.listener.Name, sess.principal(), sess.info.RemoteAddr, proxy_logger.Redact(err.Error()))
+			sess.audit.refused(request, manualTx, err)
+			writeFailure(client, FORBIDDEN_CODE, err.Error())
+			startingTx, manualTx, failed = false, false, true
+			continue
+		}
+
+		// Auto-commit queries that only read may go to a replica
+		if sess.router != nil {
+			if conn := sess.router.route(msg, msg.T == bolt.RunMsg && !manualTx); conn != server {
+				server = conn
+				if !startingTx {
+					// the answers come from the other connection now
+					haltTxHandler(&comm_chans, timeouts)
+					comm_chans = newCommChans(1)
+					go handleClientServerCommunication(client, server, &comm_chans, timeouts, sess)
+				}
+			}
+		}
+
 		// XXX: This is a mess, but if we're starting a new transaction
 		// we need to find a new connection to switch to
 		proxy_logger.DebugLog.Printf("the incoming client message %v is manual: %t and startingTx: %t", msg.T, manualTx, startingTx)
 		if startingTx {
-			startNewTx(msg, server, back, &comm_chans)
+			startNewTx(msg, server, back, &comm_chans, timeouts)
 			comm_chans = newCommChans(1)
 
 			// kick off a new tx handler routine
-			go handleClientServerCommunication(client, server, &comm_chans)
+			go handleClientServerCommunication(client, server, &comm_chans, timeouts, sess)
 			startingTx = false
 		}
 
 		// TODO: this connected/not-connected handling looks messy
 		if server != nil {
+			// before the server can answer it
+			sess.audit.sent(msg, manualTx)
 			err = server.WriteMessage(msg)
 			if err != nil {
 				// TODO: figure out best way to handle failed writes
@@ -302,7 +556,7 @@ func proxyListen(client bolt.BoltConn, server bolt.BoltConn, back *backend.Backe
 	}
 }
 
-func startNewTx(msg *bolt.Message, server bolt.BoltConn, back *backend.Backend, comm_chans *CommunicationChannels) {
+func startNewTx(msg *bolt.Message, server bolt.BoltConn, back *backend.Backend, comm_chans *CommunicationChannels, timeouts config.Timeouts) {
 	var err error
 
 	var n int
@@ -340,24 +594,29 @@ func startNewTx(msg *bolt.Message, server bolt.BoltConn, back *backend.Backend,
 	// Are we already using a host? If so try to stop the
 	// current tx handler before we create a new one
 	if server != nil {
-		select {
-		case comm_chans.halt <- true:
-			proxy_logger.DebugLog.Println("...asking current tx handler to halt")
-			select {
-			case <-comm_chans.ack:
-				proxy_logger.DebugLog.Println("tx handler ack'd stop")
-			case <-time.After(5 * time.Second):
-				proxy_logger.DebugLog.Println("timeout waiting for ack from tx handler")
-			}
-		default:
-			// this shouldn't happen!
-			panic("couldn't send halt to tx handler!")
-		}
+		haltTxHandler(comm_chans, timeouts)
 	}
 
 	proxy_logger.DebugLog.Printf("grabbed conn for access to memgraph on host %s", host)
 }
 
+// Ask the current tx handler to stop and wait for it to acknowledge.
+func haltTxHandler(comm_chans *CommunicationChannels, timeouts config.Timeouts) {
+	select {
+	case comm_chans.halt <- true:
+		proxy_logger.DebugLog.Println("...asking current tx handler to halt")
+		select {
+		case <-comm_chans.ack:
+			proxy_logger.DebugLog.Println("tx handler ack'd stop")
+		case <-time.After(timeouts.Halt.Duration):
+			proxy_logger.DebugLog.Println("timeout waiting for ack from tx handler")
+		}
+	default:
+		// this shouldn't happen!
+		panic("couldn't send halt to tx handler!")
+	}
+}
+
 // Primary Transaction server-side event handler, collecting Messages from
 // the backend Bolt server and writing them to the given client.
 //
@@ -370,7 +629,7 @@ func startNewTx(msg *bolt.Message, server bolt.BoltConn, back *backend.Backend,
 // halt: used by an external routine to request this handler to cleanly
 //       stop execution
 //
-func handleClientServerCommunication(client, server bolt.BoltConn, comm_chans *CommunicationChannels) {
+func handleClientServerCommunication(client, server bolt.BoltConn, comm_chans *CommunicationChannels, timeouts config.Timeouts, sess *session) {
 	finished := false
 
 	for !finished {
@@ -378,6 +637,8 @@ func handleClientServerCommunication(client, server bolt.BoltConn, comm_chans *C
 		case msg, ok := <-server.R():
 			if ok {
 				proxy_logger.LogMessage("P<-S", msg)
+				msg = sess.checkSummary(msg)
+				sess.audit.received(msg)
 				err := client.WriteMessage(msg)
 				if err != nil {
 					panic(err)
@@ -397,7 +658,7 @@ func handleClientServerCommunication(client, server bolt.BoltConn, comm_chans *C
 		case <-comm_chans.halt:
 			finished = true
 
-		case <-time.After(time.Duration(MAX_IDLE_MINS) * time.Minute):
+		case <-time.After(timeouts.Idle.Duration):
 			proxy_logger.DebugLog.Println("timeout reading server!")
 			finished = true
 		}
@@ -0,0 +1,91 @@
+/*
+Copyright (c) 2021 Memgraph Ltd. [https://memgraph.com]
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package frontend
+
+import (
+	"net"
+
+	"github.com/memgraph/bolt-proxy/audit"
+	"github.com/memgraph/bolt-proxy/backend"
+	"github.com/memgraph/bolt-proxy/config"
+	"github.com/memgraph/bolt-proxy/proxy_logger"
+)
+
+// Policy shared by every client accepted on one frontend listener: which
+// transports are allowed, how clients authenticate and where their
+// traffic is proxied to.
+type Listener struct {
+	Name     string
+	Backend  *backend.Backend
+	Auth     backend.Authenticator
+	Timeouts config.Timeouts
+	// Credentials sent to the backend for authenticated clients, nil to
+	// forward their own
+	Credentials *backend.CredentialMapper
+	// Decides which queries clients may run, nil to allow any
+	Authorizer *backend.Authorizer
+	// Consulted for every query the Authorizer allows, if not nil
+	Policy backend.PolicyDecisionPoint
+	// The only queries clients may run, if not nil
+	Allowlist *backend.QueryAllowlist
+	// Limits new connections and queries, if not nil
+	RateLimiter *backend.RateLimiter
+	// Records sessions and their queries, if not nil
+	Audit *audit.Logger
+	// The configured auth method, for the audit log
+	AuthMethod string
+
+	AllowBolt      bool
+	AllowWebSocket bool
+
+	// How a verified client certificate takes part in authentication
+	CertAuth      string
+	PrincipalFrom string
+}
+
+func NewListener(conf config.Listener, back *backend.Backend, auth backend.Authenticator, timeouts config.Timeouts) *Listener {
+	return &Listener{
+		Name:           conf.Name,
+		Backend:        back,
+		Auth:           auth,
+		Timeouts:       timeouts,
+		AllowBolt:      conf.Allows(config.TRANSPORT_BOLT),
+		AllowWebSocket: conf.Allows(config.TRANSPORT_WEBSOCKET),
+		CertAuth:       conf.TLS.CertAuth,
+		PrincipalFrom:  conf.TLS.PrincipalFrom,
+	}
+}
+
+func (l *Listener) IsAuthEnabled() bool {
+	return l.Auth != nil || (l.CertAuth != config.CERT_AUTH_IGNORE && l.CertAuth != "")
+}
+
+// Accept connections on ln, handing each client off to its own go routine.
+// Only returns if ln gets closed.
+func (l *Listener) Serve(ln net.Listener) error {
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			if ne, ok := err.(net.Error); ok && ne.Temporary() {
+				proxy_logger.WarnLog.Printf("[%s] error: %v\n", l.Name, err)
+				continue
+			}
+			return err
+		}
+		go HandleClient(conn, l)
+	}
+}
@@ -0,0 +1,102 @@
+/*
+Copyright (c) 2021 Memgraph Ltd. [https://memgraph.com]
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package frontend
+
+import (
+	"bufio"
+	"bytes"
+	"net"
+	"net/http"
+	"testing"
+
+	"github.com/gobwas/ws"
+	"github.com/memgraph/bolt-proxy/bolt"
+	"github.com/memgraph/bolt-proxy/config"
+)
+
+func TestNewListenerTransports(t *testing.T) {
+	l := NewListener(config.Listener{
+		Name:       "internal",
+		Transports: []string{config.TRANSPORT_BOLT},
+	}, nil, nil, config.Default().Timeouts)
+
+	if !l.AllowBolt || l.AllowWebSocket {
+		t.Fatalf("expected only bolt to be allowed: %#v", l)
+	}
+	if l.IsAuthEnabled() {
+		t.Fatal("expected auth to be disabled without an authenticator")
+	}
+}
+
+func TestUpgradeWebSocket(t *testing.T) {
+	client, server := net.Pipe()
+	defer client.Close()
+	defer server.Close()
+
+	req := []byte("GET / HTTP/1.1\r\n" +
+		"Host: localhost\r\n" +
+		"Upgrade: websocket\r\n" +
+		"Connection: Upgrade\r\n" +
+		"Sec-WebSocket-Key: dGhlIHNhbXBsZSBub25jZQ==\r\n" +
+		"Sec-WebSocket-Version: 13\r\n\r\n")
+	serverVersion := []byte{0x00, 0x00, 0x03, 0x04}
+
+	type result struct {
+		version []byte
+		err     error
+	}
+	done := make(chan result)
+	go func() {
+		version, err := upgradeWebSocket(server, req, serverVersion)
+		done <- result{version, err}
+	}()
+
+	reader := bufio.NewReader(client)
+	resp, err := http.ReadResponse(reader, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusSwitchingProtocols {
+		t.Fatalf("expected protocol switch, got %s", resp.Status)
+	}
+
+	handshake := append(bolt.BoltSignature[:], []byte{
+		0x00, 0x00, 0x03, 0x04,
+		0x00, 0x00, 0x00, 0x00,
+		0x00, 0x00, 0x00, 0x00,
+		0x00, 0x00, 0x00, 0x00}...)
+	err = ws.WriteFrame(client, ws.MaskFrame(ws.NewBinaryFrame(handshake)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	frame, err := ws.ReadFrame(reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(frame.Payload, serverVersion) {
+		t.Fatalf("expected version %#v, got %#v", serverVersion, frame.Payload)
+	}
+
+	res := <-done
+	if res.err != nil {
+		t.Fatal(res.err)
+	}
+	if !bytes.Equal(res.version, serverVersion) {
+		t.Fatalf("expected negotiated version %#v, got %#v", serverVersion, res.version)
+	}
+}
@@ -0,0 +1,219 @@
+/*
+Copyright (c) 2021 Memgraph Ltd. [https://memgraph.com]
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package frontend
+
+import (
+	"sync/atomic"
+	"time"
+
+	"github.com/memgraph/bolt-proxy/bolt"
+	"github.com/memgraph/bolt-proxy/cypher"
+	"github.com/memgraph/bolt-proxy/metrics"
+	"github.com/memgraph/bolt-proxy/proxy_logger"
+)
+
+var routedQueries = metrics.NewCounter("bolt_proxy_routed_queries_total",
+	"Auto-commit queries by the instance they were sent to (main or replica).", "instance")
+
+// How often to check whether a connection answered everything it was asked
+const IDLE_POLL_INTERVAL = 5 * time.Millisecond
+
+// A server connection counting the requests it has yet to answer, so the
+// proxy can tell when switching to another connection won't mix up the
+// answers of both.
+type trackedConn struct {
+	bolt.BoltConn
+	r    chan *bolt.Message
+	done chan struct{}
+	// requests sent without a summary back yet
+	pending int32
+	// 1 once the server hung up
+	closed int32
+}
+
+func trackConn(conn bolt.BoltConn) *trackedConn {
+	c := &trackedConn{BoltConn: conn, r: make(chan *bolt.Message), done: make(chan struct{})}
+	go func() {
+		defer close(c.r)
+		for msg := range conn.R() {
+			select {
+			case c.r <- msg:
+			case <-c.done:
+				return
+			}
+			// once the relay has it, it reaches the client before
+			// anything the relay of another connection gets
+			switch msg.T {
+			case bolt.SuccessMsg, bolt.FailureMsg, bolt.IgnoreMsg:
+				atomic.AddInt32(&c.pending, -1)
+			}
+		}
+		atomic.StoreInt32(&c.closed, 1)
+	}()
+	return c
+}
+
+func (c *trackedConn) Close() error {
+	select {
+	case <-c.done:
+	default:
+		close(c.done)
+	}
+	return c.BoltConn.Close()
+}
+
+func (c *trackedConn) R() <-chan *bolt.Message {
+	return c.r
+}
+
+// Every request but GOODBYE gets exactly one summary. The chunks after the
+// first of a large message aren't requests of their own.
+func (c *trackedConn) WriteMessage(m *bolt.Message) error {
+	request := m.T != bolt.ChunkedMsg && m.T != bolt.GoodbyeMsg
+	if request {
+		atomic.AddInt32(&c.pending, 1)
+	}
+	err := c.BoltConn.WriteMessage(m)
+	if err != nil && request {
+		atomic.AddInt32(&c.pending, -1)
+	}
+	return err
+}
+
+func (c *trackedConn) isClosed() bool {
+	return atomic.LoadInt32(&c.closed) == 1
+}
+
+// Wait for the server to answer every request, at most timeout.
+func (c *trackedConn) waitIdle(timeout time.Duration) bool {
+	deadline := time.Now().Add(timeout)
+	for atomic.LoadInt32(&c.pending) > 0 && !c.isClosed() {
+		if time.Now().After(deadline) {
+			return false
+		}
+		time.Sleep(IDLE_POLL_INTERVAL)
+	}
+	return true
+}
+
+// Picks the connection each message of a client goes to: auto-commit
+// queries that only read go to a replica, everything else to the main
+// instance.
+type router struct {
+	main    *trackedConn
+	replica *trackedConn
+	// the connection messages currently go to
+	current *trackedConn
+	// How long to wait for a connection to answer everything before
+	// switching away from it
+	wait time.Duration
+
+	// What the main instance was authenticated with, for the replicas
+	hello, logon []byte
+	dial         func(hello, logon []byte) (bolt.BoltConn, string, error)
+}
+
+func newRouter(main *trackedConn, hello, logon []byte, wait time.Duration,
+	dial func(hello, logon []byte) (bolt.BoltConn, string, error)) *router {
+	return &router{main: main, current: main, wait: wait, hello: hello, logon: logon, dial: dial}
+}
+
+// The connection to send msg to. Connections are only switched once the
+// current one answered everything, so the client gets the answers in
+// order; a query that may go to a replica stays on the main instance
+// otherwise.
+func (r *router) route(msg *bolt.Message, autoCommit bool) *trackedConn {
+	switch {
+	case msg.T == bolt.LogonMsg || msg.T == bolt.LogoffMsg:
+		// the replica connection is authenticated anew when needed
+		r.switchTo(r.main)
+		r.closeReplica()
+		if msg.T == bolt.LogonMsg {
+			r.logon = msg.Data
+		}
+	case msg.T == bolt.BeginMsg:
+		r.switchTo(r.main)
+	case msg.T == bolt.RunMsg && autoCommit:
+		target, instance := r.main, "main"
+		if readOnlyRun(msg) && (r.current != r.main || r.main.waitIdle(r.wait)) {
+			if replica := r.replicaConn(); replica != nil {
+				target, instance = replica, "replica"
+			}
+		}
+		r.switchTo(target)
+		routedQueries.Inc(instance)
+	}
+	return r.current
+}
+
+func (r *router) switchTo(conn *trackedConn) {
+	if r.current != conn && !r.current.waitIdle(r.wait) {
+		proxy_logger.WarnLog.Printf("switching connections before all answers arrived")
+	}
+	r.current = conn
+}
+
+// The connection to a replica, connecting to one if needed. Nil if none
+// can be reached.
+func (r *router) replicaConn() *trackedConn {
+	if r.replica != nil && r.replica.isClosed() {
+		r.closeReplica()
+	}
+	if r.replica == nil {
+		conn, address, err := r.dial(r.hello, r.logon)
+		if err != nil {
+			proxy_logger.WarnLog.Printf("no replica available, reading from main: %v", err)
+			return nil
+		}
+		proxy_logger.DebugLog.Printf("connected to replica %s", address)
+		r.replica = trackConn(conn)
+	}
+	return r.replica
+}
+
+func (r *router) closeReplica() {
+	if r.replica != nil {
+		r.replica.Close()
+		r.replica = nil
+	}
+}
+
+// Whether an auto-commit RUN surely only reads: its query has nothing but
+// reading clauses, and only calls procedures if the client asked for read
+// mode. Anything the proxy can't tell, e.g. of a query too large for a
+// single chunk, is taken for a write, and so are queries with bookmarks a
+// replica may not have caught up with yet.
+func readOnlyRun(run *bolt.Message) bool {
+	mode, err := bolt.MetadataString(run, "mode")
+	if err == bolt.ErrNoMetadata {
+		// Bolt v1 and v2
+		mode, err = "", nil
+	}
+	if err != nil {
+		return false
+	}
+	if bookmarks, _ := bolt.MetadataValue(run, "bookmarks"); len(bookmarks) > 0 && bookmarks[0] != 0x90 {
+		return false
+	}
+	query, _, err := bolt.ParseString(run.Data[4:])
+	if err != nil {
+		return false
+	}
+
+	q := cypher.Analyze(query)
+	return q.ReadOnly() && (len(q.Procedures) == 0 || mode == "r")
+}
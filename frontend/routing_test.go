@@ -0,0 +1,141 @@
+/*
+Copyright (c) 2021 Memgraph Ltd. [https://memgraph.com]
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package frontend
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/memgraph/bolt-proxy/backend"
+	"github.com/memgraph/bolt-proxy/bolt"
+	"github.com/memgraph/bolt-proxy/config"
+)
+
+func TestReadOnlyRun(t *testing.T) {
+	none := map[string]interface{}{}
+	tests := []struct {
+		query    string
+		metadata map[string]interface{}
+		readOnly bool
+	}{
+		{"MATCH (n) RETURN n", none, true},
+		{"MATCH (n) SET n.x = 1", none, false},
+		{"SHOW STORAGE INFO", none, false},
+		// procedures may write unless the client asks for read mode
+		{"CALL mg.procedures() YIELD name RETURN name", none, false},
+		{"CALL mg.procedures() YIELD name RETURN name", map[string]interface{}{"mode": "r"}, true},
+		{"CREATE (n)", map[string]interface{}{"mode": "r"}, false},
+		// a replica may not have seen the bookmarked writes yet
+		{"MATCH (n) RETURN n", map[string]interface{}{"bookmarks": []interface{}{"bm:1"}}, false},
+		{"MATCH (n) RETURN n", map[string]interface{}{"bookmarks": []interface{}{}}, true},
+	}
+	for _, test := range tests {
+		run := message(t, 0x10, test.query, none, test.metadata)
+		if readOnly := readOnlyRun(run); readOnly != test.readOnly {
+			t.Errorf("%s %v: expected read only %t", test.query, test.metadata, test.readOnly)
+		}
+	}
+
+	// Bolt v1 and v2 RUN messages have no metadata
+	if !readOnlyRun(message(t, 0x10, "MATCH (n) RETURN n", none)) {
+		t.Error("expected a legacy RUN to be read only")
+	}
+}
+
+// A fakeConn answering every request with an empty SUCCESS.
+type answeringConn struct {
+	*fakeConn
+}
+
+func (c answeringConn) WriteMessage(m *bolt.Message) error {
+	_ = c.fakeConn.WriteMessage(m)
+	c.r <- &emptySuccess
+	return nil
+}
+
+func types(messages []*bolt.Message) []bolt.Type {
+	var types []bolt.Type
+	for _, msg := range messages {
+		types = append(types, msg.T)
+	}
+	return types
+}
+
+func TestRoutingToReplica(t *testing.T) {
+	timeouts := config.Default().Timeouts
+	back, err := backend.NewBackend(config.Backend{URI: "bolt://localhost:7687"}, config.Pool{}, timeouts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	l := NewListener(config.Listener{Name: "test"}, back, nil, timeouts)
+	sess := newSession(l, backend.ClientInfo{})
+
+	main, replica := answeringConn{newFakeConn()}, answeringConn{newFakeConn()}
+	dials := 0
+	trackedMain := trackConn(main)
+	sess.router = newRouter(trackedMain, []byte("hello"), nil, time.Second,
+		func(hello, logon []byte) (bolt.BoltConn, string, error) {
+			if string(hello) != "hello" {
+				return nil, "", errors.New("unexpected hello")
+			}
+			dials++
+			return replica, "replica:7687", nil
+		})
+
+	none := map[string]interface{}{}
+	client := newFakeConn()
+	client.r <- message(t, 0x10, "MATCH (n) RETURN n", none, none)
+	client.r <- message(t, 0x3f, map[string]interface{}{"n": -1})
+	client.r <- message(t, 0x10, "CREATE (n)", none, none)
+	client.r <- message(t, 0x3f, map[string]interface{}{"n": -1})
+	client.r <- message(t, 0x10, "MATCH (n) RETURN count(n)", none, none)
+	client.r <- message(t, 0x3f, map[string]interface{}{"n": -1})
+	client.r <- message(t, 0x11, none)
+	client.r <- message(t, 0x10, "MATCH (n) RETURN n", none, none)
+	client.r <- message(t, 0x3f, map[string]interface{}{"n": -1})
+	client.r <- message(t, 0x12)
+	done := make(chan struct{})
+	go func() {
+		proxyListen(client, trackedMain, back, timeouts, sess)
+		close(done)
+	}()
+
+	// the client hangs up once it has all the answers
+	deadline := time.Now().Add(5 * time.Second)
+	for len(client.messages()) < 10 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	close(client.r)
+	<-done
+	if answers := client.messages(); len(answers) != 10 {
+		t.Fatalf("expected every request to be answered, got %v", types(answers))
+	}
+
+	expectedMain := []bolt.Type{bolt.RunMsg, bolt.PullMsg, bolt.BeginMsg, bolt.RunMsg, bolt.PullMsg, bolt.CommitMsg}
+	if got := types(main.messages()); !reflect.DeepEqual(got, expectedMain) {
+		t.Errorf("expected %v on main, got %v", expectedMain, got)
+	}
+	expectedReplica := []bolt.Type{bolt.RunMsg, bolt.PullMsg, bolt.RunMsg, bolt.PullMsg}
+	if got := types(replica.messages()); !reflect.DeepEqual(got, expectedReplica) {
+		t.Errorf("expected %v on the replica, got %v", expectedReplica, got)
+	}
+	if dials != 1 {
+		t.Errorf("expected the replica connection to be reused, got %d dials", dials)
+	}
+}
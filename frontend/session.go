@@ -0,0 +1,352 @@
+/*
+Copyright (c) 2021 Memgraph Ltd. [https://memgraph.com]
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package frontend
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/memgraph/bolt-proxy/backend"
+	"github.com/memgraph/bolt-proxy/bolt"
+	"github.com/memgraph/bolt-proxy/config"
+	"github.com/memgraph/bolt-proxy/proxy_logger"
+)
+
+// Codes of the FAILUREs the proxy sends itself
+const (
+	// TODO clients wont recognize unless it is specifically from Memgraph
+	UNAUTHENTICATED_CODE = "Memgraph.ClientError.Security.Unauthenticated"
+	// Drivers recognize this one and refresh their token, if they can
+	TOKEN_EXPIRED_CODE = "Neo.ClientError.Security.TokenExpired"
+	// A query the client's roles don't allow
+	FORBIDDEN_CODE = "Neo.ClientError.Security.Forbidden"
+	// Too many connections or queries, drivers retry transient errors
+	// with backoff
+	RATE_LIMITED_CODE = "Neo.TransientError.Request.RateLimited"
+)
+
+var (
+	// SUCCESS without any metadata
+	emptySuccess = bolt.Message{
+		T:    bolt.SuccessMsg,
+		Data: []byte{0x00, 0x03, 0xb1, 0x70, 0xa0, 0x00, 0x00},
+	}
+	ignored = bolt.Message{
+		T:    bolt.IgnoreMsg,
+		Data: []byte{0x00, 0x02, 0xb0, 0x7e, 0x00, 0x00},
+	}
+	rollback = bolt.Message{
+		T:    bolt.RollbackMsg,
+		Data: []byte{0x00, 0x02, 0xb0, 0x13, 0x00, 0x00},
+	}
+)
+
+// Who a client connection is authenticated as. Bolt 5.1+ clients can
+// change it with LOGOFF and LOGON without reconnecting.
+type session struct {
+	listener *Listener
+	info     backend.ClientInfo
+	// nil while logged off
+	identity *backend.Identity
+	// nil if the listener doesn't authorize queries
+	permissions *backend.Permissions
+	// How many queries the client may run, going by its roles
+	queryLimit config.RateLimit
+	// Of the current explicit transaction
+	database string
+	// 1 while the client's roles only allow reading, read by the server
+	// side of the session too
+	readOnly int32
+	// Picks the main instance or a replica for each message, nil without
+	// replicas
+	router *router
+	// nil if the listener has no audit log
+	audit *auditTrail
+	now   func() time.Time
+}
+
+func newSession(l *Listener, info backend.ClientInfo) *session {
+	return &session{listener: l, info: info, now: time.Now}
+}
+
+// Authenticate the client with its HELLO or LOGON, returning the message
+// to authenticate with the backend in its place.
+func (s *session) authenticate(msg *bolt.Message) (*bolt.Message, error) {
+	l := s.listener
+	identity := &backend.Identity{}
+	if l.IsAuthEnabled() {
+		var err error
+		identity, err = backend.Authenticate(l.Auth, l.CertAuth, msg, s.info)
+		if err != nil {
+			return nil, fmt.Errorf("not authorized to use proxy: %v", err)
+		}
+		proxy_logger.InfoLog.Printf("[%s] client %s authenticated as %q",
+			l.Name, s.info.RemoteAddr, identity.Principal)
+	}
+
+	backendMsg, err := backend.BackendAuthMessage(l.Credentials, msg, identity)
+	if err != nil {
+		return nil, fmt.Errorf("no backend credentials: %v", err)
+	}
+	s.identity = identity
+	if l.Authorizer != nil {
+		s.setPermissions(l.Authorizer.Permissions(identity))
+	}
+	if l.RateLimiter != nil {
+		var roles []string
+		if s.permissions != nil {
+			roles = s.permissions.Roles
+		}
+		s.queryLimit = l.RateLimiter.QueryLimit(roles)
+	}
+	return backendMsg, nil
+}
+
+func (s *session) logoff() {
+	s.identity = nil
+	if s.permissions != nil {
+		s.setPermissions(&backend.Permissions{})
+	}
+}
+
+func (s *session) setPermissions(permissions *backend.Permissions) {
+	s.permissions = permissions
+	var readOnly int32
+	if permissions.Access == config.ACCESS_READ {
+		readOnly = 1
+	}
+	atomic.StoreInt32(&s.readOnly, readOnly)
+}
+
+func (s *session) isReadOnly() bool {
+	return atomic.LoadInt32(&s.readOnly) == 1
+}
+
+// Who the client is logged on as, "" while logged off.
+func (s *session) principal() string {
+	if s.identity == nil {
+		return ""
+	}
+	return s.identity.Principal
+}
+
+// Whether the client may run another query, counted against its principal
+// or, without one, its address.
+func (s *session) allowQuery() bool {
+	if s.listener.RateLimiter == nil {
+		return true
+	}
+	key := s.principal()
+	if key == "" {
+		key = s.info.Host()
+	}
+	return s.listener.RateLimiter.AllowQuery(key, s.queryLimit)
+}
+
+// Whether the proxy looks into the client's messages, so it has to be able
+// to tell what each one is.
+func (s *session) inspects() bool {
+	l := s.listener
+	return s.permissions != nil || l.Policy != nil || l.Allowlist != nil ||
+		l.RateLimiter != nil || s.audit != nil
+}
+
+// Check a message against the client's roles and the listener's policy,
+// returning what to send the server in its place. Read-only sessions get
+// mode "r" forced into BEGIN and auto-commit RUN messages, and their
+// COMMITs become ROLLBACKs since there's nothing to commit.
+func (s *session) authorize(msg *bolt.Message, autoCommit bool) (*bolt.Message, error) {
+	if s.permissions == nil && s.listener.Policy == nil && s.listener.Allowlist == nil {
+		return msg, nil
+	}
+	switch msg.T {
+	case bolt.BeginMsg:
+		s.database, _ = bolt.MetadataString(msg, "db")
+		if s.permissions == nil {
+			break
+		}
+		if err := s.permissions.AllowBegin(); err != nil {
+			return nil, err
+		}
+		if s.isReadOnly() {
+			return readMode(msg)
+		}
+	case bolt.RunMsg:
+		if s.listener.Allowlist != nil {
+			var err error
+			msg, err = s.resolveQuery(msg)
+			if err != nil {
+				return nil, err
+			}
+		}
+		// only the first chunk of a large RUN is seen here, proxyListen
+		// makes sure it holds the tag; queries that don't fit in it are
+		// refused
+		query, _, err := bolt.ParseString(msg.Data[4:])
+		if err != nil {
+			return nil, fmt.Errorf("unreadable query: %v", err)
+		}
+		if s.permissions != nil {
+			if err := s.permissions.Allow(query); err != nil {
+				return nil, err
+			}
+		}
+		if s.listener.Policy != nil {
+			if err := s.admit(msg, query, autoCommit); err != nil {
+				return nil, err
+			}
+		}
+		if autoCommit && s.isReadOnly() {
+			return readMode(msg)
+		}
+	case bolt.CommitMsg:
+		if s.isReadOnly() {
+			return &rollback, nil
+		}
+	}
+	return msg, nil
+}
+
+// Check the query of a RUN against the listener's allowlist, replacing a
+// query id with the approved query.
+func (s *session) resolveQuery(run *bolt.Message) (*bolt.Message, error) {
+	// a query continuing past the first chunk can't be approved
+	query, _, err := bolt.ParseString(run.Data[4:])
+	if err != nil {
+		return nil, fmt.Errorf("unreadable query: %v", err)
+	}
+	// messages too large to read the metadata from can only be approved
+	// by their query
+	id, _ := bolt.MetadataString(run, backend.QUERY_ID_KEY)
+	approved, err := s.listener.Allowlist.Resolve(query, id)
+	if err != nil || id == "" {
+		return run, err
+	}
+
+	run, err = bolt.DeleteMetadata(run, backend.QUERY_ID_KEY)
+	if err != nil {
+		return nil, err
+	}
+	return bolt.SetQuery(run, approved)
+}
+
+// Ask the listener's policy whether the client may run the query.
+func (s *session) admit(run *bolt.Message, query string, autoCommit bool) error {
+	input := &backend.PolicyInput{Query: query, Database: s.database}
+	if s.identity != nil {
+		input.Principal = s.identity.Principal
+		input.Claims = s.identity.Claims
+	}
+	if s.permissions != nil {
+		input.Roles = s.permissions.Roles
+	}
+	if s.info.RemoteAddr != nil {
+		input.ClientAddress = s.info.RemoteAddr.String()
+	}
+	if autoCommit {
+		input.Database, _ = bolt.MetadataString(run, "db")
+	}
+	// left out of messages too large to read them from, e.g. bulk imports
+	names, err := bolt.ParameterNames(run)
+	if err == nil {
+		input.Parameters = names
+	} else if err != bolt.ErrNoMetadata {
+		proxy_logger.DebugLog.Printf("[%s] unreadable parameters: %v", s.listener.Name, err)
+	}
+
+	decision, err := s.listener.Policy.Decide(input)
+	if err != nil {
+		return err
+	}
+	if !decision.Allow && decision.Reason != "" {
+		return fmt.Errorf("refused by policy: %s", decision.Reason)
+	}
+	if !decision.Allow {
+		return errors.New("refused by policy")
+	}
+	return nil
+}
+
+// Force mode "r" into a BEGIN or RUN, refusing one asking for write mode.
+func readMode(msg *bolt.Message) (*bolt.Message, error) {
+	mode, err := bolt.MetadataString(msg, "mode")
+	if err == bolt.ErrNoMetadata {
+		// Bolt v1 and v2 have no access modes
+		return msg, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("unreadable metadata: %v", err)
+	}
+	switch mode {
+	case "r":
+		return msg, nil
+	case "":
+		return bolt.SetMetadata(msg, "mode", "r")
+	default:
+		return nil, fmt.Errorf("read-only sessions can't use mode %q", mode)
+	}
+}
+
+// Check the server's summary of a query in a read-only session: one that
+// wrote anything or changed the schema is replaced with a FAILURE, so the
+// client resets and an explicit transaction is rolled back. Auto-commit
+// queries are committed by then, which is only logged.
+func (s *session) checkSummary(msg *bolt.Message) *bolt.Message {
+	if msg.T != bolt.SuccessMsg || !s.isReadOnly() {
+		return msg
+	}
+	queryType, err := bolt.MetadataString(msg, "type")
+	if err != nil {
+		proxy_logger.DebugLog.Printf("[%s] unreadable summary: %v", s.listener.Name, err)
+		return msg
+	}
+	switch queryType {
+	case "", "r":
+		return msg
+	}
+
+	proxy_logger.AuditLog.Printf("[%s] read-only session of %q from %s ran a query of type %q",
+		s.listener.Name, s.principal(), s.info.RemoteAddr, queryType)
+	failure, err := failureMessage(FORBIDDEN_CODE, "Read-only sessions can't write")
+	if err != nil {
+		proxy_logger.WarnLog.Printf("failed to serialize error message: %v", err)
+		return msg
+	}
+	return failure
+}
+
+// Whether the client's token expired, after which it may not start new
+// transactions until it logs on again.
+func (s *session) expired() bool {
+	expiry, found := s.identity.Expiry()
+	return found && !s.now().Before(expiry)
+}
+
+// Serializes writes to a client, which both sides of a session write to.
+type lockedConn struct {
+	bolt.BoltConn
+	mu sync.Mutex
+}
+
+func (c *lockedConn) WriteMessage(m *bolt.Message) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.BoltConn.WriteMessage(m)
+}
@@ -0,0 +1,664 @@
+/*
+Copyright (c) 2021 Memgraph Ltd. [https://memgraph.com]
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package frontend
+
+import (
+	"errors"
+	"io/ioutil"
+	"log"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/memgraph/bolt-proxy/backend"
+	"github.com/memgraph/bolt-proxy/bolt"
+	"github.com/memgraph/bolt-proxy/config"
+	"github.com/memgraph/bolt-proxy/proxy_logger"
+)
+
+func TestMain(m *testing.M) {
+	proxy_logger.DebugLog = log.New(ioutil.Discard, "", 0)
+	proxy_logger.InfoLog = log.New(ioutil.Discard, "", 0)
+	proxy_logger.WarnLog = log.New(ioutil.Discard, "", 0)
+	proxy_logger.AuditLog = log.New(ioutil.Discard, "", 0)
+	os.Exit(m.Run())
+}
+
+// A BoltConn fed through a channel, recording what is written to it.
+type fakeConn struct {
+	r chan *bolt.Message
+
+	mu      sync.Mutex
+	written []*bolt.Message
+}
+
+func newFakeConn() *fakeConn {
+	return &fakeConn{r: make(chan *bolt.Message, 16)}
+}
+
+func (c *fakeConn) R() <-chan *bolt.Message {
+	return c.r
+}
+
+func (c *fakeConn) WriteMessage(m *bolt.Message) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.written = append(c.written, m)
+	return nil
+}
+
+func (c *fakeConn) Close() error {
+	return nil
+}
+
+func (c *fakeConn) messages() []*bolt.Message {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return append([]*bolt.Message(nil), c.written...)
+}
+
+// Accepts the tokens "old" and "fresh", which expire an hour ago and in an
+// hour.
+type expiringAuth struct{}
+
+func (expiringAuth) Authenticate(token *backend.AuthToken) (*backend.Identity, error) {
+	var exp time.Time
+	switch token.Credentials {
+	case "old":
+		exp = time.Now().Add(-time.Hour)
+	case "fresh":
+		exp = time.Now().Add(time.Hour)
+	default:
+		return nil, errors.New("unauthorized creds")
+	}
+	return &backend.Identity{
+		Principal: "alice",
+		Claims:    map[string]interface{}{"exp": float64(exp.Unix())},
+	}, nil
+}
+
+func message(t *testing.T, tag byte, fields ...interface{}) *bolt.Message {
+	msg, err := bolt.NewMessage(tag, fields...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	msg.T = bolt.IdentifyType(msg.Data)
+	return msg
+}
+
+// A message split the way a BoltConn reads it: the first chunk keeps the
+// message type, the others are ChunkedMsg, the last one ends with 00 00.
+func chunks(msg *bolt.Message) []*bolt.Message {
+	var split []*bolt.Message
+	data := msg.Data
+	for len(data) > 2 {
+		size := 2 + int(data[0])<<8 + int(data[1])
+		if size+2 == len(data) {
+			size = len(data)
+		}
+		chunk := &bolt.Message{T: bolt.ChunkedMsg, Data: make([]byte, size)}
+		copy(chunk.Data, data)
+		if len(split) == 0 {
+			chunk.T = msg.T
+		}
+		split = append(split, chunk)
+		data = data[size:]
+	}
+	return split
+}
+
+// A single chunk message split into two chunks after n bytes of its body,
+// the first one read as typ.
+func splitAt(msg *bolt.Message, n int, typ bolt.Type) []*bolt.Message {
+	body := msg.Data[2 : len(msg.Data)-2]
+	first := append([]byte{byte(n >> 8), byte(n)}, body[:n]...)
+	rest := len(body) - n
+	second := append([]byte{byte(rest >> 8), byte(rest)}, body[n:]...)
+	return []*bolt.Message{
+		{T: typ, Data: first},
+		{T: bolt.ChunkedMsg, Data: append(second, 0x00, 0x00)},
+	}
+}
+
+func logon(t *testing.T, token string) *bolt.Message {
+	return message(t, 0x6a, map[string]interface{}{"scheme": "bearer", "credentials": token})
+}
+
+func TestExpiredTokenAndReauth(t *testing.T) {
+	timeouts := config.Default().Timeouts
+	timeouts.Idle = config.Duration{Duration: time.Second}
+	l := NewListener(config.Listener{Name: "test"}, nil, expiringAuth{}, timeouts)
+	sess := newSession(l, backend.ClientInfo{})
+	if _, err := sess.authenticate(logon(t, "old")); err != nil {
+		t.Fatal(err)
+	}
+	if !sess.expired() {
+		t.Fatal("expected the old token to be expired")
+	}
+
+	client, server := newFakeConn(), newFakeConn()
+	for _, msg := range []*bolt.Message{
+		message(t, 0x10, "RETURN 1", map[string]interface{}{}, map[string]interface{}{}),
+		message(t, 0x3f, map[string]interface{}{"n": -1}),
+		message(t, 0x0f),
+		message(t, 0x6b),
+		logon(t, "fresh"),
+	} {
+		client.r <- msg
+	}
+	close(client.r)
+	proxyListen(client, server, nil, timeouts, sess)
+
+	// the RUN fails, the PULL is ignored until the RESET
+	written := client.messages()
+	if len(written) != 2 || written[0].T != bolt.FailureMsg || written[1].T != bolt.IgnoreMsg {
+		t.Fatalf("expected FAILURE and IGNORED, got %v", written)
+	}
+	failure, _, err := bolt.ParseMap(written[0].Data[4:])
+	if err != nil || failure["code"] != TOKEN_EXPIRED_CODE {
+		t.Fatalf("expected a token expired failure, got %v", failure)
+	}
+
+	// the server only sees the RESET and the new logon
+	forwarded := server.messages()
+	if len(forwarded) != 3 || forwarded[0].T != bolt.ResetMsg ||
+		forwarded[1].T != bolt.LogoffMsg || forwarded[2].T != bolt.LogonMsg {
+		t.Fatalf("unexpected messages to the server %v", forwarded)
+	}
+	if sess.expired() || sess.identity.Principal != "alice" {
+		t.Fatalf("expected a fresh identity, got %#v", sess.identity)
+	}
+}
+
+func TestRefusedChunkedRun(t *testing.T) {
+	l := NewListener(config.Listener{Name: "test"}, nil, expiringAuth{}, config.Default().Timeouts)
+	sess := newSession(l, backend.ClientInfo{})
+	if _, err := sess.authenticate(logon(t, "old")); err != nil {
+		t.Fatal(err)
+	}
+
+	client, server := newFakeConn(), newFakeConn()
+	run := message(t, 0x10, strings.Repeat("x", 2*bolt.MAX_CHUNK_SIZE), map[string]interface{}{}, map[string]interface{}{})
+	for _, chunk := range chunks(run) {
+		client.r <- chunk
+	}
+	client.r <- message(t, 0x3f, map[string]interface{}{"n": -1})
+	client.r <- message(t, 0x0f)
+	close(client.r)
+	proxyListen(client, server, nil, config.Default().Timeouts, sess)
+
+	// one summary for the RUN, however many chunks it took
+	written := client.messages()
+	if len(written) != 2 || written[0].T != bolt.FailureMsg || written[1].T != bolt.IgnoreMsg {
+		t.Fatalf("expected FAILURE and IGNORED, got %v", written)
+	}
+	if forwarded := server.messages(); len(forwarded) != 1 || forwarded[0].T != bolt.ResetMsg {
+		t.Fatalf("expected only the RESET to reach the server, got %v", forwarded)
+	}
+}
+
+func TestReauthFailureEndsSession(t *testing.T) {
+	l := NewListener(config.Listener{Name: "test"}, nil, expiringAuth{}, config.Default().Timeouts)
+	sess := newSession(l, backend.ClientInfo{})
+	if _, err := sess.authenticate(logon(t, "fresh")); err != nil {
+		t.Fatal(err)
+	}
+
+	client, server := newFakeConn(), newFakeConn()
+	client.r <- message(t, 0x6b)
+	client.r <- logon(t, "forged")
+	proxyListen(client, server, nil, config.Default().Timeouts, sess)
+
+	written := client.messages()
+	if len(written) != 1 || written[0].T != bolt.FailureMsg {
+		t.Fatalf("expected a FAILURE, got %v", written)
+	}
+	if forwarded := server.messages(); len(forwarded) != 1 || forwarded[0].T != bolt.LogoffMsg {
+		t.Fatalf("expected only the LOGOFF to reach the server, got %v", forwarded)
+	}
+}
+
+func TestForbiddenQuery(t *testing.T) {
+	authorizer, err := backend.NewAuthorizer(config.Authorization{
+		Roles:        []config.Role{{Name: "reader", Access: config.ACCESS_READ}},
+		DefaultRoles: []string{"reader"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	l := NewListener(config.Listener{Name: "test"}, nil, expiringAuth{}, config.Default().Timeouts)
+	l.Authorizer = authorizer
+	sess := newSession(l, backend.ClientInfo{})
+	if _, err := sess.authenticate(logon(t, "fresh")); err != nil {
+		t.Fatal(err)
+	}
+
+	client, server := newFakeConn(), newFakeConn()
+	client.r <- message(t, 0x10, "MATCH (n) DETACH DELETE n", map[string]interface{}{}, map[string]interface{}{})
+	client.r <- message(t, 0x3f, map[string]interface{}{"n": -1})
+	client.r <- message(t, 0x0f)
+	close(client.r)
+	proxyListen(client, server, nil, config.Default().Timeouts, sess)
+
+	written := client.messages()
+	if len(written) != 2 || written[0].T != bolt.FailureMsg || written[1].T != bolt.IgnoreMsg {
+		t.Fatalf("expected FAILURE and IGNORED, got %v", written)
+	}
+	failure, _, err := bolt.ParseMap(written[0].Data[4:])
+	if err != nil || failure["code"] != FORBIDDEN_CODE {
+		t.Fatalf("expected a forbidden failure, got %v", failure)
+	}
+	if forwarded := server.messages(); len(forwarded) != 1 || forwarded[0].T != bolt.ResetMsg {
+		t.Fatalf("expected only the RESET to reach the server, got %v", forwarded)
+	}
+}
+
+func TestSplitQuery(t *testing.T) {
+	authorizer, err := backend.NewAuthorizer(config.Authorization{
+		Roles:        []config.Role{{Name: "reader", Access: config.ACCESS_READ}},
+		DefaultRoles: []string{"reader"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	l := NewListener(config.Listener{Name: "test"}, nil, expiringAuth{}, config.Default().Timeouts)
+	l.Authorizer = authorizer
+	sess := newSession(l, backend.ClientInfo{})
+	if _, err := sess.authenticate(logon(t, "fresh")); err != nil {
+		t.Fatal(err)
+	}
+
+	// the first chunk only holds the structure marker, the server puts the
+	// RUN back together; read as unknown, or typed by the next chunk's
+	// length
+	run := message(t, 0x10, "MATCH (n) DETACH DELETE n", map[string]interface{}{}, map[string]interface{}{})
+	for _, typ := range []bolt.Type{bolt.UnknownMsg, bolt.RunMsg} {
+		client, server := newFakeConn(), newFakeConn()
+		for _, chunk := range splitAt(run, 1, typ) {
+			client.r <- chunk
+		}
+		client.r <- message(t, 0x3f, map[string]interface{}{"n": -1})
+		client.r <- message(t, 0x0f)
+		close(client.r)
+		proxyListen(client, server, nil, config.Default().Timeouts, sess)
+
+		written := client.messages()
+		if len(written) != 2 || written[0].T != bolt.FailureMsg || written[1].T != bolt.IgnoreMsg {
+			t.Fatalf("%s: expected FAILURE and IGNORED, got %v", typ, written)
+		}
+		failure, _, err := bolt.ParseMap(written[0].Data[4:])
+		if err != nil || failure["code"] != FORBIDDEN_CODE {
+			t.Fatalf("%s: expected a forbidden failure, got %v", typ, failure)
+		}
+		if forwarded := server.messages(); len(forwarded) != 1 || forwarded[0].T != bolt.ResetMsg {
+			t.Fatalf("%s: expected only the RESET to reach the server, got %v", typ, forwarded)
+		}
+	}
+
+	// without anything looking into messages they are passed on as before
+	plain := newSession(NewListener(config.Listener{Name: "test"}, nil, nil, config.Default().Timeouts), backend.ClientInfo{})
+	client, server := newFakeConn(), newFakeConn()
+	for _, chunk := range splitAt(message(t, 0x2f, map[string]interface{}{"n": -1}), 1, bolt.UnknownMsg) {
+		client.r <- chunk
+	}
+	close(client.r)
+	proxyListen(client, server, nil, config.Default().Timeouts, plain)
+	if written, forwarded := client.messages(), server.messages(); len(written) != 0 || len(forwarded) != 2 {
+		t.Fatalf("expected the chunks to be passed on, got %v and %v", written, forwarded)
+	}
+}
+
+func TestOversizedQuery(t *testing.T) {
+	authorizer, err := backend.NewAuthorizer(config.Authorization{
+		Roles:        []config.Role{{Name: "writer", Access: config.ACCESS_WRITE}},
+		DefaultRoles: []string{"writer"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	l := NewListener(config.Listener{Name: "test"}, nil, expiringAuth{}, config.Default().Timeouts)
+	l.Authorizer = authorizer
+	sess := newSession(l, backend.ClientInfo{})
+	if _, err := sess.authenticate(logon(t, "fresh")); err != nil {
+		t.Fatal(err)
+	}
+
+	// the query continues past the first chunk, so it can't be checked
+	query := "RETURN '" + strings.Repeat("x", bolt.MAX_CHUNK_SIZE) + "'"
+	client, server := newFakeConn(), newFakeConn()
+	for _, chunk := range chunks(message(t, 0x10, query, map[string]interface{}{}, map[string]interface{}{})) {
+		client.r <- chunk
+	}
+	client.r <- message(t, 0x3f, map[string]interface{}{"n": -1})
+	client.r <- message(t, 0x0f)
+	close(client.r)
+	proxyListen(client, server, nil, config.Default().Timeouts, sess)
+
+	written := client.messages()
+	if len(written) != 2 || written[0].T != bolt.FailureMsg || written[1].T != bolt.IgnoreMsg {
+		t.Fatalf("expected FAILURE and IGNORED, got %v", written)
+	}
+	failure, _, err := bolt.ParseMap(written[0].Data[4:])
+	if err != nil || failure["code"] != FORBIDDEN_CODE || !strings.HasPrefix(failure["message"].(string), "unreadable query") {
+		t.Fatalf("expected an unreadable query failure, got %v", failure)
+	}
+	if forwarded := server.messages(); len(forwarded) != 1 || forwarded[0].T != bolt.ResetMsg {
+		t.Fatalf("expected only the RESET to reach the server, got %v", forwarded)
+	}
+
+	// large parameters are fine as long as the query fits
+	params := map[string]interface{}{"blob": strings.Repeat("x", bolt.MAX_CHUNK_SIZE)}
+	first := chunks(message(t, 0x10, "CREATE (n {blob: $blob})", params, map[string]interface{}{}))[0]
+	if _, err := sess.authorize(first, true); err != nil {
+		t.Fatalf("expected the query to be allowed, got %v", err)
+	}
+}
+
+func TestReadOnlySession(t *testing.T) {
+	authorizer, err := backend.NewAuthorizer(config.Authorization{
+		Roles:        []config.Role{{Name: "reader", Access: config.ACCESS_READ}},
+		DefaultRoles: []string{"reader"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	l := NewListener(config.Listener{Name: "test"}, nil, expiringAuth{}, config.Default().Timeouts)
+	l.Authorizer = authorizer
+	sess := newSession(l, backend.ClientInfo{})
+	if _, err := sess.authenticate(logon(t, "fresh")); err != nil {
+		t.Fatal(err)
+	}
+	if !sess.isReadOnly() {
+		t.Fatal("expected a read-only session")
+	}
+
+	// BEGIN and auto-commit RUN messages are forced into read mode
+	run := message(t, 0x10, "MATCH (n) RETURN n", map[string]interface{}{}, map[string]interface{}{})
+	for _, test := range []struct {
+		msg        *bolt.Message
+		autoCommit bool
+		mode       string
+	}{
+		{message(t, 0x11, map[string]interface{}{}), false, "r"},
+		{message(t, 0x11, map[string]interface{}{"mode": "r"}), false, "r"},
+		{run, true, "r"},
+		{run, false, ""},
+	} {
+		authorized, err := sess.authorize(test.msg, test.autoCommit)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if mode, err := bolt.MetadataString(authorized, "mode"); err != nil || mode != test.mode {
+			t.Errorf("%s: expected mode %q, got %q, %v", test.msg.T, test.mode, mode, err)
+		}
+	}
+
+	// split messages can't have their mode forced, whether the first chunk
+	// holds the tag or not
+	for _, msg := range []*bolt.Message{message(t, 0x11, map[string]interface{}{"mode": "w"}), run} {
+		for n, typ := range map[int]bolt.Type{1: bolt.UnknownMsg, 2: msg.T} {
+			client, server := newFakeConn(), newFakeConn()
+			for _, chunk := range splitAt(msg, n, typ) {
+				client.r <- chunk
+			}
+			close(client.r)
+			proxyListen(client, server, nil, config.Default().Timeouts, sess)
+			if written := client.messages(); len(written) != 1 || written[0].T != bolt.FailureMsg {
+				t.Errorf("%s split after %d bytes: expected a FAILURE, got %v", msg.T, n, written)
+			}
+			if forwarded := server.messages(); len(forwarded) != 0 {
+				t.Errorf("%s split after %d bytes: expected nothing forwarded, got %v", msg.T, n, forwarded)
+			}
+		}
+	}
+
+	// write transactions are refused, commits become rollbacks
+	if _, err := sess.authorize(message(t, 0x11, map[string]interface{}{"mode": "w"}), false); err == nil {
+		t.Error("expected a write transaction to be refused")
+	}
+	if authorized, err := sess.authorize(message(t, 0x12), false); err != nil || authorized.T != bolt.RollbackMsg {
+		t.Errorf("expected a ROLLBACK, got %v, %v", authorized, err)
+	}
+
+	// summaries of queries that wrote anything become FAILUREs
+	for queryType, allowed := range map[string]bool{"r": true, "w": false, "rw": false, "s": false} {
+		summary := message(t, 0x70, map[string]interface{}{"type": queryType, "t_last": 1})
+		if checked := sess.checkSummary(summary); (checked == summary) != allowed {
+			t.Errorf("type %s: unexpected %v", queryType, checked.T)
+		}
+	}
+}
+
+// Refuses everything, remembering what it was asked.
+type refusingPolicy struct {
+	inputs []*backend.PolicyInput
+}
+
+func (p *refusingPolicy) Decide(input *backend.PolicyInput) (*backend.PolicyDecision, error) {
+	p.inputs = append(p.inputs, input)
+	return &backend.PolicyDecision{Reason: "not today"}, nil
+}
+
+func TestRateLimitedQuery(t *testing.T) {
+	conf := config.Default().Auth
+	conf.RateLimits.Queries = config.RateLimit{Rate: 0.001}
+	l := NewListener(config.Listener{Name: "test"}, nil, expiringAuth{}, config.Default().Timeouts)
+	l.RateLimiter = backend.NewRateLimiter(conf)
+	sess := newSession(l, backend.ClientInfo{})
+	if _, err := sess.authenticate(logon(t, "fresh")); err != nil {
+		t.Fatal(err)
+	}
+	// another connection of alice takes the only token
+	other := newSession(l, backend.ClientInfo{})
+	if _, err := other.authenticate(logon(t, "fresh")); err != nil {
+		t.Fatal(err)
+	}
+	if !other.allowQuery() {
+		t.Fatal("expected the first query to be allowed")
+	}
+
+	client, server := newFakeConn(), newFakeConn()
+	client.r <- message(t, 0x10, "RETURN 1", map[string]interface{}{}, map[string]interface{}{})
+	client.r <- message(t, 0x3f, map[string]interface{}{"n": -1})
+	client.r <- message(t, 0x0f)
+	close(client.r)
+	proxyListen(client, server, nil, config.Default().Timeouts, sess)
+
+	written := client.messages()
+	if len(written) != 2 || written[0].T != bolt.FailureMsg || written[1].T != bolt.IgnoreMsg {
+		t.Fatalf("expected FAILURE and IGNORED, got %v", written)
+	}
+	failure, _, err := bolt.ParseMap(written[0].Data[4:])
+	if err != nil || failure["code"] != RATE_LIMITED_CODE {
+		t.Fatalf("expected a rate limited failure, got %v", failure)
+	}
+	if forwarded := server.messages(); len(forwarded) != 1 || forwarded[0].T != bolt.ResetMsg {
+		t.Fatalf("expected only the RESET to reach the server, got %v", forwarded)
+	}
+
+	// clients without a principal are counted by their address
+	l.Auth = nil
+	anonymous := newSession(l, backend.ClientInfo{RemoteAddr: &net.TCPAddr{IP: net.IPv4(10, 0, 0, 1), Port: 5000}})
+	if _, err := anonymous.authenticate(message(t, 0x01, map[string]interface{}{"scheme": "none"})); err != nil {
+		t.Fatal(err)
+	}
+	if !anonymous.allowQuery() || anonymous.allowQuery() {
+		t.Fatal("expected a single query from the address to be allowed")
+	}
+
+	// queries split before the RUN's tag can't be counted, so they're
+	// refused, while there are tokens left too
+	fresh := NewListener(config.Listener{Name: "test"}, nil, expiringAuth{}, config.Default().Timeouts)
+	fresh.RateLimiter = backend.NewRateLimiter(conf)
+	sess = newSession(fresh, backend.ClientInfo{})
+	if _, err := sess.authenticate(logon(t, "fresh")); err != nil {
+		t.Fatal(err)
+	}
+	client, server = newFakeConn(), newFakeConn()
+	for _, chunk := range splitAt(message(t, 0x10, "RETURN 1", map[string]interface{}{}, map[string]interface{}{}), 1, bolt.UnknownMsg) {
+		client.r <- chunk
+	}
+	close(client.r)
+	proxyListen(client, server, nil, config.Default().Timeouts, sess)
+	if written := client.messages(); len(written) != 1 || written[0].T != bolt.FailureMsg {
+		t.Fatalf("expected a FAILURE, got %v", written)
+	}
+	if forwarded := server.messages(); len(forwarded) != 0 {
+		t.Fatalf("expected nothing forwarded, got %v", forwarded)
+	}
+}
+
+func TestRateLimitedConnection(t *testing.T) {
+	conf := config.Default().Auth
+	conf.RateLimits.Connections = config.RateLimit{Rate: 0.001}
+	l := NewListener(config.Listener{Name: "test"}, nil, nil, config.Default().Timeouts)
+	l.RateLimiter = backend.NewRateLimiter(conf)
+	if !l.RateLimiter.AllowConnection("10.0.0.1") {
+		t.Fatal("expected the first connection to be allowed")
+	}
+
+	client := newFakeConn()
+	client.r <- message(t, 0x01, map[string]interface{}{"user_agent": "test", "scheme": "none"})
+	info := backend.ClientInfo{RemoteAddr: &net.TCPAddr{IP: net.IPv4(10, 0, 0, 1), Port: 5001}}
+	handleBoltConn(client, []byte{0x00, 0x00, 0x04, 0x04}, l, info)
+
+	written := client.messages()
+	if len(written) != 1 || written[0].T != bolt.FailureMsg {
+		t.Fatalf("expected a FAILURE, got %v", written)
+	}
+	failure, _, err := bolt.ParseMap(written[0].Data[4:])
+	if err != nil || failure["code"] != RATE_LIMITED_CODE {
+		t.Fatalf("expected a rate limited failure, got %v", failure)
+	}
+}
+
+func TestPolicyRefusesQuery(t *testing.T) {
+	policy := &refusingPolicy{}
+	l := NewListener(config.Listener{Name: "test"}, nil, expiringAuth{}, config.Default().Timeouts)
+	l.Policy = policy
+	sess := newSession(l, backend.ClientInfo{})
+	if _, err := sess.authenticate(logon(t, "fresh")); err != nil {
+		t.Fatal(err)
+	}
+
+	begin := message(t, 0x11, map[string]interface{}{"db": "sales"})
+	if _, err := sess.authorize(begin, false); err != nil {
+		t.Fatal(err)
+	}
+	run := message(t, 0x10, "MATCH (n) WHERE n.id = $id RETURN n", map[string]interface{}{"id": 1}, map[string]interface{}{})
+	_, err := sess.authorize(run, false)
+	if err == nil || err.Error() != "refused by policy: not today" {
+		t.Fatalf("expected the policy's reason, got %v", err)
+	}
+
+	if len(policy.inputs) != 1 {
+		t.Fatalf("expected the policy to be asked once, got %d", len(policy.inputs))
+	}
+	input := policy.inputs[0]
+	if input.Principal != "alice" || input.Database != "sales" || input.Query != "MATCH (n) WHERE n.id = $id RETURN n" ||
+		len(input.Parameters) != 1 || input.Parameters[0] != "id" || input.Claims["exp"] == nil {
+		t.Fatalf("unexpected policy input %#v", input)
+	}
+
+	// a RUN split before its tag is refused before the policy sees it
+	client, server := newFakeConn(), newFakeConn()
+	for _, chunk := range splitAt(run, 1, bolt.UnknownMsg) {
+		client.r <- chunk
+	}
+	close(client.r)
+	proxyListen(client, server, nil, config.Default().Timeouts, sess)
+	if written := client.messages(); len(written) != 1 || written[0].T != bolt.FailureMsg {
+		t.Fatalf("expected a FAILURE, got %v", written)
+	}
+	if forwarded := server.messages(); len(forwarded) != 0 || len(policy.inputs) != 1 {
+		t.Fatalf("expected the split RUN to be refused unseen, got %v", forwarded)
+	}
+}
+
+func TestAllowlistedQueries(t *testing.T) {
+	dir, err := ioutil.TempDir("", "bolt-proxy-session")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	path := filepath.Join(dir, "queries.yaml")
+	if err := ioutil.WriteFile(path, []byte("person_by_id: |\n  MATCH (p:Person {id: $id}) RETURN p\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	allowlist, err := backend.NewQueryAllowlist(config.QueryAllowlist{Path: path})
+	if err != nil {
+		t.Fatal(err)
+	}
+	l := NewListener(config.Listener{Name: "test"}, nil, expiringAuth{}, config.Default().Timeouts)
+	l.Allowlist = allowlist
+	sess := newSession(l, backend.ClientInfo{})
+	if _, err := sess.authenticate(logon(t, "fresh")); err != nil {
+		t.Fatal(err)
+	}
+
+	// a query id is replaced with the approved query
+	byID := message(t, 0x10, "", map[string]interface{}{"id": 1}, map[string]interface{}{"query_id": "person_by_id"})
+	authorized, err := sess.authorize(byID, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if query, _, err := bolt.ParseString(authorized.Data[4:]); err != nil || query != "MATCH (p:Person {id: $id}) RETURN p\n" {
+		t.Fatalf("expected the approved query, got %q, %v", query, err)
+	}
+	if id, err := bolt.MetadataValue(authorized, "query_id"); err != nil || id != nil {
+		t.Fatalf("expected the query id not to reach the server, got %#v, %v", id, err)
+	}
+
+	// approved queries are passed on as they are, others refused
+	byText := message(t, 0x10, "MATCH (p:Person {id: $id})\nRETURN p;", map[string]interface{}{"id": 1}, map[string]interface{}{})
+	if authorized, err := sess.authorize(byText, true); err != nil || authorized != byText {
+		t.Fatalf("expected the approved query to be kept, got %v, %v", authorized, err)
+	}
+	for _, refused := range []*bolt.Message{
+		message(t, 0x10, "MATCH (n) DETACH DELETE n", map[string]interface{}{}, map[string]interface{}{}),
+		message(t, 0x10, "", map[string]interface{}{}, map[string]interface{}{"query_id": "delete_all"}),
+	} {
+		if _, err := sess.authorize(refused, true); err == nil {
+			t.Error("expected the query to be refused")
+		}
+	}
+
+	// only the first chunk of a larger query is seen, which is refused
+	large := message(t, 0x10, "RETURN '"+strings.Repeat("x", bolt.MAX_CHUNK_SIZE)+"'", map[string]interface{}{}, map[string]interface{}{})
+	_, err = sess.resolveQuery(chunks(large)[0])
+	if err == nil || !strings.HasPrefix(err.Error(), "unreadable query") {
+		t.Fatalf("expected the large query to be unreadable, got %v", err)
+	}
+
+	// and so are queries split before the RUN's tag
+	client, server := newFakeConn(), newFakeConn()
+	for _, chunk := range splitAt(message(t, 0x10, "MATCH (n) DETACH DELETE n", map[string]interface{}{}, map[string]interface{}{}), 1, bolt.UnknownMsg) {
+		client.r <- chunk
+	}
+	close(client.r)
+	proxyListen(client, server, nil, config.Default().Timeouts, sess)
+	if written := client.messages(); len(written) != 1 || written[0].T != bolt.FailureMsg {
+		t.Fatalf("expected a FAILURE, got %v", written)
+	}
+	if forwarded := server.messages(); len(forwarded) != 0 {
+		t.Fatalf("expected nothing forwarded, got %v", forwarded)
+	}
+}
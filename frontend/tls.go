@@ -0,0 +1,111 @@
+/*
+Copyright (c) 2021 Memgraph Ltd. [https://memgraph.com]
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package frontend
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"time"
+
+	"github.com/memgraph/bolt-proxy/backend"
+	"github.com/memgraph/bolt-proxy/config"
+)
+
+// Build the server side TLS config of a listener, including client
+// certificate verification if a client CA bundle is configured. The loaded
+// certificate is recorded for status reporting under the listener's name.
+func NewTLSConfig(name string, conf config.TLS) (*tls.Config, error) {
+	store, err := newCertStore(name, conf)
+	if err != nil {
+		return nil, err
+	}
+	tlsConfig := &tls.Config{
+		GetCertificate: store.getCertificate,
+		NextProtos:     conf.ALPN,
+	}
+
+	tlsConfig.MinVersion, err = config.TLSVersion(conf.MinVersion)
+	if err != nil {
+		return nil, err
+	}
+	tlsConfig.CipherSuites, err = config.CipherSuites(conf.CipherSuites)
+	if err != nil {
+		return nil, err
+	}
+
+	if conf.ClientCAFile != "" {
+		pem, err := ioutil.ReadFile(conf.ClientCAFile)
+		if err != nil {
+			return nil, err
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in %s", conf.ClientCAFile)
+		}
+		tlsConfig.ClientCAs = pool
+	}
+
+	switch conf.ClientAuth {
+	case config.CLIENT_AUTH_NONE, "":
+		tlsConfig.ClientAuth = tls.NoClientCert
+	case config.CLIENT_AUTH_REQUEST:
+		tlsConfig.ClientAuth = tls.RequestClientCert
+	case config.CLIENT_AUTH_REQUIRE:
+		tlsConfig.ClientAuth = tls.RequireAnyClientCert
+	case config.CLIENT_AUTH_VERIFY_IF_GIVEN:
+		tlsConfig.ClientAuth = tls.VerifyClientCertIfGiven
+	case config.CLIENT_AUTH_REQUIRE_AND_VERIFY:
+		tlsConfig.ClientAuth = tls.RequireAndVerifyClientCert
+	default:
+		return nil, fmt.Errorf("unknown client auth %q", conf.ClientAuth)
+	}
+
+	return tlsConfig, nil
+}
+
+// Collect what we know about the client from its connection. For TLS
+// connections this completes the handshake, so the verified client
+// certificate is available before any Bolt message is read.
+func clientInfo(conn net.Conn, principalFrom string, timeout time.Duration) (backend.ClientInfo, error) {
+	info := backend.ClientInfo{RemoteAddr: conn.RemoteAddr()}
+
+	tlsConn, ok := conn.(*tls.Conn)
+	if !ok {
+		return info, nil
+	}
+
+	err := tlsConn.SetDeadline(time.Now().Add(timeout))
+	if err != nil {
+		return info, err
+	}
+	err = tlsConn.Handshake()
+	if err != nil {
+		return info, err
+	}
+	err = tlsConn.SetDeadline(time.Time{})
+	if err != nil {
+		return info, err
+	}
+
+	state := tlsConn.ConnectionState()
+	info.TLS = &state
+	info.CertPrincipal, err = backend.VerifiedCertPrincipal(&state, principalFrom)
+	return info, err
+}
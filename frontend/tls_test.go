@@ -0,0 +1,298 @@
+/*
+Copyright (c) 2021 Memgraph Ltd. [https://memgraph.com]
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package frontend
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/memgraph/bolt-proxy/config"
+	"golang.org/x/crypto/ocsp"
+)
+
+type testCert struct {
+	cert     *x509.Certificate
+	key      *ecdsa.PrivateKey
+	certFile string
+	keyFile  string
+}
+
+func (c *testCert) tlsCertificate() tls.Certificate {
+	return tls.Certificate{
+		Certificate: [][]byte{c.cert.Raw},
+		PrivateKey:  c.key,
+		Leaf:        c.cert,
+	}
+}
+
+// Create a certificate signed by parent (self-signed if parent is nil)
+// and write it and its key as PEM files into dir.
+func newTestCert(t *testing.T, dir, name string, template *x509.Certificate, parent *testCert) *testCert {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	template.SerialNumber = big.NewInt(time.Now().UnixNano())
+	template.NotBefore = time.Now().Add(-time.Hour)
+	if template.NotAfter.IsZero() {
+		template.NotAfter = time.Now().Add(time.Hour)
+	}
+
+	signer, signerKey := template, key
+	if parent != nil {
+		signer, signerKey = parent.cert, parent.key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, signer, &key.PublicKey, signerKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tc := &testCert{
+		cert:     cert,
+		key:      key,
+		certFile: filepath.Join(dir, name+".pem"),
+		keyFile:  filepath.Join(dir, name+"-key.pem"),
+	}
+	err = ioutil.WriteFile(tc.certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = ioutil.WriteFile(tc.keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer}), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tc
+}
+
+// A CA, a server certificate for localhost and a client certificate for
+// "service-a", all signed by the CA.
+func newTestPKI(t *testing.T) (ca, server, client *testCert) {
+	dir, err := ioutil.TempDir("", "bolt-proxy-tls")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+
+	ca = newTestCert(t, dir, "ca", &x509.Certificate{
+		Subject:               pkix.Name{CommonName: "test-ca"},
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}, nil)
+	server = newTestCert(t, dir, "server", &x509.Certificate{
+		Subject:     pkix.Name{CommonName: "localhost"},
+		DNSNames:    []string{"localhost"},
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}, ca)
+	client = newTestCert(t, dir, "client", &x509.Certificate{
+		Subject:     pkix.Name{CommonName: "service-a"},
+		DNSNames:    []string{"service-a.mesh.local"},
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}, ca)
+	return ca, server, client
+}
+
+func TestClientInfoFromVerifiedCert(t *testing.T) {
+	ca, server, client := newTestPKI(t)
+
+	serverConfig, err := NewTLSConfig("test", config.TLS{
+		CertFile:     server.certFile,
+		KeyFile:      server.keyFile,
+		ClientCAFile: ca.certFile,
+		ClientAuth:   config.CLIENT_AUTH_REQUIRE_AND_VERIFY,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	roots := x509.NewCertPool()
+	roots.AddCert(ca.cert)
+	clientConfig := &tls.Config{
+		RootCAs:      roots,
+		ServerName:   "localhost",
+		Certificates: []tls.Certificate{client.tlsCertificate()},
+	}
+
+	left, right := net.Pipe()
+	defer left.Close()
+	defer right.Close()
+
+	go func() {
+		_ = tls.Client(left, clientConfig).Handshake()
+	}()
+
+	info, err := clientInfo(tls.Server(right, serverConfig), config.PRINCIPAL_SAN_DNS, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.TLS == nil {
+		t.Fatal("expected tls connection state")
+	}
+	if info.CertPrincipal != "service-a.mesh.local" {
+		t.Fatalf("unexpected principal %q", info.CertPrincipal)
+	}
+}
+
+func TestClientInfoWithoutClientCert(t *testing.T) {
+	ca, server, _ := newTestPKI(t)
+
+	serverConfig, err := NewTLSConfig("test", config.TLS{
+		CertFile:     server.certFile,
+		KeyFile:      server.keyFile,
+		ClientCAFile: ca.certFile,
+		ClientAuth:   config.CLIENT_AUTH_VERIFY_IF_GIVEN,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	roots := x509.NewCertPool()
+	roots.AddCert(ca.cert)
+
+	left, right := net.Pipe()
+	defer left.Close()
+	defer right.Close()
+
+	go func() {
+		_ = tls.Client(left, &tls.Config{RootCAs: roots, ServerName: "localhost"}).Handshake()
+	}()
+
+	info, err := clientInfo(tls.Server(right, serverConfig), config.PRINCIPAL_SUBJECT_CN, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.CertPrincipal != "" {
+		t.Fatalf("expected no principal, got %q", info.CertPrincipal)
+	}
+}
+
+func TestTLSHardeningAndCertStatus(t *testing.T) {
+	ca, server, _ := newTestPKI(t)
+
+	// staple a "good" OCSP response signed by the CA
+	staple, err := ocsp.CreateResponse(ca.cert, ca.cert, ocsp.Response{
+		Status:       ocsp.Good,
+		SerialNumber: server.cert.SerialNumber,
+		ThisUpdate:   time.Now().Add(-time.Minute),
+		NextUpdate:   time.Now().Add(24 * time.Hour),
+	}, ca.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stapleFile := filepath.Join(filepath.Dir(server.certFile), "server.ocsp")
+	if err = ioutil.WriteFile(stapleFile, staple, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	serverConfig, err := NewTLSConfig("hardened", config.TLS{
+		CertFile:       server.certFile,
+		KeyFile:        server.keyFile,
+		MinVersion:     "1.2",
+		CipherSuites:   []string{"TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256"},
+		ALPN:           []string{"bolt"},
+		OCSPStapleFile: stapleFile,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if serverConfig.MinVersion != tls.VersionTLS12 {
+		t.Fatalf("unexpected min version %x", serverConfig.MinVersion)
+	}
+	if len(serverConfig.CipherSuites) != 1 || serverConfig.CipherSuites[0] != tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256 {
+		t.Fatalf("unexpected cipher suites %v", serverConfig.CipherSuites)
+	}
+
+	roots := x509.NewCertPool()
+	roots.AddCert(ca.cert)
+	left, right := net.Pipe()
+	defer left.Close()
+	defer right.Close()
+
+	go func() {
+		_ = tls.Server(right, serverConfig).Handshake()
+	}()
+	client := tls.Client(left, &tls.Config{
+		RootCAs:    roots,
+		ServerName: "localhost",
+		MaxVersion: tls.VersionTLS12,
+		NextProtos: []string{"bolt"},
+	})
+	if err = client.Handshake(); err != nil {
+		t.Fatal(err)
+	}
+	state := client.ConnectionState()
+	if state.NegotiatedProtocol != "bolt" {
+		t.Fatalf("expected ALPN to negotiate bolt, got %q", state.NegotiatedProtocol)
+	}
+	if !bytes.Equal(state.OCSPResponse, staple) {
+		t.Fatal("expected the OCSP response to be stapled")
+	}
+
+	// the loaded certificate shows up on the admin endpoint
+	rec := httptest.NewRecorder()
+	AdminHandler().ServeHTTP(rec, httptest.NewRequest("GET", "/tls/certificates", nil))
+	var statuses []CertificateStatus
+	if err = json.Unmarshal(rec.Body.Bytes(), &statuses); err != nil {
+		t.Fatal(err)
+	}
+	var status *CertificateStatus
+	for i := range statuses {
+		if statuses[i].Listener == "hardened" {
+			status = &statuses[i]
+		}
+	}
+	if status == nil {
+		t.Fatalf("certificate status missing from %s", rec.Body.String())
+	}
+	if status.Subject != "CN=localhost" || len(status.SANs) != 1 || status.SANs[0] != "localhost" {
+		t.Fatalf("unexpected status %#v", status)
+	}
+	if !status.NotAfter.Equal(server.cert.NotAfter) || !status.OCSPStapled {
+		t.Fatalf("unexpected status %#v", status)
+	}
+
+	rec = httptest.NewRecorder()
+	AdminHandler().ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	if !strings.Contains(rec.Body.String(), `bolt_proxy_tls_certificate_expiry_timestamp_seconds{listener="hardened",subject="CN=localhost"}`) {
+		t.Fatalf("expected expiry metric in:\n%s", rec.Body.String())
+	}
+}
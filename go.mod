@@ -3,9 +3,18 @@ module github.com/memgraph/bolt-proxy
 go 1.15
 
 require (
+	github.com/BurntSushi/toml v0.3.1
 	github.com/coreos/go-oidc/v3 v3.0.0
+	github.com/go-asn1-ber/asn1-ber v1.5.1
+	github.com/go-ldap/ldap/v3 v3.4.1
 	github.com/gobwas/httphead v0.1.0 // indirect
 	github.com/gobwas/pool v0.2.1 // indirect
 	github.com/gobwas/ws v1.0.4
 	github.com/neo4j/neo4j-go-driver/v4 v4.3.3
+	github.com/open-policy-agent/opa v0.30.2
+	golang.org/x/crypto v0.0.0-20200622213623-75b288015ac9
+	golang.org/x/sys v0.7.0 // indirect
+	golang.org/x/term v0.0.0-20201210144234-2321bbc49cbf
+	gopkg.in/square/go-jose.v2 v2.5.1
+	gopkg.in/yaml.v3 v3.0.1
 )
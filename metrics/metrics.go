@@ -0,0 +1,220 @@
+/*
+Copyright (c) 2021 Memgraph Ltd. [https://memgraph.com]
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Minimal metrics registry exposed in the Prometheus text format, so the
+// proxy doesn't need a full client library for a handful of counters.
+package metrics
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+type kind string
+
+const (
+	counterKind kind = "counter"
+	gaugeKind   kind = "gauge"
+)
+
+// A set of metrics that can be written out together.
+type Registry struct {
+	mu      sync.Mutex
+	metrics []*metric
+}
+
+// Registry used by the package level NewCounter and NewGauge.
+var Default = NewRegistry()
+
+func NewRegistry() *Registry {
+	return &Registry{}
+}
+
+type metric struct {
+	name, help string
+	kind       kind
+	labels     []string
+
+	mu     sync.Mutex
+	values map[string]float64
+}
+
+// Monotonically increasing value, optionally split by labels.
+type Counter struct {
+	m *metric
+}
+
+// Value that can go up and down, optionally split by labels.
+type Gauge struct {
+	m *metric
+}
+
+func (r *Registry) register(name, help string, k kind, labels []string) *metric {
+	m := &metric{
+		name:   name,
+		help:   help,
+		kind:   k,
+		labels: labels,
+		values: make(map[string]float64),
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, existing := range r.metrics {
+		if existing.name == name {
+			panic(fmt.Sprintf("metric %s registered twice", name))
+		}
+	}
+	r.metrics = append(r.metrics, m)
+	return m
+}
+
+func (r *Registry) NewCounter(name, help string, labels ...string) *Counter {
+	return &Counter{r.register(name, help, counterKind, labels)}
+}
+
+func (r *Registry) NewGauge(name, help string, labels ...string) *Gauge {
+	return &Gauge{r.register(name, help, gaugeKind, labels)}
+}
+
+func NewCounter(name, help string, labels ...string) *Counter {
+	return Default.NewCounter(name, help, labels...)
+}
+
+func NewGauge(name, help string, labels ...string) *Gauge {
+	return Default.NewGauge(name, help, labels...)
+}
+
+// Label values are joined into a single map key, in label order.
+func (m *metric) key(values []string) string {
+	if len(values) != len(m.labels) {
+		panic(fmt.Sprintf("metric %s expects %d label values, got %d",
+			m.name, len(m.labels), len(values)))
+	}
+	return strings.Join(values, "\x00")
+}
+
+func (m *metric) add(delta float64, values []string) {
+	key := m.key(values)
+	m.mu.Lock()
+	m.values[key] += delta
+	m.mu.Unlock()
+}
+
+func (m *metric) set(value float64, values []string) {
+	key := m.key(values)
+	m.mu.Lock()
+	m.values[key] = value
+	m.mu.Unlock()
+}
+
+func (m *metric) get(values []string) float64 {
+	key := m.key(values)
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.values[key]
+}
+
+func (c *Counter) Inc(labelValues ...string) {
+	c.m.add(1, labelValues)
+}
+
+func (c *Counter) Add(delta float64, labelValues ...string) {
+	if delta < 0 {
+		panic("counters can't decrease")
+	}
+	c.m.add(delta, labelValues)
+}
+
+func (c *Counter) Value(labelValues ...string) float64 {
+	return c.m.get(labelValues)
+}
+
+func (g *Gauge) Set(value float64, labelValues ...string) {
+	g.m.set(value, labelValues)
+}
+
+func (g *Gauge) Add(delta float64, labelValues ...string) {
+	g.m.add(delta, labelValues)
+}
+
+func (g *Gauge) Value(labelValues ...string) float64 {
+	return g.m.get(labelValues)
+}
+
+// Write every metric of the registry in the Prometheus text format.
+func (r *Registry) Write(w io.Writer) error {
+	r.mu.Lock()
+	metrics := make([]*metric, len(r.metrics))
+	copy(metrics, r.metrics)
+	r.mu.Unlock()
+
+	for _, m := range metrics {
+		_, err := fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", m.name, m.help, m.name, m.kind)
+		if err != nil {
+			return err
+		}
+
+		m.mu.Lock()
+		keys := make([]string, 0, len(m.values))
+		for key := range m.values {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		lines := make([]string, len(keys))
+		for i, key := range keys {
+			lines[i] = m.name + m.formatLabels(key) + " " +
+				strconv.FormatFloat(m.values[key], 'g', -1, 64) + "\n"
+		}
+		m.mu.Unlock()
+
+		for _, line := range lines {
+			if _, err = io.WriteString(w, line); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (m *metric) formatLabels(key string) string {
+	if len(m.labels) == 0 {
+		return ""
+	}
+	values := strings.Split(key, "\x00")
+	pairs := make([]string, len(m.labels))
+	for i, label := range m.labels {
+		pairs[i] = fmt.Sprintf("%s=%s", label, strconv.Quote(values[i]))
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+// HTTP handler serving the registry for Prometheus to scrape.
+func (r *Registry) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+		_ = r.Write(w)
+	})
+}
+
+func Handler() http.Handler {
+	return Default.Handler()
+}
@@ -0,0 +1,79 @@
+/*
+Copyright (c) 2021 Memgraph Ltd. [https://memgraph.com]
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package metrics
+
+import (
+	"bytes"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestRegistryOutput(t *testing.T) {
+	r := NewRegistry()
+	logins := r.NewCounter("logins_total", "Logins by outcome", "outcome")
+	sessions := r.NewGauge("sessions", "Open sessions")
+
+	logins.Inc("ok")
+	logins.Inc("ok")
+	logins.Add(3, "failed")
+	sessions.Set(4)
+	sessions.Add(-1)
+
+	if logins.Value("ok") != 2 || sessions.Value() != 3 {
+		t.Fatalf("unexpected values: %v, %v", logins.Value("ok"), sessions.Value())
+	}
+
+	buf := new(bytes.Buffer)
+	if err := r.Write(buf); err != nil {
+		t.Fatal(err)
+	}
+	expected := `# HELP logins_total Logins by outcome
+# TYPE logins_total counter
+logins_total{outcome="failed"} 3
+logins_total{outcome="ok"} 2
+# HELP sessions Open sessions
+# TYPE sessions gauge
+sessions 3
+`
+	if buf.String() != expected {
+		t.Fatalf("unexpected output:\n%s", buf.String())
+	}
+}
+
+func TestHandler(t *testing.T) {
+	r := NewRegistry()
+	r.NewGauge("expiry_seconds", "Expiry", "subject").Set(1.5e9, `CN="quoted"`)
+
+	rec := httptest.NewRecorder()
+	r.Handler().ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+
+	if !strings.Contains(rec.Body.String(), `expiry_seconds{subject="CN=\"quoted\""} 1.5e+09`) {
+		t.Fatalf("unexpected body:\n%s", rec.Body.String())
+	}
+}
+
+func TestDuplicateRegistration(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Fatal("expected duplicate registration to panic")
+		}
+	}()
+	r := NewRegistry()
+	r.NewCounter("twice", "")
+	r.NewGauge("twice", "")
+}
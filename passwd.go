@@ -0,0 +1,92 @@
+/*
+Copyright (c) 2021 Memgraph Ltd. [https://memgraph.com]
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bufio"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/memgraph/bolt-proxy/backend"
+	"golang.org/x/term"
+)
+
+// bolt-proxy passwd [-algorithm bcrypt|argon2id] [-roles role,...] user
+//
+// Print an htpasswd line for the user. The password is prompted for on a
+// terminal, otherwise the first line of stdin is used.
+func runPasswd(args []string) error {
+	flags := flag.NewFlagSet("passwd", flag.ExitOnError)
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "usage: bolt-proxy passwd [flags] user")
+		flags.PrintDefaults()
+	}
+	algorithm := flags.String("algorithm", backend.HASH_BCRYPT, "hash algorithm, bcrypt or argon2id")
+	roles := flags.String("roles", "", "comma separated roles of the user")
+	_ = flags.Parse(args)
+	if flags.NArg() != 1 {
+		flags.Usage()
+		os.Exit(2)
+	}
+
+	password, err := readPassword()
+	if err != nil {
+		return err
+	}
+	hash, err := backend.HashPassword(password, *algorithm)
+	if err != nil {
+		return err
+	}
+	entry, err := backend.HtpasswdEntry(flags.Arg(0), hash, *roles)
+	if err != nil {
+		return err
+	}
+	fmt.Println(entry)
+	return nil
+}
+
+func readPassword() (string, error) {
+	fd := int(os.Stdin.Fd())
+	if !term.IsTerminal(fd) {
+		line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+		if err != nil && err != io.EOF {
+			return "", err
+		}
+		return strings.TrimRight(line, "\r\n"), nil
+	}
+
+	fmt.Fprint(os.Stderr, "Password: ")
+	password, err := term.ReadPassword(fd)
+	fmt.Fprintln(os.Stderr)
+	if err != nil {
+		return "", err
+	}
+	fmt.Fprint(os.Stderr, "Confirm password: ")
+	confirm, err := term.ReadPassword(fd)
+	fmt.Fprintln(os.Stderr)
+	if err != nil {
+		return "", err
+	}
+	if string(password) != string(confirm) {
+		return "", errors.New("passwords don't match")
+	}
+	return string(password), nil
+}
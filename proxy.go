@@ -20,16 +20,25 @@ import (
 	"crypto/tls"
 	"flag"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"net"
+	"net/http"
 	"os"
 
+	"github.com/memgraph/bolt-proxy/audit"
 	"github.com/memgraph/bolt-proxy/backend"
+	"github.com/memgraph/bolt-proxy/config"
 	"github.com/memgraph/bolt-proxy/frontend"
 	"github.com/memgraph/bolt-proxy/proxy_logger"
+	"github.com/memgraph/bolt-proxy/redact"
 )
 
+// Command line flags. Only the flags explicitly given override values
+// from the config file and the environment.
 type Parameters struct {
+	configFile         string
+	checkConfig        bool
 	debugMode          bool
 	bindOn             string
 	proxyTo            string
@@ -37,105 +46,220 @@ type Parameters struct {
 	certFile, keyFile  string
 }
 
-const (
-	DEFAULT_BIND string = "localhost:8888"
-	DEFAULT_URI  string = "bolt://localhost:7687"
-	DEFAULT_USER string = "neo4j"
-)
-
 var proxy_params Parameters
 
 func init() {
-	var (
-		debugMode          bool
-		bindOn             string
-		proxyTo            string
-		username, password string
-		certFile, keyFile  string
-	)
-
-	bindOn, found := os.LookupEnv("BOLT_PROXY_BIND")
-	if !found {
-		bindOn = DEFAULT_BIND
-	}
-	proxyTo, found = os.LookupEnv("BOLT_PROXY_URI")
-	if !found {
-		proxyTo = DEFAULT_URI
-	}
-	username, found = os.LookupEnv("BOLT_PROXY_USER")
-	if !found {
-		username = DEFAULT_USER
-	}
-	_, debugMode = os.LookupEnv("BOLT_PROXY_DEBUG")
-	password = os.Getenv("BOLT_PROXY_PASSWORD")
-	certFile = os.Getenv("BOLT_PROXY_CERT")
-	keyFile = os.Getenv("BOLT_PROXY_KEY")
-
-	// to keep it easy, let the defaults be populated by the env vars
-	flag.StringVar(&proxy_params.bindOn, "bind", bindOn, "host:port to bind to")
-	flag.StringVar(&proxy_params.proxyTo, "uri", proxyTo, "bolt uri for remote Memgraph")
-	flag.StringVar(&proxy_params.username, "user", username, "Memgraph username")
-	flag.StringVar(&proxy_params.password, "pass", password, "Memgraph password")
-	flag.StringVar(&proxy_params.certFile, "cert", certFile, "x509 certificate")
-	flag.StringVar(&proxy_params.keyFile, "key", keyFile, "x509 private key")
-	flag.BoolVar(&proxy_params.debugMode, "debug", debugMode, "enable debug logging")
-	flag.Parse()
+	flag.StringVar(&proxy_params.configFile, "config", os.Getenv("BOLT_PROXY_CONFIG"), "path to a YAML or TOML config file")
+	flag.BoolVar(&proxy_params.checkConfig, "check-config", false, "validate the configuration and exit")
+	flag.StringVar(&proxy_params.bindOn, "bind", config.DEFAULT_BIND, "host:port to bind to")
+	flag.StringVar(&proxy_params.proxyTo, "uri", config.DEFAULT_URI, "bolt uri for remote Memgraph")
+	flag.StringVar(&proxy_params.username, "user", config.DEFAULT_USER, "Memgraph username")
+	flag.StringVar(&proxy_params.password, "pass", "", "Memgraph password")
+	flag.StringVar(&proxy_params.certFile, "cert", "", "x509 certificate")
+	flag.StringVar(&proxy_params.keyFile, "key", "", "x509 private key")
+	flag.BoolVar(&proxy_params.debugMode, "debug", false, "enable debug logging")
 }
 
-func main() {
-	// Set up loggers
-	proxy_logger.SetUpInfoLog(os.Stdout)
-	proxy_logger.SetUpWarnLog(os.Stderr)
-	if proxy_params.debugMode {
-		proxy_logger.SetUpDebugLog(os.Stdout)
+// Build the effective configuration. Precedence, from lowest to highest:
+// built-in defaults, config file, environment variables, command line flags.
+func loadConfig() (*config.Config, error) {
+	cfg := config.Default()
+	if proxy_params.configFile != "" {
+		var err error
+		cfg, err = config.Load(proxy_params.configFile)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	cfg.ApplyEnv(os.LookupEnv)
+
+	flag.Visit(func(f *flag.Flag) {
+		switch f.Name {
+		case "bind":
+			cfg.Listeners[0].Bind = proxy_params.bindOn
+		case "uri":
+			cfg.Backends[0].URI = proxy_params.proxyTo
+		case "user":
+			cfg.Backends[0].User = proxy_params.username
+		case "pass":
+			cfg.Backends[0].Password = proxy_params.password
+		case "cert":
+			cfg.Listeners[0].TLS.CertFile = proxy_params.certFile
+		case "key":
+			cfg.Listeners[0].TLS.KeyFile = proxy_params.keyFile
+		case "debug":
+			cfg.Logging.Debug = proxy_params.debugMode
+		}
+	})
+
+	return cfg, cfg.Validate()
+}
+
+func setUpLogging(conf config.Logging) error {
+	var info, warn io.Writer = os.Stdout, os.Stderr
+	if conf.File != "" {
+		file, err := os.OpenFile(conf.File, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+		if err != nil {
+			return err
+		}
+		info, warn = file, file
+	}
+
+	proxy_logger.SetUpInfoLog(info)
+	proxy_logger.SetUpWarnLog(warn)
+	proxy_logger.SetUpAuditLog(info)
+	if conf.Debug {
+		proxy_logger.SetUpDebugLog(info)
 	} else {
 		proxy_logger.SetUpDebugLog(ioutil.Discard)
 	}
+	return nil
+}
 
-	// ---------- BACK END
-	proxy_logger.InfoLog.Println("starting bolt-proxy backend")
-	auth, err := backend.NewAuth()
+func main() {
+	if len(os.Args) > 1 && os.Args[1] == "passwd" {
+		err := runPasswd(os.Args[2:])
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "verify-audit" {
+		err := runVerifyAudit(os.Args[2:])
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	flag.Parse()
+
+	cfg, err := loadConfig()
+	if proxy_params.checkConfig {
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		fmt.Println("configuration ok")
+		os.Exit(0)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+
+	// Set up loggers
+	err = setUpLogging(cfg.Logging)
 	if err != nil {
-		panic(fmt.Sprintf("auth not being used: %v\n", err))
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
 	}
-	back, err := backend.NewBackend(proxy_params.username, proxy_params.password, proxy_params.proxyTo, auth)
+
+	redactor, err := redact.New(cfg.Redaction)
 	if err != nil {
 		proxy_logger.WarnLog.Fatal(err)
 	}
-	proxy_logger.InfoLog.Println("connected to backend", proxy_params.proxyTo)
-	proxy_logger.InfoLog.Printf("found backend version %s\n", back.Version())
+	proxy_logger.SetRedactor(redactor)
+	auditLog, err := audit.New(cfg.Audit, redactor)
+	if err != nil {
+		proxy_logger.WarnLog.Fatalf("audit log: %v", err)
+	}
+
+	// ---------- BACK END
+	proxy_logger.InfoLog.Println("starting bolt-proxy backend")
+	backends := make(map[string]*backend.Backend, len(cfg.Backends))
+	for _, conf := range cfg.Backends {
+		back, err := backend.NewBackend(conf, cfg.Pool, cfg.Timeouts)
+		if err != nil {
+			proxy_logger.WarnLog.Fatal(err)
+		}
+		proxy_logger.InfoLog.Printf("connected to backend %s at %s\n", conf.Name, conf.URI)
+		proxy_logger.InfoLog.Printf("found backend version %s\n", back.Version())
+		backends[conf.Name] = back
+	}
 
 	// ---------- FRONT END
 	proxy_logger.InfoLog.Println("starting bolt-proxy frontend")
-
-	var listener net.Listener
-	if proxy_params.certFile == "" || proxy_params.keyFile == "" {
-		// non-tls
-		listener, err = net.Listen("tcp", proxy_params.bindOn)
+	done := make(chan error)
+	for _, conf := range cfg.Listeners {
+		listenerAuth := cfg.ListenerAuth(conf)
+		auth, err := backend.NewAuth(listenerAuth)
 		if err != nil {
-			proxy_logger.WarnLog.Fatal(err)
+			panic(fmt.Sprintf("auth not being used: %v\n", err))
 		}
-		proxy_logger.InfoLog.Printf("listening on %s\n", proxy_params.bindOn)
-	} else {
-		// tls
-		cert, err := tls.LoadX509KeyPair(proxy_params.certFile, proxy_params.keyFile)
+		if lockout, ok := auth.(*backend.LockoutAuth); ok {
+			lockout.Audit, lockout.Listener = auditLog, conf.Name
+		}
+		credentials, err := backend.NewCredentialMapper(listenerAuth.BackendCredentials)
 		if err != nil {
-			proxy_logger.WarnLog.Fatal(err)
+			proxy_logger.WarnLog.Fatalf("[%s] backend credentials: %v", conf.Name, err)
 		}
-		config := &tls.Config{Certificates: []tls.Certificate{cert}}
-		listener, err = tls.Listen("tcp", proxy_params.bindOn, config)
+		authorizer, err := backend.NewAuthorizer(listenerAuth.Authorization)
+		if err != nil {
+			proxy_logger.WarnLog.Fatalf("[%s] authorization: %v", conf.Name, err)
+		}
+		policy, err := backend.NewPolicy(listenerAuth.Authorization.Policy)
+		if err != nil {
+			proxy_logger.WarnLog.Fatalf("[%s] policy: %v", conf.Name, err)
+		}
+		allowlist, err := backend.NewQueryAllowlist(listenerAuth.Authorization.Allowlist)
+		if err != nil {
+			proxy_logger.WarnLog.Fatalf("[%s] query allowlist: %v", conf.Name, err)
+		}
+		rateLimiter := backend.NewRateLimiter(listenerAuth)
+		listener, err := listen(conf)
 		if err != nil {
 			proxy_logger.WarnLog.Fatal(err)
 		}
-		proxy_logger.InfoLog.Printf("listening for TLS connections on %s\n", proxy_params.bindOn)
+
+		front := frontend.NewListener(conf, backends[conf.Backend], auth, cfg.Timeouts)
+		front.Credentials = credentials
+		front.Authorizer = authorizer
+		front.Policy = policy
+		front.Allowlist = allowlist
+		front.RateLimiter = rateLimiter
+		front.Audit = auditLog
+		front.AuthMethod = listenerAuth.Method
+		// ---------- Event Loop
+		go func() {
+			done <- front.Serve(listener)
+		}()
+	}
+
+	if cfg.Admin.Bind != "" {
+		proxy_logger.InfoLog.Printf("serving admin endpoint on %s\n", cfg.Admin.Bind)
+		go func() {
+			done <- http.ListenAndServe(cfg.Admin.Bind, frontend.AdminHandler())
+		}()
 	}
-	// ---------- Event Loop
-	for {
-		conn, err := listener.Accept()
+
+	proxy_logger.WarnLog.Fatal(<-done)
+}
+
+// Open the network listener for a frontend, using TLS if configured.
+func listen(conf config.Listener) (net.Listener, error) {
+	if !conf.TLS.Enabled() {
+		// non-tls
+		listener, err := net.Listen("tcp", conf.Bind)
 		if err != nil {
-			proxy_logger.WarnLog.Printf("error: %v\n", err)
-		} else {
-			go frontend.HandleClient(conn, back)
+			return nil, err
 		}
+		proxy_logger.InfoLog.Printf("[%s] listening on %s\n", conf.Name, conf.Bind)
+		return listener, nil
+	}
+
+	// tls
+	tlsConfig, err := frontend.NewTLSConfig(conf.Name, conf.TLS)
+	if err != nil {
+		return nil, err
+	}
+	listener, err := tls.Listen("tcp", conf.Bind, tlsConfig)
+	if err != nil {
+		return nil, err
 	}
+	proxy_logger.InfoLog.Printf("[%s] listening for TLS connections on %s\n", conf.Name, conf.Bind)
+	return listener, nil
 }
@@ -17,22 +17,25 @@ limitations under the License.
 package proxy_logger
 
 import (
-	"fmt"
 	"io"
+	"io/ioutil"
 	"log"
 
 	"github.com/memgraph/bolt-proxy/bolt"
+	"github.com/memgraph/bolt-proxy/redact"
 )
 
 var (
 	DebugLog *log.Logger
 	InfoLog  *log.Logger
 	WarnLog  *log.Logger
-)
+	// Security relevant events, e.g. clients being locked out
+	AuditLog *log.Logger
 
-const (
-	// max bytes to display in logs in debug mode
-	MAX_BYTES int = 32
+	// Masks what's sensitive in the messages written to the debug log
+	redactor = redact.Default()
+	// decoding messages is only worth it if they're written somewhere
+	debugEnabled bool
 )
 
 func SetUpInfoLog(out io.Writer) {
@@ -43,30 +46,37 @@ func SetUpWarnLog(out io.Writer) {
 	WarnLog = log.New(out, "WARN: ", log.Ldate|log.Ltime|log.Lmsgprefix)
 }
 
+func SetUpAuditLog(out io.Writer) {
+	AuditLog = log.New(out, "AUDIT: ", log.Ldate|log.Ltime|log.Lmsgprefix)
+}
+
 func SetUpDebugLog(out io.Writer) {
 	DebugLog = log.New(out, "DEBUG: ", log.Ldate|log.Ltime|log.Lmsgprefix)
+	debugEnabled = out != ioutil.Discard
+}
+
+// Use r to mask the messages written to the debug log.
+func SetRedactor(r *redact.Redactor) {
+	redactor = r
+}
+
+// Text with the redaction patterns masked, for anything logged that may
+// quote a query or a value.
+func Redact(text string) string {
+	return redactor.Text(text)
 }
 
+// Write a message to the debug log, decoded and with what's sensitive
+// masked.
 func LogMessage(who string, msg *bolt.Message) {
-	if msg != nil {
-		end := MAX_BYTES
-		suffix := fmt.Sprintf("...+%d bytes", len(msg.Data))
-		if len(msg.Data) < MAX_BYTES {
-			end = len(msg.Data)
-			suffix = ""
-		}
-		switch msg.T {
-		case bolt.HelloMsg:
-			// make sure we don't print the secrets in a Hello!
-			DebugLog.Printf("[%s] <%s>: %#v\n\n", who, msg.T, msg.Data[:4])
-		case bolt.BeginMsg, bolt.FailureMsg:
-			DebugLog.Printf("[%s] <%s>: %#v\n%s\n", who, msg.T, msg.Data[:end], msg.Data)
-		default:
-			DebugLog.Printf("[%s] <%s>: %#v%s, last2:%#v\n", who, msg.T, msg.Data[:end], suffix, msg.Data[len(msg.Data)-2:])
-		}
-	} else {
+	if !debugEnabled {
+		return
+	}
+	if msg == nil {
 		DebugLog.Print("Message is nil")
+		return
 	}
+	DebugLog.Printf("[%s] <%s>: %s\n", who, msg.T, redactor.Message(msg))
 }
 
 func LogMessages(who string, messages []*bolt.Message) {
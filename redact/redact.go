@@ -0,0 +1,230 @@
+/*
+Copyright (c) 2021 Memgraph Ltd. [https://memgraph.com]
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Masks sensitive values, e.g. passwords, emails and tokens, in the Bolt
+// messages and queries the proxy logs.
+package redact
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/memgraph/bolt-proxy/bolt"
+	"github.com/memgraph/bolt-proxy/config"
+)
+
+// What masked values are replaced with in REDACT_ACTION_MASK mode
+const MASK = "***"
+
+// Regular expressions of the built-in patterns
+var builtinPatterns = map[string]string{
+	config.REDACT_PATTERN_EMAIL:  `[A-Za-z0-9._%+-]+@[A-Za-z0-9-]+(\.[A-Za-z0-9-]+)*\.[A-Za-z]{2,}`,
+	config.REDACT_PATTERN_JWT:    `eyJ[A-Za-z0-9_-]*\.[A-Za-z0-9_-]+\.[A-Za-z0-9_-]*`,
+	config.REDACT_PATTERN_BEARER: `(?i)bearer\s+[A-Za-z0-9._~+/=-]+`,
+}
+
+// Keys whose values are masked whatever the configuration says, and never
+// hashed: a hash of a password can still be matched against guesses
+var alwaysMasked = []string{"credentials", "password"}
+
+// Applies the redaction rules to messages, values and text. Safe for
+// concurrent use.
+type Redactor struct {
+	// lower case
+	keys      []string
+	patterns  []*regexp.Regexp
+	hash      bool
+	hashKey   []byte
+	maxLength int
+}
+
+func New(conf config.Redaction) (*Redactor, error) {
+	r := &Redactor{
+		hash:      conf.Action == config.REDACT_ACTION_HASH,
+		hashKey:   []byte(conf.HashKey),
+		maxLength: conf.MaxLength,
+	}
+	if r.hash && conf.HashKey == "" {
+		return nil, errors.New("redaction hash_key must be set to hash values")
+	}
+	for _, key := range append(conf.Parameters, alwaysMasked...) {
+		r.keys = append(r.keys, strings.ToLower(key))
+	}
+	for _, pattern := range conf.Patterns {
+		if builtin, found := builtinPatterns[pattern]; found {
+			pattern = builtin
+		}
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("redaction pattern %q: %v", pattern, err)
+		}
+		r.patterns = append(r.patterns, re)
+	}
+	return r, nil
+}
+
+// The redactor with the default rules.
+func Default() *Redactor {
+	r, err := New(config.Default().Redaction)
+	if err != nil {
+		panic(err)
+	}
+	return r
+}
+
+// Whether the values of key are masked.
+func (r *Redactor) masks(key string) bool {
+	key = strings.ToLower(key)
+	for _, pattern := range r.keys {
+		if matched, _ := path.Match(pattern, key); matched {
+			return true
+		}
+	}
+	return false
+}
+
+// Whether the values of key are never shown, not even hashed.
+func (r *Redactor) Secret(key string) bool {
+	for _, secret := range alwaysMasked {
+		if strings.EqualFold(key, secret) {
+			return true
+		}
+	}
+	return false
+}
+
+func (r *Redactor) mask(value string) string {
+	if !r.hash {
+		return MASK
+	}
+	mac := hmac.New(sha256.New, r.hashKey)
+	mac.Write([]byte(value))
+	return "hmac-sha256:" + hex.EncodeToString(mac.Sum(nil)[:8])
+}
+
+// Text with every match of the patterns masked, e.g. query text.
+func (r *Redactor) Text(text string) string {
+	for _, pattern := range r.patterns {
+		text = pattern.ReplaceAllStringFunc(text, r.mask)
+	}
+	return text
+}
+
+// Text masked and cut to the maximum length.
+func (r *Redactor) truncate(text string) string {
+	text = r.Text(text)
+	if r.maxLength == 0 || utf8.RuneCountInString(text) <= r.maxLength {
+		return text
+	}
+	runes := []rune(text)
+	return fmt.Sprintf("%s...(+%d)", string(runes[:r.maxLength]), len(runes)-r.maxLength)
+}
+
+// The value of key, e.g. a query parameter, as shown in logs. Pass "" for
+// values without a key.
+func (r *Redactor) Value(key string, value interface{}) string {
+	var b strings.Builder
+	r.format(&b, key, value)
+	return b.String()
+}
+
+// The packed value of key as shown in logs.
+func (r *Redactor) PackedValue(key string, packed []byte) string {
+	value, _, err := bolt.Unpack(packed)
+	if err != nil {
+		return fmt.Sprintf("<%d bytes>", len(packed))
+	}
+	return r.Value(key, value)
+}
+
+// A message as shown in logs: its fields decoded and redacted. Messages
+// too large for a single chunk are only described.
+func (r *Redactor) Message(msg *bolt.Message) string {
+	fields, err := bolt.Fields(msg)
+	if err != nil {
+		return fmt.Sprintf("<%d bytes>", len(msg.Data))
+	}
+	var b strings.Builder
+	for i, field := range fields {
+		if i > 0 {
+			b.WriteByte(' ')
+		}
+		r.format(&b, "", field)
+	}
+	return b.String()
+}
+
+func (r *Redactor) format(b *strings.Builder, key string, value interface{}) {
+	if key != "" && value != nil && r.Secret(key) {
+		b.WriteString(MASK)
+		return
+	}
+	if key != "" && value != nil && r.masks(key) {
+		b.WriteString(r.mask(fmt.Sprint(value)))
+		return
+	}
+
+	switch v := value.(type) {
+	case nil:
+		b.WriteString("null")
+	case string:
+		b.WriteString(strconv.Quote(r.truncate(v)))
+	case float64:
+		b.WriteString(strconv.FormatFloat(v, 'g', -1, 64))
+	case []byte:
+		// could be anything, never shown
+		fmt.Fprintf(b, "<%d bytes>", len(v))
+	case []interface{}:
+		b.WriteByte('[')
+		for i, element := range v {
+			if i > 0 {
+				b.WriteString(", ")
+			}
+			r.format(b, "", element)
+		}
+		b.WriteByte(']')
+	case map[string]interface{}:
+		keys := make([]string, 0, len(v))
+		for k := range v {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		b.WriteByte('{')
+		for i, k := range keys {
+			if i > 0 {
+				b.WriteString(", ")
+			}
+			b.WriteString(k)
+			b.WriteString(": ")
+			r.format(b, k, v[k])
+		}
+		b.WriteByte('}')
+	case bolt.Structure:
+		fmt.Fprintf(b, "#%02x", v.Tag)
+		r.format(b, "", v.Fields)
+	default:
+		fmt.Fprint(b, v)
+	}
+}
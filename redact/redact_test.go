@@ -0,0 +1,138 @@
+/*
+Copyright (c) 2021 Memgraph Ltd. [https://memgraph.com]
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package redact
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/memgraph/bolt-proxy/bolt"
+	"github.com/memgraph/bolt-proxy/config"
+)
+
+func message(t *testing.T, tag byte, fields ...interface{}) *bolt.Message {
+	msg, err := bolt.NewMessage(tag, fields...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	msg.T = bolt.IdentifyType(msg.Data)
+	return msg
+}
+
+func TestMessage(t *testing.T) {
+	r := Default()
+	tests := []struct {
+		msg      *bolt.Message
+		expected string
+	}{
+		{message(t, 0x10, "MATCH (u:User {email: $email}) SET u.password = $password",
+			map[string]interface{}{"email": "alice@example.com", "password": "hunter2", "apiToken": 1.5},
+			map[string]interface{}{"db": "memgraph"}),
+			`"MATCH (u:User {email: $email}) SET u.password = $password" {apiToken: ***, email: "***", password: ***} {db: "memgraph"}`},
+		{message(t, 0x01, map[string]interface{}{"user_agent": "app/1.0", "scheme": "basic", "principal": "alice", "credentials": "s3cret"}),
+			`{credentials: ***, principal: "alice", scheme: "basic", user_agent: "app/1.0"}`},
+		{message(t, 0x71, []interface{}{strings.Repeat("a", 70), nil, true, int64(-3)}),
+			`["` + strings.Repeat("a", 64) + `...(+6)", null, true, -3]`},
+		{message(t, 0x7f, map[string]interface{}{"code": "Neo.ClientError.Security.Unauthorized",
+			"message": "token eyJhbGciOiJIUzI1NiJ9.eyJzdWIiOiJ4In0.sig expired"}),
+			`{code: "Neo.ClientError.Security.Unauthorized", message: "token *** expired"}`},
+		{message(t, 0x0f), ``},
+	}
+	for _, test := range tests {
+		if got := r.Message(test.msg); got != test.expected {
+			t.Errorf("%s:\nexpected %s\n     got %s", test.msg.T, test.expected, got)
+		}
+	}
+
+	// a RECORD with a node and some bytes
+	record := &bolt.Message{T: bolt.RecordMsg, Data: []byte{0x00, 0x13, 0xb1, 0x71, 0x92,
+		0xb3, 0x4e, 0x01, 0x90, 0xa1, 0x85, 't', 'o', 'k', 'e', 'n', 0x81, 'x',
+		0xcc, 0x01, 0xff, 0x00, 0x00}}
+	if got := r.Message(record); got != "[#4e[1, [], {token: ***}], <1 bytes>]" {
+		t.Errorf("unexpected record %s", got)
+	}
+
+	large := message(t, 0x10, strings.Repeat("x", bolt.MAX_CHUNK_SIZE), map[string]interface{}{})
+	if got := r.Message(large); !strings.HasPrefix(got, "<") {
+		t.Errorf("expected a chunked message to be described, got %.40s", got)
+	}
+}
+
+func TestHashAndCustomRules(t *testing.T) {
+	r, err := New(config.Redaction{
+		Parameters: []string{"SSN"},
+		Patterns:   []string{`\b\d{3}-\d{2}-\d{4}\b`},
+		Action:     config.REDACT_ACTION_HASH,
+		HashKey:    "pepper",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ssn := r.Value("ssn", "123-45-6789")
+	if !strings.HasPrefix(ssn, "hmac-sha256:") || ssn != r.Value("ssn", "123-45-6789") || ssn == r.Value("ssn", "987-65-4321") {
+		t.Errorf("expected the value to be hashed consistently, got %s", ssn)
+	}
+	text := r.Text("MATCH (p {ssn: '123-45-6789'}) RETURN p")
+	if strings.Contains(text, "123-45-6789") || !strings.Contains(text, "hmac-sha256:") {
+		t.Errorf("expected the number to be hashed, got %s", text)
+	}
+	// without the default rules emails show, and nothing is truncated
+	if got := r.Value("email", "alice@example.com"); got != `"alice@example.com"` {
+		t.Errorf("expected the email to be kept, got %s", got)
+	}
+
+	// the hashes depend on the key
+	other, err := New(config.Redaction{Parameters: []string{"ssn"}, Action: config.REDACT_ACTION_HASH, HashKey: "salt"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if other.Value("ssn", "123-45-6789") == ssn {
+		t.Error("expected another key to give another hash")
+	}
+
+	if _, err := New(config.Redaction{Patterns: []string{"(unclosed"}}); err == nil {
+		t.Error("expected an invalid pattern to be refused")
+	}
+	if _, err := New(config.Redaction{Action: config.REDACT_ACTION_HASH}); err == nil {
+		t.Error("expected hashing without a key to be refused")
+	}
+}
+
+func TestPasswordsNeverHashed(t *testing.T) {
+	r, err := New(config.Redaction{Parameters: []string{"*"}, Action: config.REDACT_ACTION_HASH, HashKey: "pepper"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	hello := message(t, 0x01, map[string]interface{}{"scheme": "basic", "principal": "alice", "credentials": "s3cret"})
+	if got := r.Message(hello); got != "{credentials: ***, principal: "+r.mask("alice")+", scheme: "+r.mask("basic")+"}" {
+		t.Errorf("expected the credentials to be masked, got %s", got)
+	}
+	for _, key := range []string{"password", "Password", "credentials"} {
+		if got := r.Value(key, "s3cret"); got != MASK {
+			t.Errorf("%s: expected %s, got %s", key, MASK, got)
+		}
+	}
+
+	// whatever the configuration says
+	r, err = New(config.Redaction{Action: config.REDACT_ACTION_MASK})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := r.Value("password", "s3cret"); got != MASK {
+		t.Errorf("expected the password to be masked, got %s", got)
+	}
+}
@@ -0,0 +1,61 @@
+/*
+Copyright (c) 2021 Memgraph Ltd. [https://memgraph.com]
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/memgraph/bolt-proxy/audit"
+)
+
+// bolt-proxy verify-audit [-prev hash] file...
+//
+// Check the hash chain of audit log files, given oldest first, e.g.
+// audit.log.2 audit.log.1 audit.log. Prints the hash of the last entry,
+// to compare with one kept elsewhere.
+func runVerifyAudit(args []string) error {
+	flags := flag.NewFlagSet("verify-audit", flag.ExitOnError)
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "usage: bolt-proxy verify-audit [flags] file...")
+		flags.PrintDefaults()
+	}
+	prev := flags.String("prev", "", "hash of the entry before the first one, if known")
+	_ = flags.Parse(args)
+	if flags.NArg() == 0 {
+		flags.Usage()
+		os.Exit(2)
+	}
+
+	last, total := *prev, 0
+	for _, path := range flags.Args() {
+		file, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		var entries int
+		last, entries, err = audit.Verify(file, last)
+		file.Close()
+		total += entries
+		if err != nil {
+			return fmt.Errorf("%s: %v", path, err)
+		}
+	}
+	fmt.Printf("%d entries ok, last hash %s\n", total, last)
+	return nil
+}